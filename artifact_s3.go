@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3RequestTimeout bounds the wait for the response headers of an S3 request; bodies
+// of large artifacts may take longer to transfer
+const s3RequestTimeout = 30 * time.Second
+
+// s3ArtifactBackend keeps artifacts in a bucket of Amazon S3 or an S3-compatible store
+// such as MinIO. Requests are signed with AWS Signature Version 4.
+type s3ArtifactBackend struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+// newS3ArtifactBackend configures the backend from the ARTIFACT_S3_* variables and
+// creates the bucket when it does not exist yet
+func newS3ArtifactBackend() (*s3ArtifactBackend, error) {
+	b := &s3ArtifactBackend{
+		region:    os.Getenv("ARTIFACT_S3_REGION"),
+		bucket:    os.Getenv("ARTIFACT_S3_BUCKET"),
+		accessKey: os.Getenv("ARTIFACT_S3_ACCESS_KEY"),
+		secretKey: os.Getenv("ARTIFACT_S3_SECRET_KEY"),
+	}
+	if b.bucket == "" || b.accessKey == "" || b.secretKey == "" {
+		return nil, errors.New("ARTIFACT_S3_BUCKET, ARTIFACT_S3_ACCESS_KEY and ARTIFACT_S3_SECRET_KEY are required for s3 storage")
+	}
+	if b.region == "" {
+		b.region = "us-east-1"
+	}
+
+	// Custom endpoints (MinIO and most S3-compatible stores) default to path-style
+	// addressing, Amazon S3 to virtual-hosted buckets
+	endpoint := os.Getenv("ARTIFACT_S3_ENDPOINT")
+	b.pathStyle = endpoint != ""
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", b.region)
+	}
+	if v := os.Getenv("ARTIFACT_S3_PATH_STYLE"); v != "" {
+		pathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARTIFACT_S3_PATH_STYLE %q", v)
+		}
+		b.pathStyle = pathStyle
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid ARTIFACT_S3_ENDPOINT %q", endpoint)
+	}
+	b.endpoint = u
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = s3RequestTimeout
+	b.client = &http.Client{Transport: transport}
+
+	if err := b.ensureBucket(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// objectURL returns the URL of a key in the bucket, or of the bucket itself for an empty key
+func (b *s3ArtifactBackend) objectURL(key string) *url.URL {
+	u := *b.endpoint
+	path := "/" + key
+	if b.pathStyle {
+		path = "/" + b.bucket + path
+	} else {
+		u.Host = b.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	return &u
+}
+
+// do signs and sends a request with a body of size bytes whose SHA-256 is payloadHash
+func (b *s3ArtifactBackend) do(method, key string, body io.Reader, size int64, payloadHash string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.objectURL(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	b.sign(req, payloadHash, time.Now().UTC())
+	return b.client.Do(req)
+}
+
+// s3Error turns an unexpected response into an error, including the start of its body
+func s3Error(resp *http.Response, action string) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("s3 %s failed with %s: %s", action, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+var emptyPayloadHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// ensureBucket creates the bucket unless it exists
+func (b *s3ArtifactBackend) ensureBucket() error {
+	resp, err := b.do(http.MethodHead, "", nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("s3 bucket check failed with %s", resp.Status)
+	}
+
+	var body string
+	if b.region != "us-east-1" {
+		body = fmt.Sprintf(`<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LocationConstraint>%s</LocationConstraint></CreateBucketConfiguration>`, b.region)
+	}
+	sum := sha256.Sum256([]byte(body))
+	resp, err = b.do(http.MethodPut, "", strings.NewReader(body), int64(len(body)), hex.EncodeToString(sum[:]), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "bucket creation")
+	}
+	return nil
+}
+
+// Put uploads an object in one request, signing the SHA-256 of its contents
+func (b *s3ArtifactBackend) Put(key string, body io.ReadSeeker, size int64, contentType string) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {contentType}}
+	resp, err := b.do(http.MethodPut, key, body, size, hex.EncodeToString(hash.Sum(nil)), header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "upload")
+	}
+	return nil
+}
+
+func (b *s3ArtifactBackend) Get(key string) (io.ReadCloser, error) {
+	resp, err := b.do(http.MethodGet, key, nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errArtifactNotFound
+	}
+	defer resp.Body.Close()
+	return nil, s3Error(resp, "download")
+}
+
+// Delete removes an object; S3 answers 204 whether or not it existed
+func (b *s3ArtifactBackend) Delete(key string) error {
+	resp, err := b.do(http.MethodDelete, key, nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp, "delete")
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers to a request
+func (b *s3ArtifactBackend) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign the host and every x-amz-* and content-type header
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, b.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
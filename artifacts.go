@@ -0,0 +1,536 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Artifact kinds
+const (
+	artifactAssetReport = "asset_report"
+	artifactInvoice     = "invoice"
+	artifactLabels      = "labels"
+	artifactJob         = "job"
+)
+
+const (
+	// defaultArtifactDir is where the local backend keeps files when ARTIFACT_DIR is unset
+	defaultArtifactDir = "./artifacts"
+
+	// defaultArtifactRetentionHours applies when ARTIFACT_RETENTION_HOURS is unset
+	defaultArtifactRetentionHours = 24
+
+	// defaultArtifactURLMinutes applies when ARTIFACT_URL_TTL_MINUTES is unset
+	defaultArtifactURLMinutes = 15
+
+	// artifactGCInterval is how often expired artifacts are deleted, artifactGCBatchSize at a time
+	artifactGCInterval  = 10 * time.Minute
+	artifactGCBatchSize = 500
+)
+
+var (
+	errArtifactNotFound  = errors.New("artifact not found")
+	errArtifactSignature = errors.New("download link is invalid or has expired")
+)
+
+// artifactContentTypes are the content types of generated files, by extension
+var artifactContentTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".zip":  "application/zip",
+	".zpl":  labelFormatContentTypes[labelFormatZPL],
+	".epl":  labelFormatContentTypes[labelFormatEPL],
+}
+
+// artifactContentType returns the content type of a generated file name
+func artifactContentType(name string) string {
+	if contentType, ok := artifactContentTypes[strings.ToLower(filepath.Ext(name))]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// artifactBackend keeps the contents of artifacts under opaque keys
+type artifactBackend interface {
+	Put(key string, body io.ReadSeeker, size int64, contentType string) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// localArtifactBackend keeps artifacts as files below a directory
+type localArtifactBackend struct {
+	dir string
+}
+
+func (b localArtifactBackend) path(key string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(key))
+}
+
+// Put writes the file under a temporary name and renames it, so readers never see it half written
+func (b localArtifactBackend) Put(key string, body io.ReadSeeker, size int64, contentType string) error {
+	path := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (b localArtifactBackend) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(b.path(key))
+	if os.IsNotExist(err) {
+		return nil, errArtifactNotFound
+	}
+	return f, err
+}
+
+func (b localArtifactBackend) Delete(key string) error {
+	err := os.Remove(b.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// artifactStorage keeps generated files in a backend, records their owner and expiry in
+// the artifacts table and signs their download links
+type artifactStorage struct {
+	backend   artifactBackend
+	urlKey    []byte
+	retention time.Duration
+	urlTTL    time.Duration
+}
+
+// artifacts is the process's artifact storage, set up by startArtifactStorage
+var artifacts *artifactStorage
+
+// envInt reads a positive integer from the environment, falling back to def
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Invalid %s %q, using %d", name, v, def)
+	}
+	return def
+}
+
+// startArtifactStorage sets up the backend chosen by ARTIFACT_STORAGE and starts the
+// collector of expired artifacts
+func startArtifactStorage() error {
+	var backend artifactBackend
+	switch storage := os.Getenv("ARTIFACT_STORAGE"); storage {
+	case "", "local":
+		dir := os.Getenv("ARTIFACT_DIR")
+		if dir == "" {
+			dir = defaultArtifactDir
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		backend = localArtifactBackend{dir: dir}
+		log.Printf("Storing artifacts in %s", dir)
+	case "s3":
+		s3, err := newS3ArtifactBackend()
+		if err != nil {
+			return err
+		}
+		backend = s3
+		log.Printf("Storing artifacts in bucket %s at %s", s3.bucket, s3.endpoint.Host)
+	default:
+		return fmt.Errorf("ARTIFACT_STORAGE must be local or s3, not %q", storage)
+	}
+
+	urlKey, err := artifactURLKey()
+	if err != nil {
+		return err
+	}
+	artifacts = &artifactStorage{
+		backend:   backend,
+		urlKey:    urlKey,
+		retention: time.Duration(envInt("ARTIFACT_RETENTION_HOURS", defaultArtifactRetentionHours)) * time.Hour,
+		urlTTL:    time.Duration(envInt("ARTIFACT_URL_TTL_MINUTES", defaultArtifactURLMinutes)) * time.Minute,
+	}
+	go func() {
+		for {
+			artifacts.collect()
+			time.Sleep(artifactGCInterval)
+		}
+	}()
+	return nil
+}
+
+// artifactURLKey returns the key download links are signed with: ARTIFACT_URL_SECRET, else
+// a key derived from the JWT secret. It fails without either, since links signed with a
+// per-process key would break on restart and differ between instances.
+func artifactURLKey() ([]byte, error) {
+	if secret := os.Getenv("ARTIFACT_URL_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	if jwtSecret != "" {
+		mac := hmac.New(sha256.New, []byte(jwtSecret))
+		mac.Write([]byte("artifact download links"))
+		return mac.Sum(nil), nil
+	}
+	return nil, errors.New("ARTIFACT_URL_SECRET is required when JWT_SECRET is not set")
+}
+
+// newArtifactID returns a random opaque artifact ID
+func newArtifactID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// artifactFile is a generated file being written. It is kept in a temporary file until
+// Save stores it, or Discard drops it.
+type artifactFile struct {
+	f    *os.File
+	name string
+}
+
+// Create starts a generated file offered for download under name
+func (s *artifactStorage) Create(name string) (*artifactFile, error) {
+	f, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		return nil, err
+	}
+	return &artifactFile{f: f, name: name}, nil
+}
+
+func (a *artifactFile) Write(p []byte) (int, error) {
+	return a.f.Write(p)
+}
+
+// Name is the file name the artifact is downloaded as
+func (a *artifactFile) Name() string {
+	return a.name
+}
+
+// Discard removes the temporary file
+func (a *artifactFile) Discard() {
+	a.f.Close()
+	os.Remove(a.f.Name())
+}
+
+// Save stores the file for a company until expires. The row is written before the upload,
+// so an interrupted upload leaves a row for the collector rather than an untracked object.
+func (s *artifactStorage) Save(a *artifactFile, companyID int, userID *int, kind string, expires time.Time) (*Artifact, error) {
+	defer a.Discard()
+	size, err := a.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	id, err := newArtifactID()
+	if err != nil {
+		return nil, err
+	}
+	art := &Artifact{
+		ID:          id,
+		CompanyID:   companyID,
+		UserID:      userID,
+		Kind:        kind,
+		Filename:    a.name,
+		ContentType: artifactContentType(a.name),
+		Size:        size,
+		StorageKey:  fmt.Sprintf("%d/%s", companyID, id),
+		CreatedAt:   time.Now(),
+		ExpiresAt:   expires,
+	}
+	_, err = db.Exec(`
+		INSERT INTO artifacts (id, company_id, user_id, kind, filename, content_type, size, storage_key, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		art.ID, art.CompanyID, art.UserID, art.Kind, art.Filename, art.ContentType, art.Size, art.StorageKey,
+		art.CreatedAt, art.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.backend.Put(art.StorageKey, a.f, size, art.ContentType); err != nil {
+		if _, delErr := db.Exec("DELETE FROM artifacts WHERE id = ?", art.ID); delErr != nil {
+			log.Printf("Error removing artifact %s after a failed upload: %v", art.ID, delErr)
+		}
+		return nil, err
+	}
+	return art, nil
+}
+
+// artifactColumns lists the artifact columns read by the storage, in scan order
+const artifactColumns = "id, company_id, user_id, kind, filename, content_type, size, storage_key, created_at, expires_at"
+
+// find returns the unexpired artifact matching a condition
+func (s *artifactStorage) find(cond string, args ...interface{}) (*Artifact, error) {
+	var a Artifact
+	err := db.QueryRow(fmt.Sprintf("SELECT %s FROM artifacts WHERE %s AND expires_at > NOW()", artifactColumns, cond), args...).
+		Scan(&a.ID, &a.CompanyID, &a.UserID, &a.Kind, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey,
+			&a.CreatedAt, &a.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, errArtifactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Get returns an unexpired artifact of a company
+func (s *artifactStorage) Get(companyID int, id string) (*Artifact, error) {
+	return s.find("id = ? AND company_id = ?", id, companyID)
+}
+
+// getLinked returns an unexpired artifact whatever its company, for signed links whose
+// signature already proves access
+func (s *artifactStorage) getLinked(id string) (*Artifact, error) {
+	return s.find("id = ?", id)
+}
+
+// signature is the hex HMAC of a link's path and expiry
+func (s *artifactStorage) signature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, s.urlKey)
+	fmt.Fprintf(mac, "%s\n%d", path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignLink adds an expiry and signature to a path, so it works without authentication
+// until the link expires
+func (s *artifactStorage) SignLink(path string) (string, time.Time) {
+	expires := time.Now().Add(s.urlTTL).Truncate(time.Second)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", s.signature(path, expires.Unix()))
+	return path + "?" + query.Encode(), expires
+}
+
+// SignedURL returns a download link for an artifact
+func (s *artifactStorage) SignedURL(id string) (string, time.Time) {
+	return s.SignLink(fmt.Sprintf("/api/artifacts/%s/download", url.PathEscape(id)))
+}
+
+// SignCompanyLink is SignLink for a path serving one company's data. The company is a
+// signed parameter of the link, so the handler can look the data up within that company.
+func (s *artifactStorage) SignCompanyLink(path string, companyID int) (string, time.Time) {
+	expires := time.Now().Add(s.urlTTL).Truncate(time.Second)
+	query := url.Values{}
+	query.Set("company", strconv.Itoa(companyID))
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", s.signature(companyLinkPath(path, companyID), expires.Unix()))
+	return path + "?" + query.Encode(), expires
+}
+
+// companyLinkPath is the path a company link's signature covers
+func companyLinkPath(path string, companyID int) string {
+	return fmt.Sprintf("%s?company=%d", path, companyID)
+}
+
+// VerifyLink checks the expiry and signature of the link a request was made with
+func (s *artifactStorage) VerifyLink(c *gin.Context) error {
+	return s.verify(c, c.Request.URL.Path)
+}
+
+// VerifyCompanyLink checks a link made by SignCompanyLink and returns its company
+func (s *artifactStorage) VerifyCompanyLink(c *gin.Context) (int, error) {
+	companyID, err := strconv.Atoi(c.Query("company"))
+	if err != nil || companyID <= 0 {
+		return 0, errArtifactSignature
+	}
+	if err := s.verify(c, companyLinkPath(c.Request.URL.Path, companyID)); err != nil {
+		return 0, err
+	}
+	return companyID, nil
+}
+
+// verify checks the expiry of a link and its signature over a path
+func (s *artifactStorage) verify(c *gin.Context, path string) error {
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return errArtifactSignature
+	}
+	expected := s.signature(path, expires)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(c.Query("signature"))) != 1 {
+		return errArtifactSignature
+	}
+	return nil
+}
+
+// Serve streams an artifact as a download
+func (s *artifactStorage) Serve(c *gin.Context, a *Artifact) {
+	body, err := s.backend.Get(a.StorageKey)
+	if err != nil {
+		artifactError(c, err, "reading")
+		return
+	}
+	defer body.Close()
+	c.DataFromReader(http.StatusOK, a.Size, a.ContentType, body, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", a.Filename),
+		"Cache-Control":       "private, no-store",
+	})
+}
+
+// collect deletes expired artifacts from the backend and the artifacts table
+func (s *artifactStorage) collect() {
+	rows, err := db.Query("SELECT id, storage_key FROM artifacts WHERE expires_at < NOW() LIMIT ?", artifactGCBatchSize)
+	if err != nil {
+		log.Printf("Error fetching expired artifacts: %v", err)
+		return
+	}
+	type expired struct {
+		id, key string
+	}
+	var batch []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.key); err != nil {
+			log.Printf("Error reading expired artifact: %v", err)
+			break
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+
+	deleted := 0
+	for _, e := range batch {
+		if err := s.backend.Delete(e.key); err != nil {
+			log.Printf("Error deleting artifact %s from storage: %v", e.id, err)
+			continue
+		}
+		if _, err := db.Exec("DELETE FROM artifacts WHERE id = ?", e.id); err != nil {
+			log.Printf("Error deleting artifact %s: %v", e.id, err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d expired artifacts", deleted)
+	}
+}
+
+// saveArtifact writes a generated file with write and stores it for the request's company
+// until the artifact retention passes, writing 500 when that fails
+func saveArtifact(c *gin.Context, kind, name string, write func(w io.Writer) error) (*Artifact, bool) {
+	fail := func(err error) (*Artifact, bool) {
+		log.Printf("Error saving %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return nil, false
+	}
+	f, err := artifacts.Create(name)
+	if err != nil {
+		return fail(err)
+	}
+	if err := write(f); err != nil {
+		f.Discard()
+		return fail(err)
+	}
+	var userID *int
+	if id := getCurrentUserID(c); id != 0 {
+		userID = &id
+	}
+	art, err := artifacts.Save(f, getCurrentCompanyID(c), userID, kind, time.Now().Add(artifacts.retention))
+	if err != nil {
+		return fail(err)
+	}
+	return art, true
+}
+
+// artifactResponse describes a stored artifact with a fresh download link
+func artifactResponse(a *Artifact) gin.H {
+	link, linkExpires := artifacts.SignedURL(a.ID)
+	return gin.H{
+		"artifactId":     a.ID,
+		"filename":       a.Filename,
+		"contentType":    a.ContentType,
+		"size":           a.Size,
+		"downloadUrl":    link,
+		"downloadExpiry": linkExpires,
+		"expiresAt":      a.ExpiresAt,
+	}
+}
+
+// artifactError writes the response for a failed artifact lookup or download
+func artifactError(c *gin.Context, err error, action string) {
+	switch err {
+	case errArtifactNotFound:
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	case errArtifactSignature:
+		c.JSON(http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	default:
+		log.Printf("Error %s artifact: %v", action, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+}
+
+// getArtifactHandler returns an artifact of the current company with a fresh download link
+func getArtifactHandler(c *gin.Context) {
+	art, err := artifacts.Get(getCurrentCompanyID(c), c.Param("id"))
+	if err != nil {
+		artifactError(c, err, "fetching")
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    artifactResponse(art),
+	})
+}
+
+// downloadArtifactHandler serves an artifact through a signed link, without authentication
+func downloadArtifactHandler(c *gin.Context) {
+	if err := artifacts.VerifyLink(c); err != nil {
+		artifactError(c, err, "verifying")
+		return
+	}
+	art, err := artifacts.getLinked(c.Param("id"))
+	if err != nil {
+		artifactError(c, err, "fetching")
+		return
+	}
+	artifacts.Serve(c, art)
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestArtifactURLKeyRequiresSecret(t *testing.T) {
+	saved := jwtSecret
+	defer func() { jwtSecret = saved }()
+
+	t.Setenv("ARTIFACT_URL_SECRET", "")
+	jwtSecret = ""
+	if _, err := artifactURLKey(); err == nil {
+		t.Fatal("artifactURLKey without secrets: want an error")
+	}
+
+	jwtSecret = "jwt-secret"
+	fromJWT, err := artifactURLKey()
+	if err != nil || len(fromJWT) == 0 {
+		t.Fatalf("artifactURLKey from JWT_SECRET: %v", err)
+	}
+	again, _ := artifactURLKey()
+	if string(again) != string(fromJWT) {
+		t.Fatal("key derived from JWT_SECRET is not stable")
+	}
+
+	t.Setenv("ARTIFACT_URL_SECRET", "link-secret")
+	if key, err := artifactURLKey(); err != nil || string(key) != "link-secret" {
+		t.Fatalf("artifactURLKey = %q, %v; want ARTIFACT_URL_SECRET", key, err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errInvalidCursor is returned when a page cursor is malformed or was issued for another sort
+var errInvalidCursor = errors.New("invalid cursor")
+
+const (
+	defaultAssetPageSize = 50
+	maxAssetPageSize     = 500
+)
+
+// AssetSort is one column of a multi-column sort
+type AssetSort struct {
+	Column string
+	Desc   bool
+}
+
+// AssetPageRequest selects one page of assets, either by page number or by cursor
+type AssetPageRequest struct {
+	Page     int
+	PageSize int
+	Cursor   string
+	Sort     []AssetSort
+}
+
+// AssetPage is one page of assets plus the information needed to fetch the next one
+type AssetPage struct {
+	Assets     []Asset
+	Total      int
+	NextCursor string
+}
+
+// assetSortKey describes a sortable column: the NULL-free SQL expression used for
+// ORDER BY and keyset comparisons, and how to read the same value from an Asset
+type assetSortKey struct {
+	expr  string
+	value func(a Asset) interface{}
+}
+
+// assetSortKeys are the columns clients may sort by
+var assetSortKeys = map[string]assetSortKey{
+	"id":               {"id", func(a Asset) interface{} { return a.ID }},
+	"asset_name":       {"asset_name", func(a Asset) interface{} { return a.AssetName }},
+	"asset_type":       {"COALESCE(asset_type, '')", func(a Asset) interface{} { return safeString(a.AssetType) }},
+	"institution_name": {"COALESCE(institution_name, '')", func(a Asset) interface{} { return safeString(a.InstitutionName) }},
+	"department":       {"COALESCE(department, '')", func(a Asset) interface{} { return safeString(a.Department) }},
+	"functional_area":  {"COALESCE(functional_area, '')", func(a Asset) interface{} { return safeString(a.FunctionalArea) }},
+	"manufacturer":     {"COALESCE(manufacturer, '')", func(a Asset) interface{} { return safeString(a.Manufacturer) }},
+	"model_number":     {"COALESCE(model_number, '')", func(a Asset) interface{} { return safeString(a.ModelNumber) }},
+	"serial_number":    {"COALESCE(serial_number, '')", func(a Asset) interface{} { return safeString(a.SerialNumber) }},
+	"location":         {"COALESCE(location, '')", func(a Asset) interface{} { return safeString(a.Location) }},
+	"status":           {"COALESCE(status, '')", func(a Asset) interface{} { return a.Status }},
+	"purchase_date": {"COALESCE(purchase_date, '1000-01-01')", func(a Asset) interface{} {
+		if a.PurchaseDate == nil {
+			return "1000-01-01"
+		}
+		return a.PurchaseDate.Format("2006-01-02")
+	}},
+	"purchase_price": {"COALESCE(purchase_price, -1)", func(a Asset) interface{} {
+		if a.PurchasePrice == nil {
+			return -1.0
+		}
+		return *a.PurchasePrice
+	}},
+	"created_at": {"created_at", func(a Asset) interface{} { return a.CreatedAt.Format("2006-01-02 15:04:05.999999") }},
+	"updated_at": {"updated_at", func(a Asset) interface{} { return a.UpdatedAt.Format("2006-01-02 15:04:05.999999") }},
+}
+
+// assetCursor is the decoded form of an opaque page cursor: the sort values of the
+// last row of the previous page, in sort order, ending with its ID
+type assetCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+// sortSignature identifies a sort so cursors cannot be replayed against a different one
+func sortSignature(sorts []AssetSort) string {
+	parts := make([]string, len(sorts))
+	for i, s := range sorts {
+		if s.Desc {
+			parts[i] = "-" + s.Column
+		} else {
+			parts[i] = s.Column
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// withIDTiebreaker appends id to a sort so that every row has a unique position
+func withIDTiebreaker(sorts []AssetSort) []AssetSort {
+	for _, s := range sorts {
+		if s.Column == "id" {
+			return sorts
+		}
+	}
+	return append(append([]AssetSort{}, sorts...), AssetSort{Column: "id"})
+}
+
+// encodeAssetCursor builds the cursor pointing just after the given asset
+func encodeAssetCursor(sorts []AssetSort, last Asset) string {
+	cursor := assetCursor{Sort: sortSignature(sorts)}
+	for _, s := range sorts {
+		cursor.Values = append(cursor.Values, assetSortKeys[s.Column].value(last))
+	}
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeAssetCursor parses a cursor and checks it was issued for the same sort
+func decodeAssetCursor(sorts []AssetSort, encoded string) (assetCursor, error) {
+	var cursor assetCursor
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, errInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return cursor, errInvalidCursor
+	}
+	if cursor.Sort != sortSignature(sorts) || len(cursor.Values) != len(sorts) {
+		return cursor, fmt.Errorf("%w: cursor does not match the requested sort", errInvalidCursor)
+	}
+	return cursor, nil
+}
+
+// keysetCondition builds the WHERE fragment selecting rows strictly after the cursor:
+// (a > ?) OR (a = ? AND b < ?) OR ... with the comparison flipped for descending columns
+func keysetCondition(sorts []AssetSort, values []interface{}) (string, []interface{}) {
+	var disjuncts []string
+	var args []interface{}
+	for i, s := range sorts {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, assetSortKeys[sorts[j].Column].expr+" = ?")
+			args = append(args, values[j])
+		}
+		op := ">"
+		if s.Desc {
+			op = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s ?", assetSortKeys[s.Column].expr, op))
+		args = append(args, values[i])
+		disjuncts = append(disjuncts, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(disjuncts, " OR ") + ")", args
+}
+
+// orderByClause renders a sort as an ORDER BY expression list
+func orderByClause(sorts []AssetSort) string {
+	parts := make([]string, len(sorts))
+	for i, s := range sorts {
+		parts[i] = assetSortKeys[s.Column].expr
+		if s.Desc {
+			parts[i] += " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseAssetSort parses "asset_name,-purchase_date" into sort columns
+func parseAssetSort(raw string) ([]AssetSort, error) {
+	var sorts []AssetSort
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		sort := AssetSort{Column: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if _, ok := assetSortKeys[sort.Column]; !ok {
+			return nil, fmt.Errorf("cannot sort by %s", sort.Column)
+		}
+		sorts = append(sorts, sort)
+	}
+	return sorts, nil
+}
+
+// parseAssetListQuery reads the filter, sort and pagination parameters of GET /api/assets
+func parseAssetListQuery(c *gin.Context) (AssetFilter, AssetPageRequest, error) {
+	filter := AssetFilter{
+		AssetName:       c.Query("asset_name"),
+		AssetType:       c.Query("asset_type"),
+		InstitutionName: c.Query("institution_name"),
+		Department:      c.Query("department"),
+		FunctionalArea:  c.Query("functional_area"),
+		Manufacturers:   c.QueryArray("manufacturer"),
+		ModelNumber:     c.Query("model_number"),
+		SerialNumber:    c.Query("serial_number"),
+		Location:        c.Query("location"),
+		Status:          c.Query("status"),
+		PurchasedFrom:   c.Query("purchase_date_from"),
+		PurchasedTo:     c.Query("purchase_date_to"),
+		Notes:           c.Query("notes"),
+	}
+	page := AssetPageRequest{Page: 1, PageSize: defaultAssetPageSize, Cursor: c.Query("cursor")}
+
+	if v := c.Query("purchase_date"); v != "" {
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			return filter, page, fmt.Errorf("purchase_date must be YYYY-MM-DD")
+		}
+		filter.PurchasedFrom, filter.PurchasedTo = v, v
+	}
+	for _, name := range []string{"purchase_date_from", "purchase_date_to"} {
+		if v := c.Query(name); v != "" {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				return filter, page, fmt.Errorf("%s must be YYYY-MM-DD", name)
+			}
+		}
+	}
+
+	intParams := map[string]**int{"category_id": &filter.CategoryID, "assigned_to": &filter.AssignedTo}
+	for name, target := range intParams {
+		if v := c.Query(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return filter, page, fmt.Errorf("%s must be an integer", name)
+			}
+			*target = &n
+		}
+	}
+
+	floatParams := map[string]**float64{
+		"purchase_price":     &filter.PurchasePrice,
+		"purchase_price_min": &filter.PurchasePriceMin,
+		"purchase_price_max": &filter.PurchasePriceMax,
+	}
+	for name, target := range floatParams {
+		if v := c.Query(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return filter, page, fmt.Errorf("%s must be a number", name)
+			}
+			*target = &f
+		}
+	}
+
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return filter, page, fmt.Errorf("page must be a positive integer")
+		}
+		page.Page = n
+	}
+	if v := c.Query("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAssetPageSize {
+			return filter, page, fmt.Errorf("page_size must be between 1 and %d", maxAssetPageSize)
+		}
+		page.PageSize = n
+	}
+
+	sorts, err := parseAssetSort(c.Query("sort"))
+	if err != nil {
+		return filter, page, err
+	}
+	page.Sort = sorts
+
+	return filter, page, nil
+}
@@ -0,0 +1,972 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errNoTenant is returned when an asset store is requested without an authenticated company
+var errNoTenant = errors.New("no company in request context")
+
+// errUnscopedQuery is returned for a store query that does not bind the store's company
+var errUnscopedQuery = errors.New("query is not scoped to the store's company")
+
+// AssetStore is the tenant-scoped data access layer for the assets table.
+// Every read and write is restricted to the company the store was created for.
+type AssetStore interface {
+	CompanyID() int
+	List(filter AssetFilter) ([]Asset, error)
+	Each(filter AssetFilter, fn func(Asset) error) error
+	Count(filter AssetFilter) (int, error)
+	Get(id int) (*Asset, error)
+	ListPage(filter AssetFilter, page AssetPageRequest) (AssetPage, error)
+	GetMany(ids []int) ([]Asset, error)
+	Create(asset *Asset) (int64, error)
+	CreateMany(assets []Asset) ([]int64, error)
+	Update(asset *Asset) error
+	Delete(id int) error
+	Restore(id int) error
+	Purge(id int) error
+	History(assetID int) ([]AssetHistoryEntry, error)
+	Search(q AssetSearchQuery) (AssetSearchResult, error)
+	SerialNumbersInUse(serials []string) (map[string]bool, error)
+	DistinctValues(column string) ([]string, error)
+	GroupCount(column string) (map[string]int, error)
+	DepartmentCounts() ([]DepartmentCount, error)
+	TotalValue() (float64, error)
+	TotalBookValue() (float64, error)
+	SetDepreciation(id int, settings DepreciationSettingsRequest) error
+	SetPrimaryPhoto(id int, attachmentID *int) error
+	AssignMissingTags() (int, error)
+	CustomFields() ([]CustomField, error)
+	ValidateCustomFields(asset *Asset) error
+	CustomFieldFilters(raw map[string]string) ([]CustomFieldFilter, error)
+	CategoryIDs() (map[string]int, error)
+}
+
+// AssetFilter describes the optional conditions applied to asset queries
+type AssetFilter struct {
+	AssetName        string // substring match
+	AssetType        string
+	CategoryID       *int
+	InstitutionName  string
+	Department       string
+	FunctionalArea   string
+	Manufacturers    []string
+	ModelNumber      string
+	SerialNumber     string
+	Location         string
+	Status           string
+	PurchasedFrom    string
+	PurchasedTo      string
+	PurchasePrice    *float64
+	PurchasePriceMin *float64
+	PurchasePriceMax *float64
+	AssignedTo       *int
+	Notes            string // substring match
+	UpdatedSince     *time.Time
+	HasBarcode       bool
+	CustomFields     []CustomFieldFilter
+	Trashed          bool       // only assets in the trash instead of only live ones
+	TrashedSince     *time.Time // also assets moved to the trash at or after this time
+	OrderBy          string
+	Limit            int
+}
+
+// assetColumns lists the asset columns read by the store, in scan order
+const assetColumns = `id, company_id, asset_name, asset_type, category_id, institution_name, department,
+	functional_area, manufacturer, model_number, serial_number, location, status, purchase_date,
+	purchase_price, assigned_to, notes, barcode, qr_code, created_at, updated_at, deleted_at, deleted_by,
+	depreciation_method, useful_life_years, salvage_value, primary_photo_id, custom_fields`
+
+// assetGroupColumns are the columns that may be used for DISTINCT and GROUP BY queries
+var assetGroupColumns = map[string]bool{
+	"asset_type":       true,
+	"institution_name": true,
+	"department":       true,
+	"functional_area":  true,
+	"manufacturer":     true,
+	"location":         true,
+	"status":           true,
+}
+
+// assetOrderColumns are the ORDER BY clauses callers may request
+var assetOrderColumns = map[string]string{
+	"":                "id",
+	"id":              "id",
+	"asset_name":      "asset_name",
+	"created_at_desc": "created_at DESC",
+	"institution":     "institution_name, department, id",
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAsset reads one row selected with assetColumns, followed by any extra selected columns
+func scanAsset(row rowScanner, extra ...interface{}) (Asset, error) {
+	var asset Asset
+	dest := []interface{}{
+		&asset.ID, &asset.CompanyID, &asset.AssetName, &asset.AssetType, &asset.CategoryID,
+		&asset.InstitutionName, &asset.Department, &asset.FunctionalArea, &asset.Manufacturer,
+		&asset.ModelNumber, &asset.SerialNumber, &asset.Location, &asset.Status, &asset.PurchaseDate,
+		&asset.PurchasePrice, &asset.AssignedTo, &asset.Notes, &asset.Barcode, &asset.QRCode,
+		&asset.CreatedAt, &asset.UpdatedAt, &asset.DeletedAt, &asset.DeletedBy,
+		&asset.DepreciationMethod, &asset.UsefulLifeYears, &asset.SalvageValue, &asset.PrimaryPhotoID,
+		&asset.CustomFields,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return asset, err
+}
+
+// auditActor identifies who makes changes through a store, for the asset history
+type auditActor struct {
+	UserID    int
+	RequestID string
+}
+
+// sqlAssetStore is the MySQL implementation of AssetStore
+type sqlAssetStore struct {
+	// conn is only used through query, queryRow, exec and inTx, which check that every
+	// statement is restricted to companyID
+	conn      *sql.DB
+	companyID int
+	actor     auditActor
+
+	// categoryDefaults caches the company's category depreciation defaults, see depreciation.go
+	categoryDefaults map[int]categoryDepreciation
+
+	// assetTagPattern caches the company's tag pattern, see asset_tags.go
+	assetTagPattern string
+
+	// customFields caches the company's custom field definitions, see custom_fields.go
+	customFields []CustomField
+}
+
+// newSQLAssetStore creates an AssetStore bound to a single company
+func newSQLAssetStore(conn *sql.DB, companyID int, actor auditActor) (AssetStore, error) {
+	store, err := newSQLAssetStoreImpl(conn, companyID, actor)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// newSQLAssetStoreImpl is newSQLAssetStore returning the concrete type, for stores of
+// related tables that must change assets inside their own transactions
+func newSQLAssetStoreImpl(conn *sql.DB, companyID int, actor auditActor) (*sqlAssetStore, error) {
+	if companyID <= 0 {
+		return nil, errNoTenant
+	}
+	return &sqlAssetStore{conn: conn, companyID: companyID, actor: actor}, nil
+}
+
+// requestActor identifies the user and request making changes
+func requestActor(c *gin.Context) auditActor {
+	return auditActor{
+		UserID:    getCurrentUserID(c),
+		RequestID: getRequestID(c),
+	}
+}
+
+// assetStoreFor returns the AssetStore for the authenticated company of the request
+func assetStoreFor(c *gin.Context) (AssetStore, error) {
+	return newSQLAssetStore(db, getCurrentCompanyID(c), requestActor(c))
+}
+
+// sqlAssetStoreFor is assetStoreFor returning the concrete type, for the stores of related
+// tables. Handlers reach the database only through these stores.
+func sqlAssetStoreFor(c *gin.Context) (*sqlAssetStore, error) {
+	return newSQLAssetStoreImpl(db, getCurrentCompanyID(c), requestActor(c))
+}
+
+// requireAssetStore resolves the request's AssetStore or aborts with 401
+func requireAssetStore(c *gin.Context) (AssetStore, bool) {
+	store, err := assetStoreFor(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Company context required",
+		})
+		c.Abort()
+		return nil, false
+	}
+	return store, true
+}
+
+// CompanyID returns the company the store is scoped to
+func (s *sqlAssetStore) CompanyID() int {
+	return s.companyID
+}
+
+// checkScoped makes sure a statement is restricted to the store's company: the first
+// "company_id = ?" condition, or an INSERT's leading company_id column, must be bound to
+// companyID. Stores of related tables run their SQL through query, queryRow and exec, so a
+// statement that forgets the tenant condition fails instead of reaching other companies.
+func (s *sqlAssetStore) checkScoped(query string, args []interface{}) error {
+	index := -1
+	if at := strings.Index(query, "company_id = ?"); at >= 0 {
+		index = strings.Count(query[:at], "?")
+	} else if strings.HasPrefix(strings.TrimSpace(query), "INSERT") &&
+		strings.Contains(strings.Join(strings.Fields(query), " "), "(company_id,") {
+		index = 0
+	}
+	if index < 0 || index >= len(args) {
+		return errUnscopedQuery
+	}
+	if id, ok := args[index].(int); !ok || id != s.companyID {
+		return errUnscopedQuery
+	}
+	return nil
+}
+
+// query runs a SELECT restricted to the store's company, see checkScoped
+func (s *sqlAssetStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	if err := s.checkScoped(query, args); err != nil {
+		return nil, err
+	}
+	return s.conn.Query(query, args...)
+}
+
+// errRow is a rowScanner that fails with an error, like a *sql.Row whose query failed
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...interface{}) error {
+	return r.err
+}
+
+// queryRow runs a single-row SELECT restricted to the store's company, see checkScoped
+func (s *sqlAssetStore) queryRow(query string, args ...interface{}) rowScanner {
+	if err := s.checkScoped(query, args); err != nil {
+		return errRow{err}
+	}
+	return s.conn.QueryRow(query, args...)
+}
+
+// exec runs a statement restricted to the store's company, see checkScoped
+func (s *sqlAssetStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	if err := s.checkScoped(query, args); err != nil {
+		return nil, err
+	}
+	return s.conn.Exec(query, args...)
+}
+
+// where builds the WHERE clause for a filter, always starting with the tenant condition
+func (s *sqlAssetStore) where(filter AssetFilter) (string, []interface{}) {
+	clause := "company_id = ? AND deleted_at IS NULL"
+	args := []interface{}{s.companyID}
+	if filter.Trashed {
+		clause = "company_id = ? AND deleted_at IS NOT NULL"
+	} else if filter.TrashedSince != nil {
+		clause = "company_id = ? AND (deleted_at IS NULL OR deleted_at >= ?)"
+		args = append(args, *filter.TrashedSince)
+	}
+
+	add := func(cond string, value interface{}) {
+		clause += " AND " + cond
+		args = append(args, value)
+	}
+
+	if filter.AssetName != "" {
+		add("asset_name LIKE ?", "%"+filter.AssetName+"%")
+	}
+	if filter.AssetType != "" {
+		add("asset_type = ?", filter.AssetType)
+	}
+	if filter.CategoryID != nil {
+		add("category_id = ?", *filter.CategoryID)
+	}
+	if filter.InstitutionName != "" {
+		add("institution_name = ?", filter.InstitutionName)
+	}
+	if filter.Department != "" {
+		add("department = ?", filter.Department)
+	}
+	if filter.FunctionalArea != "" {
+		add("functional_area = ?", filter.FunctionalArea)
+	}
+	if len(filter.Manufacturers) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(filter.Manufacturers)), ",")
+		clause += fmt.Sprintf(" AND manufacturer IN (%s)", placeholders)
+		for _, m := range filter.Manufacturers {
+			args = append(args, m)
+		}
+	}
+	if filter.ModelNumber != "" {
+		add("model_number = ?", filter.ModelNumber)
+	}
+	if filter.SerialNumber != "" {
+		add("serial_number = ?", filter.SerialNumber)
+	}
+	if filter.Location != "" {
+		add("location = ?", filter.Location)
+	}
+	if filter.Status != "" {
+		add("status = ?", filter.Status)
+	}
+	if filter.PurchasedFrom != "" {
+		add("purchase_date >= ?", filter.PurchasedFrom)
+	}
+	if filter.PurchasedTo != "" {
+		add("purchase_date <= ?", filter.PurchasedTo)
+	}
+	if filter.PurchasePrice != nil {
+		add("purchase_price = ?", *filter.PurchasePrice)
+	}
+	if filter.PurchasePriceMin != nil {
+		add("purchase_price >= ?", *filter.PurchasePriceMin)
+	}
+	if filter.PurchasePriceMax != nil {
+		add("purchase_price <= ?", *filter.PurchasePriceMax)
+	}
+	if filter.AssignedTo != nil {
+		add("assigned_to = ?", *filter.AssignedTo)
+	}
+	if filter.Notes != "" {
+		add("notes LIKE ?", "%"+filter.Notes+"%")
+	}
+	if filter.UpdatedSince != nil {
+		add("updated_at > ?", *filter.UpdatedSince)
+	}
+	if filter.HasBarcode {
+		clause += " AND (barcode IS NOT NULL OR qr_code IS NOT NULL)"
+	}
+	for _, f := range filter.CustomFields {
+		cond, condArgs := f.condition()
+		clause += " AND " + cond
+		args = append(args, condArgs...)
+	}
+
+	return clause, args
+}
+
+// queryAssets runs a SELECT over assetColumns and scans every row
+func (s *sqlAssetStore) queryAssets(query string, args ...interface{}) ([]Asset, error) {
+	defaults, err := s.depreciationDefaults()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assets []Asset
+	for rows.Next() {
+		asset, err := scanAsset(rows)
+		if err != nil {
+			return nil, err
+		}
+		applyDepreciation(&asset, defaults)
+		assets = append(assets, asset)
+	}
+	return assets, rows.Err()
+}
+
+// List returns the company's assets matching the filter
+func (s *sqlAssetStore) List(filter AssetFilter) ([]Asset, error) {
+	orderBy, ok := assetOrderColumns[filter.OrderBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported order: %s", filter.OrderBy)
+	}
+
+	clause, args := s.where(filter)
+	query := fmt.Sprintf("SELECT %s FROM assets WHERE %s ORDER BY %s", assetColumns, clause, orderBy)
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+	return s.queryAssets(query, args...)
+}
+
+// Each calls fn for every asset matching the filter, reading rows one at a time
+// so that large result sets are never loaded into memory. It stops at fn's first error.
+func (s *sqlAssetStore) Each(filter AssetFilter, fn func(Asset) error) error {
+	orderBy, ok := assetOrderColumns[filter.OrderBy]
+	if !ok {
+		return fmt.Errorf("unsupported order: %s", filter.OrderBy)
+	}
+	clause, args := s.where(filter)
+	query := fmt.Sprintf("SELECT %s FROM assets WHERE %s ORDER BY %s", assetColumns, clause, orderBy)
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	defaults, err := s.depreciationDefaults()
+	if err != nil {
+		return err
+	}
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		asset, err := scanAsset(rows)
+		if err != nil {
+			return err
+		}
+		applyDepreciation(&asset, defaults)
+		if err := fn(asset); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListPage returns one page of the company's assets matching the filter.
+// When a cursor is given the page number is ignored and keyset pagination is used.
+func (s *sqlAssetStore) ListPage(filter AssetFilter, page AssetPageRequest) (AssetPage, error) {
+	var result AssetPage
+
+	total, err := s.Count(filter)
+	if err != nil {
+		return result, err
+	}
+	result.Total = total
+
+	sorts := withIDTiebreaker(page.Sort)
+	clause, args := s.where(filter)
+
+	if page.Cursor != "" {
+		cursor, err := decodeAssetCursor(sorts, page.Cursor)
+		if err != nil {
+			return result, err
+		}
+		cond, condArgs := keysetCondition(sorts, cursor.Values)
+		clause += " AND " + cond
+		args = append(args, condArgs...)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM assets WHERE %s ORDER BY %s LIMIT ?", assetColumns, clause, orderByClause(sorts))
+	args = append(args, page.PageSize+1)
+	if page.Cursor == "" {
+		query += " OFFSET ?"
+		args = append(args, (page.Page-1)*page.PageSize)
+	}
+
+	assets, err := s.queryAssets(query, args...)
+	if err != nil {
+		return result, err
+	}
+
+	// One extra row was fetched to learn whether another page exists
+	if len(assets) > page.PageSize {
+		assets = assets[:page.PageSize]
+		result.NextCursor = encodeAssetCursor(sorts, assets[len(assets)-1])
+	}
+	result.Assets = assets
+
+	return result, nil
+}
+
+// Count returns the number of the company's assets matching the filter
+func (s *sqlAssetStore) Count(filter AssetFilter) (int, error) {
+	clause, args := s.where(filter)
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM assets WHERE "+clause, args...).Scan(&count)
+	return count, err
+}
+
+// Get returns a single asset, or sql.ErrNoRows if it does not belong to the company
+func (s *sqlAssetStore) Get(id int) (*Asset, error) {
+	row := s.queryRow(
+		fmt.Sprintf("SELECT %s FROM assets WHERE id = ? AND company_id = ? AND deleted_at IS NULL", assetColumns),
+		id, s.companyID)
+	asset, err := scanAsset(row)
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := s.depreciationDefaults()
+	if err != nil {
+		return nil, err
+	}
+	applyDepreciation(&asset, defaults)
+	return &asset, nil
+}
+
+// GetMany returns the company's assets among the given IDs; foreign IDs are silently dropped
+func (s *sqlAssetStore) GetMany(ids []int) ([]Asset, error) {
+	if len(ids) == 0 {
+		return []Asset{}, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, s.companyID)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM assets WHERE company_id = ? AND deleted_at IS NULL AND id IN (%s) ORDER BY id", assetColumns, placeholders)
+	return s.queryAssets(query, args...)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertAsset writes a new asset row owned by the store's company
+func (s *sqlAssetStore) insertAsset(conn execer, asset *Asset) (int64, error) {
+	now := time.Now()
+	asset.CompanyID = s.companyID
+	result, err := conn.Exec(`
+		INSERT INTO assets (company_id, asset_name, asset_type, category_id, institution_name, department,
+		functional_area, manufacturer, model_number, serial_number, location, status, purchase_date,
+		purchase_price, assigned_to, notes, custom_fields, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.companyID, asset.AssetName, asset.AssetType, asset.CategoryID, asset.InstitutionName, asset.Department,
+		asset.FunctionalArea, asset.Manufacturer, asset.ModelNumber, asset.SerialNumber, asset.Location,
+		asset.Status, asset.PurchaseDate, asset.PurchasePrice, asset.AssignedTo, asset.Notes, asset.CustomFields,
+		now, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// inTx runs fn in a transaction, committing only if it succeeds
+func (s *sqlAssetStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getForUpdate reads and locks a live or, when trashed is set, a trashed asset of the
+// company inside a transaction
+func (s *sqlAssetStore) getForUpdate(tx *sql.Tx, id int, trashed bool) (*Asset, error) {
+	state := "deleted_at IS NULL"
+	if trashed {
+		state = "deleted_at IS NOT NULL"
+	}
+	row := tx.QueryRow(
+		fmt.Sprintf("SELECT %s FROM assets WHERE id = ? AND company_id = ? AND %s FOR UPDATE", assetColumns, state),
+		id, s.companyID)
+	asset, err := scanAsset(row)
+	if err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
+
+// createInTx inserts an asset with its permanent tag and its "create" history entry
+func (s *sqlAssetStore) createInTx(tx *sql.Tx, asset *Asset) (int64, error) {
+	asset.Barcode, asset.QRCode = nil, nil
+	id, err := s.insertAsset(tx, asset)
+	if err != nil {
+		return 0, err
+	}
+	asset.ID = int(id)
+	if err := s.assignTagInTx(tx, asset); err != nil {
+		return 0, err
+	}
+	return id, s.recordHistory(tx, asset.ID, nil, asset)
+}
+
+// Create inserts an asset for the company; any CompanyID on the input is ignored
+func (s *sqlAssetStore) Create(asset *Asset) (int64, error) {
+	var id int64
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		id, err = s.createInTx(tx, asset)
+		return err
+	})
+	return id, err
+}
+
+// CreateMany inserts several assets in one transaction
+func (s *sqlAssetStore) CreateMany(assets []Asset) ([]int64, error) {
+	ids := make([]int64, 0, len(assets))
+	err := s.inTx(func(tx *sql.Tx) error {
+		for i := range assets {
+			id, err := s.createInTx(tx, &assets[i])
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Update overwrites an existing asset of the company, or returns sql.ErrNoRows
+func (s *sqlAssetStore) Update(asset *Asset) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		before, err := s.getForUpdate(tx, asset.ID, false)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE assets SET asset_name = ?, asset_type = ?, category_id = ?, institution_name = ?, department = ?,
+			functional_area = ?, manufacturer = ?, model_number = ?, serial_number = ?, location = ?,
+			status = ?, purchase_date = ?, purchase_price = ?, assigned_to = ?, notes = ?, custom_fields = ?,
+			updated_at = ?
+			WHERE id = ? AND company_id = ?`,
+			asset.AssetName, asset.AssetType, asset.CategoryID, asset.InstitutionName, asset.Department,
+			asset.FunctionalArea, asset.Manufacturer, asset.ModelNumber, asset.SerialNumber, asset.Location,
+			asset.Status, asset.PurchaseDate, asset.PurchasePrice, asset.AssignedTo, asset.Notes, asset.CustomFields,
+			time.Now(), asset.ID, s.companyID)
+		if err != nil {
+			return err
+		}
+
+		// Columns the UPDATE does not touch keep their stored values
+		after := *asset
+		after.Barcode, after.QRCode = before.Barcode, before.QRCode
+		after.DepreciationMethod, after.UsefulLifeYears, after.SalvageValue =
+			before.DepreciationMethod, before.UsefulLifeYears, before.SalvageValue
+		after.PrimaryPhotoID = before.PrimaryPhotoID
+		after.DeletedAt, after.DeletedBy = nil, nil
+		return s.recordHistory(tx, asset.ID, before, &after)
+	})
+}
+
+// setStatusInTx changes the status of an asset locked with getForUpdate, inside the
+// caller's transaction, and records the change in the asset history
+func (s *sqlAssetStore) setStatusInTx(tx *sql.Tx, asset *Asset, status string) error {
+	if asset.Status == status {
+		return nil
+	}
+	_, err := tx.Exec("UPDATE assets SET status = ?, updated_at = ? WHERE id = ? AND company_id = ?",
+		status, time.Now(), asset.ID, s.companyID)
+	if err != nil {
+		return err
+	}
+
+	after := *asset
+	after.Status = status
+	if err := s.recordHistory(tx, asset.ID, asset, &after); err != nil {
+		return err
+	}
+	asset.Status = status
+	return nil
+}
+
+// setAssigneeInTx changes the assignee of an asset locked with getForUpdate, inside the
+// caller's transaction, and records the change in the asset history
+func (s *sqlAssetStore) setAssigneeInTx(tx *sql.Tx, asset *Asset, userID *int) error {
+	_, err := tx.Exec("UPDATE assets SET assigned_to = ?, updated_at = ? WHERE id = ? AND company_id = ?",
+		userID, time.Now(), asset.ID, s.companyID)
+	if err != nil {
+		return err
+	}
+
+	after := *asset
+	after.AssignedTo = userID
+	if err := s.recordHistory(tx, asset.ID, asset, &after); err != nil {
+		return err
+	}
+	asset.AssignedTo = userID
+	return nil
+}
+
+// Delete moves an asset of the company to the trash, or returns sql.ErrNoRows. Work on
+// the asset ends with it: its open assignment is returned and its open work orders are
+// cancelled, so a restored asset is available again.
+func (s *sqlAssetStore) Delete(id int) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		before, err := s.getForUpdate(tx, id, false)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().Truncate(time.Second)
+		var deletedBy *int
+		if s.actor.UserID > 0 {
+			deletedBy = &s.actor.UserID
+		}
+		after := *before
+		after.DeletedAt, after.DeletedBy = &now, deletedBy
+		if err := s.endOpenWorkInTx(tx, &after, now); err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE assets SET deleted_at = ?, deleted_by = ? WHERE id = ? AND company_id = ?",
+			now, deletedBy, id, s.companyID)
+		if err != nil {
+			return err
+		}
+		return s.recordHistory(tx, id, before, &after)
+	})
+}
+
+// endOpenWorkInTx returns the open assignment of an asset being trashed and cancels its
+// open work orders, updating asset's assignee and status to match
+func (s *sqlAssetStore) endOpenWorkInTx(tx *sql.Tx, asset *Asset, now time.Time) error {
+	var returnedBy interface{}
+	if s.actor.UserID > 0 {
+		returnedBy = s.actor.UserID
+	}
+	_, err := tx.Exec(`
+		UPDATE asset_assignments SET returned_at = ?, returned_by = ?, return_notes = ?
+		WHERE company_id = ? AND asset_id = ? AND returned_at IS NULL`,
+		now, returnedBy, "Returned when the asset was moved to the trash", s.companyID, asset.ID)
+	if err != nil {
+		return err
+	}
+
+	// An asset put into maintenance by its work orders gets back its earlier status
+	status, previous := asset.Status, asset.Status
+	if status == assetStatusMaintenance {
+		if previous, err = statusBeforeMaintenanceInTx(tx, s.companyID, asset.ID); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec("UPDATE asset_maintenance SET status = ?, completed_at = ? WHERE company_id = ? AND asset_id = ? AND status = ?",
+		workOrderCancelled, now, s.companyID, asset.ID, workOrderOpen)
+	if err != nil {
+		return err
+	}
+	if cancelled, err := result.RowsAffected(); err != nil {
+		return err
+	} else if cancelled > 0 {
+		status = previous
+	}
+
+	if asset.AssignedTo == nil && status == asset.Status {
+		return nil
+	}
+	_, err = tx.Exec("UPDATE assets SET assigned_to = NULL, status = ? WHERE id = ? AND company_id = ?",
+		status, asset.ID, s.companyID)
+	asset.AssignedTo, asset.Status = nil, status
+	return err
+}
+
+// Restore takes a trashed asset of the company out of the trash, or returns sql.ErrNoRows
+func (s *sqlAssetStore) Restore(id int) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		before, err := s.getForUpdate(tx, id, true)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE assets SET deleted_at = NULL, deleted_by = NULL WHERE id = ? AND company_id = ?",
+			id, s.companyID)
+		if err != nil {
+			return err
+		}
+
+		after := *before
+		after.DeletedAt, after.DeletedBy = nil, nil
+		return s.recordHistory(tx, id, before, &after)
+	})
+}
+
+// Purge permanently removes a trashed asset of the company, or returns sql.ErrNoRows.
+// Live assets must be deleted first.
+func (s *sqlAssetStore) Purge(id int) error {
+	var files []string
+	err := s.inTx(func(tx *sql.Tx) error {
+		before, err := s.getForUpdate(tx, id, true)
+		if err != nil {
+			return err
+		}
+		// The attachment rows go with the asset; their files are removed once that is committed
+		if files, err = attachmentFilesInTx(tx, s.companyID, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM assets WHERE id = ? AND company_id = ?", id, s.companyID); err != nil {
+			return err
+		}
+		return s.recordHistory(tx, id, before, nil)
+	})
+	if err != nil {
+		return err
+	}
+	removeAttachmentFiles(files)
+	return nil
+}
+
+// SerialNumbersInUse reports which of the given serial numbers already belong to the company's
+// assets. Trashed assets count, since restoring one would otherwise create a duplicate.
+func (s *sqlAssetStore) SerialNumbersInUse(serials []string) (map[string]bool, error) {
+	inUse := make(map[string]bool)
+	const batchSize = 500
+	for start := 0; start < len(serials); start += batchSize {
+		end := start + batchSize
+		if end > len(serials) {
+			end = len(serials)
+		}
+		batch := serials[start:end]
+
+		args := []interface{}{s.companyID}
+		for _, serial := range batch {
+			args = append(args, serial)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		rows, err := s.query(fmt.Sprintf(
+			"SELECT serial_number FROM assets WHERE company_id = ? AND serial_number IN (%s)", placeholders), args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var serial string
+			if err := rows.Scan(&serial); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			inUse[serial] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inUse, nil
+}
+
+// DistinctValues returns the non-empty distinct values of a column for the company
+func (s *sqlAssetStore) DistinctValues(column string) ([]string, error) {
+	if !assetGroupColumns[column] {
+		return nil, fmt.Errorf("unsupported column: %s", column)
+	}
+
+	rows, err := s.query(fmt.Sprintf(
+		"SELECT DISTINCT %s FROM assets WHERE company_id = ? AND deleted_at IS NULL AND %s IS NOT NULL AND %s != '' ORDER BY %s",
+		column, column, column, column), s.companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// GroupCount returns the number of the company's assets per value of a column
+func (s *sqlAssetStore) GroupCount(column string) (map[string]int, error) {
+	if !assetGroupColumns[column] {
+		return nil, fmt.Errorf("unsupported column: %s", column)
+	}
+
+	rows, err := s.query(fmt.Sprintf(
+		"SELECT %s, COUNT(*) FROM assets WHERE company_id = ? AND deleted_at IS NULL AND %s IS NOT NULL GROUP BY %s",
+		column, column, column), s.companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var value string
+		var count int
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		counts[value] = count
+	}
+	return counts, rows.Err()
+}
+
+// DepartmentCount is the number of assets of one institution and department. Missing
+// names are "".
+type DepartmentCount struct {
+	Institution string
+	Department  string
+	Count       int
+}
+
+// DepartmentCounts returns the number of the company's assets per institution and
+// department, in the order of the "institution" AssetFilter ordering
+func (s *sqlAssetStore) DepartmentCounts() ([]DepartmentCount, error) {
+	rows, err := s.query(`
+		SELECT COALESCE(institution_name, ''), COALESCE(department, ''), COUNT(*)
+		FROM assets WHERE company_id = ? AND deleted_at IS NULL
+		GROUP BY 1, 2 ORDER BY 1, 2`, s.companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []DepartmentCount{}
+	for rows.Next() {
+		var dc DepartmentCount
+		if err := rows.Scan(&dc.Institution, &dc.Department, &dc.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, dc)
+	}
+	return counts, rows.Err()
+}
+
+// TotalValue returns the sum of purchase prices of the company's assets
+func (s *sqlAssetStore) TotalValue() (float64, error) {
+	var total float64
+	err := s.queryRow(
+		"SELECT COALESCE(SUM(purchase_price), 0) FROM assets WHERE company_id = ? AND deleted_at IS NULL AND purchase_price IS NOT NULL",
+		s.companyID).Scan(&total)
+	return total, err
+}
+
+// formatDate formats an optional date as YYYY-MM-DD, or "" when unset
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// assetResponse renders an asset in the camelCase shape used by the legacy frontend
+func assetResponse(asset Asset) gin.H {
+	return gin.H{
+		"id":              asset.ID,
+		"assetName":       asset.AssetName,
+		"assetType":       asset.AssetType,
+		"institutionName": asset.InstitutionName,
+		"department":      asset.Department,
+		"functionalArea":  asset.FunctionalArea,
+		"manufacturer":    asset.Manufacturer,
+		"modelNumber":     asset.ModelNumber,
+		"serialNumber":    asset.SerialNumber,
+		"location":        asset.Location,
+		"status":          asset.Status,
+		"purchaseDate":    formatDate(asset.PurchaseDate),
+		"purchasePrice":   asset.PurchasePrice,
+		"bookValue":       asset.BookValue,
+		"createdAt":       asset.CreatedAt.Format("2006-01-02 15:04:05"),
+		"updatedAt":       asset.UpdatedAt.Format("2006-01-02 15:04:05"),
+		"primaryPhotoId":  asset.PrimaryPhotoID,
+		"customFields":    asset.customFieldsOrEmpty(),
+	}
+}
+
+// customFieldsOrEmpty returns the asset's custom field values, never nil, so responses
+// always carry an object
+func (a Asset) customFieldsOrEmpty() customFieldValues {
+	if a.CustomFields == nil {
+		return customFieldValues{}
+	}
+	return a.CustomFields
+}
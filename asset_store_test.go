@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// The tests below use a store for ownCompany and asset foreignAssetID, which belongs to
+// otherCompany. The mock database answers a query the way MySQL would: rows come back
+// only when the query binds the asset's own company.
+const (
+	ownCompany     = 1
+	otherCompany   = 2
+	foreignAssetID = 42
+)
+
+func newMockAssetStore(t *testing.T, companyID int) (*sqlAssetStore, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("opening mock database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	store, err := newSQLAssetStoreImpl(conn, companyID, auditActor{UserID: 7})
+	if err != nil {
+		t.Fatalf("creating store: %v", err)
+	}
+	return store, mock
+}
+
+// assetRows returns an empty result with the columns of assetColumns
+func assetRows() *sqlmock.Rows {
+	var columns []string
+	for _, column := range strings.Split(assetColumns, ",") {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+	return sqlmock.NewRows(columns)
+}
+
+// assetRow returns the values of an asset row, in the order of assetColumns
+func assetRow(id, companyID int) []driver.Value {
+	row := make([]driver.Value, len(strings.Split(assetColumns, ",")))
+	row[0], row[1], row[2], row[12] = id, companyID, "Laptop", "Active"
+	row[19], row[20] = time.Now(), time.Now()
+	return row
+}
+
+func categoryDefaultRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "depreciation_method", "useful_life_years", "salvage_percent"})
+}
+
+// expectLockedLookup expects the row lock every write starts with, bound to companyID. The
+// foreign asset is only found when companyID is its own company.
+func expectLockedLookup(mock sqlmock.Sqlmock, companyID int, trashed bool) {
+	state := "deleted_at IS NULL"
+	if trashed {
+		state = "deleted_at IS NOT NULL"
+	}
+	rows := assetRows()
+	if companyID == otherCompany {
+		rows.AddRow(assetRow(foreignAssetID, otherCompany)...)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM assets WHERE id = ? AND company_id = ? AND "+state+" FOR UPDATE")).
+		WithArgs(foreignAssetID, companyID).
+		WillReturnRows(rows)
+}
+
+func checkMock(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAssetStoreRequiresCompany(t *testing.T) {
+	if _, err := newSQLAssetStore(nil, 0, auditActor{}); err != errNoTenant {
+		t.Fatalf("store without company: got %v, want errNoTenant", err)
+	}
+}
+
+func TestAssetStoreListIsScoped(t *testing.T) {
+	store, mock := newMockAssetStore(t, ownCompany)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM asset_categories WHERE company_id = ?")).
+		WithArgs(ownCompany).
+		WillReturnRows(categoryDefaultRows())
+	mock.ExpectQuery(regexp.QuoteMeta("FROM assets WHERE company_id = ? AND deleted_at IS NULL")).
+		WithArgs(ownCompany).
+		WillReturnRows(assetRows())
+
+	assets, err := store.List(AssetFilter{})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(assets) != 0 {
+		t.Fatalf("List returned %d assets of another company", len(assets))
+	}
+	checkMock(t, mock)
+}
+
+func TestAssetStoreGetForeignAsset(t *testing.T) {
+	store, mock := newMockAssetStore(t, ownCompany)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM assets WHERE id = ? AND company_id = ? AND deleted_at IS NULL")).
+		WithArgs(foreignAssetID, ownCompany).
+		WillReturnRows(assetRows())
+
+	if _, err := store.Get(foreignAssetID); err != sql.ErrNoRows {
+		t.Fatalf("Get: got %v, want sql.ErrNoRows", err)
+	}
+	checkMock(t, mock)
+}
+
+func TestAssetStoreGetManyDropsForeignAssets(t *testing.T) {
+	store, mock := newMockAssetStore(t, ownCompany)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM asset_categories WHERE company_id = ?")).
+		WithArgs(ownCompany).
+		WillReturnRows(categoryDefaultRows())
+	mock.ExpectQuery(regexp.QuoteMeta("FROM assets WHERE company_id = ? AND deleted_at IS NULL AND id IN (?)")).
+		WithArgs(ownCompany, foreignAssetID).
+		WillReturnRows(assetRows())
+
+	assets, err := store.GetMany([]int{foreignAssetID})
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if len(assets) != 0 {
+		t.Fatalf("GetMany returned %d assets of another company", len(assets))
+	}
+	checkMock(t, mock)
+}
+
+// TestAssetStoreWritesToForeignAsset checks that every write stops at the company-scoped
+// row lock: sqlmock fails on any UPDATE or DELETE that was not expected, so passing
+// expectations also prove that no row was changed.
+func TestAssetStoreWritesToForeignAsset(t *testing.T) {
+	writes := []struct {
+		name    string
+		trashed bool
+		write   func(s *sqlAssetStore) error
+	}{
+		{"Update", false, func(s *sqlAssetStore) error {
+			return s.Update(&Asset{ID: foreignAssetID, AssetName: "Taken over", Status: "Active"})
+		}},
+		{"Delete", false, func(s *sqlAssetStore) error { return s.Delete(foreignAssetID) }},
+		{"Restore", true, func(s *sqlAssetStore) error { return s.Restore(foreignAssetID) }},
+		{"Purge", true, func(s *sqlAssetStore) error { return s.Purge(foreignAssetID) }},
+	}
+	for _, w := range writes {
+		t.Run(w.name, func(t *testing.T) {
+			store, mock := newMockAssetStore(t, ownCompany)
+			expectLockedLookup(mock, ownCompany, w.trashed)
+			mock.ExpectRollback()
+
+			if err := w.write(store); err != sql.ErrNoRows {
+				t.Fatalf("%s: got %v, want sql.ErrNoRows", w.name, err)
+			}
+			checkMock(t, mock)
+		})
+	}
+}
+
+// TestAssetStoreDeleteOwnAsset is the counterpart of the test above: the owning company's
+// store finds the asset and changes it, scoped to that company.
+func TestAssetStoreDeleteOwnAsset(t *testing.T) {
+	store, mock := newMockAssetStore(t, otherCompany)
+	expectLockedLookup(mock, otherCompany, false)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE asset_assignments SET returned_at = ?")).
+		WithArgs(sqlmock.AnyArg(), 7, sqlmock.AnyArg(), otherCompany, foreignAssetID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE asset_maintenance SET status = ?")).
+		WithArgs(workOrderCancelled, sqlmock.AnyArg(), otherCompany, foreignAssetID, workOrderOpen).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE assets SET deleted_at = ?, deleted_by = ? WHERE id = ? AND company_id = ?")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), foreignAssetID, otherCompany).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO asset_history")).
+		WithArgs(otherCompany, foreignAssetID, "delete", sqlmock.AnyArg(), 7, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := store.Delete(foreignAssetID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	checkMock(t, mock)
+}
+
+func TestCheckScoped(t *testing.T) {
+	store, mock := newMockAssetStore(t, ownCompany)
+	tests := []struct {
+		query string
+		args  []interface{}
+		ok    bool
+	}{
+		{"SELECT id FROM asset_scans WHERE company_id = ? AND asset_id = ?", []interface{}{ownCompany, 3}, true},
+		{"SELECT id FROM asset_maintenance WHERE id = ? AND company_id = ?", []interface{}{3, ownCompany}, true},
+		{"SELECT id FROM asset_maintenance WHERE id = ? AND company_id = ?", []interface{}{3, otherCompany}, false},
+		{"SELECT id FROM asset_maintenance WHERE id = ?", []interface{}{3}, false},
+		{"SELECT id FROM assets WHERE company_id = ?", nil, false},
+		{"INSERT INTO asset_scans (company_id, asset_id) VALUES (?, ?)", []interface{}{ownCompany, 3}, true},
+		{"INSERT INTO asset_scans (company_id, asset_id) VALUES (?, ?)", []interface{}{otherCompany, 3}, false},
+		{"INSERT INTO asset_scans (asset_id, company_id) VALUES (?, ?)", []interface{}{3, ownCompany}, false},
+	}
+	for _, tt := range tests {
+		if err := store.checkScoped(tt.query, tt.args); (err == nil) != tt.ok {
+			t.Errorf("checkScoped(%q, %v) = %v, want ok=%v", tt.query, tt.args, err, tt.ok)
+		}
+	}
+
+	// Unscoped statements never reach the database
+	if _, err := store.exec("DELETE FROM asset_scans WHERE id = ?", 3); err != errUnscopedQuery {
+		t.Errorf("exec: got %v, want errUnscopedQuery", err)
+	}
+	var count int
+	if err := store.queryRow("SELECT COUNT(*) FROM asset_scans WHERE company_id = ?", otherCompany).Scan(&count); err != errUnscopedQuery {
+		t.Errorf("queryRow: got %v, want errUnscopedQuery", err)
+	}
+	checkMock(t, mock)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// assetTagPatternSetting is the company_settings key holding the company's tag pattern
+	assetTagPatternSetting = "asset_tag_pattern"
+
+	// defaultAssetTagPattern produces tags like UOT-COM-000042
+	defaultAssetTagPattern = "{INST}-{TYPE}-{SEQ:6}"
+
+	// defaultTagSequenceWidth is the zero padding of a bare {SEQ}
+	defaultTagSequenceWidth = 4
+
+	// maxAssetTagLength keeps tags short enough for a Code 128 label
+	maxAssetTagLength = 32
+
+	// tagSequenceMark stands in for the sequence number in tag prefixes
+	tagSequenceMark = "#"
+)
+
+// tagTokenRegexp matches the placeholders of a tag pattern, e.g. {INST} or {SEQ:6}
+var tagTokenRegexp = regexp.MustCompile(`\{([A-Z]+)(?::([0-9]+))?\}`)
+
+// tagLiteralRegexp lists the characters allowed between placeholders; all of them are
+// Code 39 safe, so tags print in every linear symbology
+var tagLiteralRegexp = regexp.MustCompile(`^[A-Z0-9./-]*$`)
+
+// tagTokenWidths is the longest value each non-sequence placeholder expands to
+var tagTokenWidths = map[string]int{
+	"INST": 5, // getInstitutionInitials
+	"TYPE": 3, // getShortForm
+	"YEAR": 4,
+}
+
+// validateAssetTagPattern checks a pattern has exactly one {SEQ} and only known
+// placeholders, and that its tags stay within maxAssetTagLength
+func validateAssetTagPattern(pattern string) error {
+	length, sequences := 0, 0
+	rest := pattern
+	for _, m := range tagTokenRegexp.FindAllStringSubmatchIndex(pattern, -1) {
+		name := pattern[m[2]:m[3]]
+		if name == "SEQ" {
+			sequences++
+			width := defaultTagSequenceWidth
+			if m[4] >= 0 {
+				width, _ = strconv.Atoi(pattern[m[4]:m[5]])
+				if width < 1 || width > 10 {
+					return errors.New("{SEQ:n} width must be between 1 and 10")
+				}
+			}
+			length += width
+		} else if width, ok := tagTokenWidths[name]; ok && m[4] < 0 {
+			length += width
+		} else {
+			return fmt.Errorf("unknown placeholder %s", pattern[m[0]:m[1]])
+		}
+		rest = strings.Replace(rest, pattern[m[0]:m[1]], "", 1)
+	}
+	if sequences != 1 {
+		return errors.New("tag pattern must contain exactly one {SEQ} or {SEQ:n}")
+	}
+	if !tagLiteralRegexp.MatchString(rest) {
+		return errors.New("tag pattern text may only use A-Z, 0-9, '-', '.' and '/'")
+	}
+	if length+len(rest) > maxAssetTagLength {
+		return fmt.Errorf("tags from this pattern can exceed %d characters", maxAssetTagLength)
+	}
+	return nil
+}
+
+// tagSegment reduces a placeholder value to upper-case letters and digits
+func tagSegment(value string) string {
+	segment := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, value)
+	if segment == "" {
+		return "UNK"
+	}
+	return segment
+}
+
+// renderTagPrefix expands every placeholder of a pattern except the sequence, which is
+// left as tagSequenceMark. Assets whose prefixes are equal share a sequence.
+func renderTagPrefix(pattern string, asset Asset, now time.Time) (prefix string, width int) {
+	width = defaultTagSequenceWidth
+	prefix = tagTokenRegexp.ReplaceAllStringFunc(pattern, func(token string) string {
+		m := tagTokenRegexp.FindStringSubmatch(token)
+		switch m[1] {
+		case "INST":
+			return tagSegment(getInstitutionInitials(safeString(asset.InstitutionName)))
+		case "TYPE":
+			return tagSegment(getShortForm(safeString(asset.AssetType)))
+		case "YEAR":
+			return strconv.Itoa(now.Year())
+		case "SEQ":
+			if m[2] != "" {
+				width, _ = strconv.Atoi(m[2])
+			}
+			return tagSequenceMark
+		}
+		return token
+	})
+	return prefix, width
+}
+
+// tagPattern returns the company's tag pattern, read once per store
+func (s *sqlAssetStore) tagPattern() (string, error) {
+	if s.assetTagPattern != "" {
+		return s.assetTagPattern, nil
+	}
+	pattern, ok, err := getCompanySetting(s.companyID, assetTagPatternSetting)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		pattern = defaultAssetTagPattern
+	}
+	s.assetTagPattern = pattern
+	return pattern, nil
+}
+
+// nextTagNumber bumps and returns the sequence of a tag prefix. The row stays locked
+// until the caller's transaction ends, so numbers are handed out without gaps.
+func (s *sqlAssetStore) nextTagNumber(tx *sql.Tx, prefix string) (int64, error) {
+	_, err := tx.Exec(`
+		INSERT INTO asset_tag_sequences (company_id, prefix, last_value) VALUES (?, ?, 1)
+		ON DUPLICATE KEY UPDATE last_value = last_value + 1`, s.companyID, prefix)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	err = tx.QueryRow("SELECT last_value FROM asset_tag_sequences WHERE company_id = ? AND prefix = ?",
+		s.companyID, prefix).Scan(&n)
+	return n, err
+}
+
+// assignTagInTx gives an inserted asset without a tag its permanent barcode and QR code.
+// The QR payload starts with the legacy "ID:" field so existing scanners keep working.
+func (s *sqlAssetStore) assignTagInTx(tx *sql.Tx, asset *Asset) error {
+	if asset.Barcode != nil {
+		return nil
+	}
+	pattern, err := s.tagPattern()
+	if err != nil {
+		return err
+	}
+	prefix, width := renderTagPrefix(pattern, *asset, time.Now())
+	n, err := s.nextTagNumber(tx, prefix)
+	if err != nil {
+		return err
+	}
+	tag := strings.Replace(prefix, tagSequenceMark, fmt.Sprintf("%0*d", width, n), 1)
+	qrCode := fmt.Sprintf("ID:%d|Tag:%s", asset.ID, tag)
+
+	_, err = tx.Exec("UPDATE assets SET barcode = ?, qr_code = ? WHERE id = ? AND company_id = ? AND barcode IS NULL",
+		tag, qrCode, asset.ID, s.companyID)
+	if err != nil {
+		return err
+	}
+	asset.Barcode, asset.QRCode = &tag, &qrCode
+	return nil
+}
+
+// AssignMissingTags tags the company's assets created before tags were assigned, oldest
+// first, and returns how many were tagged
+func (s *sqlAssetStore) AssignMissingTags() (int, error) {
+	tagged := 0
+	err := s.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(
+			fmt.Sprintf("SELECT %s FROM assets WHERE company_id = ? AND barcode IS NULL ORDER BY id FOR UPDATE", assetColumns),
+			s.companyID)
+		if err != nil {
+			return err
+		}
+		var assets []Asset
+		for rows.Next() {
+			asset, err := scanAsset(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			assets = append(assets, asset)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for i := range assets {
+			before := assets[i]
+			if err := s.assignTagInTx(tx, &assets[i]); err != nil {
+				return err
+			}
+			if err := s.recordHistory(tx, assets[i].ID, &before, &assets[i]); err != nil {
+				return err
+			}
+		}
+		tagged = len(assets)
+		return nil
+	})
+	return tagged, err
+}
+
+// assignAssetTagsHandler tags all untagged assets of the company (admin only)
+func assignAssetTagsHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
+	tagged, err := store.AssignMissingTags()
+	if err != nil {
+		log.Printf("Error assigning asset tags: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Tagged %d assets", tagged),
+		Data:    gin.H{"tagged": tagged},
+	})
+}
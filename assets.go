@@ -2,103 +2,84 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// getAssetsHandler returns all assets
-func getAssetsHandler(c *gin.Context) {
-	rows, err := db.Query("SELECT id, asset_name, asset_type, institution_name, department, functional_area, manufacturer, model_number, serial_number, location, status, purchase_date, purchase_price, created_at, updated_at FROM assets")
-	if err != nil {
-		log.Printf("Error fetching assets: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Internal Server Error",
-		})
-		return
+// optionalString returns nil for empty strings so they are stored as NULL
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// assetFromRequest converts a legacy AssetRequest into an Asset
+func assetFromRequest(req AssetRequest) (Asset, error) {
+	asset := Asset{
+		AssetName:       req.AssetName,
+		AssetType:       optionalString(req.AssetType),
+		InstitutionName: optionalString(req.InstitutionName),
+		Department:      optionalString(req.Department),
+		FunctionalArea:  optionalString(req.FunctionalArea),
+		Manufacturer:    optionalString(req.Manufacturer),
+		ModelNumber:     optionalString(req.ModelNumber),
+		SerialNumber:    optionalString(req.SerialNumber),
+		Location:        optionalString(req.Location),
+		Status:          req.Status,
+		PurchasePrice:   &req.PurchasePrice,
+		CustomFields:    customFieldValues(req.CustomFields),
 	}
-	defer rows.Close()
 
-	var assets []gin.H
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
+	if req.PurchaseDate != "" {
+		purchaseDate, err := time.Parse("2006-01-02", req.PurchaseDate)
 		if err != nil {
-			log.Printf("Error scanning asset: %v", err)
-			continue
+			return asset, err
 		}
-
-		assets = append(assets, gin.H{
-			"id":              asset.ID,
-			"assetName":       asset.AssetName,
-			"assetType":       asset.AssetType,
-			"institutionName": asset.InstitutionName,
-			"department":      asset.Department,
-			"functionalArea":  asset.FunctionalArea,
-			"manufacturer":    asset.Manufacturer,
-			"modelNumber":     asset.ModelNumber,
-			"serialNumber":    asset.SerialNumber,
-			"location":        asset.Location,
-			"status":          asset.Status,
-			"purchaseDate":    asset.PurchaseDate.Format("2006-01-02"),
-			"purchasePrice":   asset.PurchasePrice,
-			"createdAt":       asset.CreatedAt.Format("2006-01-02 15:04:05"),
-			"updatedAt":       asset.UpdatedAt.Format("2006-01-02 15:04:05"),
-		})
+		asset.PurchaseDate = &purchaseDate
 	}
 
-	c.JSON(http.StatusOK, assets)
+	return asset, nil
 }
 
-// addAssetHandler creates a new asset
-func addAssetHandler(c *gin.Context) {
-	var req AssetRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+// getAssetsHandler returns one page of the current company's assets.
+// Supports page/page_size or cursor pagination, ?sort=col,-col, a filter per asset field
+// and custom_fields[key]=value filters.
+func getAssetsHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
+	filter, pageReq, err := parseAssetListQuery(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Invalid input data",
+			Error:   err.Error(),
 		})
 		return
 	}
+	if filter.CustomFields, ok = requireCustomFieldFilters(c, store, c.QueryMap("custom_fields")); !ok {
+		return
+	}
 
-	// Get the current company ID from the authenticated user
-	companyID := getCurrentCompanyID(c)
-
-	// Parse purchase date
-	var purchaseDate time.Time
-	var err error
-	if req.PurchaseDate != "" {
-		purchaseDate, err = time.Parse("2006-01-02", req.PurchaseDate)
-		if err != nil {
+	page, err := store.ListPage(filter, pageReq)
+	if err != nil {
+		if errors.Is(err, errInvalidCursor) {
 			c.JSON(http.StatusBadRequest, APIResponse{
 				Success: false,
-				Error:   "Invalid purchase date format",
+				Error:   err.Error(),
 			})
 			return
 		}
-	}
-
-	// Insert the new asset
-	result, err := db.Exec(`
-		INSERT INTO assets (asset_name, asset_type, institution_name, department, functional_area, 
-		manufacturer, model_number, serial_number, location, status, purchase_date, purchase_price, 
-		created_at, updated_at, company_id) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		req.AssetName, req.AssetType, req.InstitutionName, req.Department, req.FunctionalArea,
-		req.Manufacturer, req.ModelNumber, req.SerialNumber, req.Location, req.Status,
-		purchaseDate, req.PurchasePrice, time.Now(), time.Now(), companyID)
-
-	if err != nil {
-		log.Printf("Error adding asset: %v", err)
+		log.Printf("Error fetching assets: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   "Internal Server Error",
@@ -106,26 +87,33 @@ func addAssetHandler(c *gin.Context) {
 		return
 	}
 
-	assetID, _ := result.LastInsertId()
+	response := []gin.H{}
+	for _, asset := range page.Assets {
+		response = append(response, assetResponse(asset))
+	}
+
+	pageNumber := pageReq.Page
+	if pageReq.Cursor != "" {
+		pageNumber = 0
+	}
 
-	c.JSON(http.StatusCreated, APIResponse{
+	c.JSON(http.StatusOK, APIResponse{
 		Success: true,
-		Message: "Asset added successfully",
-		Data: gin.H{
-			"assetId": assetID,
+		Data: PaginatedResponse{
+			Data:       response,
+			Total:      page.Total,
+			Page:       pageNumber,
+			PageSize:   pageReq.PageSize,
+			TotalPages: (page.Total + pageReq.PageSize - 1) / pageReq.PageSize,
+			NextCursor: page.NextCursor,
 		},
 	})
 }
 
-// updateAssetHandler updates an existing asset
-func updateAssetHandler(c *gin.Context) {
-	id := c.Param("id")
-	assetID, err := strconv.Atoi(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, APIResponse{
-			Success: false,
-			Error:   "Invalid asset ID",
-		})
+// addAssetHandler creates a new asset
+func addAssetHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
 		return
 	}
 
@@ -138,58 +126,21 @@ func updateAssetHandler(c *gin.Context) {
 		return
 	}
 
-	// Parse purchase date
-	var purchaseDate time.Time
-	if req.PurchaseDate != "" {
-		purchaseDate, err = time.Parse("2006-01-02", req.PurchaseDate)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, APIResponse{
-				Success: false,
-				Error:   "Invalid purchase date format",
-			})
-			return
-		}
-	}
-
-	// Update the asset
-	_, err = db.Exec(`
-		UPDATE assets SET asset_name = ?, asset_type = ?, institution_name = ?, department = ?, 
-		functional_area = ?, manufacturer = ?, model_number = ?, serial_number = ?, location = ?, 
-		status = ?, purchase_date = ?, purchase_price = ?, updated_at = ? WHERE id = ?`,
-		req.AssetName, req.AssetType, req.InstitutionName, req.Department, req.FunctionalArea,
-		req.Manufacturer, req.ModelNumber, req.SerialNumber, req.Location, req.Status,
-		purchaseDate, req.PurchasePrice, time.Now(), assetID)
-
+	asset, err := assetFromRequest(req)
 	if err != nil {
-		log.Printf("Error updating asset: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
+		c.JSON(http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Internal Server Error",
+			Error:   "Invalid purchase date format",
 		})
 		return
 	}
-
-	c.JSON(http.StatusOK, APIResponse{
-		Success: true,
-		Message: "Asset updated successfully",
-	})
-}
-
-// deleteAssetHandler deletes an asset
-func deleteAssetHandler(c *gin.Context) {
-	id := c.Param("id")
-	assetID, err := strconv.Atoi(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, APIResponse{
-			Success: false,
-			Error:   "Invalid asset ID",
-		})
+	if !requireValidCustomFields(c, store, &asset) {
 		return
 	}
 
-	_, err = db.Exec("DELETE FROM assets WHERE id = ?", assetID)
+	assetID, err := store.Create(&asset)
 	if err != nil {
-		log.Printf("Error deleting asset: %v", err)
+		log.Printf("Error adding asset: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   "Internal Server Error",
@@ -197,102 +148,53 @@ func deleteAssetHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, APIResponse{
+	c.JSON(http.StatusCreated, APIResponse{
 		Success: true,
-		Message: "Asset deleted successfully",
+		Message: "Asset added successfully",
+		Data: gin.H{
+			"assetId": assetID,
+		},
 	})
 }
 
-// searchAssetsHandler searches for assets
-func searchAssetsHandler(c *gin.Context) {
-	query := c.Query("query")
-	if query == "" {
-		c.JSON(http.StatusBadRequest, APIResponse{
-			Success: false,
-			Error:   "Search query is required",
-		})
+// updateAssetHandler updates an existing asset
+func updateAssetHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
 		return
 	}
 
-	searchQuery := "%" + query + "%"
-	rows, err := db.Query(`
-		SELECT id, asset_name, asset_type, institution_name, department, functional_area, 
-		manufacturer, model_number, serial_number, location, status, purchase_date, 
-		purchase_price, created_at, updated_at 
-		FROM assets 
-		WHERE asset_name LIKE ? OR asset_type LIKE ? OR institution_name LIKE ? OR 
-		department LIKE ? OR manufacturer LIKE ? OR model_number LIKE ? OR 
-		serial_number LIKE ? OR location LIKE ?`,
-		searchQuery, searchQuery, searchQuery, searchQuery, searchQuery, searchQuery, searchQuery, searchQuery)
-
+	assetID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		log.Printf("Error searching assets: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
+		c.JSON(http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Internal Server Error",
+			Error:   "Invalid asset ID",
 		})
 		return
 	}
-	defer rows.Close()
-
-	var assets []gin.H
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
-		if err != nil {
-			log.Printf("Error scanning asset: %v", err)
-			continue
-		}
 
-		assets = append(assets, gin.H{
-			"id":              asset.ID,
-			"assetName":       asset.AssetName,
-			"assetType":       asset.AssetType,
-			"institutionName": asset.InstitutionName,
-			"department":      asset.Department,
-			"functionalArea":  asset.FunctionalArea,
-			"manufacturer":    asset.Manufacturer,
-			"modelNumber":     asset.ModelNumber,
-			"serialNumber":    asset.SerialNumber,
-			"location":        asset.Location,
-			"status":          asset.Status,
-			"purchaseDate":    asset.PurchaseDate.Format("2006-01-02"),
-			"purchasePrice":   asset.PurchasePrice,
-			"createdAt":       asset.CreatedAt.Format("2006-01-02 15:04:05"),
-			"updatedAt":       asset.UpdatedAt.Format("2006-01-02 15:04:05"),
+	var req AssetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid input data",
 		})
+		return
 	}
 
-	c.JSON(http.StatusOK, assets)
-}
-
-// getAssetDetailsHandler returns details of a specific asset
-func getAssetDetailsHandler(c *gin.Context) {
-	assetIDStr := c.Query("assetId")
-	assetID, err := strconv.Atoi(assetIDStr)
+	asset, err := assetFromRequest(req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Invalid asset ID",
+			Error:   "Invalid purchase date format",
 		})
 		return
 	}
+	asset.ID = assetID
 
-	var asset Asset
-	err = db.QueryRow(`
-		SELECT id, asset_name, asset_type, institution_name, department, functional_area, 
-		manufacturer, model_number, serial_number, location, status, purchase_date, 
-		purchase_price, created_at, updated_at 
-		FROM assets WHERE id = ?`, assetID).
-		Scan(&asset.ID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
-
+	// The legacy request has no category, assignee or notes; keep the stored values.
+	// Custom fields left out of the request keep theirs too.
+	existing, err := store.Get(assetID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, APIResponse{
@@ -301,424 +203,322 @@ func getAssetDetailsHandler(c *gin.Context) {
 			})
 			return
 		}
-		log.Printf("Error fetching asset details: %v", err)
+		log.Printf("Error fetching asset for update: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   "Internal Server Error",
 		})
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"id":              asset.ID,
-		"assetName":       asset.AssetName,
-		"assetType":       asset.AssetType,
-		"institutionName": asset.InstitutionName,
-		"department":      asset.Department,
-		"functionalArea":  asset.FunctionalArea,
-		"manufacturer":    asset.Manufacturer,
-		"modelNumber":     asset.ModelNumber,
-		"serialNumber":    asset.SerialNumber,
-		"location":        asset.Location,
-		"status":          asset.Status,
-		"purchaseDate":    asset.PurchaseDate.Format("2006-01-02"),
-		"purchasePrice":   asset.PurchasePrice,
-		"createdAt":       asset.CreatedAt.Format("2006-01-02 15:04:05"),
-		"updatedAt":       asset.UpdatedAt.Format("2006-01-02 15:04:05"),
-	})
-}
-
-// addMultipleAssetsHandler adds multiple assets
-func addMultipleAssetsHandler(c *gin.Context) {
-	assetType := c.Param("assetType")
-	var req MultipleAssetRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, APIResponse{
-			Success: false,
-			Error:   "Invalid input data",
-		})
+	asset.CategoryID = existing.CategoryID
+	asset.AssignedTo = existing.AssignedTo
+	asset.Notes = existing.Notes
+	asset.CustomFields = mergeCustomFields(existing.CustomFields, req.CustomFields)
+	if !requireValidCustomFields(c, store, &asset) {
 		return
 	}
 
-	// Get the current company ID from the authenticated user
-	companyID := getCurrentCompanyID(c)
-
-	var assetIDs []int64
-	for _, assetReq := range req.Assets {
-		// Parse purchase date
-		var purchaseDate time.Time
-		var err error
-		if assetReq.PurchaseDate != "" {
-			purchaseDate, err = time.Parse("2006-01-02", assetReq.PurchaseDate)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, APIResponse{
-					Success: false,
-					Error:   "Invalid purchase date format",
-				})
-				return
-			}
-		}
-
-		// Insert the asset
-		result, err := db.Exec(`
-			INSERT INTO assets (asset_name, asset_type, institution_name, department, functional_area, 
-			manufacturer, model_number, serial_number, location, status, purchase_date, purchase_price, 
-			created_at, updated_at, company_id) 
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			assetReq.AssetName, assetType, assetReq.InstitutionName, assetReq.Department, assetReq.FunctionalArea,
-			assetReq.Manufacturer, assetReq.ModelNumber, assetReq.SerialNumber, assetReq.Location, assetReq.Status,
-			purchaseDate, assetReq.PurchasePrice, time.Now(), time.Now(), companyID)
-
-		if err != nil {
-			log.Printf("Error adding asset: %v", err)
-			c.JSON(http.StatusInternalServerError, APIResponse{
+	if err := store.Update(&asset); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, APIResponse{
 				Success: false,
-				Error:   "Internal Server Error",
+				Error:   "Asset not found",
 			})
 			return
 		}
-
-		assetID, _ := result.LastInsertId()
-		assetIDs = append(assetIDs, assetID)
-	}
-
-	c.JSON(http.StatusCreated, APIResponse{
-		Success: true,
-		Message: fmt.Sprintf("Successfully added %d assets", len(req.Assets)),
-		Data: gin.H{
-			"assetIds": assetIDs,
-		},
-	})
-}
-
-// getInstitutionsHandler returns all unique institutions
-func getInstitutionsHandler(c *gin.Context) {
-	// Check if database connection is available
-	if db == nil {
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Database connection not available",
-		})
-		return
-	}
-
-	// Test database connection
-	err := db.Ping()
-	if err != nil {
-		log.Printf("Database ping failed: %v", err)
+		log.Printf("Error updating asset: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   "Database connection failed",
+			Error:   "Internal Server Error",
 		})
 		return
 	}
 
-	// Check if assets table exists
-	var tableExists int
-	err = db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'assets'").Scan(&tableExists)
-	if err != nil {
-		log.Printf("Error checking table existence: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Failed to check table existence",
-		})
-		return
-	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Asset updated successfully",
+	})
+}
 
-	if tableExists == 0 {
-		// Table doesn't exist, return empty array
-		c.JSON(http.StatusOK, APIResponse{
-			Success: true,
-			Data:    []string{},
-		})
+// deleteAssetHandler deletes an asset
+func deleteAssetHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
 		return
 	}
 
-	rows, err := db.Query("SELECT DISTINCT institution_name FROM assets WHERE institution_name IS NOT NULL AND institution_name != ''")
+	assetID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		log.Printf("Error fetching institutions: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
+		c.JSON(http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Failed to fetch institutions: " + err.Error(),
+			Error:   "Invalid asset ID",
 		})
 		return
 	}
-	defer rows.Close()
 
-	var institutions []string = []string{}
-	for rows.Next() {
-		var institution string
-		err := rows.Scan(&institution)
-		if err != nil {
-			log.Printf("Error scanning institution: %v", err)
-			continue
-		}
-		if institution != "" {
-			institutions = append(institutions, institution)
+	if err := store.Delete(assetID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Asset not found",
+			})
+			return
 		}
-	}
-
-	// Check for any errors during iteration
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating institutions: %v", err)
+		log.Printf("Error deleting asset: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   "Error processing institutions",
+			Error:   "Internal Server Error",
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, APIResponse{
 		Success: true,
-		Data:    institutions,
+		Message: "Asset deleted successfully",
 	})
 }
 
-// getDepartmentsHandler returns all unique departments
-func getDepartmentsHandler(c *gin.Context) {
-	// Check if database connection is available
-	if db == nil {
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Database connection not available",
-		})
+// searchAssetsHandler runs a ranked full-text search combined with the structured
+// filters of SearchAssetsRequest, returning highlighted hits and facet counts
+func searchAssetsHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
 		return
 	}
 
-	// Test database connection
-	err := db.Ping()
-	if err != nil {
-		log.Printf("Database ping failed: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Database connection failed",
-		})
-		return
+	var req SearchAssetsRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "Invalid input data",
+			})
+			return
+		}
+	}
+	// Legacy clients send the text as ?query= with an empty body
+	if req.Query == "" {
+		req.Query = c.Query("query")
 	}
 
-	// Check if assets table exists
-	var tableExists int
-	err = db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'assets'").Scan(&tableExists)
-	if err != nil {
-		log.Printf("Error checking table existence: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
+	filter := AssetFilter{
+		AssetType:       req.AssetType,
+		InstitutionName: req.InstitutionName,
+		Department:      req.Department,
+		Status:          req.Status,
+		CategoryID:      req.CategoryID,
+	}
+	if filter.CustomFields, ok = requireCustomFieldFilters(c, store, req.CustomFields); !ok {
+		return
+	}
+	hasFilter := req.AssetType != "" || req.InstitutionName != "" || req.Department != "" ||
+		req.Status != "" || req.CategoryID != nil || len(filter.CustomFields) > 0
+	if strings.TrimSpace(req.Query) == "" && !hasFilter {
+		c.JSON(http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Failed to check table existence",
+			Error:   "Search query or filter is required",
 		})
 		return
 	}
 
-	if tableExists == 0 {
-		// Table doesn't exist, return empty array
-		c.JSON(http.StatusOK, APIResponse{
-			Success: true,
-			Data:    []string{},
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.PageSize == 0 {
+		req.PageSize = defaultAssetPageSize
+	}
+	if req.Page < 1 || req.PageSize < 1 || req.PageSize > maxAssetPageSize {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("page must be positive and page_size between 1 and %d", maxAssetPageSize),
 		})
 		return
 	}
 
-	rows, err := db.Query("SELECT DISTINCT department FROM assets WHERE department IS NOT NULL AND department != ''")
+	result, err := store.Search(AssetSearchQuery{
+		Text:     req.Query,
+		Filter:   filter,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	})
 	if err != nil {
-		log.Printf("Error fetching departments: %v", err)
+		log.Printf("Error searching assets: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   "Failed to fetch departments: " + err.Error(),
+			Error:   "Internal Server Error",
 		})
 		return
 	}
-	defer rows.Close()
 
-	var departments []string = []string{}
-	for rows.Next() {
-		var department string
-		err := rows.Scan(&department)
-		if err != nil {
-			log.Printf("Error scanning department: %v", err)
-			continue
+	hl := newHighlighter(req.Query)
+	response := []gin.H{}
+	for _, hit := range result.Hits {
+		item := assetResponse(hit.Asset)
+		item["score"] = hit.Score
+		if hl != nil {
+			item["highlights"] = hl.assetHighlights(hit.Asset)
 		}
-		if department != "" {
-			departments = append(departments, department)
-		}
-	}
-
-	// Check for any errors during iteration
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating departments: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Error processing departments",
-		})
-		return
+		response = append(response, item)
 	}
 
 	c.JSON(http.StatusOK, APIResponse{
 		Success: true,
-		Data:    departments,
+		Data: SearchResponse{
+			Data:       response,
+			Total:      result.Total,
+			Page:       req.Page,
+			PageSize:   req.PageSize,
+			TotalPages: (result.Total + req.PageSize - 1) / req.PageSize,
+			Facets:     result.Facets,
+		},
 	})
 }
 
-// getFunctionalAreasHandler returns all unique functional areas
-func getFunctionalAreasHandler(c *gin.Context) {
-	// Check if database connection is available
-	if db == nil {
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Database connection not available",
-		})
+// getAssetDetailsHandler returns details of a specific asset
+func getAssetDetailsHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
 		return
 	}
 
-	// Test database connection
-	err := db.Ping()
+	// Legacy clients pass the ID as ?assetId= instead of the path parameter
+	assetIDStr := c.Param("id")
+	if assetIDStr == "" {
+		assetIDStr = c.Query("assetId")
+	}
+	assetID, err := strconv.Atoi(assetIDStr)
 	if err != nil {
-		log.Printf("Database ping failed: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
+		c.JSON(http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Database connection failed",
+			Error:   "Invalid asset ID",
 		})
 		return
 	}
 
-	// Check if assets table exists
-	var tableExists int
-	err = db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'assets'").Scan(&tableExists)
+	asset, err := store.Get(assetID)
 	if err != nil {
-		log.Printf("Error checking table existence: %v", err)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Asset not found",
+			})
+			return
+		}
+		log.Printf("Error fetching asset details: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   "Failed to check table existence",
+			Error:   "Internal Server Error",
 		})
 		return
 	}
 
-	if tableExists == 0 {
-		// Table doesn't exist, return empty array
-		c.JSON(http.StatusOK, APIResponse{
-			Success: true,
-			Data:    []string{},
-		})
+	response := assetResponse(*asset)
+	response["primaryPhoto"] = nil
+	if photo, err := assetPrimaryPhoto(*asset); err != nil {
+		log.Printf("Error fetching primary photo: %v", err)
+	} else if photo != nil {
+		response["primaryPhoto"] = photo.withLinks()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// addMultipleAssetsHandler adds multiple assets in a single transaction
+func addMultipleAssetsHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
 		return
 	}
 
-	rows, err := db.Query("SELECT DISTINCT functional_area FROM assets WHERE functional_area IS NOT NULL AND functional_area != ''")
-	if err != nil {
-		log.Printf("Error fetching functional areas: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
+	assetType := c.Param("assetType")
+	var req MultipleAssetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
 			Success: false,
-			Error:   "Failed to fetch functional areas: " + err.Error(),
+			Error:   "Invalid input data",
 		})
 		return
 	}
-	defer rows.Close()
 
-	var functionalAreas []string = []string{}
-	for rows.Next() {
-		var functionalArea string
-		err := rows.Scan(&functionalArea)
+	assets := make([]Asset, 0, len(req.Assets))
+	for i, assetReq := range req.Assets {
+		if assetType != "" {
+			assetReq.AssetType = assetType
+		}
+		asset, err := assetFromRequest(assetReq)
 		if err != nil {
-			log.Printf("Error scanning functional area: %v", err)
-			continue
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "Invalid purchase date format",
+			})
+			return
 		}
-		if functionalArea != "" {
-			functionalAreas = append(functionalAreas, functionalArea)
+		if err := store.ValidateCustomFields(&asset); err != nil {
+			var invalid *customFieldError
+			if errors.As(err, &invalid) {
+				c.JSON(http.StatusBadRequest, APIResponse{
+					Success: false,
+					Error:   fmt.Sprintf("asset %d: %s", i+1, invalid.Error()),
+				})
+				return
+			}
+			customFieldRequestError(c, err, "validating")
+			return
 		}
+		assets = append(assets, asset)
 	}
 
-	// Check for any errors during iteration
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating functional areas: %v", err)
+	assetIDs, err := store.CreateMany(assets)
+	if err != nil {
+		log.Printf("Error adding assets: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   "Error processing functional areas",
+			Error:   "Internal Server Error",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, APIResponse{
+	c.JSON(http.StatusCreated, APIResponse{
 		Success: true,
-		Data:    functionalAreas,
+		Message: fmt.Sprintf("Successfully added %d assets", len(req.Assets)),
+		Data: gin.H{
+			"assetIds": assetIDs,
+		},
 	})
 }
 
-// getManufacturersHandler returns all unique manufacturers
-func getManufacturersHandler(c *gin.Context) {
-	// Check if database connection is available
-	if db == nil {
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Database connection not available",
-		})
-		return
-	}
-
-	// Test database connection
-	err := db.Ping()
-	if err != nil {
-		log.Printf("Database ping failed: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Database connection failed",
-		})
-		return
-	}
+// distinctAssetValuesHandler returns a handler listing the unique values of an asset column
+func distinctAssetValuesHandler(column, label string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store, ok := requireAssetStore(c)
+		if !ok {
+			return
+		}
 
-	// Check if assets table exists
-	var tableExists int
-	err = db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'assets'").Scan(&tableExists)
-	if err != nil {
-		log.Printf("Error checking table existence: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Failed to check table existence",
-		})
-		return
-	}
+		values, err := store.DistinctValues(column)
+		if err != nil {
+			log.Printf("Error fetching %s: %v", label, err)
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   "Failed to fetch " + label,
+			})
+			return
+		}
 
-	if tableExists == 0 {
-		// Table doesn't exist, return empty array
 		c.JSON(http.StatusOK, APIResponse{
 			Success: true,
-			Data:    []string{},
+			Data:    values,
 		})
-		return
 	}
+}
 
-	rows, err := db.Query("SELECT DISTINCT manufacturer FROM assets WHERE manufacturer IS NOT NULL AND manufacturer != ''")
-	if err != nil {
-		log.Printf("Error fetching manufacturers: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Failed to fetch manufacturers: " + err.Error(),
-		})
-		return
-	}
-	defer rows.Close()
+// getInstitutionsHandler returns all unique institutions of the current company
+var getInstitutionsHandler = distinctAssetValuesHandler("institution_name", "institutions")
 
-	var manufacturers []string = []string{}
-	for rows.Next() {
-		var manufacturer string
-		err := rows.Scan(&manufacturer)
-		if err != nil {
-			log.Printf("Error scanning manufacturer: %v", err)
-			continue
-		}
-		if manufacturer != "" {
-			manufacturers = append(manufacturers, manufacturer)
-		}
-	}
+// getDepartmentsHandler returns all unique departments of the current company
+var getDepartmentsHandler = distinctAssetValuesHandler("department", "departments")
 
-	// Check for any errors during iteration
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating manufacturers: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Error processing manufacturers",
-		})
-		return
-	}
+// getFunctionalAreasHandler returns all unique functional areas of the current company
+var getFunctionalAreasHandler = distinctAssetValuesHandler("functional_area", "functional areas")
 
-	c.JSON(http.StatusOK, APIResponse{
-		Success: true,
-		Data:    manufacturers,
-	})
-} 
\ No newline at end of file
+// getManufacturersHandler returns all unique manufacturers of the current company
+var getManufacturersHandler = distinctAssetValuesHandler("manufacturer", "manufacturers")
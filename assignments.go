@@ -0,0 +1,386 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errAssetCheckedOut    = errors.New("asset is already checked out")
+	errAssetNotCheckedOut = errors.New("asset is not checked out")
+	errAssetUnavailable   = errors.New("assets in maintenance or retired cannot be checked out")
+	errUnknownAssignee    = errors.New("assigned_to must be an active user of the company")
+)
+
+// assignmentColumns lists the assignment columns read by the store, in scan order.
+// Queries alias asset_assignments as aa and join assets as a and the assignee as u.
+const assignmentColumns = `aa.id, aa.company_id, aa.asset_id, aa.assigned_to, aa.assigned_by, aa.assigned_at,
+	aa.returned_at, aa.notes, aa.due_date, aa.returned_by, aa.return_notes, a.asset_name, u.username`
+
+// assignmentJoins joins the asset and assignee of an assignment, leaving out the
+// assignments of trashed assets
+const assignmentJoins = `FROM asset_assignments aa
+	JOIN assets a ON a.id = aa.asset_id AND a.company_id = aa.company_id AND a.deleted_at IS NULL
+	LEFT JOIN users u ON u.id = aa.assigned_to`
+
+// scanAssignment reads one row selected with assignmentColumns
+func scanAssignment(row rowScanner) (AssetAssignment, error) {
+	var a AssetAssignment
+	err := row.Scan(
+		&a.ID, &a.CompanyID, &a.AssetID, &a.AssignedTo, &a.AssignedBy, &a.AssignedAt,
+		&a.ReturnedAt, &a.Notes, &a.DueDate, &a.ReturnedBy, &a.ReturnNotes, &a.AssetName, &a.AssigneeUsername)
+	return a, err
+}
+
+// assignmentStore is the tenant-scoped data access layer for asset check-outs.
+// Check-outs and check-ins lock the asset row, so an asset has at most one open
+// assignment; the uq_assignments_open_asset index enforces the same in the schema.
+type assignmentStore struct {
+	assets *sqlAssetStore
+}
+
+// requireAssignmentStore resolves the request's assignment store or aborts with 401
+func requireAssignmentStore(c *gin.Context) (*assignmentStore, bool) {
+	assets, err := sqlAssetStoreFor(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Company context required",
+		})
+		c.Abort()
+		return nil, false
+	}
+	return &assignmentStore{assets: assets}, true
+}
+
+// query runs an assignment query and scans every row
+func (s *assignmentStore) query(query string, args ...interface{}) ([]AssetAssignment, error) {
+	rows, err := s.assets.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []AssetAssignment{}
+	for rows.Next() {
+		a, err := scanAssignment(rows)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+// History returns every assignment of an asset, newest first
+func (s *assignmentStore) History(assetID int) ([]AssetAssignment, error) {
+	return s.query(fmt.Sprintf(`SELECT %s %s
+		WHERE aa.company_id = ? AND aa.asset_id = ?
+		ORDER BY aa.assigned_at DESC, aa.id DESC`, assignmentColumns, assignmentJoins),
+		s.assets.companyID, assetID)
+}
+
+// OpenFor returns the live assets currently checked out to a user, soonest due first
+func (s *assignmentStore) OpenFor(userID int) ([]AssetAssignment, error) {
+	return s.query(fmt.Sprintf(`SELECT %s %s
+		WHERE aa.company_id = ? AND aa.assigned_to = ? AND aa.returned_at IS NULL
+		ORDER BY aa.due_date IS NULL, aa.due_date, aa.assigned_at`, assignmentColumns, assignmentJoins),
+		s.assets.companyID, userID)
+}
+
+// Overdue returns the open assignments of live assets whose due date has passed, most overdue first
+func (s *assignmentStore) Overdue() ([]AssetAssignment, error) {
+	assignments, err := s.query(fmt.Sprintf(`SELECT %s %s
+		WHERE aa.company_id = ? AND aa.returned_at IS NULL AND aa.due_date < CURDATE()
+		ORDER BY aa.due_date, aa.id`, assignmentColumns, assignmentJoins),
+		s.assets.companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for i, a := range assignments {
+		due := time.Date(a.DueDate.Year(), a.DueDate.Month(), a.DueDate.Day(), 0, 0, 0, 0, time.UTC)
+		assignments[i].DaysOverdue = int(today.Sub(due).Hours() / 24)
+	}
+	return assignments, nil
+}
+
+// openInTx reads and locks the open assignment of an asset, or returns sql.ErrNoRows
+func (s *assignmentStore) openInTx(tx *sql.Tx, assetID int) (*AssetAssignment, error) {
+	a, err := scanAssignment(tx.QueryRow(fmt.Sprintf(`SELECT %s %s
+		WHERE aa.company_id = ? AND aa.asset_id = ? AND aa.returned_at IS NULL
+		FOR UPDATE`, assignmentColumns, assignmentJoins), s.assets.companyID, assetID))
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// CheckOut assigns a live asset to a user of the company and sets the asset's assignee.
+// Returns sql.ErrNoRows for an unknown asset, errAssetCheckedOut when the asset has an
+// open assignment, errAssetUnavailable and errUnknownAssignee.
+func (s *assignmentStore) CheckOut(assetID, userID int, due *time.Time, notes string) (int64, error) {
+	var id int64
+	err := s.assets.inTx(func(tx *sql.Tx) error {
+		asset, err := s.assets.getForUpdate(tx, assetID, false)
+		if err != nil {
+			return err
+		}
+		if asset.Status == assetStatusMaintenance || asset.Status == "Retired" {
+			return errAssetUnavailable
+		}
+		if _, err := s.openInTx(tx, assetID); err == nil {
+			return errAssetCheckedOut
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+
+		var active bool
+		err = tx.QueryRow("SELECT is_active FROM users WHERE id = ? AND company_id = ?",
+			userID, s.assets.companyID).Scan(&active)
+		if err == sql.ErrNoRows || (err == nil && !active) {
+			return errUnknownAssignee
+		}
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(`
+			INSERT INTO asset_assignments (company_id, asset_id, assigned_to, assigned_by, assigned_at, due_date, notes)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			s.assets.companyID, assetID, userID, s.assets.actor.UserID, time.Now(), due, optionalString(notes))
+		if err != nil {
+			return err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		return s.assets.setAssigneeInTx(tx, asset, &userID)
+	})
+	return id, err
+}
+
+// CheckIn closes the open assignment of a live asset and clears the asset's assignee.
+// Returns sql.ErrNoRows for an unknown asset and errAssetNotCheckedOut.
+func (s *assignmentStore) CheckIn(assetID int, notes string) error {
+	return s.assets.inTx(func(tx *sql.Tx) error {
+		asset, err := s.assets.getForUpdate(tx, assetID, false)
+		if err != nil {
+			return err
+		}
+		open, err := s.openInTx(tx, assetID)
+		if err == sql.ErrNoRows {
+			return errAssetNotCheckedOut
+		}
+		if err != nil {
+			return err
+		}
+
+		var returnedBy interface{}
+		if s.assets.actor.UserID > 0 {
+			returnedBy = s.assets.actor.UserID
+		}
+		_, err = tx.Exec(`
+			UPDATE asset_assignments SET returned_at = ?, returned_by = ?, return_notes = ?
+			WHERE id = ? AND company_id = ?`,
+			time.Now(), returnedBy, optionalString(notes), open.ID, s.assets.companyID)
+		if err != nil {
+			return err
+		}
+
+		return s.assets.setAssigneeInTx(tx, asset, nil)
+	})
+}
+
+// assignmentError writes the response for an assignment store error
+func assignmentError(c *gin.Context, err error, action string) {
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Asset not found",
+		})
+	case errors.Is(err, errUnknownAssignee):
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	case errors.Is(err, errAssetCheckedOut), errors.Is(err, errAssetNotCheckedOut), errors.Is(err, errAssetUnavailable):
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	default:
+		log.Printf("Error %s asset: %v", action, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+}
+
+// pathAssetID parses the :id path parameter, writing 400 when it is invalid
+func pathAssetID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid asset ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// checkOutAssetHandler checks an asset out to a user, optionally until a due date
+func checkOutAssetHandler(c *gin.Context) {
+	store, ok := requireAssignmentStore(c)
+	if !ok {
+		return
+	}
+	assetID, ok := pathAssetID(c)
+	if !ok {
+		return
+	}
+
+	var req CheckOutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid input data",
+		})
+		return
+	}
+	due, err := parseOptionalDate(req.DueDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "due_date must be YYYY-MM-DD",
+		})
+		return
+	}
+
+	id, err := store.CheckOut(assetID, req.AssignedTo, due, req.Notes)
+	if err != nil {
+		assignmentError(c, err, "checking out")
+		return
+	}
+
+	c.JSON(http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Asset checked out successfully",
+		Data: gin.H{
+			"id": id,
+		},
+	})
+}
+
+// checkInAssetHandler returns a checked-out asset
+func checkInAssetHandler(c *gin.Context) {
+	store, ok := requireAssignmentStore(c)
+	if !ok {
+		return
+	}
+	assetID, ok := pathAssetID(c)
+	if !ok {
+		return
+	}
+
+	var req CheckInRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "Invalid input data",
+			})
+			return
+		}
+	}
+
+	if err := store.CheckIn(assetID, req.Notes); err != nil {
+		assignmentError(c, err, "checking in")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Asset checked in successfully",
+	})
+}
+
+// getAssetAssignmentsHandler returns the check-out history of an asset
+func getAssetAssignmentsHandler(c *gin.Context) {
+	store, ok := requireAssignmentStore(c)
+	if !ok {
+		return
+	}
+	assetID, ok := pathAssetID(c)
+	if !ok {
+		return
+	}
+
+	assignments, err := store.History(assetID)
+	if err != nil {
+		assignmentError(c, err, "fetching assignments of")
+		return
+	}
+	if len(assignments) == 0 {
+		if _, err := store.assets.Get(assetID); err == sql.ErrNoRows {
+			assignmentError(c, err, "fetching assignments of")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    assignments,
+	})
+}
+
+// getMyAssetsHandler returns the assets currently checked out to the authenticated user
+func getMyAssetsHandler(c *gin.Context) {
+	store, ok := requireAssignmentStore(c)
+	if !ok {
+		return
+	}
+
+	assignments, err := store.OpenFor(getCurrentUserID(c))
+	if err != nil {
+		assignmentError(c, err, "fetching checked-out")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    assignments,
+	})
+}
+
+// getOverdueAssignmentsHandler returns check-outs past their due date
+func getOverdueAssignmentsHandler(c *gin.Context) {
+	store, ok := requireAssignmentStore(c)
+	if !ok {
+		return
+	}
+
+	assignments, err := store.Overdue()
+	if err != nil {
+		assignmentError(c, err, "fetching overdue")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    assignments,
+	})
+}
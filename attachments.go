@@ -0,0 +1,735 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Attachment kinds
+const (
+	attachmentPhoto    = "photo"
+	attachmentInvoice  = "invoice"
+	attachmentWarranty = "warranty"
+	attachmentManual   = "manual"
+	attachmentOther    = "other"
+)
+
+const (
+	// defaultAttachmentMaxMB applies when ATTACHMENT_MAX_MB is unset
+	defaultAttachmentMaxMB = 25
+
+	// maxAttachmentDescription is the longest description, in characters
+	maxAttachmentDescription = 500
+)
+
+var (
+	errAttachmentNotFound = errors.New("attachment not found")
+	errNotAPhoto          = errors.New("only photos with a thumbnail can be the primary photo")
+)
+
+// attachmentKinds are the accepted kinds of attachments
+var attachmentKinds = map[string]bool{
+	attachmentPhoto:    true,
+	attachmentInvoice:  true,
+	attachmentWarranty: true,
+	attachmentManual:   true,
+	attachmentOther:    true,
+}
+
+// attachmentType is an accepted file type. Sniffed is the prefix http.DetectContentType
+// returns for such files, so a renamed executable is not stored as a PDF.
+type attachmentType struct {
+	ContentType string
+	Sniffed     string
+	Image       bool
+}
+
+// attachmentTypes are the accepted file types, by extension
+var attachmentTypes = map[string]attachmentType{
+	".jpg":  {"image/jpeg", "image/jpeg", true},
+	".jpeg": {"image/jpeg", "image/jpeg", true},
+	".png":  {"image/png", "image/png", true},
+	".gif":  {"image/gif", "image/gif", true},
+	".pdf":  {"application/pdf", "application/pdf", false},
+	".txt":  {"text/plain; charset=utf-8", "text/plain", false},
+	".csv":  {"text/csv; charset=utf-8", "text/plain", false},
+	".docx": {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/zip", false},
+	".xlsx": {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "application/zip", false},
+}
+
+// attachmentExtensions lists the accepted extensions for error messages
+func attachmentExtensions() string {
+	return "jpg, jpeg, png, gif, pdf, txt, csv, docx or xlsx"
+}
+
+// attachmentMaxBytes returns the largest accepted upload, from ATTACHMENT_MAX_MB
+func attachmentMaxBytes() int64 {
+	return int64(envInt("ATTACHMENT_MAX_MB", defaultAttachmentMaxMB)) << 20
+}
+
+// checkAttachmentType returns the type of an upload from its extension, after checking
+// that its contents look like that type
+func checkAttachmentType(filename string, file io.ReadSeeker) (attachmentType, error) {
+	t, ok := attachmentTypes[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return t, fmt.Errorf("file must be a %s file", attachmentExtensions())
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return t, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return t, err
+	}
+	if n == 0 {
+		return t, errors.New("file is empty")
+	}
+	if !strings.HasPrefix(http.DetectContentType(head[:n]), t.Sniffed) {
+		return t, fmt.Errorf("file contents do not match its %s extension", filepath.Ext(filename))
+	}
+	return t, nil
+}
+
+// attachmentStorageKey returns where an attachment's file is kept. Keys are random, so
+// they reveal nothing about other files.
+func attachmentStorageKey(companyID, assetID int) (string, error) {
+	id, err := newArtifactID()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("attachments/%d/%d/%s", companyID, assetID, id), nil
+}
+
+// attachmentColumns lists the asset_attachments columns read by scanAttachment, in scan order
+const attachmentColumns = `id, company_id, asset_id, kind, filename, content_type, size, width, height,
+	description, storage_key, thumbnail_key, uploaded_by, created_at`
+
+// scanAttachment reads one row selected with attachmentColumns
+func scanAttachment(row rowScanner) (AssetAttachment, error) {
+	var a AssetAttachment
+	err := row.Scan(&a.ID, &a.CompanyID, &a.AssetID, &a.Kind, &a.Filename, &a.ContentType, &a.Size, &a.Width,
+		&a.Height, &a.Description, &a.StorageKey, &a.ThumbnailKey, &a.UploadedBy, &a.CreatedAt)
+	return a, err
+}
+
+// attachmentStore keeps the files uploaded for the company's assets. Their contents are
+// kept in the artifact storage backend; they do not expire.
+type attachmentStore struct {
+	assets *sqlAssetStore
+}
+
+// requireAttachmentStore resolves the request's attachment store or aborts with 401
+func requireAttachmentStore(c *gin.Context) (*attachmentStore, bool) {
+	assets, err := sqlAssetStoreFor(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Company context required",
+		})
+		c.Abort()
+		return nil, false
+	}
+	return &attachmentStore{assets: assets}, true
+}
+
+// List returns the attachments of a live asset, oldest first, marking its primary photo
+func (s *attachmentStore) List(assetID int) ([]AssetAttachment, error) {
+	asset, err := s.assets.Get(assetID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.assets.query(
+		fmt.Sprintf("SELECT %s FROM asset_attachments WHERE company_id = ? AND asset_id = ? ORDER BY id", attachmentColumns),
+		s.assets.companyID, assetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []AssetAttachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		a.IsPrimary = asset.PrimaryPhotoID != nil && *asset.PrimaryPhotoID == a.ID
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// Get returns an attachment of one of the company's assets
+func (s *attachmentStore) Get(assetID, id int) (*AssetAttachment, error) {
+	return s.find("id = ? AND asset_id = ?", id, assetID)
+}
+
+// linkedAttachmentStore returns the attachment store of the company a signed link was made for
+func linkedAttachmentStore(companyID int) (*attachmentStore, error) {
+	assets, err := newSQLAssetStoreImpl(db, companyID, auditActor{})
+	if err != nil {
+		return nil, err
+	}
+	return &attachmentStore{assets: assets}, nil
+}
+
+// Linked returns an attachment of the company by ID alone, for signed links
+func (s *attachmentStore) Linked(id int) (*AssetAttachment, error) {
+	return s.find("id = ?", id)
+}
+
+// find returns the company's attachment matching a condition
+func (s *attachmentStore) find(cond string, args ...interface{}) (*AssetAttachment, error) {
+	a, err := scanAttachment(s.assets.queryRow(
+		fmt.Sprintf("SELECT %s FROM asset_attachments WHERE company_id = ? AND %s", attachmentColumns, cond),
+		append([]interface{}{s.assets.companyID}, args...)...))
+	if err == sql.ErrNoRows {
+		return nil, errAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// attachmentUpload is a checked upload waiting to be stored
+type attachmentUpload struct {
+	file      multipart.File
+	thumbnail []byte
+}
+
+// Create stores an upload for a live asset. Photos become the asset's primary photo when
+// it has none, or when primary is set. The files are written before the row, and removed
+// again when the row cannot be written.
+func (s *attachmentStore) Create(a *AssetAttachment, upload attachmentUpload, primary bool) error {
+	asset, err := s.assets.Get(a.AssetID)
+	if err != nil {
+		return err
+	}
+
+	a.CompanyID = s.assets.companyID
+	key, err := attachmentStorageKey(a.CompanyID, a.AssetID)
+	if err != nil {
+		return err
+	}
+	if err := artifacts.backend.Put(key, upload.file, a.Size, a.ContentType); err != nil {
+		return err
+	}
+	a.StorageKey = key
+	if upload.thumbnail != nil {
+		thumbKey := key + "_thumb.jpg"
+		err := artifacts.backend.Put(thumbKey, bytes.NewReader(upload.thumbnail), int64(len(upload.thumbnail)), "image/jpeg")
+		if err != nil {
+			removeAttachmentFiles([]string{key})
+			return err
+		}
+		a.ThumbnailKey = &thumbKey
+	}
+
+	if s.assets.actor.UserID > 0 {
+		a.UploadedBy = &s.assets.actor.UserID
+	}
+	a.CreatedAt = time.Now().Truncate(time.Second)
+	result, err := s.assets.exec(`
+		INSERT INTO asset_attachments (company_id, asset_id, kind, filename, content_type, size, width, height,
+		description, storage_key, thumbnail_key, uploaded_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.CompanyID, a.AssetID, a.Kind, a.Filename, a.ContentType, a.Size, a.Width, a.Height, a.Description,
+		a.StorageKey, a.ThumbnailKey, a.UploadedBy, a.CreatedAt)
+	if err != nil {
+		removeAttachmentFiles(a.files())
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	a.ID = int(id)
+
+	if a.Kind == attachmentPhoto && a.ThumbnailKey != nil && (primary || asset.PrimaryPhotoID == nil) {
+		if err := s.assets.SetPrimaryPhoto(a.AssetID, &a.ID); err != nil {
+			return err
+		}
+		a.IsPrimary = true
+	}
+	return nil
+}
+
+// Delete removes an attachment and its files. Deleting the primary photo clears it.
+func (s *attachmentStore) Delete(assetID, id int) error {
+	a, err := s.Get(assetID, id)
+	if err != nil {
+		return err
+	}
+	asset, err := s.assets.Get(assetID)
+	if err != nil {
+		return err
+	}
+	if asset.PrimaryPhotoID != nil && *asset.PrimaryPhotoID == id {
+		if err := s.assets.SetPrimaryPhoto(assetID, nil); err != nil {
+			return err
+		}
+	}
+	_, err = s.assets.exec("DELETE FROM asset_attachments WHERE id = ? AND company_id = ?", id, s.assets.companyID)
+	if err != nil {
+		return err
+	}
+	removeAttachmentFiles(a.files())
+	return nil
+}
+
+// files returns the storage keys of an attachment's file and thumbnail
+func (a *AssetAttachment) files() []string {
+	files := []string{a.StorageKey}
+	if a.ThumbnailKey != nil {
+		files = append(files, *a.ThumbnailKey)
+	}
+	return files
+}
+
+// removeAttachmentFiles deletes files from storage. A file that cannot be deleted is
+// logged and left behind; its row is already gone.
+func removeAttachmentFiles(keys []string) {
+	for _, key := range keys {
+		if err := artifacts.backend.Delete(key); err != nil {
+			log.Printf("Error deleting attachment file %s: %v", key, err)
+		}
+	}
+}
+
+// attachmentFilesInTx returns the storage keys of all attachments of an asset, for
+// removal once the asset is purged
+func attachmentFilesInTx(tx *sql.Tx, companyID, assetID int) ([]string, error) {
+	rows, err := tx.Query(
+		fmt.Sprintf("SELECT %s FROM asset_attachments WHERE company_id = ? AND asset_id = ?", attachmentColumns),
+		companyID, assetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, a.files()...)
+	}
+	return files, rows.Err()
+}
+
+// SetPrimaryPhoto sets or, with nil, clears the primary photo of a live asset of the
+// company. The photo must be an image attachment of the asset.
+func (s *sqlAssetStore) SetPrimaryPhoto(id int, attachmentID *int) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		before, err := s.getForUpdate(tx, id, false)
+		if err != nil {
+			return err
+		}
+		if attachmentID != nil {
+			var kind string
+			var thumbnail sql.NullString
+			err := tx.QueryRow(
+				"SELECT kind, thumbnail_key FROM asset_attachments WHERE id = ? AND asset_id = ? AND company_id = ?",
+				*attachmentID, id, s.companyID).Scan(&kind, &thumbnail)
+			if err == sql.ErrNoRows {
+				return errAttachmentNotFound
+			}
+			if err != nil {
+				return err
+			}
+			if kind != attachmentPhoto || !thumbnail.Valid {
+				return errNotAPhoto
+			}
+		}
+
+		after := *before
+		after.PrimaryPhotoID = attachmentID
+		_, err = tx.Exec("UPDATE assets SET primary_photo_id = ?, updated_at = ? WHERE id = ? AND company_id = ?",
+			attachmentID, time.Now(), id, s.companyID)
+		if err != nil {
+			return err
+		}
+		return s.recordHistory(tx, id, before, &after)
+	})
+}
+
+// assetPrimaryPhoto returns the primary photo of an asset, or nil when it has none
+func assetPrimaryPhoto(asset Asset) (*AssetAttachment, error) {
+	if asset.PrimaryPhotoID == nil {
+		return nil, nil
+	}
+	a, err := scanAttachment(db.QueryRow(
+		fmt.Sprintf("SELECT %s FROM asset_attachments WHERE id = ? AND asset_id = ? AND company_id = ?", attachmentColumns),
+		*asset.PrimaryPhotoID, asset.ID, asset.CompanyID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.IsPrimary = true
+	return &a, nil
+}
+
+// assetPhotoThumbnail returns the JPEG thumbnail of an asset's primary photo, or nil
+// when it has none
+func assetPhotoThumbnail(asset Asset) ([]byte, error) {
+	photo, err := assetPrimaryPhoto(asset)
+	if err != nil || photo == nil || photo.ThumbnailKey == nil {
+		return nil, err
+	}
+	body, err := artifacts.backend.Get(*photo.ThumbnailKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// withLinks fills in the signed download and thumbnail links of an attachment
+func (a *AssetAttachment) withLinks() *AssetAttachment {
+	a.DownloadURL, _ = artifacts.SignCompanyLink(fmt.Sprintf("/api/attachments/%d/download", a.ID), a.CompanyID)
+	if a.ThumbnailKey != nil {
+		a.ThumbnailURL, _ = artifacts.SignCompanyLink(fmt.Sprintf("/api/attachments/%d/thumbnail", a.ID), a.CompanyID)
+	}
+	return a
+}
+
+// attachmentError writes the response for a failed attachment operation
+func attachmentError(c *gin.Context, err error, action string) {
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Asset not found",
+		})
+	case err == errAttachmentNotFound || err == errArtifactNotFound:
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   errAttachmentNotFound.Error(),
+		})
+	case err == errNotAPhoto:
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	case err == errArtifactSignature:
+		c.JSON(http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	default:
+		log.Printf("Error %s attachment: %v", action, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+}
+
+// attachmentID reads an attachment ID path parameter
+func attachmentID(c *gin.Context, param string) (int, bool) {
+	id, err := strconv.Atoi(c.Param(param))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid attachment ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// readAttachmentUpload checks the multipart file of an upload and prepares the thumbnail
+// of images, writing 400 or 413 when the file is not accepted
+func readAttachmentUpload(c *gin.Context, header *multipart.FileHeader, kind string) (*AssetAttachment, attachmentUpload, bool) {
+	reject := func(status int, msg string) (*AssetAttachment, attachmentUpload, bool) {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   msg,
+		})
+		return nil, attachmentUpload{}, false
+	}
+	if max := attachmentMaxBytes(); header.Size > max {
+		return reject(http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d MB", max>>20))
+	}
+	filename := filepath.Base(strings.ReplaceAll(header.Filename, "\\", "/"))
+	if filename == "" || filename == "." || len(filename) > 255 {
+		return reject(http.StatusBadRequest, "file name is missing or longer than 255 characters")
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return reject(http.StatusBadRequest, "Invalid file upload")
+	}
+	t, err := checkAttachmentType(filename, file)
+	if err != nil {
+		file.Close()
+		return reject(http.StatusBadRequest, err.Error())
+	}
+	if kind == "" {
+		kind = attachmentOther
+		if t.Image {
+			kind = attachmentPhoto
+		}
+	}
+	if kind == attachmentPhoto && !t.Image {
+		file.Close()
+		return reject(http.StatusBadRequest, "photos must be jpg, png or gif images")
+	}
+
+	a := &AssetAttachment{Kind: kind, Filename: filename, ContentType: t.ContentType, Size: header.Size}
+	var upload attachmentUpload
+	if t.Image {
+		thumb, err := makeThumbnail(file)
+		if err != nil {
+			file.Close()
+			return reject(http.StatusBadRequest, err.Error())
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return reject(http.StatusBadRequest, "Invalid file upload")
+		}
+		a.Width, a.Height = &thumb.Width, &thumb.Height
+		upload.thumbnail = thumb.JPEG
+	}
+	upload.file = file
+	return a, upload, true
+}
+
+// uploadAttachmentHandler stores a file uploaded for an asset as multipart form data:
+// "file", with optional "kind", "description" and "primary"
+func uploadAttachmentHandler(c *gin.Context) {
+	store, ok := requireAttachmentStore(c)
+	if !ok {
+		return
+	}
+	assetID, ok := pathAssetID(c)
+	if !ok {
+		return
+	}
+
+	// Leave room for the other form fields and the multipart framing
+	limit := attachmentMaxBytes() + 1<<20
+	if c.Request.ContentLength > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("file is larger than %d MB", attachmentMaxBytes()>>20),
+		})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "A file is required in the \"file\" form field",
+		})
+		return
+	}
+
+	kind := strings.ToLower(strings.TrimSpace(c.PostForm("kind")))
+	if kind != "" && !attachmentKinds[kind] {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "kind must be photo, invoice, warranty, manual or other",
+		})
+		return
+	}
+	description := strings.TrimSpace(c.PostForm("description"))
+	if len([]rune(description)) > maxAttachmentDescription {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("description may be at most %d characters", maxAttachmentDescription),
+		})
+		return
+	}
+	primary, _ := strconv.ParseBool(c.PostForm("primary"))
+
+	a, upload, ok := readAttachmentUpload(c, header, kind)
+	if !ok {
+		return
+	}
+	defer upload.file.Close()
+	a.AssetID = assetID
+	a.Description = optionalString(description)
+
+	if err := store.Create(a, upload, primary); err != nil {
+		attachmentError(c, err, "uploading")
+		return
+	}
+	c.JSON(http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Attachment uploaded",
+		Data:    a.withLinks(),
+	})
+}
+
+// getAttachmentsHandler lists the attachments of an asset with signed download links
+func getAttachmentsHandler(c *gin.Context) {
+	store, ok := requireAttachmentStore(c)
+	if !ok {
+		return
+	}
+	assetID, ok := pathAssetID(c)
+	if !ok {
+		return
+	}
+	list, err := store.List(assetID)
+	if err != nil {
+		attachmentError(c, err, "listing")
+		return
+	}
+	for i := range list {
+		list[i].withLinks()
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    list,
+	})
+}
+
+// downloadAttachmentHandler serves an attachment of the company's asset
+func downloadAttachmentHandler(c *gin.Context) {
+	store, ok := requireAttachmentStore(c)
+	if !ok {
+		return
+	}
+	assetID, ok := pathAssetID(c)
+	if !ok {
+		return
+	}
+	id, ok := attachmentID(c, "attachmentId")
+	if !ok {
+		return
+	}
+	a, err := store.Get(assetID, id)
+	if err != nil {
+		attachmentError(c, err, "fetching")
+		return
+	}
+	serveAttachment(c, a, false)
+}
+
+// deleteAttachmentHandler deletes an attachment and its files
+func deleteAttachmentHandler(c *gin.Context) {
+	store, ok := requireAttachmentStore(c)
+	if !ok {
+		return
+	}
+	assetID, ok := pathAssetID(c)
+	if !ok {
+		return
+	}
+	id, ok := attachmentID(c, "attachmentId")
+	if !ok {
+		return
+	}
+	if err := store.Delete(assetID, id); err != nil {
+		attachmentError(c, err, "deleting")
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Attachment deleted",
+	})
+}
+
+// setPrimaryPhotoHandler sets an asset's primary photo, or clears it with a null attachment_id
+func setPrimaryPhotoHandler(c *gin.Context) {
+	store, ok := requireAttachmentStore(c)
+	if !ok {
+		return
+	}
+	assetID, ok := pathAssetID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		AttachmentID *int `json:"attachment_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+	if err := store.assets.SetPrimaryPhoto(assetID, req.AttachmentID); err != nil {
+		attachmentError(c, err, "setting the primary photo of")
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Primary photo updated",
+	})
+}
+
+// linkedAttachmentHandler serves an attachment, or its thumbnail, through a signed link
+func linkedAttachmentHandler(thumbnail bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		companyID, err := artifacts.VerifyCompanyLink(c)
+		if err != nil {
+			attachmentError(c, err, "verifying")
+			return
+		}
+		id, ok := attachmentID(c, "id")
+		if !ok {
+			return
+		}
+		store, err := linkedAttachmentStore(companyID)
+		if err != nil {
+			attachmentError(c, err, "fetching")
+			return
+		}
+		a, err := store.Linked(id)
+		if err != nil {
+			attachmentError(c, err, "fetching")
+			return
+		}
+		serveAttachment(c, a, thumbnail)
+	}
+}
+
+// serveAttachment streams an attachment or its thumbnail. Images are shown inline, other
+// files are downloaded.
+func serveAttachment(c *gin.Context, a *AssetAttachment, thumbnail bool) {
+	key, contentType, size := a.StorageKey, a.ContentType, a.Size
+	if thumbnail {
+		if a.ThumbnailKey == nil {
+			attachmentError(c, errAttachmentNotFound, "fetching")
+			return
+		}
+		key, contentType, size = *a.ThumbnailKey, "image/jpeg", -1
+	}
+	body, err := artifacts.backend.Get(key)
+	if err != nil {
+		attachmentError(c, err, "reading")
+		return
+	}
+	defer body.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	c.DataFromReader(http.StatusOK, size, contentType, body, map[string]string{
+		"Content-Disposition":    fmt.Sprintf("%s; filename=%q", disposition, a.Filename),
+		"Cache-Control":          "private, max-age=300",
+		"X-Content-Type-Options": "nosniff",
+	})
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -22,6 +23,7 @@ type Claims struct {
 	CompanyID int    `json:"company_id"`
 	Username  string `json:"username"`
 	Role      string `json:"role"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -73,11 +75,11 @@ func loginHandler(c *gin.Context) {
 		return
 	}
 
-	// Check if company trial has expired
-	if company.TrialEndsAt != nil && time.Now().After(*company.TrialEndsAt) {
-		c.JSON(http.StatusForbidden, APIResponse{
+	// Check the company's trial and whether it allows password login
+	if status, message := checkLoginCompany(company, false); status != 0 {
+		c.JSON(status, APIResponse{
 			Success: false,
-			Error:   "Company trial has expired. Please contact support.",
+			Error:   message,
 		})
 		return
 	}
@@ -117,53 +119,102 @@ func loginHandler(c *gin.Context) {
 		return
 	}
 
-	// Update last login
-	_, err = db.Exec("UPDATE users SET last_login = NOW() WHERE id = ?", user.ID)
+	completeFirstFactor(c, user, company, false, req.EnrollmentCode)
+}
+
+// checkLoginCompany refuses logins to a company whose trial has expired, and password
+// logins to a company that only allows single sign-on. It returns 0 when the login may
+// go on.
+func checkLoginCompany(company Company, viaSSO bool) (int, string) {
+	if company.TrialEndsAt != nil && time.Now().After(*company.TrialEndsAt) {
+		return http.StatusForbidden, "Company trial has expired. Please contact support."
+	}
+	if viaSSO {
+		return 0, ""
+	}
+	// Companies can require single sign-on instead of passwords
+	ssoOnly, err := passwordLoginDisabled(company.ID)
 	if err != nil {
-		// Log error but don't fail login
-		log.Printf("Failed to update last login: %v", err)
+		log.Printf("Error fetching single sign-on settings: %v", err)
+		return http.StatusInternalServerError, "Internal Server Error"
 	}
-
-	// Generate JWT token
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := Claims{
-		UserID:    user.ID,
-		CompanyID: user.CompanyID,
-		Username:  user.Username,
-		Role:      user.Role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
+	if ssoOnly {
+		return http.StatusForbidden, "Password login is disabled for this company; sign in with single sign-on"
 	}
+	return 0, ""
+}
 
-	secretKey := os.Getenv("JWT_SECRET")
-	if secretKey == "" {
-		secretKey = "your-secret-key"
+// completeFirstFactor continues a login whose first factor, a password or a single
+// sign-on, succeeded. It asks for a second factor when the user has one or company
+// policy requires one, and otherwise completes the login.
+func completeFirstFactor(c *gin.Context, user User, company Company, viaSSO bool, enrollmentCode string) {
+	challenge, err := twoFactorChallenge(user, company, viaSSO, enrollmentCode)
+	if err == errEnrollmentRequired {
+		c.JSON(http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(secretKey))
 	if err != nil {
+		log.Printf("Failed to start two-factor challenge: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   "Failed to generate token",
+			Error:   "Internal Server Error",
 		})
 		return
 	}
+	if challenge != nil {
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Message: "Two-factor authentication required",
+			Data:    challenge,
+		})
+		return
+	}
+
+	completeLogin(c, user, company, nil)
+}
+
+// loadLoginCompany reads an active company users sign in to
+func loadLoginCompany(condition string, arg interface{}) (Company, error) {
+	var company Company
+	err := db.QueryRow(`
+		SELECT id, company_name, company_code, email, subscription_plan, is_active, trial_ends_at
+		FROM companies
+		WHERE `+condition+` AND is_active = true`, arg).Scan(
+		&company.ID, &company.CompanyName, &company.CompanyCode,
+		&company.Email, &company.SubscriptionPlan, &company.IsActive, &company.TrialEndsAt,
+	)
+	return company, err
+}
 
-	// Fetch roles for this user
-	roleRows, err := db.Query(`SELECT role FROM user_roles WHERE user_id = ? AND company_id = ?`, user.ID, user.CompanyID)
+// completeLogin starts a session for a fully authenticated user and writes the login
+// response. Recovery codes are included when the login just enrolled the user in 2FA.
+func completeLogin(c *gin.Context, user User, company Company, recoveryCodes []string) {
+	// Fetch the permissions of the user's role first, so a failure leaves no session behind
+	perms, _, err := rolePermissions(user.CompanyID, user.Role)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{ Success: false, Error: "Failed to load user roles" })
 		return
 	}
-	defer roleRows.Close()
-	var roles []string
-	for roleRows.Next() {
-		var r string
-		if err := roleRows.Scan(&r); err == nil { roles = append(roles, r) }
+
+	// Update last login
+	_, err = db.Exec("UPDATE users SET last_login = NOW() WHERE id = ?", user.ID)
+	if err != nil {
+		// Log error but don't fail login
+		log.Printf("Failed to update last login: %v", err)
+	}
+
+	// Start a session with a short-lived access token and a refresh token
+	tokens, err := startSession(c, user)
+	if err != nil {
+		log.Printf("Failed to start session: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to generate token",
+		})
+		return
 	}
 
 	// Return login response
@@ -171,11 +222,15 @@ func loginHandler(c *gin.Context) {
 		Success: true,
 		Message: "Login successful",
 		Data: LoginResponse{
-			Token:     tokenString,
-			User:      user,
-			Company:   company,
-			ExpiresAt: expirationTime.Unix(),
-			Roles:     roles,
+			Token:            tokens.Token,
+			RefreshToken:     tokens.RefreshToken,
+			User:             user,
+			Company:          company,
+			ExpiresAt:        tokens.ExpiresAt,
+			RefreshExpiresAt: tokens.RefreshExpiresAt,
+			Roles:            legacyRoles(perms),
+			Permissions:      sortedPermissions(perms),
+			RecoveryCodes:    recoveryCodes,
 		},
 	})
 }
@@ -323,21 +378,6 @@ func registerCompanyHandler(c *gin.Context) {
 		return
 	}
 
-	// Create default user roles for admin
-	_, err = tx.Exec(`
-		INSERT INTO user_roles (user_id, company_id, role) VALUES 
-		(?, ?, 'userManagement'),
-		(?, ?, 'assetManagement'),
-		(?, ?, 'encodeAssets')
-	`, userID, companyID, userID, companyID, userID, companyID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Failed to create user roles: " + err.Error(),
-		})
-		return
-	}
-
 	// Create default asset categories
 	defaultCategories := []struct {
 		name  string
@@ -403,14 +443,7 @@ func authMiddleware() gin.HandlerFunc {
 			tokenString = tokenString[7:]
 		}
 
-		secretKey := os.Getenv("JWT_SECRET")
-		if secretKey == "" {
-			secretKey = "your-secret-key"
-		}
-
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(secretKey), nil
-		})
+		token, err := jwtKeys.parse(tokenString, &Claims{})
 
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, APIResponse{
@@ -422,7 +455,7 @@ func authMiddleware() gin.HandlerFunc {
 		}
 
 		claims, ok := token.Claims.(*Claims)
-		if !ok {
+		if !ok || claims.SessionID == "" {
 			c.JSON(http.StatusUnauthorized, APIResponse{
 				Success: false,
 				Error:   "Invalid token claims",
@@ -431,13 +464,15 @@ func authMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Check if user still exists and is active
+		// Check if user still exists and is active, and the session was not revoked
 		var user User
 		err = db.QueryRow(`
-			SELECT id, company_id, username, email, first_name, last_name, role, is_active
-			FROM users 
-			WHERE id = ? AND company_id = ? AND is_active = true
-		`, claims.UserID, claims.CompanyID).Scan(
+			SELECT u.id, u.company_id, u.username, u.email, u.first_name, u.last_name, u.role, u.is_active
+			FROM users u
+			JOIN user_sessions s ON s.user_id = u.id
+			WHERE u.id = ? AND u.company_id = ? AND u.is_active = true
+			AND s.id = ? AND s.revoked_at IS NULL AND s.expires_at > NOW()
+		`, claims.UserID, claims.CompanyID, claims.SessionID).Scan(
 			&user.ID, &user.CompanyID, &user.Username, &user.Email,
 			&user.FirstName, &user.LastName, &user.Role, &user.IsActive,
 		)
@@ -445,7 +480,7 @@ func authMiddleware() gin.HandlerFunc {
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, APIResponse{
 				Success: false,
-				Error:   "User not found or inactive",
+				Error:   "Session expired or revoked, or user inactive",
 			})
 			c.Abort()
 			return
@@ -455,10 +490,42 @@ func authMiddleware() gin.HandlerFunc {
 		c.Set("user", user)
 		c.Set("company_id", claims.CompanyID)
 		c.Set("user_id", claims.UserID)
+		c.Set("session_id", claims.SessionID)
+		c.Next()
+	}
+}
+
+// requestIDMiddleware tags every request with an ID, taken from a well-formed
+// X-Request-ID header or generated, and echoes it in the response
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if !validRequestID(requestID) {
+			buf := make([]byte, 16)
+			if _, err := rand.Read(buf); err != nil {
+				log.Printf("Error generating request ID: %v", err)
+			}
+			requestID = hex.EncodeToString(buf)
+		}
+		c.Set("request_id", requestID)
+		c.Header("X-Request-ID", requestID)
 		c.Next()
 	}
 }
 
+// validRequestID accepts client request IDs of up to 64 letters, digits, '-', '_' and '.'
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
 // checkTrialStatus checks if company trial is still active
 func checkTrialStatus() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -524,39 +591,7 @@ func getCurrentUserID(c *gin.Context) int {
 	return 0
 }
 
-// generateJWT creates a JWT token for the given user and company
-func generateJWT(userID, companyID int, username, role string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := Claims{
-		UserID:    userID,
-		CompanyID: companyID,
-		Username:  username,
-		Role:      role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
-	}
-
-	secretKey := os.Getenv("JWT_SECRET")
-	if secretKey == "" {
-		secretKey = "your-secret-key"
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(secretKey))
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
+// getRequestID returns the ID assigned to the current request by requestIDMiddleware
+func getRequestID(c *gin.Context) string {
+	return c.GetString("request_id")
 }
-
-// logoutHandler acknowledges logout (JWT is stateless; clients should discard the token)
-func logoutHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, APIResponse{
-		Success: true,
-		Message: "Logged out",
-	})
-} 
\ No newline at end of file
@@ -1,19 +1,163 @@
 package main
 
 import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
 	"fmt"
-	"image/png"
+	"io"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 
-	"github.com/boombuler/barcode"
-	"github.com/boombuler/barcode/code128"
 	"github.com/gin-gonic/gin"
-	"github.com/jung-kurt/gofpdf"
 )
 
+// Job types of label sheets
+const (
+	jobTypeLabels     = "labels"
+	jobTypeLabelBatch = "label_batch"
+)
+
+// labelJobPayload is a queued label sheet: the assets of a generateBarcodes request, or
+// those of an institution and optionally one of its departments
+type labelJobPayload struct {
+	AssetIDs    []int  `json:"assetIds"`
+	Institution string `json:"institution,omitempty"`
+	Department  string `json:"department,omitempty"`
+	LabelOptions
+}
+
+// sheet returns the header, fallback template and file name the synchronous endpoint uses
+func (p labelJobPayload) sheet() (header, fallback, filename string) {
+	switch {
+	case p.AssetIDs != nil:
+		return "Asset Barcodes", "a4-2up", "asset_barcodes.pdf"
+	case p.Department != "":
+		return fmt.Sprintf("Asset Barcodes - %s - %s", p.Institution, p.Department), "a4-2up",
+			fmt.Sprintf("barcodes_%s_%s.pdf", strings.ReplaceAll(p.Institution, " ", "_"), strings.ReplaceAll(p.Department, " ", "_"))
+	}
+	return fmt.Sprintf("Asset Barcodes - %s", p.Institution), "a4-4up",
+		fmt.Sprintf("barcodes_%s.pdf", strings.ReplaceAll(p.Institution, " ", "_"))
+}
+
+// enqueueLabelJob checks the label options of a request and queues its sheet
+func enqueueLabelJob(c *gin.Context, companyID int, p labelJobPayload) {
+	_, fallback, _ := p.sheet()
+	if _, ok := requireLabelSheet(c, companyID, p.LabelOptions, fallback); !ok {
+		return
+	}
+	enqueueJobHandler(c, jobTypeLabels, p)
+}
+
+// labelJobSheet starts the sheet of a queued job. Options that were valid when the job was
+// queued can stop resolving when a template is deleted, which retrying does not fix.
+func labelJobSheet(job *runningJob, opts LabelOptions, fallback string) (*labelSheet, error) {
+	sheet, err := resolveLabelSheet(job.CompanyID, opts, fallback)
+	if errors.Is(err, errUnknownSymbology) || errors.Is(err, errUnknownLabelTemplate) {
+		return nil, permanent(err)
+	}
+	return sheet, err
+}
+
+// runLabelJob prints a queued label sheet to the job's file
+func runLabelJob(job *runningJob) (interface{}, error) {
+	var p labelJobPayload
+	if err := job.DecodePayload(&p); err != nil {
+		return nil, err
+	}
+	store, err := job.AssetStore()
+	if err != nil {
+		return nil, err
+	}
+	var assets []Asset
+	switch {
+	case p.AssetIDs != nil:
+		assets, err = store.GetMany(p.AssetIDs)
+	case p.Department != "":
+		assets, err = store.List(AssetFilter{InstitutionName: p.Institution, Department: p.Department})
+	default:
+		assets, err = store.List(AssetFilter{InstitutionName: p.Institution})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header, fallback, filename := p.sheet()
+	sheet, err := labelJobSheet(job, p.LabelOptions, fallback)
+	if err != nil {
+		return nil, err
+	}
+	sheet.SetHeader(header)
+	skipped := []gin.H{}
+	for i, asset := range assets {
+		if err := sheet.Add(asset); err != nil {
+			skipped = append(skipped, skippedBarcode(asset, err))
+		}
+		if err := job.Progress(i+1, len(assets)); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := job.CreateArtifact(strings.TrimSuffix(filename, ".pdf") + "." + sheet.format)
+	if err != nil {
+		return nil, err
+	}
+	if err := sheet.Write(f); err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"assetCount":    len(assets),
+		"symbology":     sheet.symName,
+		"labelTemplate": sheet.tpl.Name,
+		"totalPages":    sheet.PageCount(),
+		"skipped":       skipped,
+	}, nil
+}
+
+// runLabelBatchJob prints a queued all-institutions batch to the job's file
+func runLabelBatchJob(job *runningJob) (interface{}, error) {
+	var req AllInstitutionsBarcodeRequest
+	if err := job.DecodePayload(&req); err != nil {
+		return nil, err
+	}
+	store, err := job.AssetStore()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := store.DepartmentCounts()
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, dc := range counts {
+		total += dc.Count
+	}
+	sheet, err := labelJobSheet(job, req.LabelOptions, "a4-4up")
+	if err != nil {
+		return nil, err
+	}
+
+	zipped := req.Output == batchOutputZIP
+	filename, _ := labelBatchFile(job.CompanyID, sheet.format, zipped)
+	f, err := job.CreateArtifact(filename)
+	if err != nil {
+		return nil, err
+	}
+	batch := newLabelBatch(f, sheet, counts, zipped)
+	err = batch.Run(store, func(done int) error {
+		return job.Progress(done, total)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"assetCount": total,
+		"symbology":  sheet.symName,
+		"skipped":    batch.skipped,
+	}, nil
+}
+
 // safeString safely converts a nullable string to a regular string
 func safeString(s *string) string {
 	if s == nil {
@@ -24,6 +168,11 @@ func safeString(s *string) string {
 
 // generateBarcodesHandler generates barcodes for specific assets
 func generateBarcodesHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
 	var req BarcodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, APIResponse{
@@ -32,19 +181,13 @@ func generateBarcodesHandler(c *gin.Context) {
 		})
 		return
 	}
-
-	// Get asset details for the provided IDs
-	placeholders := strings.Repeat("?,", len(req.AssetIDs))
-	placeholders = placeholders[:len(placeholders)-1] // Remove trailing comma
-
-	query := fmt.Sprintf("SELECT id, asset_name, asset_type, institution_name, department, functional_area, manufacturer, model_number, serial_number, location, status, purchase_date, purchase_price, created_at, updated_at FROM assets WHERE id IN (%s)", placeholders)
-
-	args := make([]interface{}, len(req.AssetIDs))
-	for i, id := range req.AssetIDs {
-		args[i] = id
+	if c.Query("async") == "true" {
+		enqueueLabelJob(c, store.CompanyID(), labelJobPayload{AssetIDs: req.AssetIDs, LabelOptions: req.LabelOptions})
+		return
 	}
 
-	rows, err := db.Query(query, args...)
+	// Get asset details for the provided IDs; IDs of other companies are dropped
+	assets, err := store.GetMany(req.AssetIDs)
 	if err != nil {
 		log.Printf("Error fetching assets for barcode generation: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
@@ -53,117 +196,48 @@ func generateBarcodesHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	var assets []Asset
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
-		if err != nil {
-			log.Printf("Error scanning asset: %v", err)
-			continue
-		}
-		assets = append(assets, asset)
-	}
-
-	// Generate PDF with barcodes
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	pdf.AddPage()
-
-	// Set font
-	pdf.SetFont("Arial", "B", 16)
-	pdf.Cell(190, 10, "Asset Barcodes")
-	pdf.Ln(15)
-
-	pdf.SetFont("Arial", "", 10)
-
-	for i, asset := range assets {
-		if i > 0 && i%2 == 0 {
-			pdf.AddPage()
-		}
-
-		// Generate barcode data
-		barcodeData := generateBarcodeData(asset)
-
-		// Create barcode
-		code, err := code128.Encode(barcodeData)
-		if err != nil {
-			log.Printf("Error creating barcode: %v", err)
-			continue
-		}
-
-		// Scale barcode
-		scaledCode, err := barcode.Scale(code, 200, 50)
-		if err != nil {
-			log.Printf("Error scaling barcode: %v", err)
-			continue
-		}
 
-		// Save barcode as image
-		tmpFile, err := os.CreateTemp("", fmt.Sprintf("barcode_%d_*.png", asset.ID))
-		if err != nil {
-			log.Printf("Error creating barcode temp file: %v", err)
-			continue
-		}
-		if err := png.Encode(tmpFile, scaledCode); err != nil {
-			_ = tmpFile.Close()
-			log.Printf("Error encoding barcode: %v", err)
-			continue
-		}
-		if err := tmpFile.Close(); err != nil {
-			log.Printf("Error closing barcode file: %v", err)
-		}
-
-		// Add barcode to PDF
-		pdf.Image(tmpFile.Name(), 10, float64(30+(i%2)*120), 80, 20, false, "", 0, "")
-		
-		// Add asset details
-		pdf.SetXY(10, float64(55+(i%2)*120))
-		pdf.Cell(80, 5, fmt.Sprintf("Asset: %s", asset.AssetName))
-		pdf.Ln(5)
-		pdf.Cell(80, 5, fmt.Sprintf("Type: %s", safeString(asset.AssetType)))
-		pdf.Ln(5)
-		pdf.Cell(80, 5, fmt.Sprintf("Institution: %s", safeString(asset.InstitutionName)))
-		pdf.Ln(5)
-		pdf.Cell(80, 5, fmt.Sprintf("Department: %s", safeString(asset.Department)))
-		pdf.Ln(5)
-		pdf.Cell(80, 5, fmt.Sprintf("Location: %s", safeString(asset.Location)))
-		pdf.Ln(10)
-
-		// Clean up temporary file
-		if err := os.Remove(tmpFile.Name()); err != nil {
-			log.Printf("Error removing temp barcode file: %v", err)
-		}
+	sheet, ok := requireLabelSheet(c, store.CompanyID(), req.LabelOptions, "a4-2up")
+	if !ok {
+		return
 	}
+	sheet.SetHeader("Asset Barcodes")
+	skipped := sheet.AddAll(assets)
 
 	// Save PDF
 	pdfFilename := "asset_barcodes.pdf"
-	err = pdf.OutputFileAndClose(pdfFilename)
-	if err != nil {
-		log.Printf("Error saving PDF: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Internal Server Error",
-		})
+	if sheet.format != labelFormatPDF {
+		sheet.ServePrinterJob(c, pdfFilename, skipped)
 		return
 	}
+	art, ok := saveArtifact(c, artifactLabels, pdfFilename, sheet.Write)
+	if !ok {
+		return
+	}
+	downloadURL, _ := artifacts.SignedURL(art.ID)
 
 	c.JSON(http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Barcodes generated successfully",
 		Data: gin.H{
 			"filename": pdfFilename,
+			"artifactId": art.ID,
+			"downloadUrl": downloadURL,
 			"assetCount": len(assets),
+			"symbology": sheet.symName,
+			"labelTemplate": sheet.tpl.Name,
+			"skipped": skipped,
 		},
 	})
 }
 
 // generateBarcodesByInstitutionHandler generates barcodes for all assets in an institution
 func generateBarcodesByInstitutionHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
 	var req InstitutionBarcodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, APIResponse{
@@ -172,16 +246,15 @@ func generateBarcodesByInstitutionHandler(c *gin.Context) {
 		})
 		return
 	}
+	if c.Query("async") == "true" {
+		enqueueLabelJob(c, store.CompanyID(), labelJobPayload{Institution: req.Institution, LabelOptions: req.LabelOptions})
+		return
+	}
 
-	// Get current company ID
-	companyID := getCurrentCompanyID(c)
+	companyID := store.CompanyID()
 
 	// Get all assets for the institution within the current company
-	rows, err := db.Query(`
-		SELECT id, company_id, asset_name, asset_type, institution_name, department, functional_area, 
-		manufacturer, model_number, serial_number, location, status, purchase_date, 
-		purchase_price, created_at, updated_at 
-		FROM assets WHERE institution_name = ? AND company_id = ?`, req.Institution, companyID)
+	assets, err := store.List(AssetFilter{InstitutionName: req.Institution})
 	if err != nil {
 		log.Printf("Error fetching assets by institution: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
@@ -190,22 +263,6 @@ func generateBarcodesByInstitutionHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	var assets []Asset
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.CompanyID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
-		if err != nil {
-			log.Printf("Error scanning asset: %v", err)
-			continue
-		}
-		assets = append(assets, asset)
-	}
 
 	// Debug logging
 	log.Printf("Found %d assets for institution '%s' in company %d", len(assets), req.Institution, companyID)
@@ -216,55 +273,16 @@ func generateBarcodesByInstitutionHandler(c *gin.Context) {
 	log.Printf("Total assets found: %d", len(assets))
 	
 	// Check total assets in company
-	var totalCompanyAssets int
-	err = db.QueryRow("SELECT COUNT(*) FROM assets WHERE company_id = ?", companyID).Scan(&totalCompanyAssets)
-	if err == nil {
+	if totalCompanyAssets, err := store.Count(AssetFilter{}); err == nil {
 		log.Printf("Total assets in company %d: %d", companyID, totalCompanyAssets)
 	}
 	
-	// Check total assets for this institution across all companies
-	var totalInstitutionAssets int
-	err = db.QueryRow("SELECT COUNT(*) FROM assets WHERE institution_name = ?", req.Institution).Scan(&totalInstitutionAssets)
-	if err == nil {
-		log.Printf("Total assets for institution '%s' across all companies: %d", req.Institution, totalInstitutionAssets)
-	}
-	
 	if len(assets) == 0 {
-		// Log all assets for this company to see what's available
-		allRows, err := db.Query("SELECT institution_name, department FROM assets WHERE company_id = ?", companyID)
-		if err == nil {
-			defer allRows.Close()
-			log.Printf("Available institution/department combinations for company %d:", companyID)
-			for allRows.Next() {
-				var inst, dept *string
-				if err := allRows.Scan(&inst, &dept); err == nil {
-					instStr := "NULL"
-					deptStr := "NULL"
-					if inst != nil {
-						instStr = *inst
-					}
-					if dept != nil {
-						deptStr = *dept
-					}
-					log.Printf("  Institution: '%s', Department: '%s'", instStr, deptStr)
-				}
-			}
-		}
-		
-		// Also check what institutions exist in the database
-		instRows, err := db.Query("SELECT DISTINCT institution_name FROM assets WHERE company_id = ? AND institution_name IS NOT NULL", companyID)
-		if err == nil {
-			defer instRows.Close()
+		// Log what institutions exist for this company
+		if institutions, err := store.DistinctValues("institution_name"); err == nil {
 			log.Printf("Available institutions for company %d:", companyID)
-			for instRows.Next() {
-				var inst *string
-				if err := instRows.Scan(&inst); err == nil {
-					instStr := "NULL"
-					if inst != nil {
-						instStr = *inst
-					}
-					log.Printf("  Institution: '%s'", instStr)
-				}
+			for _, inst := range institutions {
+				log.Printf("  Institution: '%s'", inst)
 			}
 		}
 	} else {
@@ -283,124 +301,42 @@ func generateBarcodesByInstitutionHandler(c *gin.Context) {
 	
 	log.Printf("=== END DEBUG ===")
 
-	// Generate PDF with barcodes
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	
-	// Calculate how many barcodes per page (2 columns, 2 rows = 4 per page)
-	barcodesPerPage := 4
-	totalPages := (len(assets) + barcodesPerPage - 1) / barcodesPerPage
-	
-	log.Printf("Generating PDF with %d assets across %d pages (%d barcodes per page)", len(assets), totalPages, barcodesPerPage)
-
-	for pageNum := 0; pageNum < totalPages; pageNum++ {
-		pdf.AddPage()
-		
-		// Set font for header
-		pdf.SetFont("Arial", "B", 16)
-		pdf.Cell(190, 10, fmt.Sprintf("Asset Barcodes - %s (Page %d of %d)", req.Institution, pageNum+1, totalPages))
-		pdf.Ln(15)
-
-		// Set font for details
-		pdf.SetFont("Arial", "", 10)
-		
-		// Calculate start and end indices for this page
-		startIdx := pageNum * barcodesPerPage
-		endIdx := startIdx + barcodesPerPage
-		if endIdx > len(assets) {
-			endIdx = len(assets)
-		}
-		
-		// Process assets for this page
-		for i := startIdx; i < endIdx; i++ {
-			asset := assets[i]
-			position := i - startIdx // Position on this page (0-3)
-			
-			// Calculate position on page (2x2 grid)
-			row := position / 2
-			col := position % 2
-			
-			xPos := float64(10 + col*95) // 95mm spacing between columns
-			yPos := float64(30 + row*120) // 120mm spacing between rows
-
-			// Generate barcode data
-			barcodeData := generateBarcodeData(asset)
-
-			// Create barcode
-			code, err := code128.Encode(barcodeData)
-			if err != nil {
-				log.Printf("Error creating barcode for asset %d: %v", asset.ID, err)
-				continue
-			}
-
-			// Scale barcode
-			scaledCode, err := barcode.Scale(code, 200, 50)
-			if err != nil {
-				log.Printf("Error scaling barcode for asset %d: %v", asset.ID, err)
-				continue
-			}
-
-			// Save barcode as image
-			tmpFile, err := os.CreateTemp("", fmt.Sprintf("barcode_%d_*.png", asset.ID))
-			if err != nil {
-				log.Printf("Error creating barcode temp file for asset %d: %v", asset.ID, err)
-				continue
-			}
-			if err := png.Encode(tmpFile, scaledCode); err != nil {
-				_ = tmpFile.Close()
-				log.Printf("Error encoding barcode for asset %d: %v", asset.ID, err)
-				continue
-			}
-			if err := tmpFile.Close(); err != nil {
-				log.Printf("Error closing barcode file for asset %d: %v", asset.ID, err)
-			}
-
-			// Add barcode to PDF
-			pdf.Image(tmpFile.Name(), xPos, yPos, 80, 20, false, "", 0, "")
-			
-			// Add asset details below barcode
-			pdf.SetXY(xPos, yPos+25.0)
-			pdf.Cell(80, 5, fmt.Sprintf("Asset: %s", asset.AssetName))
-			pdf.Ln(5)
-			pdf.Cell(80, 5, fmt.Sprintf("Type: %s", safeString(asset.AssetType)))
-			pdf.Ln(5)
-			pdf.Cell(80, 5, fmt.Sprintf("Department: %s", safeString(asset.Department)))
-			pdf.Ln(5)
-			pdf.Cell(80, 5, fmt.Sprintf("Location: %s", safeString(asset.Location)))
-			pdf.Ln(10)
-
-			// Clean up temporary file immediately
-			if err := os.Remove(tmpFile.Name()); err != nil {
-				log.Printf("Error removing temp barcode file for asset %d: %v", asset.ID, err)
-			}
-		}
-		
-		// Add progress indicator
-		log.Printf("Generated page %d/%d with %d barcodes", pageNum+1, totalPages, endIdx-startIdx)
+	sheet, ok := requireLabelSheet(c, store.CompanyID(), req.LabelOptions, "a4-4up")
+	if !ok {
+		return
 	}
+	sheet.SetHeader(fmt.Sprintf("Asset Barcodes - %s", req.Institution))
+	skipped := sheet.AddAll(assets)
+	log.Printf("Generated PDF with %d assets across %d pages", len(assets), sheet.PageCount())
 
 	// Save PDF
 	pdfFilename := fmt.Sprintf("barcodes_%s.pdf", strings.ReplaceAll(req.Institution, " ", "_"))
-	err = pdf.OutputFileAndClose(pdfFilename)
-	if err != nil {
-		log.Printf("Error saving PDF: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Internal Server Error",
-		})
+	if sheet.format != labelFormatPDF {
+		sheet.ServePrinterJob(c, pdfFilename, skipped)
 		return
 	}
+	art, ok := saveArtifact(c, artifactLabels, pdfFilename, sheet.Write)
+	if !ok {
+		return
+	}
+	downloadURL, _ := artifacts.SignedURL(art.ID)
 
 	c.JSON(http.StatusOK, APIResponse{
 		Success: true,
 		Message: fmt.Sprintf("Barcodes generated successfully for %d assets", len(assets)),
 		Data: gin.H{
 			"filename":   pdfFilename,
+			"artifactId": art.ID,
+			"downloadUrl": downloadURL,
 			"assetCount": len(assets),
 			"institution": req.Institution,
-			"barcodeTags": generateBarcodeTags(assets),
+			"barcodeTags": generateBarcodeTags(assets, sheet.sym, sheet.symName),
+			"symbology": sheet.symName,
+			"labelTemplate": sheet.tpl.Name,
+			"skipped": skipped,
 			"assetDetails": assets,
-			"totalPages": totalPages,
-			"barcodesPerPage": barcodesPerPage,
+			"totalPages": sheet.PageCount(),
+			"barcodesPerPage": sheet.tpl.Rows * sheet.tpl.Columns,
 			"generationTime": "Completed", // Could add actual timing if needed
 		},
 	})
@@ -408,6 +344,11 @@ func generateBarcodesByInstitutionHandler(c *gin.Context) {
 
 // generateBarcodesByInstitutionAndDepartmentHandler generates barcodes for assets in a specific institution and department
 func generateBarcodesByInstitutionAndDepartmentHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
 	var req InstitutionDepartmentBarcodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, APIResponse{
@@ -416,19 +357,18 @@ func generateBarcodesByInstitutionAndDepartmentHandler(c *gin.Context) {
 		})
 		return
 	}
+	if c.Query("async") == "true" {
+		enqueueLabelJob(c, store.CompanyID(), labelJobPayload{Institution: req.Institution, Department: req.Department, LabelOptions: req.LabelOptions})
+		return
+	}
 
 	// Debug logging
 	log.Printf("Received barcode generation request - Institution: '%s', Department: '%s'", req.Institution, req.Department)
 
-	// Get current company ID
-	companyID := getCurrentCompanyID(c)
+	companyID := store.CompanyID()
 
 	// Get all assets for the institution and department within the current company
-	rows, err := db.Query(`
-		SELECT id, company_id, asset_name, asset_type, institution_name, department, functional_area, 
-		manufacturer, model_number, serial_number, location, status, purchase_date, 
-		purchase_price, created_at, updated_at 
-		FROM assets WHERE institution_name = ? AND department = ? AND company_id = ?`, req.Institution, req.Department, companyID)
+	assets, err := store.List(AssetFilter{InstitutionName: req.Institution, Department: req.Department})
 	if err != nil {
 		log.Printf("Error fetching assets by institution and department: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
@@ -437,151 +377,66 @@ func generateBarcodesByInstitutionAndDepartmentHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	var assets []Asset
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.CompanyID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
-		if err != nil {
-			log.Printf("Error scanning asset: %v", err)
-			continue
-		}
-		assets = append(assets, asset)
-	}
 
 	// Debug logging
 	log.Printf("Found %d assets for institution '%s' and department '%s' in company %d", len(assets), req.Institution, req.Department, companyID)
 	if len(assets) == 0 {
-		// Log all assets for this company to see what's available
-		allRows, err := db.Query("SELECT institution_name, department FROM assets WHERE company_id = ?", companyID)
-		if err == nil {
-			defer allRows.Close()
-			log.Printf("Available institution/department combinations for company %d:", companyID)
-			for allRows.Next() {
-				var inst, dept *string
-				if err := allRows.Scan(&inst, &dept); err == nil {
-					instStr := "NULL"
-					deptStr := "NULL"
-					if inst != nil {
-						instStr = *inst
-					}
-					if dept != nil {
-						deptStr = *dept
-					}
-					log.Printf("  Institution: '%s', Department: '%s'", instStr, deptStr)
-				}
-			}
+		// Log the departments available for this company to see what's available
+		if departments, err := store.DistinctValues("department"); err == nil {
+			log.Printf("Available departments for company %d: %v", companyID, departments)
 		}
 	}
 
-	// Generate PDF with barcodes
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	pdf.AddPage()
-
-	// Set font
-	pdf.SetFont("Arial", "B", 16)
-	pdf.Cell(190, 10, fmt.Sprintf("Asset Barcodes - %s - %s", req.Institution, req.Department))
-	pdf.Ln(15)
-
-	pdf.SetFont("Arial", "", 10)
-
-	for i, asset := range assets {
-		if i > 0 && i%2 == 0 {
-			pdf.AddPage()
-		}
-
-		// Generate barcode data
-		barcodeData := generateBarcodeData(asset)
-
-		// Create barcode
-		code, err := code128.Encode(barcodeData)
-		if err != nil {
-			log.Printf("Error creating barcode: %v", err)
-			continue
-		}
-
-		// Scale barcode
-		scaledCode, err := barcode.Scale(code, 200, 50)
-		if err != nil {
-			log.Printf("Error scaling barcode: %v", err)
-			continue
-		}
-
-		// Save barcode as image
-		tmpFile, err := os.CreateTemp("", fmt.Sprintf("barcode_%d_*.png", asset.ID))
-		if err != nil {
-			log.Printf("Error creating barcode temp file: %v", err)
-			continue
-		}
-		if err := png.Encode(tmpFile, scaledCode); err != nil {
-			_ = tmpFile.Close()
-			log.Printf("Error encoding barcode: %v", err)
-			continue
-		}
-		if err := tmpFile.Close(); err != nil {
-			log.Printf("Error closing barcode file: %v", err)
-		}
-
-		// Add barcode to PDF
-		pdf.Image(tmpFile.Name(), 10, float64(30+(i%2)*120), 80, 20, false, "", 0, "")
-		
-		// Add asset details
-		pdf.SetXY(10, float64(55+(i%2)*120))
-		pdf.Cell(80, 5, fmt.Sprintf("Asset: %s", asset.AssetName))
-		pdf.Ln(5)
-		pdf.Cell(80, 5, fmt.Sprintf("Type: %s", safeString(asset.AssetType)))
-		pdf.Ln(5)
-		pdf.Cell(80, 5, fmt.Sprintf("Location: %s", safeString(asset.Location)))
-		pdf.Ln(5)
-		pdf.Cell(80, 5, fmt.Sprintf("Status: %s", asset.Status))
-		pdf.Ln(10)
-
-		// Clean up temporary file
-		if err := os.Remove(tmpFile.Name()); err != nil {
-			log.Printf("Error removing temp barcode file: %v", err)
-		}
+	sheet, ok := requireLabelSheet(c, store.CompanyID(), req.LabelOptions, "a4-2up")
+	if !ok {
+		return
 	}
+	sheet.SetHeader(fmt.Sprintf("Asset Barcodes - %s - %s", req.Institution, req.Department))
+	skipped := sheet.AddAll(assets)
 
 	// Save PDF
 	pdfFilename := fmt.Sprintf("barcodes_%s_%s.pdf", 
 		strings.ReplaceAll(req.Institution, " ", "_"),
 		strings.ReplaceAll(req.Department, " ", "_"))
-	err = pdf.OutputFileAndClose(pdfFilename)
-	if err != nil {
-		log.Printf("Error saving PDF: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Internal Server Error",
-		})
+	if sheet.format != labelFormatPDF {
+		sheet.ServePrinterJob(c, pdfFilename, skipped)
+		return
+	}
+	art, ok := saveArtifact(c, artifactLabels, pdfFilename, sheet.Write)
+	if !ok {
 		return
 	}
+	downloadURL, _ := artifacts.SignedURL(art.ID)
 
 	c.JSON(http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Barcodes generated successfully",
 		Data: gin.H{
 			"filename":   pdfFilename,
+			"artifactId": art.ID,
+			"downloadUrl": downloadURL,
 			"assetCount": len(assets),
 			"institution": req.Institution,
 			"department":  req.Department,
-			"barcodeTags": generateBarcodeTags(assets),
+			"barcodeTags": generateBarcodeTags(assets, sheet.sym, sheet.symName),
+			"symbology": sheet.symName,
+			"labelTemplate": sheet.tpl.Name,
+			"skipped": skipped,
 			"assetDetails": assets,
 		},
 	})
 }
 
 // generateBarcodeTags creates barcode tag data for frontend display
-func generateBarcodeTags(assets []Asset) []gin.H {
+func generateBarcodeTags(assets []Asset, sym barcodeSymbology, symName string) []gin.H {
 	var barcodeTags []gin.H
 	for _, asset := range assets {
 		barcodeData := generateBarcodeData(asset)
 		barcodeTags = append(barcodeTags, gin.H{
 			"formattedString": barcodeData,
+			"tag":             safeString(asset.Barcode),
+			"payload":         sym.Payload(asset),
+			"symbology":       symName,
 			"assetDetails": gin.H{
 				"id":              asset.ID,
 				"assetName":       asset.AssetName,
@@ -675,6 +530,9 @@ func getShortForm(assetType string) string {
 	if shortForm, exists := shortForms[assetType]; exists {
 		return shortForm
 	}
+	if len(assetType) <= 3 {
+		return assetType
+	}
 	return assetType[:3]
 }
 
@@ -698,260 +556,290 @@ func getInstitutionInitials(institutionName string) string {
 	return initials
 } 
 
-// generateBarcodesForAllInstitutionsHandler generates barcodes for all assets across all institutions in the company
-func generateBarcodesForAllInstitutionsHandler(c *gin.Context) {
-	// Get current company ID
-	companyID := getCurrentCompanyID(c)
-
-	// Get all assets for the company
-	rows, err := db.Query(`
-		SELECT id, company_id, asset_name, asset_type, institution_name, department, functional_area, 
-		manufacturer, model_number, serial_number, location, status, purchase_date, 
-		purchase_price, created_at, updated_at 
-		FROM assets WHERE company_id = ? ORDER BY institution_name, department`, companyID)
-	if err != nil {
-		log.Printf("Error fetching all assets by company: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Internal Server Error",
-		})
-		return
+// Output of the all-institutions batch: one document, or a ZIP with a document per institution
+const (
+	batchOutputSingle = "single"
+	batchOutputZIP    = "zip"
+)
+
+const (
+	// maxBatchPDFLabels caps single-document PDF batches, which gofpdf must assemble in
+	// memory before writing; larger batches are requested as a ZIP
+	maxBatchPDFLabels = 5000
+
+	// maxBatchFileLabels is the most labels in one document of a ZIP batch. Larger
+	// institutions are split into parts, so memory stays bounded whatever the batch size.
+	maxBatchFileLabels = 1000
+)
+
+// batchInstitution and batchDepartment name the groups of the all-institutions batch
+func batchInstitution(name string) string {
+	if name == "" {
+		return "Unknown Institution"
 	}
-	defer rows.Close()
+	return name
+}
 
-	var assets []Asset
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.CompanyID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
-		if err != nil {
-			log.Printf("Error scanning asset: %v", err)
-			continue
-		}
-		assets = append(assets, asset)
+func batchDepartment(name string) string {
+	if name == "" {
+		return "Unknown Department"
 	}
+	return name
+}
 
-	// Enhanced debugging for heavy load testing
-	log.Printf("=== HEAVY LOAD TEST: Generating barcodes for ALL institutions ===")
-	log.Printf("Company ID: %d", companyID)
-	log.Printf("Total assets found: %d", len(assets))
-	
-	// Group assets by institution for better organization
-	institutionAssets := make(map[string][]Asset)
-	for _, asset := range assets {
-		institution := safeString(asset.InstitutionName)
-		if institution == "" {
-			institution = "Unknown Institution"
+// batchFileName reduces a name to characters that are safe in file names
+func batchFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
 		}
-		institutionAssets[institution] = append(institutionAssets[institution], asset)
+		return '_'
+	}, name)
+}
+
+// labelBatch streams the labels of many institutions. Without a ZIP writer everything goes
+// into one sheet; with one, each institution (or part of one) is written to the archive as
+// soon as it is complete, and its sheet is dropped.
+type labelBatch struct {
+	w       io.Writer
+	zip     *zip.Writer
+	sheet   *labelSheet
+	counts  []DepartmentCount
+	skipped []gin.H
+	inst    string
+	dept    string
+	part    int
+	inFile  int
+	started bool
+}
+
+// labelBatchFile names the output of an all-institutions batch and gives its content type
+func labelBatchFile(companyID int, format string, zipped bool) (string, string) {
+	filename := fmt.Sprintf("all_institutions_barcodes_company_%d", companyID)
+	switch {
+	case zipped:
+		return filename + ".zip", "application/zip"
+	case format == labelFormatPDF:
+		return filename + ".pdf", "application/pdf"
 	}
-	
-	log.Printf("Assets grouped by institution:")
-	for institution, instAssets := range institutionAssets {
-		log.Printf("  %s: %d assets", institution, len(instAssets))
+	return filename + "." + format, labelFormatContentTypes[format]
+}
+
+// newLabelBatch starts a batch writing to w, as a ZIP when zipped
+func newLabelBatch(w io.Writer, sheet *labelSheet, counts []DepartmentCount, zipped bool) *labelBatch {
+	b := &labelBatch{w: w, sheet: sheet, counts: counts}
+	if zipped {
+		b.zip = zip.NewWriter(w)
 	}
-	
-	// Check total assets in company
-	var totalCompanyAssets int
-	err = db.QueryRow("SELECT COUNT(*) FROM assets WHERE company_id = ?", companyID).Scan(&totalCompanyAssets)
-	if err == nil {
-		log.Printf("Total assets in company %d: %d", companyID, totalCompanyAssets)
+	return b
+}
+
+// Run prints the labels of all the company's assets, reading them one at a time, and
+// reports each printed or skipped asset to progress when it is set
+func (b *labelBatch) Run(store AssetStore, progress func(done int) error) error {
+	done := 0
+	err := store.Each(AssetFilter{OrderBy: "institution"}, func(asset Asset) error {
+		if err := b.Add(asset); err != nil {
+			return err
+		}
+		if done++; progress != nil {
+			return progress(done)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	
-	// Get unique institutions
-	var uniqueInstitutions []string
-	instRows, err := db.Query("SELECT DISTINCT institution_name FROM assets WHERE company_id = ? AND institution_name IS NOT NULL ORDER BY institution_name", companyID)
-	if err == nil {
-		defer instRows.Close()
-		for instRows.Next() {
-			var inst *string
-			if err := instRows.Scan(&inst); err == nil {
-				if inst != nil {
-					uniqueInstitutions = append(uniqueInstitutions, *inst)
-				}
-			}
+	return b.Close()
+}
+
+// summary lists the departments of an institution with their asset counts
+func (b *labelBatch) summary(institution string) []string {
+	total := 0
+	lines := []string{}
+	for _, dc := range b.counts {
+		if strings.EqualFold(batchInstitution(dc.Institution), institution) {
+			total += dc.Count
+			lines = append(lines, fmt.Sprintf("  %s: %d assets", batchDepartment(dc.Department), dc.Count))
 		}
 	}
-	log.Printf("Unique institutions found: %d", len(uniqueInstitutions))
-	for _, inst := range uniqueInstitutions {
-		log.Printf("  - %s", inst)
+	return append([]string{fmt.Sprintf("Total Assets: %d", total), "Departments:"}, lines...)
+}
+
+// flush writes the current sheet to the archive and starts an empty one
+func (b *labelBatch) flush() error {
+	name := batchFileName(b.inst)
+	if b.part > 1 {
+		name = fmt.Sprintf("%s_part%d", name, b.part)
 	}
-	
-	log.Printf("=== END HEAVY LOAD TEST DEBUG ===")
+	w, err := b.zip.Create(name + "." + b.sheet.format)
+	if err != nil {
+		return err
+	}
+	if err := b.sheet.r.write(w); err != nil {
+		return err
+	}
+	if f, ok := b.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	b.sheet = b.sheet.Next()
+	b.inFile = 0
+	return nil
+}
 
-	// Generate PDF with barcodes organized by institution
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	
-	// Calculate pagination
-	barcodesPerPage := 4
-	totalPages := (len(assets) + barcodesPerPage - 1) / barcodesPerPage
-	
-	log.Printf("Generating comprehensive PDF with %d assets across %d pages (%d barcodes per page)", len(assets), totalPages, barcodesPerPage)
+// Add prints an asset, starting a section page for each institution and new pages, with
+// their own header, for each department
+func (b *labelBatch) Add(asset Asset) error {
+	inst := batchInstitution(safeString(asset.InstitutionName))
+	dept := batchDepartment(safeString(asset.Department))
 
-	currentPage := 0
-	assetIndex := 0
+	if !b.started || !strings.EqualFold(inst, b.inst) {
+		if b.zip != nil && b.started {
+			if err := b.flush(); err != nil {
+				return err
+			}
+		}
+		b.inst, b.dept, b.part, b.started = inst, "", 1, true
+		b.sheet.AddSectionPage(fmt.Sprintf("Institution: %s", inst), b.summary(inst))
+	} else if b.zip != nil && b.inFile >= maxBatchFileLabels {
+		if err := b.flush(); err != nil {
+			return err
+		}
+		b.part++
+		b.dept = ""
+	}
 
-	for _, institution := range uniqueInstitutions {
-		instAssets := institutionAssets[institution]
-		if len(instAssets) == 0 {
-			continue
+	if !strings.EqualFold(dept, b.dept) {
+		b.dept = dept
+		b.sheet.SetHeader(fmt.Sprintf("Asset Barcodes - %s - %s", inst, dept))
+		b.sheet.BreakPage()
+	}
+	if err := b.sheet.Add(asset); err != nil {
+		log.Printf("Error creating %s barcode for asset %d: %v", b.sheet.symName, asset.ID, err)
+		b.skipped = append(b.skipped, skippedBarcode(asset, err))
+		return nil
+	}
+	b.inFile++
+	return nil
+}
+
+// Close writes what is left: the last document, and the assets that were not printed,
+// as a final section page or a skipped.csv file in the archive
+func (b *labelBatch) Close() error {
+	if b.zip == nil {
+		if len(b.skipped) > 0 {
+			b.sheet.AddSectionPage("Assets not printed", skippedLines(b.skipped))
 		}
+		return b.sheet.r.write(b.w)
+	}
 
-		// Add institution header page
-		pdf.AddPage()
-		currentPage++
-		
-		// Set font for institution header
-		pdf.SetFont("Arial", "B", 18)
-		pdf.Cell(190, 15, fmt.Sprintf("Institution: %s", institution))
-		pdf.Ln(20)
-		
-		pdf.SetFont("Arial", "B", 12)
-		pdf.Cell(190, 10, fmt.Sprintf("Total Assets: %d", len(instAssets)))
-		pdf.Ln(15)
-		
-		// Add institution summary
-		pdf.SetFont("Arial", "", 10)
-		pdf.Cell(190, 8, "Asset Summary:")
-		pdf.Ln(10)
-		
-		// Group by department
-		deptAssets := make(map[string][]Asset)
-		for _, asset := range instAssets {
-			dept := safeString(asset.Department)
-			if dept == "" {
-				dept = "Unknown Department"
-			}
-			deptAssets[dept] = append(deptAssets[dept], asset)
+	if b.started {
+		if err := b.flush(); err != nil {
+			return err
 		}
-		
-		for dept, deptAssetList := range deptAssets {
-			pdf.Cell(190, 6, fmt.Sprintf("  %s: %d assets", dept, len(deptAssetList)))
-			pdf.Ln(6)
+	}
+	if len(b.skipped) > 0 {
+		w, err := b.zip.Create("skipped.csv")
+		if err != nil {
+			return err
 		}
-		
-		// Generate barcodes for this institution
-		for _, asset := range instAssets {
-			// Check if we need a new page for barcodes
-			if assetIndex > 0 && assetIndex%barcodesPerPage == 0 {
-				pdf.AddPage()
-				currentPage++
-				
-				// Add page header
-				pdf.SetFont("Arial", "B", 14)
-				pdf.Cell(190, 10, fmt.Sprintf("Asset Barcodes - %s (Page %d)", institution, currentPage))
-				pdf.Ln(15)
-			}
-			
-			// If this is the first barcode on a new page, add header
-			if assetIndex%barcodesPerPage == 0 {
-				pdf.SetFont("Arial", "B", 12)
-				pdf.Cell(190, 8, fmt.Sprintf("Institution: %s", institution))
-				pdf.Ln(10)
-				pdf.SetFont("Arial", "", 10)
-			}
-
-			position := assetIndex % barcodesPerPage
-			
-			// Calculate position on page (2x2 grid)
-			row := position / 2
-			col := position % 2
-			
-			xPos := float64(10 + col*95) // 95mm spacing between columns
-			yPos := float64(40 + row*120) // 40mm offset for header, 120mm spacing between rows
-
-			// Generate barcode data
-			barcodeData := generateBarcodeData(asset)
-
-			// Create barcode
-			code, err := code128.Encode(barcodeData)
-			if err != nil {
-				log.Printf("Error creating barcode for asset %d: %v", asset.ID, err)
-				assetIndex++
-				continue
-			}
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"asset_id", "error"})
+		for _, skip := range b.skipped {
+			cw.Write([]string{fmt.Sprint(skip["assetId"]), fmt.Sprint(skip["error"])})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	return b.zip.Close()
+}
 
-			// Scale barcode
-			scaledCode, err := barcode.Scale(code, 200, 50)
-			if err != nil {
-				log.Printf("Error scaling barcode for asset %d: %v", asset.ID, err)
-				assetIndex++
-				continue
-			}
+// skippedLines describes skipped assets for a section page
+func skippedLines(skipped []gin.H) []string {
+	lines := make([]string, len(skipped))
+	for i, skip := range skipped {
+		lines[i] = fmt.Sprintf("Asset %v: %v", skip["assetId"], skip["error"])
+	}
+	return lines
+}
 
-			// Save barcode as image
-			tmpFile, err := os.CreateTemp("", fmt.Sprintf("barcode_%d_*.png", asset.ID))
-			if err != nil {
-				log.Printf("Error creating barcode temp file for asset %d: %v", asset.ID, err)
-				assetIndex++
-				continue
-			}
-			if err := png.Encode(tmpFile, scaledCode); err != nil {
-				_ = tmpFile.Close()
-				log.Printf("Error encoding barcode for asset %d: %v", asset.ID, err)
-				assetIndex++
-				continue
-			}
-			if err := tmpFile.Close(); err != nil {
-				log.Printf("Error closing barcode file for asset %d: %v", asset.ID, err)
-			}
+// generateBarcodesForAllInstitutionsHandler streams the labels of all the company's assets,
+// grouped by institution and department, as one document or as a ZIP of one document per
+// institution. Assets are read and printed one at a time.
+func generateBarcodesForAllInstitutionsHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
 
-			// Add barcode to PDF
-			pdf.Image(tmpFile.Name(), xPos, yPos, 80, 20, false, "", 0, "")
-			
-			// Add asset details below barcode
-			pdf.SetXY(xPos, yPos+25.0)
-			pdf.Cell(80, 5, fmt.Sprintf("Asset: %s", asset.AssetName))
-			pdf.Ln(5)
-			pdf.Cell(80, 5, fmt.Sprintf("Type: %s", safeString(asset.AssetType)))
-			pdf.Ln(5)
-			pdf.Cell(80, 5, fmt.Sprintf("Department: %s", safeString(asset.Department)))
-			pdf.Ln(5)
-			pdf.Cell(80, 5, fmt.Sprintf("Location: %s", safeString(asset.Location)))
-			pdf.Ln(10)
-
-			// Clean up temporary file immediately
-			if err := os.Remove(tmpFile.Name()); err != nil {
-				log.Printf("Error removing temp barcode file for asset %d: %v", asset.ID, err)
-			}
-			
-			assetIndex++
-		}
-		
-		// Add progress indicator
-		log.Printf("Generated barcodes for institution '%s' with %d assets", institution, len(instAssets))
+	var req AllInstitutionsBarcodeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid label options",
+		})
+		return
+	}
+	if req.Output == "" {
+		req.Output = batchOutputSingle
+	}
+	if req.Output != batchOutputSingle && req.Output != batchOutputZIP {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "output must be single or zip",
+		})
+		return
 	}
-	
-	log.Printf("Completed heavy load test: Generated %d total barcodes across %d institutions", len(assets), len(uniqueInstitutions))
 
-	// Save PDF
-	pdfFilename := fmt.Sprintf("all_institutions_barcodes_company_%d.pdf", companyID)
-	err = pdf.OutputFileAndClose(pdfFilename)
+	counts, err := store.DepartmentCounts()
 	if err != nil {
-		log.Printf("Error saving PDF: %v", err)
+		log.Printf("Error counting assets by institution: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   "Internal Server Error",
 		})
 		return
 	}
+	total := 0
+	for _, dc := range counts {
+		total += dc.Count
+	}
+	if total == 0 {
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "No assets found",
+		})
+		return
+	}
 
-	c.JSON(http.StatusOK, APIResponse{
-		Success: true,
-		Message: fmt.Sprintf("Heavy load test completed: Generated barcodes for %d assets across %d institutions", len(assets), len(uniqueInstitutions)),
-		Data: gin.H{
-			"filename":   pdfFilename,
-			"assetCount": len(assets),
-			"institutionCount": len(uniqueInstitutions),
-			"institutions": uniqueInstitutions,
-			"barcodeTags": generateBarcodeTags(assets),
-			"assetDetails": assets,
-			"totalPages": currentPage,
-			"barcodesPerPage": barcodesPerPage,
-			"generationTime": "Heavy Load Test Completed",
-		},
-	})
-} 
\ No newline at end of file
+	sheet, ok := requireLabelSheet(c, store.CompanyID(), req.LabelOptions, "a4-4up")
+	if !ok {
+		return
+	}
+	if req.Output == batchOutputSingle && sheet.format == labelFormatPDF && total > maxBatchPDFLabels {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Single PDFs are limited to %d assets; request output=zip for larger batches", maxBatchPDFLabels),
+		})
+		return
+	}
+
+	if c.Query("async") == "true" {
+		enqueueJobHandler(c, jobTypeLabelBatch, req)
+		return
+	}
+	filename, contentType := labelBatchFile(store.CompanyID(), sheet.format, req.Output == batchOutputZIP)
+	log.Printf("Generating %d labels for company %d as %s", total, store.CompanyID(), filename)
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	batch := newLabelBatch(c.Writer, sheet, counts, req.Output == batchOutputZIP)
+	if err := batch.Run(store, nil); err != nil {
+		// The status and part of the body are already sent, so the error can only be logged
+		log.Printf("Error generating barcodes for all institutions: %v", err)
+	}
+}
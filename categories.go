@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -14,7 +15,8 @@ func getCategoriesHandler(c *gin.Context) {
 	companyID := getCurrentCompanyID(c)
 
 	rows, err := db.Query(`
-		SELECT id, company_id, name, description, color, is_active, created_at, updated_at
+		SELECT id, company_id, name, description, color, is_active, depreciation_method,
+		useful_life_years, salvage_percent, created_at, updated_at
 		FROM asset_categories 
 		WHERE company_id = ? AND is_active = true
 		ORDER BY name
@@ -33,7 +35,8 @@ func getCategoriesHandler(c *gin.Context) {
 		var cat AssetCategory
 		err := rows.Scan(
 			&cat.ID, &cat.CompanyID, &cat.Name, &cat.Description,
-			&cat.Color, &cat.IsActive, &cat.CreatedAt, &cat.UpdatedAt,
+			&cat.Color, &cat.IsActive, &cat.DepreciationMethod, &cat.UsefulLifeYears,
+			&cat.SalvagePercent, &cat.CreatedAt, &cat.UpdatedAt,
 		)
 		if err != nil {
 			log.Printf("Error scanning category: %v", err)
@@ -59,6 +62,9 @@ func getCategoriesHandler(c *gin.Context) {
 			"description": description,
 			"color":       color,
 			"is_active":   cat.IsActive,
+			"depreciation_method": cat.DepreciationMethod,
+			"useful_life_years":   cat.UsefulLifeYears,
+			"salvage_percent":     cat.SalvagePercent,
 			"created_at":  cat.CreatedAt.Format("2006-01-02 15:04:05"),
 			"updated_at":  cat.UpdatedAt.Format("2006-01-02 15:04:05"),
 		})
@@ -93,6 +99,14 @@ func addCategoryHandler(c *gin.Context) {
 		return
 	}
 
+	if err := validateCategoryDepreciation(req.DepreciationMethod, req.UsefulLifeYears, req.SalvagePercent); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	// Check if category name already exists for this company
 	var existingID int
 	err := db.QueryRow("SELECT id FROM asset_categories WHERE company_id = ? AND name = ?", companyID, req.Name).Scan(&existingID)
@@ -110,9 +124,11 @@ func addCategoryHandler(c *gin.Context) {
 	}
 
 	result, err := db.Exec(`
-		INSERT INTO asset_categories (company_id, name, description, color)
-		VALUES (?, ?, ?, ?)
-	`, companyID, req.Name, req.Description, req.Color)
+		INSERT INTO asset_categories (company_id, name, description, color, depreciation_method,
+		useful_life_years, salvage_percent)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, companyID, req.Name, req.Description, req.Color, optionalString(req.DepreciationMethod),
+		req.UsefulLifeYears, req.SalvagePercent)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -153,6 +169,14 @@ func updateCategoryHandler(c *gin.Context) {
 		return
 	}
 
+	if err := validateCategoryDepreciation(req.DepreciationMethod, req.UsefulLifeYears, req.SalvagePercent); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	// Check if category exists and belongs to this company
 	var existingID int
 	err = db.QueryRow("SELECT id FROM asset_categories WHERE id = ? AND company_id = ?", categoryID, companyID).Scan(&existingID)
@@ -192,6 +216,18 @@ func updateCategoryHandler(c *gin.Context) {
 		updates = append(updates, "is_active = ?")
 		params = append(params, *req.IsActive)
 	}
+	if req.DepreciationMethod != "" {
+		updates = append(updates, "depreciation_method = ?")
+		params = append(params, req.DepreciationMethod)
+	}
+	if req.UsefulLifeYears != nil {
+		updates = append(updates, "useful_life_years = ?")
+		params = append(params, *req.UsefulLifeYears)
+	}
+	if req.SalvagePercent != nil {
+		updates = append(updates, "salvage_percent = ?")
+		params = append(params, *req.SalvagePercent)
+	}
 
 	if len(updates) == 0 {
 		c.JSON(http.StatusBadRequest, APIResponse{
@@ -254,8 +290,11 @@ func deleteCategoryHandler(c *gin.Context) {
 	}
 
 	// Check if category is being used by any assets
-	var assetCount int
-	err = db.QueryRow("SELECT COUNT(*) FROM assets WHERE category_id = ? AND company_id = ?", categoryID, companyID).Scan(&assetCount)
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+	assetCount, err := store.Count(AssetFilter{CategoryID: &categoryID})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -286,4 +325,15 @@ func deleteCategoryHandler(c *gin.Context) {
 		Success: true,
 		Message: "Category deleted successfully",
 	})
-} 
\ No newline at end of file
+}
+
+// validateCategoryDepreciation checks the depreciation defaults of a category request
+func validateCategoryDepreciation(method string, lifeYears *int, salvagePercent *float64) error {
+	if err := validateDepreciation(method, lifeYears); err != nil {
+		return err
+	}
+	if salvagePercent != nil && (*salvagePercent < 0 || *salvagePercent > 100) {
+		return errors.New("salvage_percent must be between 0 and 100")
+	}
+	return nil
+}
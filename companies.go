@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -122,16 +123,6 @@ func createCompanyHandler(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token for the new admin user
-	token, err := generateJWT(int(userID), int(companyID), req.AdminUser.Username, "admin")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Failed to generate token: " + err.Error(),
-		})
-		return
-	}
-
 	// Get the created company and user
 	var company Company
 	err = db.QueryRow(`
@@ -169,15 +160,29 @@ func createCompanyHandler(c *gin.Context) {
 		return
 	}
 
+	// Sign the new admin user in
+	tokens, err := startSession(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to generate token: " + err.Error(),
+		})
+		return
+	}
+
+	adminPerms, _, _ := rolePermissions(int(companyID), "admin")
 	c.JSON(http.StatusCreated, APIResponse{
 		Success: true,
 		Message: "Company created successfully",
 		Data: LoginResponse{
-			Token:     token,
-			User:      user,
-			Company:   company,
-			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
-			Roles:     []string{"admin"},
+			Token:            tokens.Token,
+			RefreshToken:     tokens.RefreshToken,
+			User:             user,
+			Company:          company,
+			ExpiresAt:        tokens.ExpiresAt,
+			RefreshExpiresAt: tokens.RefreshExpiresAt,
+			Roles:            legacyRoles(adminPerms),
+			Permissions:      sortedPermissions(adminPerms),
 		},
 	})
 }
@@ -273,23 +278,16 @@ func updateCompanyHandler(c *gin.Context) {
 	})
 }
 
-// listCompaniesHandler returns all companies (admin only)
+// listCompaniesHandler returns the caller's company (requires company:manage). Company
+// roles only ever reach their own tenant, so other companies are never listed.
 func listCompaniesHandler(c *gin.Context) {
-	// Check if user is admin
-	user := getCurrentUser(c)
-	if user == nil || user.Role != "admin" {
-		c.JSON(http.StatusForbidden, APIResponse{
-			Success: false,
-			Error:   "Access denied",
-		})
-		return
-	}
-
+	companyID := getCurrentCompanyID(c)
 	rows, err := db.Query(`
 		SELECT id, company_name, company_code, email, subscription_plan, is_active, trial_ends_at, created_at, updated_at
 		FROM companies
+		WHERE id = ?
 		ORDER BY created_at DESC
-	`)
+	`, companyID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -318,4 +316,172 @@ func listCompaniesHandler(c *gin.Context) {
 		Success: true,
 		Data:    companies,
 	})
-} 
\ No newline at end of file
+} 
+
+// companySettingValidators lists the company settings that can be changed through the
+// API, each with the check its value must pass
+var companySettingValidators = map[string]func(value string) error{
+	trashRetentionSetting: func(v string) error {
+		if days, err := strconv.Atoi(v); err != nil || days < 0 {
+			return fmt.Errorf("%s must be a whole number of days", trashRetentionSetting)
+		}
+		return nil
+	},
+	assetTagPatternSetting: validateAssetTagPattern,
+	labelTemplateSetting:   validateLabelTemplateSetting,
+	labelLogoSetting:       validateLabelLogo,
+	barcodeSymbologySetting: func(v string) error {
+		if _, ok := barcodeSymbologies[v]; !ok {
+			return fmt.Errorf("%s must be one of %s", barcodeSymbologySetting, symbologyNames())
+		}
+		return nil
+	},
+	twoFactorRequiredSetting: validateTwoFactorSetting,
+}
+
+// getCompanySetting reads one company setting; ok is false when it is unset
+func getCompanySetting(companyID int, key string) (value string, ok bool, err error) {
+	var v sql.NullString
+	err = db.QueryRow("SELECT setting_value FROM company_settings WHERE company_id = ? AND setting_key = ?",
+		companyID, key).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return v.String, v.Valid && v.String != "", nil
+}
+
+// getCompanySettingsHandler returns the current company's API-managed settings
+func getCompanySettingsHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+
+	settings := gin.H{}
+	for key := range companySettingValidators {
+		value, ok, err := getCompanySetting(companyID, key)
+		if err != nil {
+			log.Printf("Error fetching company setting %s: %v", key, err)
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   "Internal Server Error",
+			})
+			return
+		}
+		if ok {
+			settings[key] = value
+		} else {
+			settings[key] = nil
+		}
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    settings,
+	})
+}
+
+// updateCompanySettingsHandler sets company settings (admin only). A null value removes
+// the setting so the server default applies again.
+func updateCompanySettingsHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+
+	var req map[string]*string
+	if err := c.ShouldBindJSON(&req); err != nil || len(req) == 0 {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data",
+		})
+		return
+	}
+	for key, value := range req {
+		validate, ok := companySettingValidators[key]
+		if !ok {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Unknown setting %q", key),
+			})
+			return
+		}
+		if value == nil {
+			continue
+		}
+		if err := validate(*value); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	// Requiring 2FA signs out everyone it covers who has not enabled it, so whoever turns
+	// it on must have enabled it, or they could lock themselves out
+	requireTwoFactor := req[twoFactorRequiredSetting] != nil && *req[twoFactorRequiredSetting] == "true"
+	if requireTwoFactor {
+		tf, err := loadUserTwoFactor(db, getCurrentUserID(c))
+		if err != nil {
+			log.Printf("Error fetching two-factor status: %v", err)
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   "Internal Server Error",
+			})
+			return
+		}
+		if tf == nil || tf.EnabledAt == nil {
+			c.JSON(http.StatusConflict, APIResponse{
+				Success: false,
+				Error:   "Enable two-factor authentication for your own account before requiring it",
+			})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error updating company settings: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	for key, value := range req {
+		if value == nil {
+			_, err = tx.Exec("DELETE FROM company_settings WHERE company_id = ? AND setting_key = ?", companyID, key)
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO company_settings (company_id, setting_key, setting_value)
+				VALUES (?, ?, ?)
+				ON DUPLICATE KEY UPDATE setting_value = VALUES(setting_value)`, companyID, key, *value)
+		}
+		if err != nil {
+			break
+		}
+	}
+	var revoked int64
+	if err == nil && requireTwoFactor {
+		revoked, err = revokeSessionsWithoutTwoFactor(tx, companyID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error updating company settings: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	if revoked > 0 {
+		log.Printf("Company %d requires two-factor authentication; revoked %d sessions without it", companyID, revoked)
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Settings updated successfully",
+	})
+}
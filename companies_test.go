@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestListCompaniesIsScoped checks that listing companies only reads the caller's own
+func TestListCompaniesIsScoped(t *testing.T) {
+	mock := useMockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM companies\n\t\tWHERE id = ?")).
+		WithArgs(ownCompany).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "company_name", "company_code", "email",
+			"subscription_plan", "is_active", "trial_ends_at", "created_at", "updated_at"}))
+
+	c, w := userRequest("admin", 0, "")
+	listCompaniesHandler(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", w.Code, w.Body)
+	}
+	checkMock(t, mock)
+}
@@ -0,0 +1,874 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Custom field types
+const (
+	customFieldText        = "text"
+	customFieldNumber      = "number"
+	customFieldDate        = "date"
+	customFieldSelect      = "select"
+	customFieldMultiSelect = "multi_select"
+	customFieldBoolean     = "boolean"
+)
+
+const (
+	// maxCustomFieldText is the longest text value when a field sets no max_length
+	maxCustomFieldText = 1000
+
+	// maxCustomFieldOptions is the most options a select field may have
+	maxCustomFieldOptions = 100
+
+	// customFieldRangeSeparator splits the bounds of number and date filters, as in "10..20"
+	customFieldRangeSeparator = ".."
+)
+
+// customFieldKeyPattern keeps keys safe to use in JSON paths and as column names
+var customFieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// customFieldTypes are the accepted field types
+var customFieldTypes = map[string]bool{
+	customFieldText:        true,
+	customFieldNumber:      true,
+	customFieldDate:        true,
+	customFieldSelect:      true,
+	customFieldMultiSelect: true,
+	customFieldBoolean:     true,
+}
+
+// customFieldValues are an asset's custom field values keyed by field key: strings for
+// text, dates and selects, float64 for numbers, bool for booleans and []string for
+// multi-selects. They are stored as a JSON object in assets.custom_fields.
+type customFieldValues map[string]interface{}
+
+// Scan reads the JSON column; NULL is an empty set of values
+func (v *customFieldValues) Scan(src interface{}) error {
+	*v = customFieldValues{}
+	var raw []byte
+	switch s := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		raw = s
+	case string:
+		raw = []byte(s)
+	default:
+		return fmt.Errorf("cannot scan %T into custom field values", src)
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return err
+	}
+	for key, value := range values {
+		// JSON arrays decode as []interface{}; multi-selects are kept as []string
+		if list, ok := value.([]interface{}); ok {
+			strs := make([]string, 0, len(list))
+			for _, item := range list {
+				strs = append(strs, fmt.Sprint(item))
+			}
+			value = strs
+		}
+		(*v)[key] = value
+	}
+	return nil
+}
+
+// Value writes the values as JSON, or NULL when there are none
+func (v customFieldValues) Value() (driver.Value, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(map[string]interface{}(v))
+}
+
+// flatCustomValue returns a value with multi-selects joined into one string, so it can
+// be compared in the asset history and written to a spreadsheet cell
+func flatCustomValue(value interface{}) interface{} {
+	if list, ok := value.([]string); ok {
+		return strings.Join(list, ", ")
+	}
+	return value
+}
+
+// customFieldError is an invalid custom field value or filter, reported to the client as 400
+type customFieldError struct {
+	Key     string
+	Message string
+}
+
+func (e *customFieldError) Error() string {
+	return fmt.Sprintf("custom field %s: %s", e.Key, e.Message)
+}
+
+// customFieldPath returns the JSON path of a field in assets.custom_fields
+func customFieldPath(key string) string {
+	return fmt.Sprintf(`$."%s"`, key)
+}
+
+// appliesTo reports whether a field is used by assets of a category
+func (f *CustomField) appliesTo(categoryID *int) bool {
+	return f.CategoryID == nil || (categoryID != nil && *f.CategoryID == *categoryID)
+}
+
+// normalize checks a value against the field's type and rules and returns it in stored
+// form. Strings are accepted for every type, so spreadsheet cells can be imported.
+// A nil result means the value is empty.
+func (f *CustomField) normalize(value interface{}) (interface{}, error) {
+	invalid := func(format string, args ...interface{}) (interface{}, error) {
+		return nil, &customFieldError{Key: f.Key, Message: fmt.Sprintf(format, args...)}
+	}
+	if s, ok := value.(string); ok {
+		value = strings.TrimSpace(s)
+		if value == "" {
+			return nil, nil
+		}
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	switch f.Type {
+	case customFieldText:
+		s, ok := value.(string)
+		if !ok {
+			return invalid("must be text")
+		}
+		limit := maxCustomFieldText
+		if f.MaxLength != nil {
+			limit = *f.MaxLength
+		}
+		if len([]rune(s)) > limit {
+			return invalid("may be at most %d characters", limit)
+		}
+		if f.Pattern != nil {
+			pattern, err := regexp.Compile(fullMatch(*f.Pattern))
+			if err != nil {
+				return nil, err
+			}
+			if !pattern.MatchString(s) {
+				return invalid("does not match the required format")
+			}
+		}
+		return s, nil
+
+	case customFieldNumber:
+		var n float64
+		switch t := value.(type) {
+		case float64:
+			n = t
+		case string:
+			parsed, err := strconv.ParseFloat(t, 64)
+			if err != nil {
+				return invalid("must be a number")
+			}
+			n = parsed
+		default:
+			return invalid("must be a number")
+		}
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return invalid("must be a number")
+		}
+		if f.MinValue != nil && n < *f.MinValue {
+			return invalid("must be at least %g", *f.MinValue)
+		}
+		if f.MaxValue != nil && n > *f.MaxValue {
+			return invalid("must be at most %g", *f.MaxValue)
+		}
+		return n, nil
+
+	case customFieldDate:
+		s, ok := value.(string)
+		if !ok {
+			return invalid("must be a date, YYYY-MM-DD")
+		}
+		date, err := parseImportDate(s)
+		if err != nil {
+			return invalid("must be a date, YYYY-MM-DD")
+		}
+		return date.Format("2006-01-02"), nil
+
+	case customFieldBoolean:
+		switch t := value.(type) {
+		case bool:
+			return t, nil
+		case string:
+			switch strings.ToLower(t) {
+			case "true", "yes", "y", "1":
+				return true, nil
+			case "false", "no", "n", "0":
+				return false, nil
+			}
+		}
+		return invalid("must be true or false")
+
+	case customFieldSelect:
+		s, ok := value.(string)
+		if !ok {
+			return invalid("must be one of the field's options")
+		}
+		option, ok := f.option(s)
+		if !ok {
+			return invalid("%q is not one of the field's options", s)
+		}
+		return option, nil
+
+	case customFieldMultiSelect:
+		var items []string
+		switch t := value.(type) {
+		case []string:
+			items = t
+		case []interface{}:
+			for _, item := range t {
+				s, ok := item.(string)
+				if !ok {
+					return invalid("must be a list of the field's options")
+				}
+				items = append(items, s)
+			}
+		case string:
+			// Spreadsheet cells hold one option, or several separated by semicolons or commas
+			if _, ok := f.option(t); ok {
+				items = []string{t}
+			} else {
+				items = strings.FieldsFunc(t, func(r rune) bool { return r == ';' || r == ',' })
+			}
+		default:
+			return invalid("must be a list of the field's options")
+		}
+		selected := []string{}
+		seen := make(map[string]bool)
+		for _, item := range items {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			option, ok := f.option(item)
+			if !ok {
+				return invalid("%q is not one of the field's options", item)
+			}
+			if !seen[option] {
+				seen[option] = true
+				selected = append(selected, option)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, nil
+		}
+		return selected, nil
+	}
+	return invalid("has an unknown type")
+}
+
+// option returns the field's option matching a value regardless of case
+func (f *CustomField) option(value string) (string, bool) {
+	for _, option := range f.Options {
+		if strings.EqualFold(option, value) {
+			return option, true
+		}
+	}
+	return "", false
+}
+
+// fullMatch anchors a pattern so it must match a whole value
+func fullMatch(pattern string) string {
+	return `^(?:` + pattern + `)$`
+}
+
+// validateCustomFieldValues checks the values of an asset against the fields that apply
+// to its category and returns them normalized. Required fields must have a value.
+func validateCustomFieldValues(fields []CustomField, categoryID *int, values customFieldValues) (customFieldValues, error) {
+	byKey := make(map[string]*CustomField)
+	for i := range fields {
+		byKey[fields[i].Key] = &fields[i]
+	}
+
+	normalized := customFieldValues{}
+	for key, value := range values {
+		field, ok := byKey[key]
+		if !ok || !field.appliesTo(categoryID) {
+			return nil, &customFieldError{Key: key, Message: "is not a custom field of the asset's category"}
+		}
+		v, err := field.normalize(value)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			normalized[key] = v
+		}
+	}
+	for i := range fields {
+		f := &fields[i]
+		if _, ok := normalized[f.Key]; f.Required && !ok && f.appliesTo(categoryID) {
+			return nil, &customFieldError{Key: f.Key, Message: "is required"}
+		}
+	}
+	return normalized, nil
+}
+
+// CustomFieldFilter is a condition on one custom field of the assets: an exact value,
+// a substring of text fields, an option of multi-selects, or a range of numbers and dates
+type CustomFieldFilter struct {
+	Key   string
+	Type  string
+	Value interface{}
+	From  interface{}
+	To    interface{}
+}
+
+// condition returns the SQL condition of a filter and its arguments
+func (f CustomFieldFilter) condition() (string, []interface{}) {
+	path := customFieldPath(f.Key)
+	text := "JSON_UNQUOTE(JSON_EXTRACT(custom_fields, ?))"
+	switch f.Type {
+	case customFieldText:
+		return "LOWER(" + text + ") LIKE ?", []interface{}{path, "%" + strings.ToLower(f.Value.(string)) + "%"}
+	case customFieldMultiSelect:
+		return "JSON_CONTAINS(JSON_EXTRACT(custom_fields, ?), JSON_QUOTE(?))", []interface{}{path, f.Value}
+	case customFieldBoolean:
+		literal := "false"
+		if f.Value.(bool) {
+			literal = "true"
+		}
+		return "JSON_EXTRACT(custom_fields, ?) = CAST('" + literal + "' AS JSON)", []interface{}{path}
+	case customFieldNumber, customFieldDate:
+		column := text
+		if f.Type == customFieldNumber {
+			column = "CAST(JSON_EXTRACT(custom_fields, ?) AS DECIMAL(30,6))"
+		}
+		if f.Value != nil {
+			return column + " = ?", []interface{}{path, f.Value}
+		}
+		var conds []string
+		var args []interface{}
+		if f.From != nil {
+			conds = append(conds, column+" >= ?")
+			args = append(args, path, f.From)
+		}
+		if f.To != nil {
+			conds = append(conds, column+" <= ?")
+			args = append(args, path, f.To)
+		}
+		return "(" + strings.Join(conds, " AND ") + ")", args
+	}
+	return text + " = ?", []interface{}{path, f.Value}
+}
+
+// parseCustomFieldFilters resolves filters given as field key -> value. Number and date
+// fields also accept ranges, "from..to" with either bound left out.
+func parseCustomFieldFilters(fields []CustomField, raw map[string]string) ([]CustomFieldFilter, error) {
+	byKey := make(map[string]*CustomField)
+	for i := range fields {
+		byKey[fields[i].Key] = &fields[i]
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var filters []CustomFieldFilter
+	for _, key := range keys {
+		value := strings.TrimSpace(raw[key])
+		if value == "" || value == "All" {
+			continue
+		}
+		field, ok := byKey[key]
+		if !ok {
+			return nil, &customFieldError{Key: key, Message: "is not a custom field"}
+		}
+		filter := CustomFieldFilter{Key: key, Type: field.Type}
+
+		// Bounds are checked for their type only, not against the field's rules
+		bound := *field
+		bound.Required, bound.MinValue, bound.MaxValue, bound.MaxLength, bound.Pattern = false, nil, nil, nil, nil
+		switch field.Type {
+		case customFieldNumber, customFieldDate:
+			if from, to, isRange := strings.Cut(value, customFieldRangeSeparator); isRange {
+				var err error
+				if filter.From, err = bound.normalize(from); err != nil {
+					return nil, err
+				}
+				if filter.To, err = bound.normalize(to); err != nil {
+					return nil, err
+				}
+				if filter.From == nil && filter.To == nil {
+					continue
+				}
+				break
+			}
+			v, err := bound.normalize(value)
+			if err != nil {
+				return nil, err
+			}
+			filter.Value = v
+		case customFieldMultiSelect:
+			option, ok := field.option(value)
+			if !ok {
+				return nil, &customFieldError{Key: key, Message: fmt.Sprintf("%q is not one of the field's options", value)}
+			}
+			filter.Value = option
+		case customFieldText:
+			filter.Value = value
+		default:
+			v, err := bound.normalize(value)
+			if err != nil {
+				return nil, err
+			}
+			filter.Value = v
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// customFieldColumns lists the custom_fields columns read by scanCustomField, in scan order
+const customFieldColumns = `id, company_id, category_id, field_key, label, field_type, required, options,
+	min_value, max_value, max_length, pattern, sort_order, created_at, updated_at`
+
+// scanCustomField reads one row selected with customFieldColumns
+func scanCustomField(row rowScanner) (CustomField, error) {
+	var f CustomField
+	var options []byte
+	err := row.Scan(&f.ID, &f.CompanyID, &f.CategoryID, &f.Key, &f.Label, &f.Type, &f.Required, &options,
+		&f.MinValue, &f.MaxValue, &f.MaxLength, &f.Pattern, &f.SortOrder, &f.CreatedAt, &f.UpdatedAt)
+	if err != nil || options == nil {
+		return f, err
+	}
+	err = json.Unmarshal(options, &f.Options)
+	return f, err
+}
+
+// loadCustomFields returns the company's custom fields in display order
+func loadCustomFields(companyID int) ([]CustomField, error) {
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT %s FROM custom_fields WHERE company_id = ? ORDER BY sort_order, label", customFieldColumns),
+		companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fields := []CustomField{}
+	for rows.Next() {
+		f, err := scanCustomField(rows)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, rows.Err()
+}
+
+// getCustomField reads a custom field of the company, or returns sql.ErrNoRows
+func getCustomField(companyID, id int) (CustomField, error) {
+	return scanCustomField(db.QueryRow(
+		fmt.Sprintf("SELECT %s FROM custom_fields WHERE id = ? AND company_id = ?", customFieldColumns),
+		id, companyID))
+}
+
+// CustomFields returns the company's custom fields, loaded once per store
+func (s *sqlAssetStore) CustomFields() ([]CustomField, error) {
+	if s.customFields == nil {
+		fields, err := loadCustomFields(s.companyID)
+		if err != nil {
+			return nil, err
+		}
+		s.customFields = fields
+	}
+	return s.customFields, nil
+}
+
+// ValidateCustomFields checks and normalizes the custom field values of an asset about
+// to be written. Invalid values are returned as a *customFieldError.
+func (s *sqlAssetStore) ValidateCustomFields(asset *Asset) error {
+	fields, err := s.CustomFields()
+	if err != nil {
+		return err
+	}
+	values, err := validateCustomFieldValues(fields, asset.CategoryID, asset.CustomFields)
+	if err != nil {
+		return err
+	}
+	asset.CustomFields = values
+	return nil
+}
+
+// CustomFieldFilters resolves custom field filters given as field key -> value
+func (s *sqlAssetStore) CustomFieldFilters(raw map[string]string) ([]CustomFieldFilter, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	fields, err := s.CustomFields()
+	if err != nil {
+		return nil, err
+	}
+	return parseCustomFieldFilters(fields, raw)
+}
+
+// customFieldRequestError writes 400 for an invalid custom field value or filter and 500
+// for anything else
+func customFieldRequestError(c *gin.Context, err error, action string) {
+	var invalid *customFieldError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   invalid.Error(),
+		})
+		return
+	}
+	log.Printf("Error %s custom fields: %v", action, err)
+	c.JSON(http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Error:   "Internal Server Error",
+	})
+}
+
+// requireValidCustomFields validates an asset's custom field values, writing 400 for
+// invalid ones
+func requireValidCustomFields(c *gin.Context, store AssetStore, asset *Asset) bool {
+	if err := store.ValidateCustomFields(asset); err != nil {
+		customFieldRequestError(c, err, "validating")
+		return false
+	}
+	return true
+}
+
+// requireCustomFieldFilters resolves custom field filters given as field key -> value,
+// writing 400 for unknown fields and invalid values
+func requireCustomFieldFilters(c *gin.Context, store AssetStore, raw map[string]string) ([]CustomFieldFilter, bool) {
+	filters, err := store.CustomFieldFilters(raw)
+	if err != nil {
+		customFieldRequestError(c, err, "filtering by")
+		return nil, false
+	}
+	return filters, true
+}
+
+// mergeCustomFields applies the custom field values of an update to the stored ones.
+// Keys set to null are removed and keys left out keep their values.
+func mergeCustomFields(stored customFieldValues, update map[string]interface{}) customFieldValues {
+	merged := customFieldValues{}
+	for key, value := range stored {
+		merged[key] = value
+	}
+	for key, value := range update {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// validateCustomField checks a field definition. Rules that do not apply to the field's
+// type are rejected rather than silently ignored.
+func validateCustomField(f *CustomField) error {
+	f.Key = strings.TrimSpace(f.Key)
+	f.Label = strings.TrimSpace(f.Label)
+	if !customFieldKeyPattern.MatchString(f.Key) {
+		return errors.New("key must start with a lowercase letter and contain only lowercase letters, digits and underscores, at most 50")
+	}
+	if f.Label == "" || len([]rune(f.Label)) > 100 {
+		return errors.New("label is required and may be at most 100 characters")
+	}
+	if !customFieldTypes[f.Type] {
+		return errors.New("type must be text, number, date, select, multi_select or boolean")
+	}
+
+	selectable := f.Type == customFieldSelect || f.Type == customFieldMultiSelect
+	if selectable {
+		if len(f.Options) == 0 || len(f.Options) > maxCustomFieldOptions {
+			return fmt.Errorf("%s fields need between 1 and %d options", f.Type, maxCustomFieldOptions)
+		}
+		seen := make(map[string]bool)
+		for i, option := range f.Options {
+			option = strings.TrimSpace(option)
+			if option == "" || len([]rune(option)) > 100 || seen[strings.ToLower(option)] {
+				return errors.New("options must be unique, non-empty and at most 100 characters")
+			}
+			seen[strings.ToLower(option)] = true
+			f.Options[i] = option
+		}
+	} else if len(f.Options) > 0 {
+		return errors.New("only select and multi_select fields have options")
+	}
+
+	if f.Type != customFieldNumber && (f.MinValue != nil || f.MaxValue != nil) {
+		return errors.New("only number fields have min_value and max_value")
+	}
+	if f.MinValue != nil && f.MaxValue != nil && *f.MinValue > *f.MaxValue {
+		return errors.New("min_value cannot be greater than max_value")
+	}
+	if f.Type != customFieldText && (f.MaxLength != nil || f.Pattern != nil) {
+		return errors.New("only text fields have max_length and pattern")
+	}
+	if f.MaxLength != nil && (*f.MaxLength < 1 || *f.MaxLength > maxCustomFieldText) {
+		return fmt.Errorf("max_length must be between 1 and %d", maxCustomFieldText)
+	}
+	if f.Pattern != nil {
+		if len(*f.Pattern) > 255 {
+			return errors.New("pattern may be at most 255 characters")
+		}
+		if _, err := regexp.Compile(fullMatch(*f.Pattern)); err != nil {
+			return fmt.Errorf("pattern is not a valid regular expression: %v", err)
+		}
+	}
+	return nil
+}
+
+// customFieldFromRequest binds and validates a field definition, checking that its
+// category belongs to the company
+func customFieldFromRequest(c *gin.Context, companyID int) (CustomField, bool) {
+	var f CustomField
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return f, false
+	}
+	err := validateCustomField(&f)
+	if err == nil && f.CategoryID != nil {
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM asset_categories WHERE id = ? AND company_id = ? AND is_active = true",
+			*f.CategoryID, companyID).Scan(&count)
+		if err != nil {
+			customFieldDefinitionError(c, err, "checking the category of")
+			return f, false
+		}
+		if count == 0 {
+			err = errors.New("category_id is not a category of the company")
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return f, false
+	}
+	return f, true
+}
+
+// customFieldID reads the :id parameter of a custom field
+func customFieldID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid custom field ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// customFieldDefinitionError writes the response for a failed custom field operation
+func customFieldDefinitionError(c *gin.Context, err error, action string) {
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Custom field not found",
+		})
+		return
+	}
+	log.Printf("Error %s custom field: %v", action, err)
+	c.JSON(http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Error:   "Internal Server Error",
+	})
+}
+
+// optionsJSON encodes the options of a select field, or NULL for other types
+func optionsJSON(f CustomField) interface{} {
+	if len(f.Options) == 0 {
+		return nil
+	}
+	raw, _ := json.Marshal(f.Options)
+	return raw
+}
+
+// getCustomFieldsHandler lists the company's custom fields, or with ?category_id= those
+// that apply to assets of one category
+func getCustomFieldsHandler(c *gin.Context) {
+	fields, err := loadCustomFields(getCurrentCompanyID(c))
+	if err != nil {
+		customFieldDefinitionError(c, err, "listing")
+		return
+	}
+	if v := c.Query("category_id"); v != "" {
+		categoryID, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "category_id must be an integer",
+			})
+			return
+		}
+		applicable := []CustomField{}
+		for _, f := range fields {
+			if f.appliesTo(&categoryID) {
+				applicable = append(applicable, f)
+			}
+		}
+		fields = applicable
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    fields,
+	})
+}
+
+// createCustomFieldHandler defines a new custom field for the company
+func createCustomFieldHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	f, ok := customFieldFromRequest(c, companyID)
+	if !ok {
+		return
+	}
+
+	// Check if the key is already used by a field of this company
+	var existingID int
+	err := db.QueryRow("SELECT id FROM custom_fields WHERE company_id = ? AND field_key = ?", companyID, f.Key).Scan(&existingID)
+	if err == nil {
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "A custom field with this key already exists",
+		})
+		return
+	}
+
+	now := time.Now()
+	result, err := db.Exec(`
+		INSERT INTO custom_fields (company_id, category_id, field_key, label, field_type, required, options,
+		min_value, max_value, max_length, pattern, sort_order, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		companyID, f.CategoryID, f.Key, f.Label, f.Type, f.Required, optionsJSON(f), f.MinValue, f.MaxValue,
+		f.MaxLength, f.Pattern, f.SortOrder, now, now)
+	if err != nil {
+		customFieldDefinitionError(c, err, "creating")
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	created, err := getCustomField(companyID, int(id))
+	if err != nil {
+		customFieldDefinitionError(c, err, "fetching")
+		return
+	}
+	c.JSON(http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Custom field created successfully",
+		Data:    created,
+	})
+}
+
+// updateCustomFieldHandler replaces a custom field's definition. The key and type cannot
+// change, as assets already hold values under them; stored values are checked against
+// new rules the next time their asset is saved.
+func updateCustomFieldHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	id, ok := customFieldID(c)
+	if !ok {
+		return
+	}
+	existing, err := getCustomField(companyID, id)
+	if err != nil {
+		customFieldDefinitionError(c, err, "updating")
+		return
+	}
+	f, ok := customFieldFromRequest(c, companyID)
+	if !ok {
+		return
+	}
+	if f.Key != existing.Key || f.Type != existing.Type {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "the key and type of a custom field cannot be changed",
+		})
+		return
+	}
+
+	_, err = db.Exec(`
+		UPDATE custom_fields SET category_id = ?, label = ?, required = ?, options = ?, min_value = ?,
+		max_value = ?, max_length = ?, pattern = ?, sort_order = ?, updated_at = ?
+		WHERE id = ? AND company_id = ?`,
+		f.CategoryID, f.Label, f.Required, optionsJSON(f), f.MinValue, f.MaxValue, f.MaxLength, f.Pattern,
+		f.SortOrder, time.Now(), id, companyID)
+	if err != nil {
+		customFieldDefinitionError(c, err, "updating")
+		return
+	}
+
+	updated, err := getCustomField(companyID, id)
+	if err != nil {
+		customFieldDefinitionError(c, err, "fetching")
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Custom field updated successfully",
+		Data:    updated,
+	})
+}
+
+// deleteCustomFieldHandler deletes a custom field and removes its values from the
+// company's assets, trashed ones included
+func deleteCustomFieldHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	id, ok := customFieldID(c)
+	if !ok {
+		return
+	}
+	f, err := getCustomField(companyID, id)
+	if err != nil {
+		customFieldDefinitionError(c, err, "deleting")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		customFieldDefinitionError(c, err, "deleting")
+		return
+	}
+	defer tx.Rollback()
+
+	path := customFieldPath(f.Key)
+	_, err = tx.Exec("DELETE FROM custom_fields WHERE id = ? AND company_id = ?", id, companyID)
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE assets SET custom_fields = NULLIF(JSON_REMOVE(custom_fields, ?), JSON_OBJECT())
+			WHERE company_id = ? AND JSON_CONTAINS_PATH(custom_fields, 'one', ?)`,
+			path, companyID, path)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		customFieldDefinitionError(c, err, "deleting")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Custom field deleted successfully",
+	})
+}
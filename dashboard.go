@@ -1,18 +1,64 @@
 package main
 
 import (
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// dashboardStore reads the company figures shown next to the asset statistics
+type dashboardStore struct {
+	assets *sqlAssetStore
+}
+
+// requireDashboardStore resolves the request's dashboard store or aborts with 401
+func requireDashboardStore(c *gin.Context) (*dashboardStore, bool) {
+	assets, err := sqlAssetStoreFor(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Company context required",
+		})
+		c.Abort()
+		return nil, false
+	}
+	return &dashboardStore{assets: assets}, true
+}
+
+// ActiveUsers returns the number of the company's active users
+func (d *dashboardStore) ActiveUsers() (int, error) {
+	var count int
+	err := d.assets.queryRow("SELECT COUNT(*) FROM users WHERE company_id = ? AND is_active = true",
+		d.assets.companyID).Scan(&count)
+	return count, err
+}
+
+// Company returns the store's company
+func (d *dashboardStore) Company() (Company, error) {
+	var company Company
+	err := d.assets.conn.QueryRow(`
+		SELECT id, company_name, company_code, email, subscription_plan, is_active, trial_ends_at, created_at, updated_at
+		FROM companies WHERE id = ?
+	`, d.assets.companyID).Scan(
+		&company.ID, &company.CompanyName, &company.CompanyCode, &company.Email,
+		&company.SubscriptionPlan, &company.IsActive, &company.TrialEndsAt,
+		&company.CreatedAt, &company.UpdatedAt,
+	)
+	return company, err
+}
+
 // getDashboardStatsHandler returns dashboard statistics for the current company
 func getDashboardStatsHandler(c *gin.Context) {
-	companyID := getCurrentCompanyID(c)
+	dashboard, ok := requireDashboardStore(c)
+	if !ok {
+		return
+	}
+	store := dashboard.assets
 
 	// Get total assets
-	var totalAssets int
-	err := db.QueryRow("SELECT COUNT(*) FROM assets WHERE company_id = ?", companyID).Scan(&totalAssets)
+	totalAssets, err := store.Count(AssetFilter{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -22,8 +68,7 @@ func getDashboardStatsHandler(c *gin.Context) {
 	}
 
 	// Get active assets
-	var activeAssets int
-	err = db.QueryRow("SELECT COUNT(*) FROM assets WHERE company_id = ? AND status = 'Active'", companyID).Scan(&activeAssets)
+	activeAssets, err := store.Count(AssetFilter{Status: "Active"})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -33,16 +78,14 @@ func getDashboardStatsHandler(c *gin.Context) {
 	}
 
 	// Get total users - handle potential column name mismatches
-	var totalUsers int
-	err = db.QueryRow("SELECT COUNT(*) FROM users WHERE company_id = ? AND is_active = true", companyID).Scan(&totalUsers)
+	totalUsers, err := dashboard.ActiveUsers()
 	if err != nil {
 		// If users table doesn't exist or has different structure, default to 0
 		totalUsers = 0
 	}
 
 	// Get total value
-	var totalValue float64
-	err = db.QueryRow("SELECT COALESCE(SUM(purchase_price), 0) FROM assets WHERE company_id = ? AND purchase_price IS NOT NULL", companyID).Scan(&totalValue)
+	totalValue, err := store.TotalValue()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -51,24 +94,35 @@ func getDashboardStatsHandler(c *gin.Context) {
 		return
 	}
 
+	// Get total book value (depreciated assets at book value, others at purchase price)
+	totalBookValue, err := store.TotalBookValue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to get total book value: " + err.Error(),
+		})
+		return
+	}
+
 	// Get total barcodes (assets with barcode or QR code)
-	var totalBarcodes int
-	err = db.QueryRow("SELECT COUNT(*) FROM assets WHERE company_id = ? AND (barcode IS NOT NULL OR qr_code IS NOT NULL)", companyID).Scan(&totalBarcodes)
+	totalBarcodes, err := store.Count(AssetFilter{HasBarcode: true})
 	if err != nil {
 		// If barcode columns don't exist, default to 0
 		totalBarcodes = 0
 	}
 
-	// Get scanned barcodes (assets that have been scanned - for now, we'll use assets with recent activity)
-	var scannedBarcodes int
-	err = db.QueryRow("SELECT COUNT(*) FROM assets WHERE company_id = ? AND updated_at > DATE_SUB(NOW(), INTERVAL 30 DAY)", companyID).Scan(&scannedBarcodes)
-	if err != nil {
-		// If there's an error, default to 0
-		scannedBarcodes = 0
+	// Get scanned barcodes (distinct assets scanned in the last 30 days)
+	since := time.Now().AddDate(0, 0, -scannedAssetsWindowDays)
+	scannedBarcodes := 0
+	scans := &scanStore{assets: store}
+	if count, err := scans.ScannedAssetCount(since); err == nil {
+		scannedBarcodes = count
+	} else {
+		log.Printf("Error counting scanned assets: %v", err)
 	}
 
 	// Get assets by status
-	rows, err := db.Query("SELECT status, COUNT(*) FROM assets WHERE company_id = ? GROUP BY status", companyID)
+	assetsByStatus, err := store.GroupCount("status")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -76,21 +130,9 @@ func getDashboardStatsHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	assetsByStatus := make(map[string]int)
-	for rows.Next() {
-		var status string
-		var count int
-		err := rows.Scan(&status, &count)
-		if err != nil {
-			continue
-		}
-		assetsByStatus[status] = count
-	}
 
 	// Get assets by type
-	rows, err = db.Query("SELECT asset_type, COUNT(*) FROM assets WHERE company_id = ? AND asset_type IS NOT NULL GROUP BY asset_type", companyID)
+	assetsByType, err := store.GroupCount("asset_type")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -98,29 +140,9 @@ func getDashboardStatsHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	assetsByType := make(map[string]int)
-	for rows.Next() {
-		var assetType string
-		var count int
-		err := rows.Scan(&assetType, &count)
-		if err != nil {
-			continue
-		}
-		assetsByType[assetType] = count
-	}
 
 	// Get recent assets (last 10)
-	rows, err = db.Query(`
-		SELECT id, company_id, asset_name, asset_type, institution_name, department, 
-		functional_area, manufacturer, model_number, serial_number, location, status, 
-		purchase_date, purchase_price, created_at, updated_at
-		FROM assets 
-		WHERE company_id = ? 
-		ORDER BY created_at DESC 
-		LIMIT 10
-	`, companyID)
+	recentAssets, err := store.List(AssetFilter{OrderBy: "created_at_desc", Limit: 10})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -128,54 +150,14 @@ func getDashboardStatsHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	var recentAssets []Asset
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.CompanyID, &asset.AssetName, &asset.AssetType,
-			&asset.InstitutionName, &asset.Department, &asset.FunctionalArea,
-			&asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt,
-		)
-		if err != nil {
-			continue
-		}
-		recentAssets = append(recentAssets, asset)
-	}
 
-	// Get recent maintenance (last 5) - handle case where table might not exist
-	var recentMaintenance []AssetMaintenance
-	rows, err = db.Query(`
-		SELECT id, company_id, asset_id, maintenance_type, description, cost,
-		performed_by, performed_at, next_maintenance_date, created_by, created_at
-		FROM asset_maintenance 
-		WHERE company_id = ? 
-		ORDER BY performed_at DESC 
-		LIMIT 5
-	`, companyID)
-	if err != nil {
-		// If table doesn't exist, just continue with empty maintenance data
-		// This is not a critical error for dashboard functionality
-		recentMaintenance = []AssetMaintenance{}
+	// Get recent work orders (last 5); the dashboard still renders if this fails
+	recentMaintenance := []AssetMaintenance{}
+	maintenance := &maintenanceStore{assets: store}
+	if orders, _, err := maintenance.List(MaintenanceFilter{Limit: 5}); err == nil {
+		recentMaintenance = orders
 	} else {
-		defer rows.Close()
-		
-		for rows.Next() {
-			var maintenance AssetMaintenance
-			err := rows.Scan(
-				&maintenance.ID, &maintenance.CompanyID, &maintenance.AssetID,
-				&maintenance.MaintenanceType, &maintenance.Description, &maintenance.Cost,
-				&maintenance.PerformedBy, &maintenance.PerformedAt, &maintenance.NextMaintenanceDate,
-				&maintenance.CreatedBy, &maintenance.CreatedAt,
-			)
-			if err != nil {
-				continue
-			}
-			recentMaintenance = append(recentMaintenance, maintenance)
-		}
+		log.Printf("Error fetching recent maintenance: %v", err)
 	}
 
 	stats := DashboardStats{
@@ -183,6 +165,7 @@ func getDashboardStatsHandler(c *gin.Context) {
 		ActiveAssets:      activeAssets,
 		TotalUsers:        totalUsers,
 		TotalValue:        totalValue,
+		TotalBookValue:    totalBookValue,
 		TotalBarcodes:     totalBarcodes,
 		ScannedBarcodes:   scannedBarcodes,
 		AssetsByStatus:    assetsByStatus,
@@ -199,20 +182,15 @@ func getDashboardStatsHandler(c *gin.Context) {
 
 // getDashboardDiagnosticsHandler provides diagnostic information for debugging company/asset issues
 func getDashboardDiagnosticsHandler(c *gin.Context) {
-	companyID := getCurrentCompanyID(c)
+	dashboard, ok := requireDashboardStore(c)
+	if !ok {
+		return
+	}
+	store := dashboard.assets
 	user := getCurrentUser(c)
 
 	// Get company information
-	var company Company
-	err := db.QueryRow(`
-		SELECT id, company_name, company_code, email, subscription_plan, is_active, trial_ends_at, created_at, updated_at
-		FROM companies WHERE id = ?
-	`, companyID).Scan(
-		&company.ID, &company.CompanyName, &company.CompanyCode, &company.Email,
-		&company.SubscriptionPlan, &company.IsActive, &company.TrialEndsAt,
-		&company.CreatedAt, &company.UpdatedAt,
-	)
-
+	company, err := dashboard.Company()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -222,66 +200,19 @@ func getDashboardDiagnosticsHandler(c *gin.Context) {
 	}
 
 	// Get total assets for this company
-	var totalAssets int
-	err = db.QueryRow("SELECT COUNT(*) FROM assets WHERE company_id = ?", companyID).Scan(&totalAssets)
+	totalAssets, err := store.Count(AssetFilter{})
 	if err != nil {
 		totalAssets = 0
 	}
 
 	// Get total users for this company
-	var totalUsers int
-	err = db.QueryRow("SELECT COUNT(*) FROM users WHERE company_id = ? AND is_active = true", companyID).Scan(&totalUsers)
+	totalUsers, err := dashboard.ActiveUsers()
 	if err != nil {
 		totalUsers = 0
 	}
 
-	// Get all companies
-	rows, err := db.Query("SELECT id, company_name, company_code FROM companies ORDER BY id")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Failed to get companies: " + err.Error(),
-		})
-		return
-	}
-	defer rows.Close()
-
-	var companies []map[string]interface{}
-	for rows.Next() {
-		var id int
-		var name, code string
-		err := rows.Scan(&id, &name, &code)
-		if err != nil {
-			continue
-		}
-		companies = append(companies, map[string]interface{}{
-			"id": id, "name": name, "code": code,
-		})
-	}
-
-	// Get assets by company
-	rows, err = db.Query("SELECT company_id, COUNT(*) FROM assets GROUP BY company_id")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Failed to get assets by company: " + err.Error(),
-		})
-		return
-	}
-	defer rows.Close()
-
-	assetsByCompany := make(map[int]int)
-	for rows.Next() {
-		var companyID, count int
-		err := rows.Scan(&companyID, &count)
-		if err != nil {
-			continue
-		}
-		assetsByCompany[companyID] = count
-	}
-
 	// Get sample assets for debugging
-	rows, err = db.Query("SELECT id, company_id, asset_name, created_at FROM assets ORDER BY created_at DESC LIMIT 5")
+	recentAssets, err := store.List(AssetFilter{OrderBy: "created_at_desc", Limit: 5})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -289,18 +220,11 @@ func getDashboardDiagnosticsHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
 
 	var sampleAssets []map[string]interface{}
-	for rows.Next() {
-		var id, assetCompanyID int
-		var assetName, createdAt string
-		err := rows.Scan(&id, &assetCompanyID, &assetName, &createdAt)
-		if err != nil {
-			continue
-		}
+	for _, asset := range recentAssets {
 		sampleAssets = append(sampleAssets, map[string]interface{}{
-			"id": id, "company_id": assetCompanyID, "asset_name": assetName, "created_at": createdAt,
+			"id": asset.ID, "company_id": asset.CompanyID, "asset_name": asset.AssetName, "created_at": asset.CreatedAt,
 		})
 	}
 
@@ -315,9 +239,7 @@ func getDashboardDiagnosticsHandler(c *gin.Context) {
 			"total_assets": totalAssets,
 			"total_users":  totalUsers,
 		},
-		"all_companies":     companies,
-		"assets_by_company": assetsByCompany,
-		"sample_assets":     sampleAssets,
+		"sample_assets": sampleAssets,
 	}
 
 	c.JSON(http.StatusOK, APIResponse{
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestAnnualAmounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy depreciationPolicy
+		want   []float64
+	}{
+		{"straight line", depreciationPolicy{Method: depreciationStraightLine, UsefulLifeYears: 5, Cost: 1200},
+			[]float64{240, 240, 240, 240, 240}},
+		{"straight line rounding in the last year", depreciationPolicy{Method: depreciationStraightLine, UsefulLifeYears: 3, Cost: 1000},
+			[]float64{333.33, 333.33, 333.34}},
+		{"declining balance switching to straight line", depreciationPolicy{Method: depreciationDecliningBalance, UsefulLifeYears: 5, Cost: 1000, SalvageValue: 100},
+			[]float64{400, 240, 144, 86.4, 29.6}},
+		{"sum of years", depreciationPolicy{Method: depreciationSumOfYears, UsefulLifeYears: 5, Cost: 1500},
+			[]float64{500, 400, 300, 200, 100}},
+		{"salvage equal to cost", depreciationPolicy{Method: depreciationSumOfYears, UsefulLifeYears: 3, Cost: 500, SalvageValue: 500},
+			[]float64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.annualAmounts(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestAccumulatedAt checks the full-month convention: the month placed in service counts
+// as a whole month, and a month is recognised on its last day
+func TestAccumulatedAt(t *testing.T) {
+	inService := date("2024-01-15")
+	straight := depreciationPolicy{Method: depreciationStraightLine, UsefulLifeYears: 5, Cost: 1200, InService: inService}
+	declining := depreciationPolicy{Method: depreciationDecliningBalance, UsefulLifeYears: 5, Cost: 1000, SalvageValue: 100, InService: inService}
+	sumOfYears := depreciationPolicy{Method: depreciationSumOfYears, UsefulLifeYears: 5, Cost: 1500, InService: inService}
+	tests := []struct {
+		name   string
+		policy depreciationPolicy
+		at     string
+		want   float64
+	}{
+		{"before service", straight, "2023-12-31", 0},
+		{"in the first month", straight, "2024-01-30", 0},
+		{"end of the first month", straight, "2024-01-31", 20},
+		{"mid month", straight, "2024-06-29", 100},
+		{"end of a month", straight, "2024-06-30", 120},
+		{"into the second year", straight, "2025-03-31", 300},
+		{"end of the useful life", straight, "2028-12-31", 1200},
+		{"after the useful life", straight, "2031-01-01", 1200},
+		{"declining balance into the second year", declining, "2025-06-30", 520},
+		{"declining balance stops at salvage", declining, "2030-01-01", 900},
+		{"sum of years into the second year", sumOfYears, "2025-03-31", 600},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.accumulatedAt(date(tt.at)); got != tt.want {
+			t.Errorf("%s: accumulatedAt(%s) = %v, want %v", tt.name, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestResolveDepreciationClampsSalvage(t *testing.T) {
+	purchased := date("2024-01-15")
+	price, life := 1000.0, 5
+	tests := []struct {
+		name    string
+		salvage float64
+		want    float64
+	}{
+		{"above cost", 1500, 1000},
+		{"negative", -50, 0},
+		{"within cost", 100, 100},
+	}
+	for _, tt := range tests {
+		salvage := tt.salvage
+		asset := Asset{PurchaseDate: &purchased, PurchasePrice: &price, UsefulLifeYears: &life, SalvageValue: &salvage}
+		p := resolveDepreciation(&asset, nil)
+		if p == nil || p.SalvageValue != tt.want {
+			t.Errorf("%s: got %+v, want salvage %v", tt.name, p, tt.want)
+			continue
+		}
+		if got := p.accumulatedAt(date("2031-01-01")); got != roundCents(price-tt.want) {
+			t.Errorf("%s: fully depreciated to %v, want %v", tt.name, got, roundCents(price-tt.want))
+		}
+	}
+}
+
+// TestDepreciationRegisterIncludesDisposals checks that an asset moved to the trash during
+// the period stays in the register, depreciated up to its disposal
+func TestDepreciationRegisterIncludesDisposals(t *testing.T) {
+	store, mock := newMockAssetStore(t, ownCompany)
+	start, end := date("2024-01-01"), date("2024-12-31")
+	rows := assetRows()
+	for _, id := range []int{1, 2} {
+		row := assetRow(id, ownCompany)
+		row[13], row[14], row[24] = date("2024-01-15"), 1200.0, 5
+		if id == 2 {
+			row[21] = time.Date(2024, 3, 10, 14, 0, 0, 0, time.UTC)
+		}
+		rows.AddRow(row...)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("FROM asset_categories WHERE company_id = ?")).
+		WithArgs(ownCompany).
+		WillReturnRows(categoryDefaultRows())
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE company_id = ? AND (deleted_at IS NULL OR deleted_at >= ?)")).
+		WithArgs(ownCompany, start).
+		WillReturnRows(rows)
+
+	register, totals, err := depreciationRegister(store, start, end)
+	if err != nil {
+		t.Fatalf("depreciationRegister: %v", err)
+	}
+	if len(register) != 2 {
+		t.Fatalf("got %d rows, want 2", len(register))
+	}
+	if r := register[0]; r.DisposedDate != "" || r.ClosingAccumulated != 240 || r.BookValue != 960 {
+		t.Errorf("live asset: got %+v", r)
+	}
+	if r := register[1]; r.DisposedDate != "2024-03-10" || r.PeriodDepreciation != 40 || r.BookValue != 1160 {
+		t.Errorf("disposed asset: got %+v", r)
+	}
+	if totals.PeriodDepreciation != 280 {
+		t.Errorf("total period depreciation %v, want 280", totals.PeriodDepreciation)
+	}
+	checkMock(t, mock)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/xuri/excelize/v2"
+)
+
+// maxPDFExportRows caps PDF exports, which gofpdf must assemble in memory before writing
+const maxPDFExportRows = 5000
+
+// exportFlushEvery is how many rows are written between flushes of the response
+const exportFlushEvery = 1000
+
+// exportColumn is one column that can be selected for export
+type exportColumn struct {
+	Key    string
+	Header string
+	Value  func(a Asset) interface{}
+}
+
+// exportColumns are the exportable asset columns, in default order
+var exportColumns = []exportColumn{
+	{"id", "ID", func(a Asset) interface{} { return a.ID }},
+	{"asset_name", "Asset Name", func(a Asset) interface{} { return a.AssetName }},
+	{"asset_type", "Asset Type", func(a Asset) interface{} { return optionalValue(a.AssetType) }},
+	{"category_id", "Category ID", func(a Asset) interface{} { return optionalValue(a.CategoryID) }},
+	{"institution_name", "Institution", func(a Asset) interface{} { return optionalValue(a.InstitutionName) }},
+	{"department", "Department", func(a Asset) interface{} { return optionalValue(a.Department) }},
+	{"functional_area", "Functional Area", func(a Asset) interface{} { return optionalValue(a.FunctionalArea) }},
+	{"manufacturer", "Manufacturer", func(a Asset) interface{} { return optionalValue(a.Manufacturer) }},
+	{"model_number", "Model Number", func(a Asset) interface{} { return optionalValue(a.ModelNumber) }},
+	{"serial_number", "Serial Number", func(a Asset) interface{} { return optionalValue(a.SerialNumber) }},
+	{"location", "Location", func(a Asset) interface{} { return optionalValue(a.Location) }},
+	{"status", "Status", func(a Asset) interface{} { return a.Status }},
+	{"purchase_date", "Purchase Date", func(a Asset) interface{} { return optionalValue(formatDate(a.PurchaseDate)) }},
+	{"purchase_price", "Purchase Price", func(a Asset) interface{} { return optionalValue(a.PurchasePrice) }},
+	{"assigned_to", "Assigned To", func(a Asset) interface{} { return optionalValue(a.AssignedTo) }},
+	{"notes", "Notes", func(a Asset) interface{} { return optionalValue(a.Notes) }},
+	{"barcode", "Barcode", func(a Asset) interface{} { return optionalValue(a.Barcode) }},
+	{"qr_code", "QR Code", func(a Asset) interface{} { return optionalValue(a.QRCode) }},
+	{"book_value", "Book Value", func(a Asset) interface{} { return optionalValue(a.BookValue) }},
+	{"created_at", "Created At", func(a Asset) interface{} { return a.CreatedAt.Format("2006-01-02 15:04:05") }},
+	{"updated_at", "Updated At", func(a Asset) interface{} { return a.UpdatedAt.Format("2006-01-02 15:04:05") }},
+}
+
+// defaultExportColumns match the columns of the legacy Excel asset report
+var defaultExportColumns = []string{
+	"id", "asset_name", "asset_type", "institution_name", "department", "functional_area", "manufacturer",
+	"model_number", "serial_number", "location", "status", "purchase_date", "purchase_price", "created_at",
+}
+
+// exportContentTypes maps each export format to its response content type
+var exportContentTypes = map[string]string{
+	"csv":   "text/csv; charset=utf-8",
+	"xlsx":  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"jsonl": "application/x-ndjson",
+	"pdf":   "application/pdf",
+}
+
+// optionalValue dereferences a nullable column, returning nil when unset or empty
+func optionalValue(v interface{}) interface{} {
+	switch p := v.(type) {
+	case *string:
+		if p == nil {
+			return nil
+		}
+		return *p
+	case *int:
+		if p == nil {
+			return nil
+		}
+		return *p
+	case *float64:
+		if p == nil {
+			return nil
+		}
+		return *p
+	case string:
+		if p == "" {
+			return nil
+		}
+		return p
+	}
+	return v
+}
+
+// exportText renders an exported value as text for CSV and PDF
+func exportText(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(t, 'f', 2, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// customFieldExportColumn exports the values of a custom field, headed by its label
+func customFieldExportColumn(f CustomField) exportColumn {
+	key := f.Key
+	return exportColumn{"custom_fields." + key, f.Label, func(a Asset) interface{} {
+		return flatCustomValue(a.CustomFields[key])
+	}}
+}
+
+// selectExportColumns resolves requested column keys, defaulting to the report columns.
+// Custom fields are selected as custom_fields.<key>.
+func selectExportColumns(keys []string, fields []CustomField) ([]exportColumn, error) {
+	// GET requests pass columns as one comma-separated value
+	var requested []string
+	for _, k := range keys {
+		for _, part := range strings.Split(k, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				requested = append(requested, part)
+			}
+		}
+	}
+	if len(requested) == 0 {
+		requested = defaultExportColumns
+	}
+
+	byKey := make(map[string]exportColumn)
+	for _, col := range exportColumns {
+		byKey[col.Key] = col
+	}
+	for _, f := range fields {
+		col := customFieldExportColumn(f)
+		byKey[col.Key] = col
+	}
+	columns := make([]exportColumn, 0, len(requested))
+	for _, key := range requested {
+		col, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown export column %q", key)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// exportAssetsHandler streams the assets matching a ReportRequest filter directly to
+// the response as CSV, XLSX, JSON Lines or PDF. Rows are read from the database one
+// at a time, so large exports are never held in memory or written to the working directory.
+func exportAssetsHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
+	var req ExportRequest
+	var err error
+	if c.Request.Method == http.MethodGet {
+		err = c.ShouldBindQuery(&req)
+	} else {
+		err = c.ShouldBindJSON(&req)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid input data",
+		})
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		req.CustomFields = c.QueryMap("customFields")
+	}
+
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+	contentType, ok := exportContentTypes[req.Format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "format must be one of csv, xlsx, jsonl or pdf",
+		})
+		return
+	}
+	fields, err := store.CustomFields()
+	if err != nil {
+		log.Printf("Error fetching custom fields for export: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	columns, err := selectExportColumns(req.Columns, fields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	filter := reportFilter(req.ReportRequest)
+	filter.OrderBy = "institution"
+	if filter.CustomFields, ok = requireCustomFieldFilters(c, store, req.CustomFields); !ok {
+		return
+	}
+
+	if req.Format == "pdf" {
+		count, err := store.Count(filter)
+		if err != nil {
+			log.Printf("Error counting assets for export: %v", err)
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   "Internal Server Error",
+			})
+			return
+		}
+		if count > maxPDFExportRows {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("PDF exports are limited to %d assets; narrow the filters or export as CSV or XLSX", maxPDFExportRows),
+			})
+			return
+		}
+	}
+
+	filename := fmt.Sprintf("assets_%s.%s", time.Now().Format("20060102_150405"), req.Format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	switch req.Format {
+	case "csv":
+		err = exportCSV(c, store, filter, columns)
+	case "xlsx":
+		err = exportXLSX(c, store, filter, columns)
+	case "jsonl":
+		err = exportJSONLines(c, store, filter, columns)
+	case "pdf":
+		err = exportPDF(c, store, filter, columns)
+	}
+	if err != nil {
+		// The status and part of the body are already sent, so the error can only be logged
+		log.Printf("Error exporting assets as %s: %v", req.Format, err)
+	}
+}
+
+// exportCSV writes a header row and one CSV record per asset
+func exportCSV(c *gin.Context, store AssetStore, filter AssetFilter, columns []exportColumn) error {
+	w := csv.NewWriter(c.Writer)
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Header
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	n := 0
+	record := make([]string, len(columns))
+	err := store.Each(filter, func(asset Asset) error {
+		for i, col := range columns {
+			record[i] = exportText(col.Value(asset))
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+		if n++; n%exportFlushEvery == 0 {
+			w.Flush()
+			c.Writer.Flush()
+		}
+		return w.Error()
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// exportJSONLines writes one JSON object per asset, keyed by column
+func exportJSONLines(c *gin.Context, store AssetStore, filter AssetFilter, columns []exportColumn) error {
+	enc := json.NewEncoder(c.Writer)
+	n := 0
+	return store.Each(filter, func(asset Asset) error {
+		row := make(map[string]interface{}, len(columns))
+		for _, col := range columns {
+			row[col.Key] = col.Value(asset)
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+		if n++; n%exportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+}
+
+// exportXLSX writes the workbook through excelize's StreamWriter, which spills rows
+// to a temporary file instead of memory; f.Close removes that file
+func exportXLSX(c *gin.Context, store AssetStore, filter AssetFilter, columns []exportColumn) error {
+	f := excelize.NewFile()
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("Error closing Excel file: %v", err)
+		}
+	}()
+
+	sw, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		return err
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = col.Header
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	rowNumber := 1
+	err = store.Each(filter, func(asset Asset) error {
+		rowNumber++
+		values := make([]interface{}, len(columns))
+		for i, col := range columns {
+			values[i] = col.Value(asset)
+		}
+		cell, err := excelize.CoordinatesToCellName(1, rowNumber)
+		if err != nil {
+			return err
+		}
+		return sw.SetRow(cell, values)
+	})
+	if err != nil {
+		return err
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	return f.Write(c.Writer)
+}
+
+// exportPDF writes a landscape table of the selected columns
+func exportPDF(c *gin.Context, store AssetStore, filter AssetFilter, columns []exportColumn) error {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	colWidth := (pageWidth - left - right) / float64(len(columns))
+
+	// fit truncates a value so it stays inside its cell
+	fit := func(s string) string {
+		s = tr(s)
+		for len(s) > 0 && pdf.GetStringWidth(s) > colWidth-2 {
+			s = s[:len(s)-1]
+		}
+		return s
+	}
+	header := func() {
+		pdf.SetFont("Arial", "B", 8)
+		for _, col := range columns {
+			pdf.CellFormat(colWidth, 7, fit(col.Header), "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Arial", "", 7)
+	}
+
+	pdf.SetFillColor(230, 230, 230)
+	pdf.SetFont("Arial", "", 7)
+	pdf.SetHeaderFunc(header)
+	pdf.AddPage()
+
+	err := store.Each(filter, func(asset Asset) error {
+		for _, col := range columns {
+			pdf.CellFormat(colWidth, 6, fit(exportText(col.Value(asset))), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+		return pdf.Error()
+	})
+	if err != nil {
+		return err
+	}
+	return pdf.Output(c.Writer)
+}
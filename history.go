@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Asset history actions
+const (
+	assetHistoryCreate       = "create"
+	assetHistoryUpdate       = "update"
+	assetHistoryDelete       = "delete"
+	assetHistoryRestore      = "restore"
+	assetHistoryPurge        = "purge"
+	assetHistoryStatusChange = "status_change"
+	assetHistoryAssignment   = "assignment"
+)
+
+// auditedAssetFields returns the asset columns tracked by the history, keyed by column
+// name, and the custom field values keyed as custom_fields.<key>. Empty strings and
+// NULLs are both recorded as null.
+func auditedAssetFields(a *Asset) map[string]interface{} {
+	fields := map[string]interface{}{
+		"asset_name":          optionalValue(a.AssetName),
+		"asset_type":          optionalValue(a.AssetType),
+		"category_id":         optionalValue(a.CategoryID),
+		"institution_name":    optionalValue(a.InstitutionName),
+		"department":          optionalValue(a.Department),
+		"functional_area":     optionalValue(a.FunctionalArea),
+		"manufacturer":        optionalValue(a.Manufacturer),
+		"model_number":        optionalValue(a.ModelNumber),
+		"serial_number":       optionalValue(a.SerialNumber),
+		"location":            optionalValue(a.Location),
+		"status":              optionalValue(a.Status),
+		"purchase_date":       optionalValue(formatDate(a.PurchaseDate)),
+		"purchase_price":      optionalValue(a.PurchasePrice),
+		"assigned_to":         optionalValue(a.AssignedTo),
+		"notes":               optionalValue(a.Notes),
+		"barcode":             optionalValue(a.Barcode),
+		"qr_code":             optionalValue(a.QRCode),
+		"deleted_at":          optionalValue(formatTimestamp(a.DeletedAt)),
+		"deleted_by":          optionalValue(a.DeletedBy),
+		"depreciation_method": optionalValue(a.DepreciationMethod),
+		"useful_life_years":   optionalValue(a.UsefulLifeYears),
+		"salvage_value":       optionalValue(a.SalvageValue),
+		"primary_photo_id":    optionalValue(a.PrimaryPhotoID),
+	}
+	for key, value := range a.CustomFields {
+		fields["custom_fields."+key] = flatCustomValue(value)
+	}
+	return fields
+}
+
+// formatTimestamp formats an optional timestamp, or "" when unset
+func formatTimestamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// diffAssets returns the changed fields between two versions of an asset.
+// A nil before is a creation and a nil after is a purge.
+func diffAssets(before, after *Asset) map[string]FieldChange {
+	var old, cur map[string]interface{}
+	if before != nil {
+		old = auditedAssetFields(before)
+	}
+	if after != nil {
+		cur = auditedAssetFields(after)
+	}
+
+	// Custom fields are only present while set, so compare every field of either version
+	changes := make(map[string]FieldChange)
+	compare := func(fields map[string]interface{}) {
+		for field := range fields {
+			if old[field] != cur[field] {
+				changes[field] = FieldChange{Before: old[field], After: cur[field]}
+			}
+		}
+	}
+	compare(auditedAssetFields(&Asset{}))
+	compare(old)
+	compare(cur)
+	return changes
+}
+
+// assetHistoryAction names a change. Updates that only change the status or the
+// assignee are recorded as status changes and assignments so auditors can filter on them.
+func assetHistoryAction(before, after *Asset, changes map[string]FieldChange) string {
+	switch {
+	case before == nil:
+		return assetHistoryCreate
+	case after == nil:
+		return assetHistoryPurge
+	case before.DeletedAt == nil && after.DeletedAt != nil:
+		return assetHistoryDelete
+	case before.DeletedAt != nil && after.DeletedAt == nil:
+		return assetHistoryRestore
+	}
+	if len(changes) == 1 {
+		if _, ok := changes["status"]; ok {
+			return assetHistoryStatusChange
+		}
+		if _, ok := changes["assigned_to"]; ok {
+			return assetHistoryAssignment
+		}
+	}
+	return assetHistoryUpdate
+}
+
+// recordHistory writes the history entry for a change inside the change's transaction.
+// Updates that change nothing are not recorded.
+func (s *sqlAssetStore) recordHistory(tx *sql.Tx, assetID int, before, after *Asset) error {
+	changes := diffAssets(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	var userID, requestID interface{}
+	if s.actor.UserID > 0 {
+		userID = s.actor.UserID
+	}
+	if s.actor.RequestID != "" {
+		requestID = s.actor.RequestID
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO asset_history (company_id, asset_id, action, changes, user_id, request_id)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		s.companyID, assetID, assetHistoryAction(before, after, changes), raw, userID, requestID)
+	return err
+}
+
+// History returns every recorded change of an asset, oldest first, including changes
+// made before the asset was trashed or purged
+func (s *sqlAssetStore) History(assetID int) ([]AssetHistoryEntry, error) {
+	rows, err := s.query(`
+		SELECT h.id, h.asset_id, h.action, h.changes, h.user_id, u.username, h.request_id, h.created_at
+		FROM asset_history h
+		LEFT JOIN users u ON u.id = h.user_id
+		WHERE h.company_id = ? AND h.asset_id = ?
+		ORDER BY h.id`, s.companyID, assetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AssetHistoryEntry{}
+	for rows.Next() {
+		var entry AssetHistoryEntry
+		var raw []byte
+		err := rows.Scan(&entry.ID, &entry.AssetID, &entry.Action, &raw, &entry.UserID,
+			&entry.Username, &entry.RequestID, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &entry.Changes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// getAssetHistoryHandler returns the full change history of an asset
+func getAssetHistoryHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
+	assetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid asset ID",
+		})
+		return
+	}
+
+	entries, err := store.History(assetID)
+	if err != nil {
+		log.Printf("Error fetching asset history: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	// Assets created before the history existed have no entries; tell them apart from unknown IDs
+	if len(entries) == 0 {
+		if _, err := store.Get(assetID); err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Asset not found",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    entries,
+	})
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	maxImportFileSize = 10 << 20
+	maxImportRows     = 10000
+	importSampleRows  = 5
+)
+
+// importFields are the asset fields a spreadsheet column can be mapped to
+var importFields = []string{
+	"asset_name", "asset_type", "category", "institution_name", "department", "functional_area",
+	"manufacturer", "model_number", "serial_number", "location", "status", "purchase_date",
+	"purchase_price", "notes",
+}
+
+// importFieldAliases are common legacy header names, keyed by normalized header
+var importFieldAliases = map[string]string{
+	"name":           "asset_name",
+	"type":           "asset_type",
+	"categoryid":     "category",
+	"institution":    "institution_name",
+	"dept":           "department",
+	"model":          "model_number",
+	"serial":         "serial_number",
+	"serialno":       "serial_number",
+	"price":          "purchase_price",
+	"cost":           "purchase_price",
+	"dateofpurchase": "purchase_date",
+}
+
+// assetStatuses are the values of the assets.status column
+var assetStatuses = []string{"Active", "Inactive", "Maintenance", "Retired"}
+
+// importDateLayouts are the purchase date formats accepted in text cells
+var importDateLayouts = []string{"2006-01-02", "2006/01/02", "2006-01-02 15:04:05"}
+
+// importSheet is the parsed content of an uploaded file: a header row and data rows
+type importSheet struct {
+	Format  string
+	Sheet   string
+	Headers []string
+	Rows    [][]string
+}
+
+// normalizeHeader reduces a header to lowercase letters and digits so that
+// "Asset Name", "asset_name" and "assetName" compare equal
+func normalizeHeader(header string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(header) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// readImportFile parses an uploaded .csv or .xlsx file into an importSheet
+func readImportFile(c *gin.Context) (*importSheet, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.New("a .csv or .xlsx file is required in the \"file\" field")
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sheet := &importSheet{}
+	var records [][]string
+	switch strings.ToLower(filepath.Ext(fileHeader.Filename)) {
+	case ".csv":
+		sheet.Format = "csv"
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV: %v", err)
+			}
+			records = append(records, record)
+		}
+		if len(records) > 0 && len(records[0]) > 0 {
+			records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+		}
+	case ".xlsx":
+		sheet.Format = "xlsx"
+		f, err := excelize.OpenReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Excel file: %v", err)
+		}
+		defer f.Close()
+
+		sheet.Sheet = c.PostForm("sheet")
+		if sheet.Sheet == "" {
+			sheet.Sheet = f.GetSheetName(0)
+		}
+		// Raw values keep dates as serial numbers instead of locale-formatted text
+		records, err = f.GetRows(sheet.Sheet, excelize.Options{RawCellValue: true})
+		if err != nil {
+			return nil, fmt.Errorf("cannot read sheet %q: %v", sheet.Sheet, err)
+		}
+	default:
+		return nil, errors.New("only .csv and .xlsx files are supported")
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("the file is empty")
+	}
+	for _, h := range records[0] {
+		sheet.Headers = append(sheet.Headers, strings.TrimSpace(h))
+	}
+	sheet.Rows = records[1:]
+	if len(sheet.Rows) > maxImportRows {
+		return nil, fmt.Errorf("the file has %d rows; at most %d can be imported at once", len(sheet.Rows), maxImportRows)
+	}
+	return sheet, nil
+}
+
+// customImportPrefix marks mapping fields holding custom field values, as in custom_fields.<key>
+const customImportPrefix = "custom_fields."
+
+// importFieldNames returns the fields a column can be mapped to, the company's custom
+// fields included
+func importFieldNames(fields []CustomField) []string {
+	names := append([]string{}, importFields...)
+	for _, f := range fields {
+		names = append(names, customImportPrefix+f.Key)
+	}
+	return names
+}
+
+// suggestImportMapping matches headers to asset fields by name and known aliases, and
+// to custom fields by key or label
+func suggestImportMapping(headers []string, fields []CustomField) map[string]string {
+	byName := make(map[string]string)
+	for _, field := range importFields {
+		byName[normalizeHeader(field)] = field
+	}
+	for _, f := range fields {
+		for _, name := range []string{f.Key, f.Label} {
+			if _, taken := byName[normalizeHeader(name)]; !taken {
+				byName[normalizeHeader(name)] = customImportPrefix + f.Key
+			}
+		}
+	}
+
+	mapping := make(map[string]string)
+	for _, header := range headers {
+		key := normalizeHeader(header)
+		field, ok := byName[key]
+		if !ok {
+			field, ok = importFieldAliases[key]
+		}
+		if ok {
+			if _, taken := mapping[field]; !taken {
+				mapping[field] = header
+			}
+		}
+	}
+	return mapping
+}
+
+// resolveImportMapping turns a field -> header mapping into field -> column index
+func resolveImportMapping(headers []string, mapping map[string]string, fields []CustomField) (map[string]int, error) {
+	known := make(map[string]bool)
+	for _, field := range importFieldNames(fields) {
+		known[field] = true
+	}
+	index := make(map[string]int)
+	for i, header := range headers {
+		index[header] = i
+	}
+
+	columns := make(map[string]int)
+	for field, header := range mapping {
+		if !known[field] {
+			return nil, fmt.Errorf("unknown asset field %q in mapping", field)
+		}
+		if header == "" {
+			continue
+		}
+		i, ok := index[header]
+		if !ok {
+			return nil, fmt.Errorf("column %q mapped to %s is not in the file", header, field)
+		}
+		columns[field] = i
+	}
+	if _, ok := columns["asset_name"]; !ok {
+		return nil, errors.New("a column must be mapped to asset_name")
+	}
+	return columns, nil
+}
+
+// importStatus matches a status cell to an asset status regardless of case
+func importStatus(value string) (string, bool) {
+	for _, status := range assetStatuses {
+		if strings.EqualFold(value, status) {
+			return status, true
+		}
+	}
+	return "", false
+}
+
+// parseImportDate accepts ISO dates and Excel serial dates
+func parseImportDate(value string) (time.Time, error) {
+	for _, layout := range importDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 {
+		return excelize.ExcelDateToTime(serial, false)
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+}
+
+// CategoryIDs returns the company's active category IDs keyed by lowercase name and by ID
+func (s *sqlAssetStore) CategoryIDs() (map[string]int, error) {
+	rows, err := s.query("SELECT id, name FROM asset_categories WHERE company_id = ? AND is_active = true", s.companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		categories[strings.ToLower(strings.TrimSpace(name))] = id
+		categories[strconv.Itoa(id)] = id
+	}
+	return categories, rows.Err()
+}
+
+// validateImportRows converts rows into assets, collecting every row-level error
+func validateImportRows(store AssetStore, sheet *importSheet, columns map[string]int) ([]Asset, []ImportRowError, error) {
+	categories, err := store.CategoryIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Serial numbers already used by the company are looked up in one query up front
+	var serials []string
+	if col, ok := columns["serial_number"]; ok {
+		for _, record := range sheet.Rows {
+			if col < len(record) && strings.TrimSpace(record[col]) != "" {
+				serials = append(serials, strings.TrimSpace(record[col]))
+			}
+		}
+	}
+	inUse, err := store.SerialNumbersInUse(serials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var assets []Asset
+	var rowErrors []ImportRowError
+	seenSerials := make(map[string]int)
+
+	for i, record := range sheet.Rows {
+		rowNumber := i + 2 // 1-based, after the header row
+		cell := func(field string) string {
+			col, ok := columns[field]
+			if !ok || col >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[col])
+		}
+
+		blank := true
+		for _, v := range record {
+			if strings.TrimSpace(v) != "" {
+				blank = false
+				break
+			}
+		}
+		if blank {
+			continue
+		}
+
+		fail := func(field, message string) {
+			rowErrors = append(rowErrors, ImportRowError{Row: rowNumber, Field: field, Message: message})
+		}
+		errorsBefore := len(rowErrors)
+
+		asset := Asset{
+			AssetName:       cell("asset_name"),
+			AssetType:       optionalString(cell("asset_type")),
+			InstitutionName: optionalString(cell("institution_name")),
+			Department:      optionalString(cell("department")),
+			FunctionalArea:  optionalString(cell("functional_area")),
+			Manufacturer:    optionalString(cell("manufacturer")),
+			ModelNumber:     optionalString(cell("model_number")),
+			SerialNumber:    optionalString(cell("serial_number")),
+			Location:        optionalString(cell("location")),
+			Status:          cell("status"),
+			Notes:           optionalString(cell("notes")),
+		}
+		if asset.AssetName == "" {
+			fail("asset_name", "asset name is required")
+		}
+		if asset.Status == "" {
+			asset.Status = "Active"
+		} else if status, ok := importStatus(asset.Status); ok {
+			asset.Status = status
+		} else {
+			fail("status", fmt.Sprintf("invalid status %q, expected one of %s", asset.Status, strings.Join(assetStatuses, ", ")))
+		}
+
+		if v := cell("category"); v != "" {
+			id, ok := categories[strings.ToLower(v)]
+			if !ok {
+				fail("category", fmt.Sprintf("unknown category %q", v))
+			} else {
+				asset.CategoryID = &id
+			}
+		}
+
+		if v := cell("purchase_date"); v != "" {
+			date, err := parseImportDate(v)
+			if err != nil {
+				fail("purchase_date", err.Error())
+			} else {
+				asset.PurchaseDate = &date
+			}
+		}
+
+		if v := cell("purchase_price"); v != "" {
+			price, err := strconv.ParseFloat(strings.ReplaceAll(v, ",", ""), 64)
+			if err != nil || price < 0 {
+				fail("purchase_price", fmt.Sprintf("invalid price %q", v))
+			} else {
+				asset.PurchasePrice = &price
+			}
+		}
+
+		if asset.SerialNumber != nil {
+			serial := *asset.SerialNumber
+			if first, dup := seenSerials[serial]; dup {
+				fail("serial_number", fmt.Sprintf("serial number %q is repeated from row %d", serial, first))
+			} else {
+				seenSerials[serial] = rowNumber
+			}
+			if inUse[serial] {
+				fail("serial_number", fmt.Sprintf("serial number %q already belongs to an existing asset", serial))
+			}
+		}
+
+		for field := range columns {
+			if key := strings.TrimPrefix(field, customImportPrefix); key != field {
+				if asset.CustomFields == nil {
+					asset.CustomFields = customFieldValues{}
+				}
+				asset.CustomFields[key] = cell(field)
+			}
+		}
+		if err := store.ValidateCustomFields(&asset); err != nil {
+			var invalid *customFieldError
+			if !errors.As(err, &invalid) {
+				return nil, nil, err
+			}
+			fail(customImportPrefix+invalid.Key, invalid.Message)
+		}
+
+		if len(rowErrors) == errorsBefore {
+			assets = append(assets, asset)
+		}
+	}
+
+	return assets, rowErrors, nil
+}
+
+// previewImportHandler reads an uploaded file and returns its headers, a suggested
+// column mapping and sample rows so the client can confirm the mapping
+func previewImportHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+	fields, err := store.CustomFields()
+	if err != nil {
+		log.Printf("Error fetching custom fields for import: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	sheet, err := readImportFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	sample := sheet.Rows
+	if len(sample) > importSampleRows {
+		sample = sample[:importSampleRows]
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data: gin.H{
+			"format":           sheet.Format,
+			"sheet":            sheet.Sheet,
+			"headers":          sheet.Headers,
+			"fields":           importFieldNames(fields),
+			"suggestedMapping": suggestImportMapping(sheet.Headers, fields),
+			"sampleRows":       sample,
+			"totalRows":        len(sheet.Rows),
+		},
+	})
+}
+
+// importAssetsHandler validates an uploaded spreadsheet and, unless dry_run is set,
+// inserts every row in one transaction. Any row error aborts the whole import.
+func importAssetsHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
+	sheet, err := readImportFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	fields, err := store.CustomFields()
+	if err != nil {
+		log.Printf("Error fetching custom fields for import: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	mapping := suggestImportMapping(sheet.Headers, fields)
+	if raw := c.PostForm("mapping"); raw != "" {
+		mapping = make(map[string]string)
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "mapping must be a JSON object of asset field to column header",
+			})
+			return
+		}
+	}
+	columns, err := resolveImportMapping(sheet.Headers, mapping, fields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	assets, rowErrors, err := validateImportRows(store, sheet, columns)
+	if err != nil {
+		log.Printf("Error validating import: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.PostForm("dry_run"))
+	result := ImportResult{
+		DryRun:    dryRun,
+		TotalRows: len(sheet.Rows),
+		ValidRows: len(assets),
+		Errors:    rowErrors,
+		Mapping:   mapping,
+	}
+	if result.Errors == nil {
+		result.Errors = []ImportRowError{}
+	}
+
+	if len(rowErrors) > 0 && !dryRun {
+		c.JSON(http.StatusUnprocessableEntity, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("%d errors found; nothing was imported", len(rowErrors)),
+			Data:    result,
+		})
+		return
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Message: fmt.Sprintf("Dry run: %d of %d rows are valid", len(assets), len(sheet.Rows)),
+			Data:    result,
+		})
+		return
+	}
+
+	ids, err := store.CreateMany(assets)
+	if err != nil {
+		log.Printf("Error importing assets: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	result.AssetIDs = ids
+
+	c.JSON(http.StatusCreated, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Successfully imported %d assets", len(ids)),
+		Data:    result,
+	})
+}
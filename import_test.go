@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+// importTestStore is the part of an AssetStore that validateImportRows uses
+type importTestStore struct {
+	AssetStore
+}
+
+func (importTestStore) CategoryIDs() (map[string]int, error) {
+	return map[string]int{"computer": 1, "1": 1}, nil
+}
+
+func (importTestStore) SerialNumbersInUse(serials []string) (map[string]bool, error) {
+	return map[string]bool{}, nil
+}
+
+func (importTestStore) ValidateCustomFields(asset *Asset) error {
+	return nil
+}
+
+func TestValidateImportRowsStatus(t *testing.T) {
+	sheet := &importSheet{
+		Headers: []string{"Name", "Status"},
+		Rows: [][]string{
+			{"Laptop", ""},
+			{"Printer", "retired"},
+			{"Router", "Broken"},
+			{"Desk", "Maintenance"},
+		},
+	}
+	columns := map[string]int{"asset_name": 0, "status": 1}
+
+	assets, rowErrors, err := validateImportRows(importTestStore{}, sheet, columns)
+	if err != nil {
+		t.Fatalf("validateImportRows: %v", err)
+	}
+
+	if len(rowErrors) != 1 {
+		t.Fatalf("got %d row errors, want 1: %+v", len(rowErrors), rowErrors)
+	}
+	if e := rowErrors[0]; e.Row != 4 || e.Field != "status" {
+		t.Errorf("row error = %+v, want row 4, field status", e)
+	}
+
+	want := map[string]string{"Laptop": "Active", "Printer": "Retired", "Desk": "Maintenance"}
+	if len(assets) != len(want) {
+		t.Fatalf("got %d valid assets, want %d", len(assets), len(want))
+	}
+	for _, asset := range assets {
+		if asset.Status != want[asset.AssetName] {
+			t.Errorf("%s: status %q, want %q", asset.AssetName, asset.Status, want[asset.AssetName])
+		}
+	}
+}
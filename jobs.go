@@ -0,0 +1,720 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job states
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+const (
+	// defaultJobWorkers applies when JOB_WORKERS is unset
+	defaultJobWorkers = 2
+
+	// defaultJobRetentionHours applies when JOB_RETENTION_HOURS is unset
+	defaultJobRetentionHours = 24
+
+	// jobMaxAttempts is how often a job is tried before it fails
+	jobMaxAttempts = 3
+
+	// jobRetryDelay is the wait before the first retry; it doubles with each attempt
+	jobRetryDelay = 30 * time.Second
+
+	// jobPollInterval is how often idle workers look for queued jobs
+	jobPollInterval = 2 * time.Second
+
+	// jobHeartbeatInterval is how often running jobs are marked alive and checked for
+	// cancellation; jobs silent for jobStaleAfter are assumed lost and tried again
+	jobHeartbeatInterval = 15 * time.Second
+	jobStaleAfter        = 2 * time.Minute
+
+	// jobSweepInterval is how often stale and expired jobs are cleaned up
+	jobSweepInterval = time.Minute
+
+	// jobListLimit is the number of recent jobs listed
+	jobListLimit = 50
+)
+
+var (
+	errJobNotFound    = errors.New("job not found")
+	errJobFinished    = errors.New("job has already finished")
+	errJobCancelled   = errors.New("job was cancelled")
+	errJobNoArtifact  = errors.New("job has no file to download")
+	errUnknownJobType = errors.New("unknown job type")
+	errJobClaimLost   = errors.New("job was given up as stale and is no longer this worker's")
+)
+
+// jobRunner does the work of one job type. It reports progress through the job, writes
+// its file with CreateArtifact and returns the job's result.
+type jobRunner func(job *runningJob) (interface{}, error)
+
+// jobRunners are the job types, keyed by the name stored in jobs.job_type
+var jobRunners = map[string]jobRunner{
+	jobTypeLabels:      runLabelJob,
+	jobTypeLabelBatch:  runLabelBatchJob,
+	jobTypeAssetReport: runAssetReportJob,
+	jobTypeInvoice:     runInvoiceJob,
+}
+
+// permanentJobError marks a failure that retrying cannot fix, such as a bad payload
+type permanentJobError struct {
+	err error
+}
+
+func (e permanentJobError) Error() string {
+	return e.err.Error()
+}
+
+// permanent wraps an error so the job fails without being retried
+func permanent(err error) error {
+	return permanentJobError{err}
+}
+
+// jobWorkerCount returns the number of workers from JOB_WORKERS
+func jobWorkerCount() int {
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err == nil && n > 0 {
+			return n
+		}
+		log.Printf("Invalid JOB_WORKERS %q, using %d", v, defaultJobWorkers)
+	}
+	return defaultJobWorkers
+}
+
+// jobRetention returns how long finished jobs and their files are kept, from JOB_RETENTION_HOURS
+func jobRetention() time.Duration {
+	if v := os.Getenv("JOB_RETENTION_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err == nil && hours > 0 {
+			return time.Duration(hours) * time.Hour
+		}
+		log.Printf("Invalid JOB_RETENTION_HOURS %q, using %d", v, defaultJobRetentionHours)
+	}
+	return defaultJobRetentionHours * time.Hour
+}
+
+// jobColumns lists the job columns read by the store, in scan order
+const jobColumns = `id, company_id, user_id, job_type, payload, status, progress, attempts, max_attempts,
+	cancel_requested, error, result, artifact_id, artifact_name, created_at, started_at, finished_at, expires_at`
+
+// scanJob reads one row selected with jobColumns
+func scanJob(row rowScanner) (Job, error) {
+	var j Job
+	var payload, result []byte
+	err := row.Scan(&j.ID, &j.CompanyID, &j.UserID, &j.Type, &payload, &j.Status, &j.Progress, &j.Attempts,
+		&j.MaxAttempts, &j.CancelRequested, &j.Error, &result, &j.ArtifactID, &j.ArtifactName, &j.CreatedAt,
+		&j.StartedAt, &j.FinishedAt, &j.ExpiresAt)
+	j.Payload = json.RawMessage(payload)
+	if result != nil {
+		j.Result = json.RawMessage(result)
+	}
+	if j.Status == jobSucceeded && j.ArtifactID != nil {
+		j.DownloadURL, _ = artifacts.SignedURL(*j.ArtifactID)
+	}
+	return j, err
+}
+
+// jobQueue runs queued jobs on a pool of workers in this process. Jobs are claimed from
+// the jobs table, so several server processes can share one queue.
+type jobQueue struct {
+	wake      chan struct{}
+	retention time.Duration
+
+	mu      sync.Mutex
+	running map[int64]context.CancelFunc
+}
+
+// backgroundJobs is the process's queue, started by startJobWorkers
+var backgroundJobs = &jobQueue{
+	wake:    make(chan struct{}, 1),
+	running: make(map[int64]context.CancelFunc),
+}
+
+// startJobWorkers starts the job workers and the sweeper of stale and expired jobs
+func startJobWorkers() {
+	backgroundJobs.retention = jobRetention()
+	workers := jobWorkerCount()
+	for i := 0; i < workers; i++ {
+		go backgroundJobs.work()
+	}
+	go func() {
+		for {
+			backgroundJobs.sweep()
+			time.Sleep(jobSweepInterval)
+		}
+	}()
+	log.Printf("Started %d job workers", workers)
+}
+
+// notify wakes an idle worker
+func (q *jobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work runs jobs until the process exits, waiting for new ones when the queue is empty
+func (q *jobQueue) work() {
+	for {
+		job, claim, err := q.claim()
+		if err != nil {
+			log.Printf("Error claiming job: %v", err)
+		}
+		if job == nil {
+			select {
+			case <-q.wake:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+		q.run(job, claim)
+	}
+}
+
+// claim takes the oldest due job. The claim is a single UPDATE with a random token,
+// so two workers never take the same job. Every later write of the run names the
+// token, so a worker whose job was requeued as stale cannot overwrite the new run.
+func (q *jobQueue) claim() (*Job, string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, "", err
+	}
+	claim := hex.EncodeToString(token)
+	result, err := db.Exec(`
+		UPDATE jobs SET status = ?, claim_token = ?, attempts = attempts + 1, progress = 0,
+		started_at = NOW(), heartbeat_at = NOW()
+		WHERE status = ? AND run_after <= NOW()
+		ORDER BY run_after, id LIMIT 1`, jobRunning, claim, jobQueued)
+	if err != nil {
+		return nil, "", err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return nil, "", err
+	}
+	job, err := scanJob(db.QueryRow(fmt.Sprintf("SELECT %s FROM jobs WHERE claim_token = ?", jobColumns), claim))
+	if err != nil {
+		return nil, "", err
+	}
+	return &job, claim, nil
+}
+
+// run executes a claimed job and records how it ended
+func (q *jobQueue) run(job *Job, claim string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q.mu.Lock()
+	q.running[job.ID] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, job.ID)
+		q.mu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go q.heartbeat(job.ID, claim, cancel, done)
+
+	rj := &runningJob{Job: job, ctx: ctx, claim: claim}
+	result, err := q.execute(rj)
+	if err == nil && ctx.Err() != nil {
+		err = errJobCancelled
+	}
+	if rj.file != nil {
+		if err == nil {
+			// The file is kept as long as the finished job
+			rj.artifact, err = artifacts.Save(rj.file, job.CompanyID, job.UserID, artifactJob, time.Now().Add(q.retention))
+		} else {
+			rj.file.Discard()
+		}
+	}
+	if err := q.finish(rj, result, err); err == errJobClaimLost {
+		// Its file, if any, expires unused
+		log.Printf("Job %d lost its claim while running; dropping the outcome of this run", job.ID)
+	} else if err != nil {
+		log.Printf("Error recording the end of job %d: %v", job.ID, err)
+	}
+}
+
+// execute calls the job's runner, turning a panic into a failure
+func (q *jobQueue) execute(job *runningJob) (result interface{}, err error) {
+	runner, ok := jobRunners[job.Type]
+	if !ok {
+		return nil, permanent(errUnknownJobType)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return runner(job)
+}
+
+// heartbeat marks a running job alive until done is closed, and cancels it once a
+// cancellation is requested, possibly through another server process, or once the job
+// is no longer claimed by this run
+func (q *jobQueue) heartbeat(id int64, claim string, cancel context.CancelFunc, done chan struct{}) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := updateClaimedJob("UPDATE jobs SET heartbeat_at = NOW() WHERE id = ? AND claim_token = ?", id, claim)
+		if err == errJobClaimLost {
+			cancel()
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating heartbeat of job %d: %v", id, err)
+		}
+		var requested bool
+		err = db.QueryRow("SELECT cancel_requested FROM jobs WHERE id = ?", id).Scan(&requested)
+		if err == nil && requested {
+			cancel()
+		}
+	}
+}
+
+// updateClaimedJob runs an UPDATE of a job whose last two arguments are the job ID and
+// claim token, returning errJobClaimLost when the job no longer has that claim. MySQL
+// counts rows an UPDATE leaves unchanged as not affected, so each one must change a column.
+func updateClaimedJob(query string, args ...interface{}) error {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		err = errJobClaimLost
+	}
+	return err
+}
+
+// finish records the outcome of a run: success, cancellation, a retry after a delay,
+// or failure once the attempts are used up. It returns errJobClaimLost, writing
+// nothing, when the job was requeued as stale meanwhile.
+func (q *jobQueue) finish(job *runningJob, result interface{}, runErr error) error {
+	expires := time.Now().Add(q.retention)
+	var permanentErr permanentJobError
+	switch {
+	case runErr == nil:
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		var artifactID, name interface{}
+		if job.artifact != nil {
+			artifactID, name = job.artifact.ID, job.artifact.Filename
+		}
+		return updateClaimedJob(`
+			UPDATE jobs SET status = ?, progress = 100, result = ?, artifact_id = ?, artifact_name = ?,
+			error = NULL, finished_at = NOW(), expires_at = ?, claim_token = NULL WHERE id = ? AND claim_token = ?`,
+			jobSucceeded, data, artifactID, name, expires, job.ID, job.claim)
+
+	case errors.Is(runErr, errJobCancelled) || errors.Is(runErr, context.Canceled):
+		return updateClaimedJob(`
+			UPDATE jobs SET status = ?, finished_at = NOW(), expires_at = ?, claim_token = NULL
+			WHERE id = ? AND claim_token = ?`,
+			jobCancelled, expires, job.ID, job.claim)
+
+	case !errors.As(runErr, &permanentErr) && job.Attempts < job.MaxAttempts:
+		log.Printf("Job %d (%s) failed on attempt %d, retrying: %v", job.ID, job.Type, job.Attempts, runErr)
+		delay := jobRetryDelay << uint(job.Attempts-1)
+		return updateClaimedJob(`
+			UPDATE jobs SET status = ?, error = ?, run_after = ?, claim_token = NULL WHERE id = ? AND claim_token = ?`,
+			jobQueued, runErr.Error(), time.Now().Add(delay), job.ID, job.claim)
+	}
+
+	log.Printf("Job %d (%s) failed: %v", job.ID, job.Type, runErr)
+	return updateClaimedJob(`
+		UPDATE jobs SET status = ?, error = ?, finished_at = NOW(), expires_at = ?, claim_token = NULL
+		WHERE id = ? AND claim_token = ?`,
+		jobFailed, runErr.Error(), expires, job.ID, job.claim)
+}
+
+// cancelLocal stops a job running in this process
+func (q *jobQueue) cancelLocal(id int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cancel, ok := q.running[id]; ok {
+		cancel()
+	}
+}
+
+// sweep requeues jobs whose process stopped sending heartbeats, counting the lost run
+// as an attempt, and deletes expired jobs. Their files expire with them and are deleted
+// by the artifact collector.
+func (q *jobQueue) sweep() {
+	_, err := db.Exec(`
+		UPDATE jobs SET status = IF(attempts < max_attempts, ?, ?),
+		error = 'worker stopped responding', claim_token = NULL,
+		finished_at = IF(attempts < max_attempts, NULL, NOW()),
+		expires_at = IF(attempts < max_attempts, expires_at, ?)
+		WHERE status = ? AND heartbeat_at < ?`,
+		jobQueued, jobFailed, time.Now().Add(q.retention), jobRunning, time.Now().Add(-jobStaleAfter))
+	if err != nil {
+		log.Printf("Error requeueing stale jobs: %v", err)
+	}
+
+	result, err := db.Exec("DELETE FROM jobs WHERE expires_at < NOW() AND status != ?", jobRunning)
+	if err != nil {
+		log.Printf("Error deleting expired jobs: %v", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Deleted %d expired jobs", n)
+	}
+}
+
+// runningJob is a job being run by a worker
+type runningJob struct {
+	*Job
+	ctx          context.Context
+	claim        string
+	lastProgress int
+	file         *artifactFile
+	artifact     *Artifact
+}
+
+// Context is cancelled when the job is cancelled
+func (j *runningJob) Context() context.Context {
+	return j.ctx
+}
+
+// Actor is the user who queued the job, for audit entries written by it
+func (j *runningJob) Actor() auditActor {
+	actor := auditActor{RequestID: fmt.Sprintf("job-%d", j.ID)}
+	if j.UserID != nil {
+		actor.UserID = *j.UserID
+	}
+	return actor
+}
+
+// AssetStore returns the job's company's asset store
+func (j *runningJob) AssetStore() (*sqlAssetStore, error) {
+	return newSQLAssetStoreImpl(db, j.CompanyID, j.Actor())
+}
+
+// DecodePayload reads the job's payload; a payload that cannot be read fails the job for good
+func (j *runningJob) DecodePayload(v interface{}) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return permanent(fmt.Errorf("invalid job payload: %v", err))
+	}
+	return nil
+}
+
+// Progress records done out of total as a percentage, writing it only when it changes.
+// It returns errJobCancelled once the job is cancelled, and errJobClaimLost once it was
+// requeued as stale, so runners stop early.
+func (j *runningJob) Progress(done, total int) error {
+	if j.ctx.Err() != nil {
+		return errJobCancelled
+	}
+	if total <= 0 {
+		return nil
+	}
+	// 100 is recorded when the job succeeds
+	pct := done * 99 / total
+	if pct == j.lastProgress {
+		return nil
+	}
+	j.lastProgress = pct
+	return updateClaimedJob("UPDATE jobs SET progress = ? WHERE id = ? AND claim_token = ?", pct, j.ID, j.claim)
+}
+
+// CreateArtifact starts the job's output file, offered for download under name. The
+// file is stored when the job succeeds and dropped otherwise.
+func (j *runningJob) CreateArtifact(name string) (*artifactFile, error) {
+	if j.file != nil {
+		j.file.Discard()
+	}
+	f, err := artifacts.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	j.file = f
+	return f, nil
+}
+
+// jobStore is the access to the jobs of the request's user
+type jobStore struct {
+	companyID int
+	userID    int
+}
+
+// requireJobStore resolves the request's job store or aborts with 401
+func requireJobStore(c *gin.Context) (*jobStore, bool) {
+	companyID, userID := getCurrentCompanyID(c), getCurrentUserID(c)
+	if companyID == 0 || userID == 0 {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Company context required",
+		})
+		c.Abort()
+		return nil, false
+	}
+	return &jobStore{companyID: companyID, userID: userID}, true
+}
+
+// Enqueue queues a job and wakes a worker
+func (s *jobStore) Enqueue(jobType string, payload interface{}) (*Job, error) {
+	if _, ok := jobRunners[jobType]; !ok {
+		return nil, errUnknownJobType
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	// Jobs that never get to run expire like finished ones
+	result, err := db.Exec(`
+		INSERT INTO jobs (company_id, user_id, job_type, payload, status, max_attempts, run_after, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.companyID, s.userID, jobType, data, jobQueued, jobMaxAttempts, now, now, now.Add(jobRetention()))
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	backgroundJobs.notify()
+	return s.Get(id)
+}
+
+// Get returns one of the user's jobs
+func (s *jobStore) Get(id int64) (*Job, error) {
+	job, err := scanJob(db.QueryRow(
+		fmt.Sprintf("SELECT %s FROM jobs WHERE id = ? AND company_id = ? AND user_id = ?", jobColumns),
+		id, s.companyID, s.userID))
+	if err == sql.ErrNoRows {
+		return nil, errJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// List returns the user's most recent jobs, newest first
+func (s *jobStore) List(status string) ([]Job, error) {
+	query := fmt.Sprintf("SELECT %s FROM jobs WHERE company_id = ? AND user_id = ?", jobColumns)
+	args := []interface{}{s.companyID, s.userID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, jobListLimit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, job)
+	}
+	return list, rows.Err()
+}
+
+// Cancel cancels a queued job at once, and asks the worker of a running job to stop
+func (s *jobStore) Cancel(id int64) (*Job, error) {
+	job, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	switch job.Status {
+	case jobQueued:
+		result, err := db.Exec(`
+			UPDATE jobs SET status = ?, cancel_requested = 1, finished_at = NOW(), expires_at = ?
+			WHERE id = ? AND status = ?`, jobCancelled, time.Now().Add(jobRetention()), id, jobQueued)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			// A worker claimed it in the meantime
+			return s.Cancel(id)
+		}
+	case jobRunning:
+		if _, err := db.Exec("UPDATE jobs SET cancel_requested = 1 WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+		backgroundJobs.cancelLocal(id)
+	default:
+		return nil, errJobFinished
+	}
+	return s.Get(id)
+}
+
+// jobID reads the :id path parameter, writing 400 when it is not a job ID
+func jobID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid job ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// jobError writes the response for a failed job lookup or action
+func jobError(c *gin.Context, err error, action string) {
+	switch err {
+	case errJobNotFound, errJobNoArtifact:
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	case errJobFinished:
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	default:
+		log.Printf("Error %s job: %v", action, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+}
+
+// enqueueJobHandler queues a job for the request and answers 202 with the job, which
+// is polled at GET /api/jobs/:id
+func enqueueJobHandler(c *gin.Context, jobType string, payload interface{}) {
+	store, ok := requireJobStore(c)
+	if !ok {
+		return
+	}
+	job, err := store.Enqueue(jobType, payload)
+	if err != nil {
+		jobError(c, err, "queueing")
+		return
+	}
+	c.Header("Location", fmt.Sprintf("/api/jobs/%d", job.ID))
+	c.JSON(http.StatusAccepted, APIResponse{
+		Success: true,
+		Message: "Job queued",
+		Data:    job,
+	})
+}
+
+// listJobsHandler returns the user's recent jobs, optionally filtered by ?status=
+func listJobsHandler(c *gin.Context) {
+	store, ok := requireJobStore(c)
+	if !ok {
+		return
+	}
+	list, err := store.List(c.Query("status"))
+	if err != nil {
+		jobError(c, err, "listing")
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    list,
+	})
+}
+
+// getJobHandler returns a job's status, progress and result
+func getJobHandler(c *gin.Context) {
+	store, ok := requireJobStore(c)
+	if !ok {
+		return
+	}
+	id, ok := jobID(c)
+	if !ok {
+		return
+	}
+	job, err := store.Get(id)
+	if err != nil {
+		jobError(c, err, "fetching")
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    job,
+	})
+}
+
+// cancelJobHandler cancels a queued or running job
+func cancelJobHandler(c *gin.Context) {
+	store, ok := requireJobStore(c)
+	if !ok {
+		return
+	}
+	id, ok := jobID(c)
+	if !ok {
+		return
+	}
+	job, err := store.Cancel(id)
+	if err != nil {
+		jobError(c, err, "cancelling")
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Job cancellation requested",
+		Data:    job,
+	})
+}
+
+// downloadJobHandler serves the file of a succeeded job
+func downloadJobHandler(c *gin.Context) {
+	store, ok := requireJobStore(c)
+	if !ok {
+		return
+	}
+	id, ok := jobID(c)
+	if !ok {
+		return
+	}
+	job, err := store.Get(id)
+	if err == nil && (job.Status != jobSucceeded || job.ArtifactID == nil) {
+		err = errJobNoArtifact
+	}
+	if err != nil {
+		jobError(c, err, "downloading")
+		return
+	}
+	art, err := artifacts.Get(job.CompanyID, *job.ArtifactID)
+	if err != nil {
+		artifactError(c, err, "fetching")
+		return
+	}
+	artifacts.Serve(c, art)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestStaleWorkerCannotRecordJob checks that a worker whose job was requeued as stale,
+// and possibly claimed by another worker, writes neither progress nor an outcome
+func TestStaleWorkerCannotRecordJob(t *testing.T) {
+	q := &jobQueue{retention: time.Hour}
+	job := &runningJob{Job: &Job{ID: 3, Type: jobTypeLabels, Attempts: 1, MaxAttempts: 3},
+		ctx: context.Background(), claim: "old-claim"}
+
+	tests := []struct {
+		name   string
+		query  string
+		record func() error
+	}{
+		{"progress", "UPDATE jobs SET progress = ?", func() error { return job.Progress(1, 2) }},
+		{"success", "UPDATE jobs SET status = ?, progress = 100", func() error { return q.finish(job, nil, nil) }},
+		{"cancellation", "UPDATE jobs SET status = ?, finished_at", func() error { return q.finish(job, nil, errJobCancelled) }},
+		{"retry", "UPDATE jobs SET status = ?, error = ?, run_after = ?", func() error { return q.finish(job, nil, errors.New("timeout")) }},
+		{"failure", "UPDATE jobs SET status = ?, error = ?, finished_at", func() error {
+			return q.finish(job, nil, permanent(errors.New("bad payload")))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := useMockDB(t)
+			mock.ExpectExec(regexp.QuoteMeta(tt.query) + `(?s).*WHERE id = \? AND claim_token = \?`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			if err := tt.record(); err != errJobClaimLost {
+				t.Fatalf("got %v, want errJobClaimLost", err)
+			}
+			checkMock(t, mock)
+		})
+	}
+}
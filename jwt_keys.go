@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minJWTSecretLength is the shortest HS256 secret accepted without a warning, the size
+// of the SHA-256 output
+const minJWTSecretLength = 32
+
+// minRSAKeyBits is the smallest RSA key accepted for RS256
+const minRSAKeyBits = 2048
+
+// jwtKey is one key tokens may be signed or verified with. Keys of earlier rotations
+// can only verify.
+type jwtKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Sign   interface{} // nil for keys that only verify
+	Verify interface{}
+	Public crypto.PublicKey // nil for HMAC keys, which are never published
+}
+
+// jwtKeyring holds the key new tokens are signed with and every key tokens are accepted
+// from, by key ID
+type jwtKeyring struct {
+	current *jwtKey
+	byID    map[string]*jwtKey
+	order   []string
+}
+
+// jwtKeys is loaded once at startup by loadJWTKeys
+var jwtKeys *jwtKeyring
+
+// loadJWTKeys reads the signing configuration from the environment. It fails when no
+// signing key is configured, so the server never runs with a guessable default.
+//
+//   - JWT_ALGORITHM: HS256 (default), RS256 or EdDSA
+//   - JWT_SECRET: the HS256 secret
+//   - JWT_PRIVATE_KEY_FILE: PEM private key for RS256 or EdDSA
+//   - JWT_KEY_ID: ID of the current key; defaults to one derived from the key
+//   - JWT_PREVIOUS_SECRETS: comma-separated HS256 secrets still accepted during a rotation
+//   - JWT_PREVIOUS_PUBLIC_KEY_FILES: comma-separated PEM public keys still accepted and published
+func loadJWTKeys() error {
+	ring := &jwtKeyring{byID: make(map[string]*jwtKey)}
+
+	var current *jwtKey
+	var err error
+	switch alg := strings.TrimSpace(os.Getenv("JWT_ALGORITHM")); alg {
+	case "", "HS256":
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return errors.New("JWT_SECRET is not set")
+		}
+		if len(secret) < minJWTSecretLength {
+			log.Printf("JWT_SECRET is shorter than %d bytes; use a longer random secret", minJWTSecretLength)
+		}
+		current = hmacJWTKey(secret)
+	case "RS256", "EdDSA":
+		path := os.Getenv("JWT_PRIVATE_KEY_FILE")
+		if path == "" {
+			return fmt.Errorf("JWT_PRIVATE_KEY_FILE is required for %s", alg)
+		}
+		current, err = loadPrivateJWTKey(path, alg)
+		if err != nil {
+			return fmt.Errorf("JWT_PRIVATE_KEY_FILE: %v", err)
+		}
+	default:
+		return fmt.Errorf("JWT_ALGORITHM must be HS256, RS256 or EdDSA, not %q", alg)
+	}
+	if id := strings.TrimSpace(os.Getenv("JWT_KEY_ID")); id != "" {
+		current.ID = id
+	}
+	ring.current = current
+	ring.add(current)
+
+	for _, secret := range splitList(os.Getenv("JWT_PREVIOUS_SECRETS")) {
+		ring.add(hmacJWTKey(secret))
+	}
+	for _, path := range splitList(os.Getenv("JWT_PREVIOUS_PUBLIC_KEY_FILES")) {
+		key, err := loadPublicJWTKey(path)
+		if err != nil {
+			return fmt.Errorf("JWT_PREVIOUS_PUBLIC_KEY_FILES: %s: %v", path, err)
+		}
+		ring.add(key)
+	}
+
+	jwtKeys = ring
+	log.Printf("Signing tokens with %s key %s; accepting %d keys", current.Method.Alg(), current.ID, len(ring.order))
+	return nil
+}
+
+// splitList splits a comma-separated setting, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// add makes a key acceptable for verification. The first key with an ID wins.
+func (r *jwtKeyring) add(key *jwtKey) {
+	if _, ok := r.byID[key.ID]; ok {
+		return
+	}
+	r.byID[key.ID] = key
+	r.order = append(r.order, key.ID)
+}
+
+// hmacJWTKey returns an HS256 key. Its ID is derived from the secret so that servers
+// sharing a secret agree on it without configuration.
+func hmacJWTKey(secret string) *jwtKey {
+	sum := sha256.Sum256([]byte("jwt key id:" + secret))
+	return &jwtKey{
+		ID:     "hs-" + hex.EncodeToString(sum[:8]),
+		Method: jwt.SigningMethodHS256,
+		Sign:   []byte(secret),
+		Verify: []byte(secret),
+	}
+}
+
+// readPEM returns the first PEM block of a file
+func readPEM(path string) (*pem.Block, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return block, nil
+}
+
+// loadPrivateJWTKey reads a PKCS#8 (or PKCS#1 RSA) private key for an algorithm
+func loadPrivateJWTKey(path, alg string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if rsaErr != nil {
+			return nil, fmt.Errorf("not a PKCS#8 or PKCS#1 private key: %v", err)
+		}
+		parsed = rsaKey
+	}
+
+	var key *jwtKey
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key, err = publicJWTKey(&k.PublicKey)
+		if err == nil {
+			key.Sign = k
+		}
+	case ed25519.PrivateKey:
+		key, err = publicJWTKey(k.Public())
+		if err == nil {
+			key.Sign = k
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.Method.Alg() != alg {
+		return nil, fmt.Errorf("the key is for %s, not %s", key.Method.Alg(), alg)
+	}
+	return key, nil
+}
+
+// loadPublicJWTKey reads a PKIX (or PKCS#1 RSA) public key of an earlier rotation
+func loadPublicJWTKey(path string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		rsaKey, rsaErr := x509.ParsePKCS1PublicKey(block.Bytes)
+		if rsaErr != nil {
+			return nil, fmt.Errorf("not a PKIX or PKCS#1 public key: %v", err)
+		}
+		parsed = rsaKey
+	}
+	return publicJWTKey(parsed)
+}
+
+// publicJWTKey returns a verify-only key for an RSA or Ed25519 public key, with its
+// RFC 7638 thumbprint as the key ID
+func publicJWTKey(public crypto.PublicKey) (*jwtKey, error) {
+	key := &jwtKey{Verify: public, Public: public}
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+
+	// The thumbprint hashes the required JWK members in lexicographic order
+	jwk := publicJWK(key)
+	var members string
+	if jwk.Kty == "RSA" {
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	} else {
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	key.ID = base64.RawURLEncoding.EncodeToString(sum[:])
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// publicJWK describes the public half of an RSA or Ed25519 key
+func publicJWK(key *jwtKey) JWK {
+	jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+	switch k := key.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k)
+	}
+	return jwk
+}
+
+// sign signs claims with the current key, naming it in the kid header
+func (r *jwtKeyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(r.current.Method, claims)
+	token.Header["kid"] = r.current.ID
+	return token.SignedString(r.current.Sign)
+}
+
+// parse verifies a token with the key its kid header names. Tokens without a kid, with
+// an unknown kid or signed with another algorithm than their key's are refused.
+func (r *jwtKeyring) parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	methods := make([]string, 0, len(r.order))
+	for _, id := range r.order {
+		methods = append(methods, r.byID[id].Method.Alg())
+	}
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := r.byID[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+		}
+		return key.Verify, nil
+	}, jwt.WithValidMethods(methods))
+}
+
+// jwks returns the public keys tokens are accepted from. HMAC secrets are never
+// published, so with HS256 alone the set is empty.
+func (r *jwtKeyring) jwks() []JWK {
+	keys := []JWK{}
+	for _, id := range r.order {
+		if key := r.byID[id]; key.Public != nil {
+			keys = append(keys, publicJWK(key))
+		}
+	}
+	return keys
+}
+
+// jwksHandler publishes the public signing keys so other services can verify tokens
+func jwksHandler(c *gin.Context) {
+	raw, err := json.Marshal(gin.H{"keys": jwtKeys.jwks()})
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Data(http.StatusOK, "application/jwk-set+json", raw)
+}
@@ -0,0 +1,979 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	// labelTemplateSetting is the company_settings key holding the company's default template,
+	// a stored template ID or a preset key
+	labelTemplateSetting = "label_template"
+
+	// labelLogoSetting is the company_settings key holding the logo printed on labels, a file in labelLogoDir
+	labelLogoSetting = "label_logo"
+	labelLogoDir     = "./assetLogos"
+
+	// maxLabelGrid is the most rows or columns a template may have
+	maxLabelGrid = 50
+
+	// maxLabelFields is the most asset fields a label may print
+	maxLabelFields = 10
+
+	// pageCountAlias in page text is replaced by the number of pages
+	pageCountAlias = "{nb}"
+
+	// minLinearBarcodeHeight is the shortest linear barcode, in mm, printed on a label
+	minLinearBarcodeHeight = 5.0
+)
+
+// Logo placements on a label
+const (
+	labelLogoNone     = "none"
+	labelLogoTopLeft  = "top_left"
+	labelLogoTopRight = "top_right"
+)
+
+// Photo placements on a label: a column beside the barcode and fields holding the
+// thumbnail of the asset's primary photo
+const (
+	labelPhotoNone  = "none"
+	labelPhotoLeft  = "left"
+	labelPhotoRight = "right"
+)
+
+var errUnknownLabelTemplate = errors.New("label template not found")
+
+// labelField is an asset field that can be printed on a label
+type labelField struct {
+	Caption string
+	Value   func(asset Asset) string
+}
+
+// labelFields are the printable asset fields, keyed by the name used in templates
+var labelFields = map[string]labelField{
+	"asset_name":    {"Asset", func(a Asset) string { return a.AssetName }},
+	"tag":           {"Tag", func(a Asset) string { return safeString(a.Barcode) }},
+	"asset_type":    {"Type", func(a Asset) string { return safeString(a.AssetType) }},
+	"institution":   {"Institution", func(a Asset) string { return safeString(a.InstitutionName) }},
+	"department":    {"Department", func(a Asset) string { return safeString(a.Department) }},
+	"location":      {"Location", func(a Asset) string { return safeString(a.Location) }},
+	"status":        {"Status", func(a Asset) string { return a.Status }},
+	"serial_number": {"Serial", func(a Asset) string { return safeString(a.SerialNumber) }},
+	"model_number":  {"Model", func(a Asset) string { return safeString(a.ModelNumber) }},
+	"manufacturer":  {"Manufacturer", func(a Asset) string { return safeString(a.Manufacturer) }},
+	"purchase_date": {"Purchased", func(a Asset) string { return formatDate(a.PurchaseDate) }},
+}
+
+// legacyLabelFields are the fields of the A4 sheets printed before templates existed
+var legacyLabelFields = []string{"asset_name", "asset_type", "institution", "department", "location"}
+
+// labelTemplatePresets are the built-in templates, addressed by key wherever a template ID is accepted
+var labelTemplatePresets = map[string]LabelTemplate{
+	// The A4 layouts used before templates existed: two labels per page, or a 2 x 2 grid
+	"a4-2up": {
+		Name: "A4, 2 per page", PageWidth: 210, PageHeight: 297, Rows: 2, Columns: 1,
+		MarginTop: 30, MarginLeft: 10, LabelWidth: 190, LabelHeight: 120,
+		Fields: legacyLabelFields, HeaderFontSize: 16, FieldFontSize: 10, LogoPosition: labelLogoNone,
+		PhotoPosition: labelPhotoNone,
+	},
+	"a4-4up": {
+		Name: "A4, 4 per page", PageWidth: 210, PageHeight: 297, Rows: 2, Columns: 2,
+		MarginTop: 30, MarginLeft: 10, LabelWidth: 95, LabelHeight: 120,
+		Fields: legacyLabelFields, HeaderFontSize: 16, FieldFontSize: 10, LogoPosition: labelLogoNone,
+		PhotoPosition: labelPhotoNone,
+	},
+	"avery-l7160": {
+		Name: "Avery L7160 (A4, 63.5 x 38.1 mm, 21 per sheet)", PageWidth: 210, PageHeight: 297,
+		Rows: 7, Columns: 3, MarginTop: 15.15, MarginLeft: 7.25, ColumnGap: 2.5,
+		LabelWidth: 63.5, LabelHeight: 38.1, Padding: 2,
+		Fields: []string{"tag", "asset_name"}, FieldFontSize: 7, LogoPosition: labelLogoNone,
+		PhotoPosition: labelPhotoNone,
+	},
+	"avery-l7163": {
+		Name: "Avery L7163 (A4, 99.1 x 38.1 mm, 14 per sheet)", PageWidth: 210, PageHeight: 297,
+		Rows: 7, Columns: 2, MarginTop: 15.15, MarginLeft: 4.65, ColumnGap: 2.5,
+		LabelWidth: 99.1, LabelHeight: 38.1, Padding: 2,
+		Fields: []string{"tag", "asset_name", "location"}, FieldFontSize: 7, LogoPosition: labelLogoNone,
+		PhotoPosition: labelPhotoNone,
+	},
+	"avery-l7651": {
+		Name: "Avery L7651 (A4, 38.1 x 21.2 mm, 65 per sheet)", PageWidth: 210, PageHeight: 297,
+		Rows: 13, Columns: 5, MarginTop: 10.7, MarginLeft: 4.75, ColumnGap: 2.5,
+		LabelWidth: 38.1, LabelHeight: 21.2, Padding: 1,
+		Fields: []string{"tag"}, FieldFontSize: 6, LogoPosition: labelLogoNone,
+		PhotoPosition: labelPhotoNone,
+	},
+	"avery-5160": {
+		Name: "Avery 5160 (Letter, 2.625 x 1 in, 30 per sheet)", PageWidth: 215.9, PageHeight: 279.4,
+		Rows: 10, Columns: 3, MarginTop: 12.7, MarginLeft: 4.76, ColumnGap: 3.18,
+		LabelWidth: 66.68, LabelHeight: 25.4, Padding: 1.5,
+		Fields: []string{"tag", "asset_name"}, FieldFontSize: 7, LogoPosition: labelLogoNone,
+		PhotoPosition: labelPhotoNone,
+	},
+	"avery-5163": {
+		Name: "Avery 5163 (Letter, 4 x 2 in, 10 per sheet)", PageWidth: 215.9, PageHeight: 279.4,
+		Rows: 5, Columns: 2, MarginTop: 12.7, MarginLeft: 3.97, ColumnGap: 4.76,
+		LabelWidth: 101.6, LabelHeight: 50.8, Padding: 3,
+		Fields:        []string{"asset_name", "tag", "institution", "department", "location"},
+		FieldFontSize: 8, LogoPosition: labelLogoTopRight, LogoHeight: 8, PhotoPosition: labelPhotoNone,
+	},
+	"thermal-50x25": {
+		Name: "Thermal roll, 50 x 25 mm", PageWidth: 50, PageHeight: 25, Rows: 1, Columns: 1,
+		LabelWidth: 50, LabelHeight: 25, Padding: 1.5,
+		Fields: []string{"tag"}, FieldFontSize: 7, LogoPosition: labelLogoNone,
+		PhotoPosition: labelPhotoNone,
+	},
+}
+
+// labelTemplateRef is a template ID in a request: a stored template's number or a preset key.
+// Both JSON numbers and strings are accepted.
+type labelTemplateRef string
+
+// UnmarshalJSON accepts 12, "12" and "avery-l7160"
+func (r *labelTemplateRef) UnmarshalJSON(data []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err == nil {
+		*r = labelTemplateRef(n.String())
+		return nil
+	}
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("templateId must be a number or a preset key")
+	}
+	*r = labelTemplateRef(safeString(s))
+	return nil
+}
+
+// fontLineHeight converts a font size in points to a line height in mm
+func fontLineHeight(size float64) float64 {
+	return size * 25.4 / 72 * 1.25
+}
+
+// validateLabelTemplate checks a template's sizes, fields and logo placement and that
+// its grid fits the page
+func validateLabelTemplate(t *LabelTemplate) error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" || len(t.Name) > 100 {
+		return errors.New("name is required and may be at most 100 characters")
+	}
+	if t.PageWidth < 10 || t.PageHeight < 10 || t.PageWidth > 1000 || t.PageHeight > 1000 {
+		return errors.New("page width and height must be between 10 and 1000 mm")
+	}
+	if t.Rows < 1 || t.Columns < 1 || t.Rows > maxLabelGrid || t.Columns > maxLabelGrid {
+		return fmt.Errorf("rows and columns must be between 1 and %d", maxLabelGrid)
+	}
+	if t.MarginTop < 0 || t.MarginLeft < 0 || t.ColumnGap < 0 || t.RowGap < 0 || t.Padding < 0 {
+		return errors.New("margins, gaps and padding cannot be negative")
+	}
+	if t.LabelWidth <= 2*t.Padding || t.LabelHeight <= 2*t.Padding {
+		return errors.New("labels must be larger than their padding")
+	}
+	// Allow for rounding in label sizes converted from inches
+	const tolerance = 0.1
+	if t.MarginLeft+float64(t.Columns)*t.LabelWidth+float64(t.Columns-1)*t.ColumnGap > t.PageWidth+tolerance {
+		return errors.New("labels do not fit the page width")
+	}
+	if t.MarginTop+float64(t.Rows)*t.LabelHeight+float64(t.Rows-1)*t.RowGap > t.PageHeight+tolerance {
+		return errors.New("labels do not fit the page height")
+	}
+
+	if len(t.Fields) > maxLabelFields {
+		return fmt.Errorf("a label can print at most %d fields", maxLabelFields)
+	}
+	seen := make(map[string]bool)
+	for _, f := range t.Fields {
+		if _, ok := labelFields[f]; !ok || seen[f] {
+			return fmt.Errorf("unknown or repeated field %q", f)
+		}
+		seen[f] = true
+	}
+
+	if t.HeaderFontSize != 0 && (t.HeaderFontSize < 6 || t.HeaderFontSize > 36) {
+		return errors.New("header_font_size must be 0 (no page header) or between 6 and 36")
+	}
+	if t.HeaderFontSize > 0 && t.MarginTop < fontLineHeight(t.HeaderFontSize) {
+		return errors.New("margin_top leaves no room for the page header")
+	}
+	if t.FieldFontSize < 4 || t.FieldFontSize > 24 {
+		return errors.New("field_font_size must be between 4 and 24")
+	}
+
+	if t.LogoPosition == "" {
+		t.LogoPosition = labelLogoNone
+	}
+	switch t.LogoPosition {
+	case labelLogoNone:
+		t.LogoHeight = 0
+	case labelLogoTopLeft, labelLogoTopRight:
+		if t.LogoHeight <= 0 || t.LogoHeight >= t.LabelHeight-2*t.Padding {
+			return errors.New("logo_height must be positive and fit the label")
+		}
+	default:
+		return fmt.Errorf("logo_position must be %s, %s or %s", labelLogoNone, labelLogoTopLeft, labelLogoTopRight)
+	}
+
+	if t.PhotoPosition == "" {
+		t.PhotoPosition = labelPhotoNone
+	}
+	switch t.PhotoPosition {
+	case labelPhotoNone:
+		t.PhotoWidth = 0
+	case labelPhotoLeft, labelPhotoRight:
+		// Leave at least a third of the label for the barcode and fields
+		if t.PhotoWidth <= 0 || t.PhotoWidth > (t.LabelWidth-2*t.Padding)*2/3 {
+			return errors.New("photo_width must be positive and at most two thirds of the label")
+		}
+	default:
+		return fmt.Errorf("photo_position must be %s, %s or %s", labelPhotoNone, labelPhotoLeft, labelPhotoRight)
+	}
+	return nil
+}
+
+// validateLabelLogo accepts a PNG or JPEG file name in labelLogoDir
+func validateLabelLogo(name string) error {
+	ext := strings.ToLower(filepath.Ext(name))
+	if filepath.Base(name) != name || (ext != ".png" && ext != ".jpg" && ext != ".jpeg") {
+		return fmt.Errorf("%s must be the name of a PNG or JPEG file in %s", labelLogoSetting, labelLogoDir)
+	}
+	if _, err := os.Stat(filepath.Join(labelLogoDir, name)); err != nil {
+		return fmt.Errorf("%s %q does not exist", labelLogoSetting, name)
+	}
+	return nil
+}
+
+// validateLabelTemplateSetting accepts a preset key or a template ID
+func validateLabelTemplateSetting(value string) error {
+	if _, ok := labelTemplatePresets[value]; ok {
+		return nil
+	}
+	if id, err := strconv.Atoi(value); err == nil && id > 0 {
+		return nil
+	}
+	return fmt.Errorf("%s must be a label template ID or preset key", labelTemplateSetting)
+}
+
+// labelTemplateColumns lists the label_templates columns read by scanLabelTemplate, in scan order
+const labelTemplateColumns = `id, name, page_width, page_height, grid_rows, grid_columns, margin_top,
+	margin_left, column_gap, row_gap, label_width, label_height, padding, fields, header_font_size,
+	field_font_size, logo_position, logo_height, photo_position, photo_width, created_at, updated_at`
+
+// scanLabelTemplate reads one row selected with labelTemplateColumns
+func scanLabelTemplate(row rowScanner) (LabelTemplate, error) {
+	var t LabelTemplate
+	var fields []byte
+	err := row.Scan(&t.ID, &t.Name, &t.PageWidth, &t.PageHeight, &t.Rows, &t.Columns, &t.MarginTop,
+		&t.MarginLeft, &t.ColumnGap, &t.RowGap, &t.LabelWidth, &t.LabelHeight, &t.Padding, &fields,
+		&t.HeaderFontSize, &t.FieldFontSize, &t.LogoPosition, &t.LogoHeight, &t.PhotoPosition, &t.PhotoWidth,
+		&t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return t, err
+	}
+	err = json.Unmarshal(fields, &t.Fields)
+	return t, err
+}
+
+// getLabelTemplate reads a stored template of the company, or returns sql.ErrNoRows
+func getLabelTemplate(companyID, id int) (LabelTemplate, error) {
+	return scanLabelTemplate(db.QueryRow(
+		fmt.Sprintf("SELECT %s FROM label_templates WHERE id = ? AND company_id = ?", labelTemplateColumns),
+		id, companyID))
+}
+
+// findLabelTemplate looks a template up by stored ID or preset key
+func findLabelTemplate(companyID int, ref string) (LabelTemplate, error) {
+	if preset, ok := labelTemplatePresets[ref]; ok {
+		preset.Preset = ref
+		return preset, nil
+	}
+	id, err := strconv.Atoi(ref)
+	if err != nil || id <= 0 {
+		return LabelTemplate{}, errUnknownLabelTemplate
+	}
+	t, err := getLabelTemplate(companyID, id)
+	if err == sql.ErrNoRows {
+		return t, errUnknownLabelTemplate
+	}
+	return t, err
+}
+
+// resolveLabelTemplate picks the requested template, else the company's default, else the
+// endpoint's legacy preset
+func resolveLabelTemplate(companyID int, requested labelTemplateRef, fallback string) (LabelTemplate, error) {
+	ref := strings.TrimSpace(string(requested))
+	if ref == "" {
+		setting, ok, err := getCompanySetting(companyID, labelTemplateSetting)
+		if err != nil {
+			return LabelTemplate{}, err
+		}
+		ref = fallback
+		if ok {
+			ref = setting
+		}
+	}
+	return findLabelTemplate(companyID, ref)
+}
+
+// requireLabelTemplate resolves the template of a barcode request, writing 400 when it is unknown
+func requireLabelTemplate(c *gin.Context, companyID int, requested labelTemplateRef, fallback string) (LabelTemplate, bool) {
+	t, err := resolveLabelTemplate(companyID, requested, fallback)
+	if err == errUnknownLabelTemplate {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return t, false
+	}
+	if err != nil {
+		log.Printf("Error resolving label template: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return t, false
+	}
+	return t, true
+}
+
+// labelLogoPath returns the company's label logo file, or "" when none is set
+func labelLogoPath(companyID int) (string, error) {
+	name, ok, err := getCompanySetting(companyID, labelLogoSetting)
+	if err != nil || !ok {
+		return "", err
+	}
+	return filepath.Join(labelLogoDir, name), nil
+}
+
+// labelSheet lays asset labels out on the pages of a template, row by row, and hands the
+// drawing to the renderer of the requested output format
+type labelSheet struct {
+	r         labelRenderer
+	format    string
+	dpi       int
+	tpl       LabelTemplate
+	symName   string
+	sym       barcodeSymbology
+	logo      string
+	logoWidth float64
+	photos    bool
+	header    string
+	slot      int
+}
+
+// labelRenderer draws the pages of a label sheet in one output format. Positions and
+// sizes are in mm from the top left of the page, and pageCountAlias in text is replaced
+// by the number of pages when the sheet is written.
+type labelRenderer interface {
+	// logoWidth loads a logo and returns its width at a height, or false when it cannot be printed
+	logoWidth(path string, height float64) (float64, bool)
+
+	// barcode encodes a payload sized to fit maxWidth x maxHeight, ready to be drawn
+	barcode(payload string, maxWidth, maxHeight float64) (labelBarcode, error)
+
+	// photo loads a JPEG sized to fit maxWidth x maxHeight, or returns false when it cannot be printed
+	photo(jpeg []byte, maxWidth, maxHeight float64) (labelPhoto, bool)
+
+	startPage()
+	text(x, y, width, size float64, bold bool, text string)
+	image(path string, x, y, width, height float64)
+	pageCount() int
+	write(w io.Writer) error
+}
+
+// labelBarcode is a barcode prepared by a renderer, drawn once its page is started
+type labelBarcode struct {
+	height float64
+	draw   func(x, y float64)
+}
+
+// labelPhoto is a photo prepared by a renderer, drawn once its page is started
+type labelPhoto struct {
+	width float64
+	draw  func(x, y float64)
+}
+
+// requireLabelSheet resolves the symbology, template, output format and logo of a barcode
+// request and starts its sheet, writing 400 when any of them is unknown
+func requireLabelSheet(c *gin.Context, companyID int, opts LabelOptions, fallback string) (*labelSheet, bool) {
+	format, dpi, err := resolveLabelFormat(opts.Format, opts.DPI)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return nil, false
+	}
+	symName, sym, ok := requireSymbology(c, companyID, opts.Symbology)
+	if !ok {
+		return nil, false
+	}
+	tpl, ok := requireLabelTemplate(c, companyID, opts.TemplateID, fallback)
+	if !ok {
+		return nil, false
+	}
+	return newLabelSheet(format, dpi, tpl, symName, sym, labelLogo(companyID)), true
+}
+
+// resolveLabelSheet starts the sheet of label options checked earlier by requireLabelSheet,
+// for work done outside the request
+func resolveLabelSheet(companyID int, opts LabelOptions, fallback string) (*labelSheet, error) {
+	format, dpi, err := resolveLabelFormat(opts.Format, opts.DPI)
+	if err != nil {
+		return nil, err
+	}
+	symName, sym, err := resolveSymbology(companyID, opts.Symbology)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := resolveLabelTemplate(companyID, opts.TemplateID, fallback)
+	if err != nil {
+		return nil, err
+	}
+	return newLabelSheet(format, dpi, tpl, symName, sym, labelLogo(companyID)), nil
+}
+
+// labelLogo returns the company's label logo file, or "" when none is set or it cannot be
+// looked up; labels are still printed, just without the logo
+func labelLogo(companyID int) string {
+	logo, err := labelLogoPath(companyID)
+	if err != nil {
+		log.Printf("Error fetching label logo: %v", err)
+	}
+	return logo
+}
+
+// newLabelSheet starts a sheet for a template. A logo that cannot be read is left off.
+func newLabelSheet(format string, dpi int, tpl LabelTemplate, symName string, sym barcodeSymbology, logoPath string) *labelSheet {
+	s := &labelSheet{format: format, dpi: dpi, tpl: tpl, symName: symName, sym: sym, slot: tpl.Rows * tpl.Columns}
+	if format == labelFormatPDF {
+		s.r = newPDFLabelRenderer(tpl, sym)
+	} else {
+		s.r = newPrinterLabelRenderer(printerLanguages[format], dpi, tpl, symName, sym)
+	}
+	if logoPath != "" && tpl.LogoPosition != labelLogoNone {
+		if width, ok := s.r.logoWidth(logoPath, tpl.LogoHeight); ok {
+			s.logo, s.logoWidth = logoPath, width
+		}
+	}
+	// Like logos, photos are only printed in PDF sheets; thermal printers get the full width
+	s.photos = format == labelFormatPDF && (tpl.PhotoPosition == labelPhotoLeft || tpl.PhotoPosition == labelPhotoRight)
+	return s
+}
+
+// Next starts an empty sheet with the same output format, template, symbology and logo
+func (s *labelSheet) Next() *labelSheet {
+	return newLabelSheet(s.format, s.dpi, s.tpl, s.symName, s.sym, s.logo)
+}
+
+// SetHeader sets the page header printed on the following pages, if the template has one
+func (s *labelSheet) SetHeader(header string) {
+	s.header = header
+}
+
+// BreakPage makes the next label start a new page
+func (s *labelSheet) BreakPage() {
+	s.slot = s.tpl.Rows * s.tpl.Columns
+}
+
+// PageCount returns the number of pages started so far
+func (s *labelSheet) PageCount() int {
+	return s.r.pageCount()
+}
+
+// startPage adds a page and prints its header
+func (s *labelSheet) startPage() {
+	s.r.startPage()
+	s.slot = 0
+	if s.tpl.HeaderFontSize > 0 && s.header != "" {
+		lineHeight := fontLineHeight(s.tpl.HeaderFontSize)
+		s.r.text(s.tpl.MarginLeft, (s.tpl.MarginTop-lineHeight)/2, s.tpl.PageWidth-2*s.tpl.MarginLeft,
+			s.tpl.HeaderFontSize, true, fmt.Sprintf("%s (Page %d of %s)", s.header, s.PageCount(), pageCountAlias))
+	}
+}
+
+// Add prints an asset's label in the next free slot. Assets whose barcode does not fit
+// the label are not printed and do not use up a slot. When the template has a photo
+// column, it is kept free on labels of assets without a primary photo.
+func (s *labelSheet) Add(asset Asset) error {
+	t := s.tpl
+	innerWidth, innerHeight := t.LabelWidth-2*t.Padding, t.LabelHeight-2*t.Padding
+	if s.photos {
+		innerWidth -= t.PhotoWidth + 1
+	}
+	lineHeight := fontLineHeight(t.FieldFontSize)
+	textHeight := float64(len(t.Fields)) * lineHeight
+	if textHeight > 0 {
+		textHeight++
+	}
+	logoHeight := 0.0
+	if s.logo != "" {
+		logoHeight = t.LogoHeight + 1
+	}
+
+	code, err := s.r.barcode(s.sym.Payload(asset), innerWidth, innerHeight-logoHeight-textHeight)
+	if err != nil {
+		return err
+	}
+	var photo labelPhoto
+	hasPhoto := false
+	if s.photos {
+		photo, hasPhoto = s.loadPhoto(asset, innerHeight)
+	}
+
+	if s.slot >= t.Rows*t.Columns {
+		s.startPage()
+	}
+	row, col := s.slot/t.Columns, s.slot%t.Columns
+	x := t.MarginLeft + float64(col)*(t.LabelWidth+t.ColumnGap) + t.Padding
+	y := t.MarginTop + float64(row)*(t.LabelHeight+t.RowGap) + t.Padding
+
+	if s.photos {
+		photoX := x
+		if t.PhotoPosition == labelPhotoLeft {
+			x += t.PhotoWidth + 1
+		} else {
+			photoX = x + innerWidth + 1
+		}
+		if hasPhoto {
+			// Centre the photo in its column
+			photo.draw(photoX+(t.PhotoWidth-photo.width)/2, y)
+		}
+	}
+
+	if s.logo != "" {
+		logoX := x
+		if t.LogoPosition == labelLogoTopRight {
+			logoX = x + innerWidth - s.logoWidth
+		}
+		s.r.image(s.logo, logoX, y, s.logoWidth, t.LogoHeight)
+		y += logoHeight
+	}
+
+	code.draw(x, y)
+	y += code.height + 1
+
+	for _, key := range t.Fields {
+		field := labelFields[key]
+		text := field.Caption + ": " + field.Value(asset)
+		if len(t.Fields) == 1 {
+			text = field.Value(asset)
+		}
+		s.r.text(x, y, innerWidth, t.FieldFontSize, false, text)
+		y += lineHeight
+	}
+
+	s.slot++
+	return nil
+}
+
+// loadPhoto prepares the thumbnail of an asset's primary photo for the photo column. A
+// photo that cannot be loaded is logged and left off the label.
+func (s *labelSheet) loadPhoto(asset Asset, height float64) (labelPhoto, bool) {
+	data, err := assetPhotoThumbnail(asset)
+	if err != nil {
+		log.Printf("Error loading photo of asset %d: %v", asset.ID, err)
+		return labelPhoto{}, false
+	}
+	if data == nil {
+		return labelPhoto{}, false
+	}
+	return s.r.photo(data, s.tpl.PhotoWidth, height)
+}
+
+// AddAll prints the labels of several assets and reports those left out
+func (s *labelSheet) AddAll(assets []Asset) []gin.H {
+	skipped := []gin.H{}
+	for _, asset := range assets {
+		if err := s.Add(asset); err != nil {
+			log.Printf("Error creating %s barcode for asset %d: %v", s.symName, asset.ID, err)
+			skipped = append(skipped, skippedBarcode(asset, err))
+		}
+	}
+	return skipped
+}
+
+// AddSectionPage adds a page with a title and lines of text outside the label grid;
+// the following labels start on a new page. Lines that run off the page are dropped.
+func (s *labelSheet) AddSectionPage(title string, lines []string) {
+	s.r.startPage()
+	margin := math.Max(s.tpl.MarginLeft, 5)
+	width := s.tpl.PageWidth - 2*margin
+	s.r.text(margin, margin, width, 18, true, title)
+	y := margin + fontLineHeight(18) + 5
+	for _, line := range lines {
+		if y+fontLineHeight(10) > s.tpl.PageHeight {
+			break
+		}
+		s.r.text(margin, y, width, 10, false, line)
+		y += fontLineHeight(10)
+	}
+	s.BreakPage()
+}
+
+// Write writes the finished sheet
+func (s *labelSheet) Write(w io.Writer) error {
+	return s.r.write(w)
+}
+
+// pdfLabelRenderer draws label sheets as a PDF document
+type pdfLabelRenderer struct {
+	pdf    *gofpdf.Fpdf
+	sym    barcodeSymbology
+	images int
+}
+
+// newPDFLabelRenderer starts a PDF with the page size of a template
+func newPDFLabelRenderer(tpl LabelTemplate, sym barcodeSymbology) *pdfLabelRenderer {
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		UnitStr: "mm",
+		Size:    gofpdf.SizeType{Wd: tpl.PageWidth, Ht: tpl.PageHeight},
+	})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AliasNbPages(pageCountAlias)
+	return &pdfLabelRenderer{pdf: pdf, sym: sym}
+}
+
+func (r *pdfLabelRenderer) logoWidth(path string, height float64) (float64, bool) {
+	info := r.pdf.RegisterImageOptions(path, gofpdf.ImageOptions{ReadDpi: true})
+	if r.pdf.Ok() && info != nil && info.Height() > 0 {
+		return height * info.Width() / info.Height(), true
+	}
+	log.Printf("Error loading label logo %s: %v", path, r.pdf.Error())
+	r.pdf.ClearError()
+	return 0, false
+}
+
+// barcode registers the barcode image before its page is started, so a payload that
+// cannot be printed does not leave an empty page behind
+func (r *pdfLabelRenderer) barcode(payload string, maxWidth, maxHeight float64) (labelBarcode, error) {
+	img, err := r.sym.render(payload, maxWidth, maxHeight)
+	if err != nil {
+		return labelBarcode{}, err
+	}
+
+	r.images++
+	name := fmt.Sprintf("barcode_%d", r.images)
+	options := gofpdf.ImageOptions{ImageType: "PNG"}
+	r.pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(img.PNG))
+	if err := r.pdf.Error(); err != nil {
+		r.pdf.ClearError()
+		return labelBarcode{}, err
+	}
+	return labelBarcode{
+		height: img.Height,
+		draw: func(x, y float64) {
+			r.pdf.ImageOptions(name, x, y, img.Width, img.Height, false, options, 0, "")
+		},
+	}, nil
+}
+
+// photo registers a thumbnail under a name of its own, as each asset has a different one
+func (r *pdfLabelRenderer) photo(jpeg []byte, maxWidth, maxHeight float64) (labelPhoto, bool) {
+	r.images++
+	name := fmt.Sprintf("photo_%d", r.images)
+	options := gofpdf.ImageOptions{ImageType: "JPG"}
+	info := r.pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(jpeg))
+	if !r.pdf.Ok() || info == nil || info.Width() <= 0 || info.Height() <= 0 {
+		log.Printf("Error loading label photo: %v", r.pdf.Error())
+		r.pdf.ClearError()
+		return labelPhoto{}, false
+	}
+	width, height := maxWidth, maxWidth*info.Height()/info.Width()
+	if height > maxHeight {
+		width, height = maxHeight*info.Width()/info.Height(), maxHeight
+	}
+	return labelPhoto{
+		width: width,
+		draw: func(x, y float64) {
+			r.pdf.ImageOptions(name, x, y, width, height, false, options, 0, "")
+		},
+	}, true
+}
+
+func (r *pdfLabelRenderer) startPage() {
+	r.pdf.AddPage()
+}
+
+func (r *pdfLabelRenderer) text(x, y, width, size float64, bold bool, text string) {
+	style := ""
+	if bold {
+		style = "B"
+	}
+	r.pdf.SetFont("Arial", style, size)
+	r.pdf.SetXY(x, y)
+	r.pdf.CellFormat(width, fontLineHeight(size), fitText(r.pdf, text, width), "", 0, "L", false, 0, "")
+}
+
+func (r *pdfLabelRenderer) image(path string, x, y, width, height float64) {
+	r.pdf.ImageOptions(path, x, y, width, height, false, gofpdf.ImageOptions{}, 0, "")
+}
+
+func (r *pdfLabelRenderer) pageCount() int {
+	return r.pdf.PageCount()
+}
+
+func (r *pdfLabelRenderer) write(w io.Writer) error {
+	return r.pdf.Output(w)
+}
+
+// fitText shortens text until it fits a width in the current font
+func fitText(pdf *gofpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.GetStringWidth(string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// labelTemplateFromRequest binds and validates a template body
+func labelTemplateFromRequest(c *gin.Context) (LabelTemplate, bool) {
+	var t LabelTemplate
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return t, false
+	}
+	if t.Fields == nil {
+		t.Fields = []string{}
+	}
+	if err := validateLabelTemplate(&t); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return t, false
+	}
+	return t, true
+}
+
+// labelTemplateID reads the :id parameter of a stored template
+func labelTemplateID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid label template ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// labelTemplateError writes the response for a failed template operation
+func labelTemplateError(c *gin.Context, err error, action string) {
+	if err == sql.ErrNoRows || err == errUnknownLabelTemplate {
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Label template not found",
+		})
+		return
+	}
+	log.Printf("Error %s label template: %v", action, err)
+	c.JSON(http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Error:   "Internal Server Error",
+	})
+}
+
+// getLabelTemplatesHandler lists the company's stored templates and the built-in presets
+func getLabelTemplatesHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT %s FROM label_templates WHERE company_id = ? ORDER BY name", labelTemplateColumns),
+		companyID)
+	if err != nil {
+		labelTemplateError(c, err, "listing")
+		return
+	}
+	defer rows.Close()
+
+	templates := []LabelTemplate{}
+	for rows.Next() {
+		t, err := scanLabelTemplate(rows)
+		if err != nil {
+			labelTemplateError(c, err, "listing")
+			return
+		}
+		templates = append(templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		labelTemplateError(c, err, "listing")
+		return
+	}
+
+	keys := make([]string, 0, len(labelTemplatePresets))
+	for key := range labelTemplatePresets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	presets := make([]LabelTemplate, 0, len(keys))
+	for _, key := range keys {
+		preset, _ := findLabelTemplate(companyID, key)
+		presets = append(presets, preset)
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data: gin.H{
+			"templates": templates,
+			"presets":   presets,
+		},
+	})
+}
+
+// getLabelTemplateHandler returns a stored template or, for a preset key, a preset
+func getLabelTemplateHandler(c *gin.Context) {
+	t, err := findLabelTemplate(getCurrentCompanyID(c), c.Param("id"))
+	if err != nil {
+		labelTemplateError(c, err, "fetching")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    t,
+	})
+}
+
+// createLabelTemplateHandler stores a new template for the company
+func createLabelTemplateHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	t, ok := labelTemplateFromRequest(c)
+	if !ok {
+		return
+	}
+
+	fields, _ := json.Marshal(t.Fields)
+	result, err := db.Exec(`
+		INSERT INTO label_templates (company_id, name, page_width, page_height, grid_rows, grid_columns,
+		margin_top, margin_left, column_gap, row_gap, label_width, label_height, padding, fields,
+		header_font_size, field_font_size, logo_position, logo_height, photo_position, photo_width)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		companyID, t.Name, t.PageWidth, t.PageHeight, t.Rows, t.Columns, t.MarginTop, t.MarginLeft,
+		t.ColumnGap, t.RowGap, t.LabelWidth, t.LabelHeight, t.Padding, fields, t.HeaderFontSize,
+		t.FieldFontSize, t.LogoPosition, t.LogoHeight, t.PhotoPosition, t.PhotoWidth)
+	if err != nil {
+		labelTemplateError(c, err, "creating")
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	created, err := getLabelTemplate(companyID, int(id))
+	if err != nil {
+		labelTemplateError(c, err, "fetching")
+		return
+	}
+
+	c.JSON(http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Label template created successfully",
+		Data:    created,
+	})
+}
+
+// updateLabelTemplateHandler replaces a stored template
+func updateLabelTemplateHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	id, ok := labelTemplateID(c)
+	if !ok {
+		return
+	}
+	t, ok := labelTemplateFromRequest(c)
+	if !ok {
+		return
+	}
+
+	fields, _ := json.Marshal(t.Fields)
+	result, err := db.Exec(`
+		UPDATE label_templates SET name = ?, page_width = ?, page_height = ?, grid_rows = ?, grid_columns = ?,
+		margin_top = ?, margin_left = ?, column_gap = ?, row_gap = ?, label_width = ?, label_height = ?,
+		padding = ?, fields = ?, header_font_size = ?, field_font_size = ?, logo_position = ?, logo_height = ?,
+		photo_position = ?, photo_width = ?
+		WHERE id = ? AND company_id = ?`,
+		t.Name, t.PageWidth, t.PageHeight, t.Rows, t.Columns, t.MarginTop, t.MarginLeft, t.ColumnGap,
+		t.RowGap, t.LabelWidth, t.LabelHeight, t.Padding, fields, t.HeaderFontSize, t.FieldFontSize,
+		t.LogoPosition, t.LogoHeight, t.PhotoPosition, t.PhotoWidth, id, companyID)
+	if err != nil {
+		labelTemplateError(c, err, "updating")
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		if _, err := getLabelTemplate(companyID, id); err != nil {
+			labelTemplateError(c, err, "updating")
+			return
+		}
+	}
+
+	updated, err := getLabelTemplate(companyID, id)
+	if err != nil {
+		labelTemplateError(c, err, "fetching")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Label template updated successfully",
+		Data:    updated,
+	})
+}
+
+// deleteLabelTemplateHandler deletes a stored template, clearing it as the company default
+func deleteLabelTemplateHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	id, ok := labelTemplateID(c)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		labelTemplateError(c, err, "deleting")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM label_templates WHERE id = ? AND company_id = ?", id, companyID)
+	if err == nil {
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			err = sql.ErrNoRows
+		}
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM company_settings WHERE company_id = ? AND setting_key = ? AND setting_value = ?",
+			companyID, labelTemplateSetting, strconv.Itoa(id))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		labelTemplateError(c, err, "deleting")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Label template deleted successfully",
+	})
+}
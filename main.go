@@ -28,6 +28,11 @@ func main() {
 		port = "5000"
 	}
 
+	// Refuse to start without a token signing key
+	if err := loadJWTKeys(); err != nil {
+		log.Fatal("Failed to load JWT signing keys: ", err)
+	}
+
 	// Database connection
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:3306)/%s?parseTime=true", username, password, host, database)
 	var err error
@@ -45,6 +50,20 @@ func main() {
 
 	log.Println("Connected to database successfully")
 
+	// Store generated files and delete them once they expire
+	if err := startArtifactStorage(); err != nil {
+		log.Fatal("Failed to set up artifact storage:", err)
+	}
+
+	// Permanently delete trashed assets past their retention period, with their attachments
+	startTrashPurger()
+
+	// Run queued label and report jobs in the background
+	startJobWorkers()
+
+	// Delete sessions that expired or were revoked long ago
+	startSessionCleanup()
+
 	// Initialize Gin router
 	r := gin.Default()
 
@@ -52,8 +71,10 @@ func main() {
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Request-ID"}
+	config.ExposeHeaders = []string{"X-Request-ID", "Content-Disposition", "X-Skipped-Assets", "Location"}
 	r.Use(cors.New(config))
+	r.Use(requestIDMiddleware())
 
 	// Serve static files
 	r.Static("/files", "./files")
@@ -67,107 +88,30 @@ func main() {
 		})
 	})
 
-	// Database test endpoint
-	r.GET("/api/db-test", func(c *gin.Context) {
-		// Check if database connection is available
-		if db == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Database connection not available",
-			})
-			return
-		}
-
-		// Test database connection
-		err := db.Ping()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Database connection failed: " + err.Error(),
-			})
-			return
-		}
-
-		// Check if assets table exists
-		var tableExists int
-		err = db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'assets'").Scan(&tableExists)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to check table existence: " + err.Error(),
-			})
-			return
-		}
-
-		if tableExists == 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Assets table does not exist",
-			})
-			return
-		}
-
-		// Check table structure
-		rows, err := db.Query("DESCRIBE assets")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to describe table: " + err.Error(),
-			})
-			return
-		}
-		defer rows.Close()
-
-		var columns []gin.H
-		for rows.Next() {
-			var field, typ, null, key, defaultVal, extra sql.NullString
-			err := rows.Scan(&field, &typ, &null, &key, &defaultVal, &extra)
-			if err != nil {
-				continue
-			}
-			columns = append(columns, gin.H{
-				"field":   field.String,
-				"type":    typ.String,
-				"null":    null.String,
-				"key":     key.String,
-				"default": defaultVal.String,
-				"extra":   extra.String,
-			})
-		}
-
-		// Count total assets
-		var assetCount int
-		err = db.QueryRow("SELECT COUNT(*) FROM assets").Scan(&assetCount)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to count assets: " + err.Error(),
-			})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"status":       "Database connected",
-			"table_exists": tableExists > 0,
-			"asset_count":  assetCount,
-			"columns":      columns,
-		})
-	})
+	// Public keys for verifying access tokens (RS256 and EdDSA only)
+	r.GET("/.well-known/jwks.json", jwksHandler)
 
 	// Public routes (no authentication required)
 	public := r.Group("/api")
 	{
 		public.POST("/login", loginHandler)
+		public.POST("/login/2fa", loginTwoFactorHandler)
+		public.POST("/login/sso", ssoTicketHandler)
+		public.GET("/sso/:company_code/login", ssoLoginHandler)
+		public.GET("/sso/callback", ssoCallbackHandler)
+		public.POST("/refresh", refreshHandler)
 		public.POST("/register/company", registerCompanyHandler)
 		public.POST("/companies", createCompanyHandler) // New company creation endpoint
-		
-		// Reference data (public access)
-		public.GET("/categories", getCategoriesHandler)
-		public.GET("/institutions", getInstitutionsHandler)
-		public.GET("/departments", getDepartmentsHandler)
-		public.GET("/functional-areas", getFunctionalAreasHandler)
-		public.GET("/manufacturers", getManufacturersHandler)
+		public.GET("/artifacts/:id/download", downloadArtifactHandler) // Signed, expiring link
+		public.GET("/attachments/:id/download", linkedAttachmentHandler(false))
+		public.GET("/attachments/:id/thumbnail", linkedAttachmentHandler(true))
 	}
 
 	// Backward-compatible alias (legacy clients hitting /create-account)
 	r.POST("/create-account", registerCompanyHandler)
 
 	// Legacy endpoints (public access for frontend compatibility)
-	r.POST("/addAsset", authMiddleware(), checkTrialStatusMiddleware(), addAssetHandler) // Legacy endpoint with auth and trial check
+	r.POST("/addAsset", authMiddleware(), checkTrialStatusMiddleware(), requirePermission(permAssetsWrite), addAssetHandler) // Legacy endpoint with auth and trial check
 
 	// Protected routes (authentication required)
 	protected := r.Group("/api")
@@ -181,58 +125,147 @@ func main() {
 
 		// Company management
 		protected.GET("/company", getCompanyHandler)
-		protected.PUT("/company", updateCompanyHandler)
-		protected.GET("/companies", listCompaniesHandler) // Admin only
-
-		// User management (admin only)
+		protected.PUT("/company", requirePermission(permCompanyManage), updateCompanyHandler)
+		protected.GET("/companies", requirePermission(permCompanyManage), listCompaniesHandler)
+		protected.GET("/company/settings", getCompanySettingsHandler)
+		protected.PUT("/company/settings", requirePermission(permCompanyManage), updateCompanySettingsHandler)
+		protected.GET("/company/sso", requirePermission(permCompanyManage), getCompanySSOHandler)
+		protected.PUT("/company/sso", requirePermission(permCompanyManage), updateCompanySSOHandler)
+		protected.DELETE("/company/sso", requirePermission(permCompanyManage), deleteCompanySSOHandler)
+
+		// Reference data (scoped to the caller's company)
+		protected.GET("/categories", getCategoriesHandler)
+		protected.GET("/institutions", getInstitutionsHandler)
+		protected.GET("/departments", getDepartmentsHandler)
+		protected.GET("/functional-areas", getFunctionalAreasHandler)
+		protected.GET("/manufacturers", getManufacturersHandler)
+
+		// Permissions and roles
+		protected.GET("/permissions", getPermissionsHandler)
+		protected.GET("/roles", getRolesHandler)
+		protected.POST("/roles", requirePermission(permRolesManage), createRoleHandler)
+		protected.PUT("/roles/:name", requirePermission(permRolesManage), updateRoleHandler)
+		protected.DELETE("/roles/:name", requirePermission(permRolesManage), deleteRoleHandler)
+
+		// User management
 		userRoutes := protected.Group("")
-		userRoutes.Use(adminMiddleware())
+		userRoutes.Use(requirePermission(permUsersManage))
 		{
 			userRoutes.GET("/users", getUsersHandler)
 			userRoutes.GET("/users/:id", getUserHandler)
 			userRoutes.POST("/users", addUserHandler)
 			userRoutes.PUT("/users/:id", updateUserHandler)
 			userRoutes.DELETE("/users/:id", deleteUserHandler)
+			userRoutes.DELETE("/users/:id/sessions", revokeUserSessionsHandler)
+			userRoutes.DELETE("/users/:id/2fa", resetUserTwoFactorHandler)
+			userRoutes.POST("/users/:id/2fa/enrollment-code", issueEnrollmentCodeHandler)
 		}
 
 		// Asset management (requires active trial/subscription)
 		assetRoutes := protected.Group("")
-		assetRoutes.Use(checkTrialStatusMiddleware())
+		assetRoutes.Use(checkTrialStatusMiddleware(), requirePermission(permAssetsRead))
 		{
 			assetRoutes.GET("/assets", getAssetsHandler)
+			assetRoutes.GET("/assets/trash", getTrashHandler)
+			assetRoutes.GET("/assets/mine", getMyAssetsHandler)
 			assetRoutes.GET("/assets/:id", getAssetDetailsHandler)
-			assetRoutes.POST("/assets", addAssetHandler)
-			assetRoutes.POST("/assets/multiple", addMultipleAssetsHandler)
-			assetRoutes.PUT("/assets/:id", updateAssetHandler)
-			assetRoutes.DELETE("/assets/:id", deleteAssetHandler)
+			assetRoutes.GET("/assets/:id/history", getAssetHistoryHandler)
+			assetRoutes.GET("/assets/:id/assignments", getAssetAssignmentsHandler)
+			assetRoutes.GET("/assets/:id/scans", getAssetScansHandler)
+			assetRoutes.GET("/assets/:id/depreciation", getAssetDepreciationHandler)
+			assetRoutes.PUT("/assets/:id/depreciation", requirePermission(permAssetsWrite), setAssetDepreciationHandler)
+			assetRoutes.POST("/assets/:id/checkout", requirePermission(permAssetsWrite), checkOutAssetHandler)
+			assetRoutes.POST("/assets/:id/checkin", requirePermission(permAssetsWrite), checkInAssetHandler)
+			assetRoutes.GET("/assets/:id/attachments", getAttachmentsHandler)
+			assetRoutes.POST("/assets/:id/attachments", requirePermission(permAssetsWrite), uploadAttachmentHandler)
+			assetRoutes.GET("/assets/:id/attachments/:attachmentId/download", downloadAttachmentHandler)
+			assetRoutes.DELETE("/assets/:id/attachments/:attachmentId", requirePermission(permAssetsWrite), deleteAttachmentHandler)
+			assetRoutes.PUT("/assets/:id/photo", requirePermission(permAssetsWrite), setPrimaryPhotoHandler)
+			assetRoutes.GET("/assignments/overdue", getOverdueAssignmentsHandler)
+			assetRoutes.GET("/scan/*code", scanAssetHandler)
+			assetRoutes.POST("/assets", requirePermission(permAssetsWrite), addAssetHandler)
+			assetRoutes.POST("/assets/multiple", requirePermission(permAssetsWrite), addMultipleAssetsHandler)
+			assetRoutes.PUT("/assets/:id", requirePermission(permAssetsWrite), updateAssetHandler)
+			assetRoutes.DELETE("/assets/:id", requirePermission(permAssetsDelete), deleteAssetHandler)
+			assetRoutes.POST("/assets/:id/restore", requirePermission(permAssetsWrite), restoreAssetHandler)
+			assetRoutes.POST("/assets/tags", requirePermission(permAssetsTag), assignAssetTagsHandler)
+			assetRoutes.DELETE("/assets/:id/purge", requirePermission(permAssetsPurge), purgeAssetHandler)
 			assetRoutes.POST("/assets/search", searchAssetsHandler)
+			assetRoutes.POST("/assets/import/preview", requirePermission(permAssetsWrite), previewImportHandler)
+			assetRoutes.POST("/assets/import", requirePermission(permAssetsWrite), importAssetsHandler)
 
 			// Asset categories (protected - for management)
-			assetRoutes.POST("/categories", addCategoryHandler)
-			assetRoutes.PUT("/categories/:id", updateCategoryHandler)
-			assetRoutes.DELETE("/categories/:id", deleteCategoryHandler)
+			assetRoutes.POST("/categories", requirePermission(permCategoriesManage), addCategoryHandler)
+			assetRoutes.PUT("/categories/:id", requirePermission(permCategoriesManage), updateCategoryHandler)
+			assetRoutes.DELETE("/categories/:id", requirePermission(permCategoriesManage), deleteCategoryHandler)
+
+			// Custom asset fields
+			assetRoutes.GET("/custom-fields", getCustomFieldsHandler)
+			assetRoutes.POST("/custom-fields", requirePermission(permCustomFieldsManage), createCustomFieldHandler)
+			assetRoutes.PUT("/custom-fields/:id", requirePermission(permCustomFieldsManage), updateCustomFieldHandler)
+			assetRoutes.DELETE("/custom-fields/:id", requirePermission(permCustomFieldsManage), deleteCustomFieldHandler)
+
+			// Maintenance work orders
+			assetRoutes.GET("/maintenance", listMaintenanceHandler)
+			assetRoutes.POST("/maintenance", requirePermission(permMaintenanceWrite), createMaintenanceHandler)
+			assetRoutes.GET("/maintenance/overdue", getOverdueMaintenanceHandler)
+			assetRoutes.GET("/maintenance/upcoming", getUpcomingMaintenanceHandler)
+			assetRoutes.GET("/maintenance/costs", getMaintenanceCostsHandler)
+			assetRoutes.GET("/maintenance/:id", getMaintenanceHandler)
+			assetRoutes.PUT("/maintenance/:id", requirePermission(permMaintenanceWrite), updateMaintenanceHandler)
+			assetRoutes.DELETE("/maintenance/:id", requirePermission(permMaintenanceWrite), deleteMaintenanceHandler)
+			assetRoutes.POST("/maintenance/:id/complete", requirePermission(permMaintenanceWrite), completeMaintenanceHandler)
+			assetRoutes.POST("/maintenance/:id/cancel", requirePermission(permMaintenanceWrite), cancelMaintenanceHandler)
+
+			// Label templates
+			assetRoutes.GET("/label-templates", getLabelTemplatesHandler)
+			assetRoutes.GET("/label-templates/:id", getLabelTemplateHandler)
+			assetRoutes.POST("/label-templates", requirePermission(permLabelsManage), createLabelTemplateHandler)
+			assetRoutes.PUT("/label-templates/:id", requirePermission(permLabelsManage), updateLabelTemplateHandler)
+			assetRoutes.DELETE("/label-templates/:id", requirePermission(permLabelsManage), deleteLabelTemplateHandler)
 
 			// Barcode generation
-			assetRoutes.POST("/barcodes", generateBarcodesHandler)
-			assetRoutes.POST("/barcodes/institution", generateBarcodesByInstitutionHandler)
-			assetRoutes.POST("/barcodes/institution-department", generateBarcodesByInstitutionAndDepartmentHandler)
-			assetRoutes.POST("/barcodes/all-institutions", generateBarcodesForAllInstitutionsHandler) // Heavy load testing
+			assetRoutes.POST("/barcodes", requirePermission(permBarcodesPrint), generateBarcodesHandler)
+			assetRoutes.POST("/barcodes/institution", requirePermission(permBarcodesPrint), generateBarcodesByInstitutionHandler)
+			assetRoutes.POST("/barcodes/institution-department", requirePermission(permBarcodesPrint), generateBarcodesByInstitutionAndDepartmentHandler)
+			assetRoutes.POST("/barcodes/all-institutions", requirePermission(permBarcodesPrint), generateBarcodesForAllInstitutionsHandler) // Streams a PDF or ZIP
 
 			// Reports
-			assetRoutes.POST("/reports", generateReportHandler)
-			assetRoutes.GET("/generateReport", generateReportHandler) // Legacy GET endpoint
-			assetRoutes.POST("/fetchAssetsByInstitution", fetchAssetsByInstitutionHandler) // For Excel reports
-			assetRoutes.POST("/reports/assets", generateAssetReportHandler)
-			assetRoutes.POST("/reports/invoice", generateInvoiceHandler)
-			assetRoutes.GET("/reports/download/:filename", downloadHandler)
+			assetRoutes.POST("/reports", requirePermission(permReportsExport), generateReportHandler)
+			assetRoutes.GET("/generateReport", requirePermission(permReportsExport), generateReportHandler) // Legacy GET endpoint
+			assetRoutes.POST("/fetchAssetsByInstitution", requirePermission(permReportsExport), fetchAssetsByInstitutionHandler) // For Excel reports
+			assetRoutes.POST("/reports/assets", requirePermission(permReportsExport), generateAssetReportHandler)
+			assetRoutes.POST("/reports/invoice", requirePermission(permReportsExport), generateInvoiceHandler)
+			assetRoutes.GET("/reports/export", requirePermission(permReportsExport), exportAssetsHandler)
+			assetRoutes.POST("/reports/export", requirePermission(permReportsExport), exportAssetsHandler)
+			assetRoutes.GET("/reports/depreciation", requirePermission(permReportsExport), getDepreciationRegisterHandler)
+			assetRoutes.GET("/reports/download/:id", requirePermission(permReportsExport), downloadHandler)
 
 			// Dashboard
 			assetRoutes.GET("/dashboard/stats", getDashboardStatsHandler)
 			assetRoutes.GET("/dashboard/diagnostics", getDashboardDiagnosticsHandler) // Diagnostic endpoint for debugging
 		}
 
+		// Generated files of the current company
+		protected.GET("/artifacts/:id", getArtifactHandler)
+
+		// Background jobs of the current user
+		protected.GET("/jobs", listJobsHandler)
+		protected.GET("/jobs/:id", getJobHandler)
+		protected.POST("/jobs/:id/cancel", cancelJobHandler)
+		protected.GET("/jobs/:id/download", downloadJobHandler)
+
 		// Auth
 		protected.POST("/logout", logoutHandler)
+		protected.POST("/logout-all", logoutAllHandler)
+		protected.GET("/sessions", getSessionsHandler)
+
+		// Two-factor authentication
+		protected.GET("/2fa", getTwoFactorStatusHandler)
+		protected.POST("/2fa/setup", setupTwoFactorHandler)
+		protected.POST("/2fa/enable", enableTwoFactorHandler)
+		protected.POST("/2fa/disable", disableTwoFactorHandler)
+		protected.POST("/2fa/recovery-codes", regenerateRecoveryCodesHandler)
 	}
 
 	// Start server
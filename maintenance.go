@@ -0,0 +1,808 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Work order states
+const (
+	workOrderOpen      = "open"
+	workOrderCompleted = "completed"
+	workOrderCancelled = "cancelled"
+)
+
+// assetStatusMaintenance is the asset status held while a work order is open
+const assetStatusMaintenance = "Maintenance"
+
+const defaultUpcomingMaintenanceDays = 30
+
+var (
+	errWorkOrderClosed = errors.New("work order is already closed")
+	errWorkOrderOpen   = errors.New("work order is still open")
+)
+
+// maintenanceTypes are the values allowed by asset_maintenance.maintenance_type
+var maintenanceTypes = map[string]bool{"Preventive": true, "Corrective": true, "Emergency": true}
+
+// maintenanceColumns lists the work order columns read by the store, in scan order
+const maintenanceColumns = `id, company_id, asset_id, maintenance_type, description, cost, performed_by,
+	performed_at, next_maintenance_date, created_by, created_at, status, scheduled_date, completed_at,
+	recurrence_days, previous_asset_status`
+
+// scanMaintenance reads one row selected with maintenanceColumns
+func scanMaintenance(row rowScanner) (AssetMaintenance, error) {
+	var m AssetMaintenance
+	err := row.Scan(
+		&m.ID, &m.CompanyID, &m.AssetID, &m.MaintenanceType, &m.Description, &m.Cost, &m.PerformedBy,
+		&m.PerformedAt, &m.NextMaintenanceDate, &m.CreatedBy, &m.CreatedAt, &m.Status, &m.ScheduledDate,
+		&m.CompletedAt, &m.RecurrenceDays, &m.PreviousAssetStatus)
+	return m, err
+}
+
+// MaintenanceFilter describes the optional conditions applied to work order listings
+type MaintenanceFilter struct {
+	AssetID         *int
+	Status          string
+	MaintenanceType string
+	Limit           int
+	Offset          int
+}
+
+// maintenanceStore is the tenant-scoped data access layer for maintenance work orders.
+// It shares the asset store's transactions so that asset status changes are atomic
+// with the work orders causing them and appear in the asset history.
+type maintenanceStore struct {
+	assets *sqlAssetStore
+}
+
+// requireMaintenanceStore resolves the request's maintenance store or aborts with 401
+func requireMaintenanceStore(c *gin.Context) (*maintenanceStore, bool) {
+	assets, err := sqlAssetStoreFor(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Company context required",
+		})
+		c.Abort()
+		return nil, false
+	}
+	return &maintenanceStore{assets: assets}, true
+}
+
+// List returns one page of the company's work orders, newest first, and the total count
+func (m *maintenanceStore) List(filter MaintenanceFilter) ([]AssetMaintenance, int, error) {
+	clause := "company_id = ?"
+	args := []interface{}{m.assets.companyID}
+	if filter.AssetID != nil {
+		clause += " AND asset_id = ?"
+		args = append(args, *filter.AssetID)
+	}
+	if filter.Status != "" {
+		clause += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+	if filter.MaintenanceType != "" {
+		clause += " AND maintenance_type = ?"
+		args = append(args, filter.MaintenanceType)
+	}
+
+	var total int
+	if err := m.assets.queryRow("SELECT COUNT(*) FROM asset_maintenance WHERE "+clause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM asset_maintenance WHERE %s ORDER BY performed_at DESC, id DESC", maintenanceColumns, clause)
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	rows, err := m.assets.query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	orders := []AssetMaintenance{}
+	for rows.Next() {
+		order, err := scanMaintenance(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, total, rows.Err()
+}
+
+// Get returns a work order of the company, or sql.ErrNoRows
+func (m *maintenanceStore) Get(id int) (*AssetMaintenance, error) {
+	row := m.assets.queryRow(
+		fmt.Sprintf("SELECT %s FROM asset_maintenance WHERE id = ? AND company_id = ?", maintenanceColumns),
+		id, m.assets.companyID)
+	order, err := scanMaintenance(row)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// Create opens a work order on a live asset and puts the asset into maintenance.
+// Returns sql.ErrNoRows when the asset does not exist.
+func (m *maintenanceStore) Create(order *AssetMaintenance) (int64, error) {
+	var id int64
+	err := m.assets.inTx(func(tx *sql.Tx) error {
+		asset, err := m.assets.getForUpdate(tx, order.AssetID, false)
+		if err != nil {
+			return err
+		}
+
+		// When the asset is already in maintenance, keep the status it had before the
+		// first open work order so it can be restored once all of them are closed
+		previous := asset.Status
+		if asset.Status == assetStatusMaintenance {
+			if previous, err = statusBeforeMaintenanceInTx(tx, m.assets.companyID, asset.ID); err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.Exec(`
+			INSERT INTO asset_maintenance (company_id, asset_id, maintenance_type, description, cost, performed_by,
+			next_maintenance_date, created_by, status, scheduled_date, recurrence_days, previous_asset_status)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			m.assets.companyID, asset.ID, order.MaintenanceType, order.Description, order.Cost, order.PerformedBy,
+			order.NextMaintenanceDate, m.assets.actor.UserID, workOrderOpen, order.ScheduledDate,
+			order.RecurrenceDays, previous)
+		if err != nil {
+			return err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		return m.assets.setStatusInTx(tx, asset, assetStatusMaintenance)
+	})
+	return id, err
+}
+
+// statusBeforeMaintenanceInTx returns the status an asset in maintenance had before its
+// first open work order, or "Active" when that is not known
+func statusBeforeMaintenanceInTx(tx *sql.Tx, companyID, assetID int) (string, error) {
+	var previous string
+	err := tx.QueryRow(`
+		SELECT previous_asset_status FROM asset_maintenance
+		WHERE company_id = ? AND asset_id = ? AND status = ? AND previous_asset_status IS NOT NULL
+		ORDER BY id LIMIT 1`, companyID, assetID, workOrderOpen).Scan(&previous)
+	if err == sql.ErrNoRows || (err == nil && (previous == "" || previous == assetStatusMaintenance)) {
+		return "Active", nil
+	}
+	return previous, err
+}
+
+// Update edits the descriptive fields of a work order, or returns sql.ErrNoRows
+func (m *maintenanceStore) Update(order *AssetMaintenance) error {
+	result, err := m.assets.exec(`
+		UPDATE asset_maintenance SET maintenance_type = ?, description = ?, cost = ?, performed_by = ?,
+		scheduled_date = ?, next_maintenance_date = ?, recurrence_days = ?
+		WHERE id = ? AND company_id = ?`,
+		order.MaintenanceType, order.Description, order.Cost, order.PerformedBy, order.ScheduledDate,
+		order.NextMaintenanceDate, order.RecurrenceDays, order.ID, m.assets.companyID)
+	if err != nil {
+		return err
+	}
+	// MySQL reports 0 affected rows for unchanged values, so check existence separately
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		_, err := m.Get(order.ID)
+		return err
+	}
+	return nil
+}
+
+// Close completes or cancels an open work order. Completing a recurring work order
+// schedules the next one. When the asset's last open work order closes, the asset
+// returns to the status it had before maintenance. Returns sql.ErrNoRows when the work
+// order or its asset does not exist, including when the asset is in the trash.
+func (m *maintenanceStore) Close(id int, state string, completion CompleteMaintenanceRequest, next *time.Time) error {
+	return m.assets.inTx(func(tx *sql.Tx) error {
+		// Lock the asset before the work order, in the same order as Create
+		var assetID int
+		err := tx.QueryRow("SELECT asset_id FROM asset_maintenance WHERE id = ? AND company_id = ?",
+			id, m.assets.companyID).Scan(&assetID)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRow("SELECT id FROM assets WHERE id = ? AND company_id = ? AND deleted_at IS NULL FOR UPDATE",
+			assetID, m.assets.companyID).Scan(&assetID)
+		if err != nil {
+			return err
+		}
+		order, err := scanMaintenance(tx.QueryRow(
+			fmt.Sprintf("SELECT %s FROM asset_maintenance WHERE id = ? AND company_id = ? FOR UPDATE", maintenanceColumns),
+			id, m.assets.companyID))
+		if err != nil {
+			return err
+		}
+		if order.Status != workOrderOpen {
+			return errWorkOrderClosed
+		}
+
+		now := time.Now()
+		if state == workOrderCompleted {
+			if completion.Cost != nil {
+				order.Cost = completion.Cost
+			}
+			if completion.PerformedBy != "" {
+				order.PerformedBy = &completion.PerformedBy
+			}
+			if next == nil && order.RecurrenceDays != nil && *order.RecurrenceDays > 0 {
+				due := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, *order.RecurrenceDays)
+				next = &due
+			}
+			if next != nil {
+				order.NextMaintenanceDate = next
+			}
+			_, err = tx.Exec(`
+				UPDATE asset_maintenance SET status = ?, completed_at = ?, performed_at = ?, cost = ?,
+				performed_by = ?, next_maintenance_date = ?
+				WHERE id = ? AND company_id = ?`,
+				workOrderCompleted, now, now, order.Cost, order.PerformedBy, order.NextMaintenanceDate,
+				id, m.assets.companyID)
+		} else {
+			_, err = tx.Exec("UPDATE asset_maintenance SET status = ?, completed_at = ? WHERE id = ? AND company_id = ?",
+				workOrderCancelled, now, id, m.assets.companyID)
+		}
+		if err != nil {
+			return err
+		}
+
+		var stillOpen int
+		err = tx.QueryRow("SELECT COUNT(*) FROM asset_maintenance WHERE company_id = ? AND asset_id = ? AND status = ?",
+			m.assets.companyID, assetID, workOrderOpen).Scan(&stillOpen)
+		if err != nil || stillOpen > 0 {
+			return err
+		}
+
+		asset, err := m.assets.getForUpdate(tx, assetID, false)
+		if err != nil {
+			return err
+		}
+		// Leave statuses changed by hand during maintenance alone
+		if asset.Status != assetStatusMaintenance {
+			return nil
+		}
+		previous := "Active"
+		if order.PreviousAssetStatus != nil && *order.PreviousAssetStatus != "" && *order.PreviousAssetStatus != assetStatusMaintenance {
+			previous = *order.PreviousAssetStatus
+		}
+		return m.assets.setStatusInTx(tx, asset, previous)
+	})
+}
+
+// Delete removes a closed work order, or returns errWorkOrderOpen or sql.ErrNoRows
+func (m *maintenanceStore) Delete(id int) error {
+	order, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	if order.Status == workOrderOpen {
+		return errWorkOrderOpen
+	}
+	_, err = m.assets.exec("DELETE FROM asset_maintenance WHERE id = ? AND company_id = ? AND status != ?",
+		id, m.assets.companyID, workOrderOpen)
+	return err
+}
+
+// Due returns live assets whose next preventive maintenance, taken from their latest
+// completed work order, falls before today (overdue) or within the next days (upcoming).
+// Assets with an open work order are left out since their maintenance is under way.
+func (m *maintenanceStore) Due(overdue bool, days int) ([]MaintenanceDue, error) {
+	window := "m.next_maintenance_date < CURDATE()"
+	args := []interface{}{m.assets.companyID, workOrderCompleted, workOrderCompleted, workOrderOpen}
+	if !overdue {
+		window = "m.next_maintenance_date BETWEEN CURDATE() AND CURDATE() + INTERVAL ? DAY"
+		args = append(args, days)
+	}
+
+	rows, err := m.assets.query(fmt.Sprintf(`
+		SELECT m.asset_id, a.asset_name, a.institution_name, a.department, a.location, m.id,
+		m.maintenance_type, m.next_maintenance_date, m.recurrence_days
+		FROM asset_maintenance m
+		JOIN assets a ON a.id = m.asset_id AND a.company_id = m.company_id AND a.deleted_at IS NULL
+		WHERE m.company_id = ? AND m.status = ? AND m.next_maintenance_date IS NOT NULL
+		AND m.id = (
+			SELECT MAX(m2.id) FROM asset_maintenance m2
+			WHERE m2.company_id = m.company_id AND m2.asset_id = m.asset_id
+			AND m2.status = ? AND m2.next_maintenance_date IS NOT NULL
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM asset_maintenance o
+			WHERE o.company_id = m.company_id AND o.asset_id = m.asset_id AND o.status = ?
+		)
+		AND %s
+		ORDER BY m.next_maintenance_date, m.asset_id`, window), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	due := []MaintenanceDue{}
+	for rows.Next() {
+		var d MaintenanceDue
+		err := rows.Scan(&d.AssetID, &d.AssetName, &d.InstitutionName, &d.Department, &d.Location,
+			&d.LastMaintenanceID, &d.MaintenanceType, &d.NextMaintenanceDate, &d.RecurrenceDays)
+		if err != nil {
+			return nil, err
+		}
+		date := d.NextMaintenanceDate
+		d.DaysUntilDue = int(time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).Sub(today).Hours() / 24)
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// Costs rolls up completed work order costs per live asset, most expensive first.
+// from and to are optional YYYY-MM-DD bounds on the completion date.
+func (m *maintenanceStore) Costs(assetID *int, from, to string) ([]MaintenanceCostSummary, error) {
+	clause := "m.company_id = ? AND m.status = ?"
+	args := []interface{}{m.assets.companyID, workOrderCompleted}
+	if assetID != nil {
+		clause += " AND m.asset_id = ?"
+		args = append(args, *assetID)
+	}
+	if from != "" {
+		clause += " AND m.performed_at >= ?"
+		args = append(args, from)
+	}
+	if to != "" {
+		clause += " AND m.performed_at < ? + INTERVAL 1 DAY"
+		args = append(args, to)
+	}
+
+	rows, err := m.assets.query(fmt.Sprintf(`
+		SELECT m.asset_id, a.asset_name, COUNT(*), COALESCE(SUM(m.cost), 0), MAX(m.performed_at)
+		FROM asset_maintenance m
+		JOIN assets a ON a.id = m.asset_id AND a.company_id = m.company_id AND a.deleted_at IS NULL
+		WHERE %s
+		GROUP BY m.asset_id, a.asset_name
+		ORDER BY COALESCE(SUM(m.cost), 0) DESC, m.asset_id`, clause), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []MaintenanceCostSummary{}
+	for rows.Next() {
+		var s MaintenanceCostSummary
+		if err := rows.Scan(&s.AssetID, &s.AssetName, &s.WorkOrders, &s.TotalCost, &s.LastMaintenanceAt); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// parseOptionalDate parses a YYYY-MM-DD date, returning nil for an empty string
+func parseOptionalDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// workOrderFromRequest validates a MaintenanceRequest and converts it into a work order
+func workOrderFromRequest(req MaintenanceRequest) (AssetMaintenance, error) {
+	order := AssetMaintenance{
+		AssetID:         req.AssetID,
+		MaintenanceType: req.MaintenanceType,
+		Description:     req.Description,
+		Cost:            req.Cost,
+		PerformedBy:     optionalString(req.PerformedBy),
+		RecurrenceDays:  req.RecurrenceDays,
+	}
+	if !maintenanceTypes[req.MaintenanceType] {
+		return order, errors.New("maintenance_type must be Preventive, Corrective or Emergency")
+	}
+	if req.Cost != nil && *req.Cost < 0 {
+		return order, errors.New("cost cannot be negative")
+	}
+	if req.RecurrenceDays != nil && *req.RecurrenceDays < 0 {
+		return order, errors.New("recurrence_days cannot be negative")
+	}
+
+	var err error
+	if order.ScheduledDate, err = parseOptionalDate(req.ScheduledDate); err != nil {
+		return order, errors.New("scheduled_date must be YYYY-MM-DD")
+	}
+	if order.NextMaintenanceDate, err = parseOptionalDate(req.NextMaintenanceDate); err != nil {
+		return order, errors.New("next_maintenance_date must be YYYY-MM-DD")
+	}
+	return order, nil
+}
+
+// maintenanceError writes the response for a maintenance store error
+func maintenanceError(c *gin.Context, err error, action string) {
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Work order not found",
+		})
+	case errors.Is(err, errWorkOrderClosed), errors.Is(err, errWorkOrderOpen):
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	default:
+		log.Printf("Error %s work order: %v", action, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+}
+
+// workOrderID parses the :id path parameter, writing 400 when it is invalid
+func workOrderID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid work order ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// listMaintenanceHandler returns one page of work orders, filtered by asset_id, status and maintenance_type
+func listMaintenanceHandler(c *gin.Context) {
+	store, ok := requireMaintenanceStore(c)
+	if !ok {
+		return
+	}
+
+	filter := MaintenanceFilter{Status: c.Query("status"), MaintenanceType: c.Query("maintenance_type")}
+	if v := c.Query("asset_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "asset_id must be an integer",
+			})
+			return
+		}
+		filter.AssetID = &id
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultAssetPageSize)))
+	if page < 1 || pageSize < 1 || pageSize > maxAssetPageSize {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("page must be positive and page_size between 1 and %d", maxAssetPageSize),
+		})
+		return
+	}
+	filter.Limit, filter.Offset = pageSize, (page-1)*pageSize
+
+	orders, total, err := store.List(filter)
+	if err != nil {
+		maintenanceError(c, err, "listing")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data: PaginatedResponse{
+			Data:       orders,
+			Total:      total,
+			Page:       page,
+			PageSize:   pageSize,
+			TotalPages: (total + pageSize - 1) / pageSize,
+		},
+	})
+}
+
+// getMaintenanceHandler returns one work order
+func getMaintenanceHandler(c *gin.Context) {
+	store, ok := requireMaintenanceStore(c)
+	if !ok {
+		return
+	}
+	id, ok := workOrderID(c)
+	if !ok {
+		return
+	}
+
+	order, err := store.Get(id)
+	if err != nil {
+		maintenanceError(c, err, "fetching")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    order,
+	})
+}
+
+// createMaintenanceHandler opens a work order and puts its asset into maintenance
+func createMaintenanceHandler(c *gin.Context) {
+	store, ok := requireMaintenanceStore(c)
+	if !ok {
+		return
+	}
+
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid input data",
+		})
+		return
+	}
+	order, err := workOrderFromRequest(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	id, err := store.Create(&order)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Asset not found",
+			})
+			return
+		}
+		maintenanceError(c, err, "creating")
+		return
+	}
+
+	c.JSON(http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Work order created successfully",
+		Data: gin.H{
+			"id": id,
+		},
+	})
+}
+
+// updateMaintenanceHandler edits a work order's type, description, cost and schedule
+func updateMaintenanceHandler(c *gin.Context) {
+	store, ok := requireMaintenanceStore(c)
+	if !ok {
+		return
+	}
+	id, ok := workOrderID(c)
+	if !ok {
+		return
+	}
+
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid input data",
+		})
+		return
+	}
+	order, err := workOrderFromRequest(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	order.ID = id
+
+	if err := store.Update(&order); err != nil {
+		maintenanceError(c, err, "updating")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Work order updated successfully",
+	})
+}
+
+// completeMaintenanceHandler closes a work order as done
+func completeMaintenanceHandler(c *gin.Context) {
+	store, ok := requireMaintenanceStore(c)
+	if !ok {
+		return
+	}
+	id, ok := workOrderID(c)
+	if !ok {
+		return
+	}
+
+	var req CompleteMaintenanceRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "Invalid input data",
+			})
+			return
+		}
+	}
+	if req.Cost != nil && *req.Cost < 0 {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "cost cannot be negative",
+		})
+		return
+	}
+	next, err := parseOptionalDate(req.NextMaintenanceDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "next_maintenance_date must be YYYY-MM-DD",
+		})
+		return
+	}
+
+	if err := store.Close(id, workOrderCompleted, req, next); err != nil {
+		maintenanceError(c, err, "completing")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Work order completed successfully",
+	})
+}
+
+// cancelMaintenanceHandler closes a work order without doing the work
+func cancelMaintenanceHandler(c *gin.Context) {
+	store, ok := requireMaintenanceStore(c)
+	if !ok {
+		return
+	}
+	id, ok := workOrderID(c)
+	if !ok {
+		return
+	}
+
+	if err := store.Close(id, workOrderCancelled, CompleteMaintenanceRequest{}, nil); err != nil {
+		maintenanceError(c, err, "cancelling")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Work order cancelled successfully",
+	})
+}
+
+// deleteMaintenanceHandler deletes a completed or cancelled work order
+func deleteMaintenanceHandler(c *gin.Context) {
+	store, ok := requireMaintenanceStore(c)
+	if !ok {
+		return
+	}
+	id, ok := workOrderID(c)
+	if !ok {
+		return
+	}
+
+	if err := store.Delete(id); err != nil {
+		maintenanceError(c, err, "deleting")
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Work order deleted successfully",
+	})
+}
+
+// maintenanceDueHandler returns a handler listing overdue or upcoming preventive maintenance
+func maintenanceDueHandler(overdue bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store, ok := requireMaintenanceStore(c)
+		if !ok {
+			return
+		}
+
+		days := defaultUpcomingMaintenanceDays
+		if v := c.Query("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 366 {
+				c.JSON(http.StatusBadRequest, APIResponse{
+					Success: false,
+					Error:   "days must be between 0 and 366",
+				})
+				return
+			}
+			days = n
+		}
+
+		due, err := store.Due(overdue, days)
+		if err != nil {
+			maintenanceError(c, err, "listing due")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    due,
+		})
+	}
+}
+
+// getOverdueMaintenanceHandler lists assets whose preventive maintenance date has passed
+var getOverdueMaintenanceHandler = maintenanceDueHandler(true)
+
+// getUpcomingMaintenanceHandler lists assets due for preventive maintenance within ?days= (default 30)
+var getUpcomingMaintenanceHandler = maintenanceDueHandler(false)
+
+// getMaintenanceCostsHandler rolls up completed maintenance costs per asset.
+// Supports ?asset_id= and a ?from=&to= completion date range.
+func getMaintenanceCostsHandler(c *gin.Context) {
+	store, ok := requireMaintenanceStore(c)
+	if !ok {
+		return
+	}
+
+	var assetID *int
+	if v := c.Query("asset_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "asset_id must be an integer",
+			})
+			return
+		}
+		assetID = &id
+	}
+	from, to := c.Query("from"), c.Query("to")
+	for name, value := range map[string]string{"from": from, "to": to} {
+		if _, err := parseOptionalDate(value); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   name + " must be YYYY-MM-DD",
+			})
+			return
+		}
+	}
+
+	summaries, err := store.Costs(assetID, from, to)
+	if err != nil {
+		maintenanceError(c, err, "summing costs of")
+		return
+	}
+
+	total := 0.0
+	for _, s := range summaries {
+		total += s.TotalCost
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data: gin.H{
+			"assets":    summaries,
+			"totalCost": total,
+		},
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestCloseWorkOrderOfTrashedAsset checks that a work order of an asset in the trash
+// cannot be closed, which would otherwise change the trashed asset's status
+func TestCloseWorkOrderOfTrashedAsset(t *testing.T) {
+	store, mock := newMockAssetStore(t, ownCompany)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT asset_id FROM asset_maintenance WHERE id = ? AND company_id = ?")).
+		WithArgs(3, ownCompany).
+		WillReturnRows(sqlmock.NewRows([]string{"asset_id"}).AddRow(10))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM assets WHERE id = ? AND company_id = ? AND deleted_at IS NULL FOR UPDATE")).
+		WithArgs(10, ownCompany).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	maintenance := &maintenanceStore{assets: store}
+	if err := maintenance.Close(3, workOrderCompleted, CompleteMaintenanceRequest{}, nil); err != sql.ErrNoRows {
+		t.Fatalf("got %v, want sql.ErrNoRows", err)
+	}
+	checkMock(t, mock)
+}
+
+// TestTrashingEndsOpenWork checks that trashing an asset returns its open assignment and
+// cancels its open work orders, giving the asset back the status it had before
+func TestTrashingEndsOpenWork(t *testing.T) {
+	store, mock := newMockAssetStore(t, ownCompany)
+	row := assetRow(10, ownCompany)
+	row[12], row[15] = assetStatusMaintenance, 8
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM assets WHERE id = ? AND company_id = ? AND deleted_at IS NULL FOR UPDATE")).
+		WithArgs(10, ownCompany).
+		WillReturnRows(assetRows().AddRow(row...))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE asset_assignments SET returned_at = ?")).
+		WithArgs(sqlmock.AnyArg(), 7, sqlmock.AnyArg(), ownCompany, 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT previous_asset_status FROM asset_maintenance")).
+		WithArgs(ownCompany, 10, workOrderOpen).
+		WillReturnRows(sqlmock.NewRows([]string{"previous_asset_status"}).AddRow("Inactive"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE asset_maintenance SET status = ?")).
+		WithArgs(workOrderCancelled, sqlmock.AnyArg(), ownCompany, 10, workOrderOpen).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE assets SET assigned_to = NULL, status = ?")).
+		WithArgs("Inactive", 10, ownCompany).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE assets SET deleted_at = ?")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO asset_history")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := store.Delete(10); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	checkMock(t, mock)
+}
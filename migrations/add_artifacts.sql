@@ -0,0 +1,63 @@
+-- Artifact storage: generated reports, invoices and label sheets, downloaded by opaque ID
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>)
+-- after add_jobs.sql.
+
+CREATE TABLE IF NOT EXISTS artifacts (
+  id CHAR(32) PRIMARY KEY,
+  company_id INT NOT NULL,
+  user_id INT NULL,
+  kind VARCHAR(50) NOT NULL,
+  filename VARCHAR(255) NOT NULL,
+  content_type VARCHAR(100) NOT NULL,
+  size BIGINT NOT NULL,
+  storage_key VARCHAR(255) NOT NULL,
+  created_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+  INDEX idx_artifacts_company (company_id, created_at),
+  INDEX idx_artifacts_expires (expires_at)
+);
+
+-- Job files move from job_artifacts/ to the artifact storage. Jobs finished before the
+-- migration lose their download; rerun them if needed.
+DELIMITER $$
+DROP PROCEDURE IF EXISTS add_column_if_missing $$
+CREATE PROCEDURE add_column_if_missing(
+  IN p_table  VARCHAR(64),
+  IN p_column VARCHAR(64),
+  IN p_definition TEXT
+)
+BEGIN
+  DECLARE col_count INT;
+  SELECT COUNT(*) INTO col_count
+  FROM INFORMATION_SCHEMA.COLUMNS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_column;
+  IF col_count = 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` ADD COLUMN `', p_column, '` ', p_definition);
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+
+DROP PROCEDURE IF EXISTS drop_column_if_exists $$
+CREATE PROCEDURE drop_column_if_exists(
+  IN p_table  VARCHAR(64),
+  IN p_column VARCHAR(64)
+)
+BEGIN
+  DECLARE col_count INT;
+  SELECT COUNT(*) INTO col_count
+  FROM INFORMATION_SCHEMA.COLUMNS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_column;
+  IF col_count > 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` DROP COLUMN `', p_column, '`');
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+CALL add_column_if_missing('jobs', 'artifact_id', 'CHAR(32) NULL AFTER result');
+CALL drop_column_if_exists('jobs', 'artifact_path');
+
+DROP PROCEDURE add_column_if_missing;
+DROP PROCEDURE drop_column_if_exists;
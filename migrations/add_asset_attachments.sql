@@ -0,0 +1,53 @@
+-- Asset attachments: photos, invoices, warranties and manuals uploaded per asset, with a
+-- primary photo shown on the asset and optionally printed on labels.
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>)
+-- after add_artifacts.sql.
+
+CREATE TABLE IF NOT EXISTS asset_attachments (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  company_id INT NOT NULL,
+  asset_id INT NOT NULL,
+  kind ENUM('photo', 'invoice', 'warranty', 'manual', 'other') NOT NULL,
+  filename VARCHAR(255) NOT NULL,
+  content_type VARCHAR(100) NOT NULL,
+  size BIGINT NOT NULL,
+  width INT NULL,
+  height INT NULL,
+  description VARCHAR(500) NULL,
+  storage_key VARCHAR(255) NOT NULL,
+  thumbnail_key VARCHAR(255) NULL,
+  uploaded_by INT NULL,
+  created_at DATETIME NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (asset_id) REFERENCES assets(id) ON DELETE CASCADE,
+  FOREIGN KEY (uploaded_by) REFERENCES users(id) ON DELETE SET NULL,
+  INDEX idx_asset_attachments_asset (company_id, asset_id)
+);
+
+DELIMITER $$
+DROP PROCEDURE IF EXISTS add_column_if_missing $$
+CREATE PROCEDURE add_column_if_missing(
+  IN p_table  VARCHAR(64),
+  IN p_column VARCHAR(64),
+  IN p_definition TEXT
+)
+BEGIN
+  DECLARE col_count INT;
+  SELECT COUNT(*) INTO col_count
+  FROM INFORMATION_SCHEMA.COLUMNS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_column;
+  IF col_count = 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` ADD COLUMN `', p_column, '` ', p_definition);
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+-- The application checks that the primary photo is an image attachment of the asset and
+-- clears it when that attachment is deleted
+CALL add_column_if_missing('assets', 'primary_photo_id', 'INT NULL AFTER salvage_value');
+
+CALL add_column_if_missing('label_templates', 'photo_position', 'ENUM(''none'', ''left'', ''right'') NOT NULL DEFAULT ''none'' AFTER logo_height');
+CALL add_column_if_missing('label_templates', 'photo_width', 'DECIMAL(7,2) NOT NULL DEFAULT 0 AFTER photo_position');
+
+DROP PROCEDURE add_column_if_missing;
@@ -0,0 +1,62 @@
+-- Asset check-out/check-in: due dates, returns, and at most one open assignment per asset
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+
+DELIMITER $$
+DROP PROCEDURE IF EXISTS add_column_if_missing $$
+CREATE PROCEDURE add_column_if_missing(
+  IN p_table  VARCHAR(64),
+  IN p_column VARCHAR(64),
+  IN p_definition TEXT
+)
+BEGIN
+  DECLARE col_count INT;
+  SELECT COUNT(*) INTO col_count
+  FROM INFORMATION_SCHEMA.COLUMNS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_column;
+  IF col_count = 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` ADD COLUMN `', p_column, '` ', p_definition);
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+CALL add_column_if_missing('asset_assignments', 'due_date', 'DATE NULL');
+CALL add_column_if_missing('asset_assignments', 'returned_by', 'INT NULL');
+CALL add_column_if_missing('asset_assignments', 'return_notes', 'TEXT NULL');
+
+-- Open assignments that conflict with a later open assignment of the same asset, listed
+-- for operators to review: the next statement closes them
+SELECT aa.id, aa.company_id, aa.asset_id, aa.assigned_to, aa.assigned_at, latest.keep_id AS kept_assignment_id
+FROM asset_assignments aa
+JOIN (
+  SELECT asset_id, MAX(id) AS keep_id
+  FROM asset_assignments
+  WHERE returned_at IS NULL
+  GROUP BY asset_id
+) latest ON latest.asset_id = aa.asset_id
+WHERE aa.returned_at IS NULL AND aa.id <> latest.keep_id
+ORDER BY aa.company_id, aa.asset_id, aa.id;
+
+-- Close all but the latest open assignment of each asset before enforcing uniqueness. These
+-- were not returned by anyone: returned_by stays NULL and the note marks them.
+UPDATE asset_assignments aa
+JOIN (
+  SELECT asset_id, MAX(id) AS keep_id
+  FROM asset_assignments
+  WHERE returned_at IS NULL
+  GROUP BY asset_id
+) latest ON latest.asset_id = aa.asset_id
+SET aa.returned_at = NOW(),
+  aa.return_notes = CONCAT('Closed by migration add_asset_checkout.sql: superseded by open assignment ', latest.keep_id)
+WHERE aa.returned_at IS NULL AND aa.id <> latest.keep_id;
+
+-- open_flag is derived from returned_at only: MySQL rejects stored generated columns
+-- over asset_id, whose foreign key cascades
+CALL add_column_if_missing('asset_assignments', 'open_flag',
+  'TINYINT GENERATED ALWAYS AS (IF(returned_at IS NULL, 1, NULL)) STORED');
+
+DROP PROCEDURE add_column_if_missing;
+
+CREATE UNIQUE INDEX uq_assignments_open_asset ON asset_assignments(asset_id, open_flag);
+CREATE INDEX idx_assignments_company_user ON asset_assignments(company_id, assigned_to, returned_at);
+CREATE INDEX idx_assignments_company_due ON asset_assignments(company_id, returned_at, due_date);
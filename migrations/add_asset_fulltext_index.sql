@@ -0,0 +1,27 @@
+-- FULLTEXT index backing ranked search on POST /api/assets/search
+-- MySQL-compatible (Amazon RDS MySQL 5.6+, InnoDB). Run with the target DB selected (-D <db_name>).
+-- The column list must match assetFullTextColumns in search.go.
+
+DELIMITER $$
+DROP PROCEDURE IF EXISTS add_fulltext_index_if_missing $$
+CREATE PROCEDURE add_fulltext_index_if_missing(
+  IN p_table  VARCHAR(64),
+  IN p_index  VARCHAR(64),
+  IN p_columns TEXT
+)
+BEGIN
+  DECLARE idx_count INT;
+  SELECT COUNT(*) INTO idx_count
+  FROM INFORMATION_SCHEMA.STATISTICS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND INDEX_NAME = p_index;
+  IF idx_count = 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` ADD FULLTEXT INDEX `', p_index, '` (', p_columns, ')');
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+CALL add_fulltext_index_if_missing('assets', 'ft_assets_search',
+  'asset_name, asset_type, institution_name, department, manufacturer, model_number, serial_number, location, notes');
+
+DROP PROCEDURE add_fulltext_index_if_missing;
@@ -0,0 +1,18 @@
+-- Audit trail of asset changes, written in the same transaction as each change
+-- MySQL-compatible (Amazon RDS MySQL 5.7+). Run with the target DB selected (-D <db_name>).
+-- asset_id has no foreign key so that history outlives deleted assets.
+
+CREATE TABLE IF NOT EXISTS asset_history (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    company_id INT NOT NULL,
+    asset_id INT NOT NULL,
+    action ENUM('create', 'update', 'delete', 'status_change', 'assignment') NOT NULL,
+    changes JSON NOT NULL,
+    user_id INT NULL,
+    request_id VARCHAR(64) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_asset_history_asset (company_id, asset_id, id),
+    INDEX idx_asset_history_request (request_id)
+);
@@ -0,0 +1,32 @@
+-- Indexes backing paginated, sorted and filtered GET /api/assets on large tenants
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+
+DELIMITER $$
+DROP PROCEDURE IF EXISTS add_index_if_missing $$
+CREATE PROCEDURE add_index_if_missing(
+  IN p_table  VARCHAR(64),
+  IN p_index  VARCHAR(64),
+  IN p_columns TEXT
+)
+BEGIN
+  DECLARE idx_count INT;
+  SELECT COUNT(*) INTO idx_count
+  FROM INFORMATION_SCHEMA.STATISTICS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND INDEX_NAME = p_index;
+  IF idx_count = 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` ADD INDEX `', p_index, '` (', p_columns, ')');
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+CALL add_index_if_missing('assets', 'idx_assets_company_id', 'company_id, id');
+CALL add_index_if_missing('assets', 'idx_assets_company_name', 'company_id, asset_name');
+CALL add_index_if_missing('assets', 'idx_assets_company_status', 'company_id, status');
+CALL add_index_if_missing('assets', 'idx_assets_company_type', 'company_id, asset_type');
+CALL add_index_if_missing('assets', 'idx_assets_company_institution', 'company_id, institution_name, department');
+CALL add_index_if_missing('assets', 'idx_assets_company_serial', 'company_id, serial_number');
+CALL add_index_if_missing('assets', 'idx_assets_company_purchase_date', 'company_id, purchase_date');
+CALL add_index_if_missing('assets', 'idx_assets_company_created', 'company_id, created_at');
+
+DROP PROCEDURE add_index_if_missing;
@@ -0,0 +1,21 @@
+-- Scan log: every resolved barcode / QR code scan with the user and an optional GPS fix
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+
+CREATE TABLE IF NOT EXISTS asset_scans (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  company_id INT NOT NULL,
+  asset_id INT NOT NULL,
+  user_id INT NULL,
+  code VARCHAR(255) NOT NULL,
+  matched_by ENUM('barcode', 'qr_code', 'legacy', 'ean13', 'serial_number') NOT NULL,
+  latitude DECIMAL(9,6) NULL,
+  longitude DECIMAL(9,6) NULL,
+  accuracy_meters DECIMAL(8,2) NULL,
+  scanned_at DATETIME NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (asset_id) REFERENCES assets(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+  INDEX idx_asset_scans_asset (company_id, asset_id, scanned_at),
+  INDEX idx_asset_scans_company (company_id, scanned_at)
+);
+
@@ -0,0 +1,37 @@
+-- Soft delete for assets: trashed rows keep their data until restored or purged
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>)
+-- after add_asset_history.sql.
+
+DELIMITER $$
+DROP PROCEDURE IF EXISTS add_column_if_missing $$
+CREATE PROCEDURE add_column_if_missing(
+  IN p_table  VARCHAR(64),
+  IN p_column VARCHAR(64),
+  IN p_definition TEXT
+)
+BEGIN
+  DECLARE col_count INT;
+  SELECT COUNT(*) INTO col_count
+  FROM INFORMATION_SCHEMA.COLUMNS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_column;
+  IF col_count = 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` ADD COLUMN `', p_column, '` ', p_definition);
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+CALL add_column_if_missing('assets', 'deleted_at', 'DATETIME NULL');
+CALL add_column_if_missing('assets', 'deleted_by', 'INT NULL');
+
+DROP PROCEDURE add_column_if_missing;
+
+CREATE INDEX idx_assets_company_deleted ON assets(company_id, deleted_at);
+
+ALTER TABLE asset_history MODIFY action
+  ENUM('create', 'update', 'delete', 'restore', 'purge', 'status_change', 'assignment') NOT NULL;
+
+-- Optional per-company retention override in days (0 keeps trashed assets until purged by hand):
+-- INSERT INTO company_settings (company_id, setting_key, setting_value)
+-- VALUES (1, 'asset_trash_retention_days', '90')
+-- ON DUPLICATE KEY UPDATE setting_value = VALUES(setting_value);
@@ -0,0 +1,43 @@
+-- Stable asset tags: per-company uniqueness of assets.barcode / qr_code and gap-free tag sequences
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+
+DELIMITER $$
+DROP PROCEDURE IF EXISTS drop_index_if_exists $$
+CREATE PROCEDURE drop_index_if_exists(
+  IN p_table VARCHAR(64),
+  IN p_index VARCHAR(64)
+)
+BEGIN
+  DECLARE idx_count INT;
+  SELECT COUNT(*) INTO idx_count
+  FROM INFORMATION_SCHEMA.STATISTICS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND INDEX_NAME = p_index;
+  IF idx_count > 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` DROP INDEX `', p_index, '`');
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+-- Tags are unique within a company, not across companies
+CALL drop_index_if_exists('assets', 'barcode');
+CALL drop_index_if_exists('assets', 'qr_code');
+CALL drop_index_if_exists('assets', 'idx_assets_barcode');
+CALL drop_index_if_exists('assets', 'idx_assets_qr_code');
+CALL drop_index_if_exists('assets', 'uq_assets_company_barcode');
+CALL drop_index_if_exists('assets', 'uq_assets_company_qr_code');
+
+DROP PROCEDURE drop_index_if_exists;
+
+CREATE UNIQUE INDEX uq_assets_company_barcode ON assets(company_id, barcode);
+CREATE UNIQUE INDEX uq_assets_company_qr_code ON assets(company_id, qr_code);
+
+-- Last number handed out per company and tag prefix. The counter is bumped in the
+-- transaction that creates the asset, so a rolled back insert leaves no gap.
+CREATE TABLE IF NOT EXISTS asset_tag_sequences (
+  company_id INT NOT NULL,
+  prefix VARCHAR(100) NOT NULL,
+  last_value BIGINT NOT NULL,
+  PRIMARY KEY (company_id, prefix),
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE
+);
@@ -0,0 +1,54 @@
+-- OpenID Connect single sign-on per company: the provider configuration, sign-ins in
+-- progress and the provider identities linked to users.
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+-- Requires migrations/add_two_factor.sql: single sign-on tickets are login_challenges rows.
+
+CREATE TABLE IF NOT EXISTS company_sso (
+  company_id INT PRIMARY KEY,
+  issuer VARCHAR(255) NOT NULL,
+  client_id VARCHAR(255) NOT NULL,
+  client_secret VARCHAR(500) NOT NULL,
+  scopes VARCHAR(255) NOT NULL DEFAULT 'openid email profile',
+  -- Claim (dotted path for nested claims) whose values role_mapping maps to roles
+  role_claim VARCHAR(255) NOT NULL DEFAULT '',
+  role_mapping JSON NULL,
+  default_role ENUM('admin', 'manager', 'user') NOT NULL DEFAULT 'user',
+  auto_provision BOOLEAN NOT NULL DEFAULT TRUE,
+  disable_password_login BOOLEAN NOT NULL DEFAULT FALSE,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  -- User who last saved the configuration. Sign-ins matched by email only link to users
+  -- whose role has no permissions beyond this user's.
+  configured_by INT NULL,
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (configured_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- Sign-ins waiting for the provider's callback, by SHA-256 hash of the state parameter
+CREATE TABLE IF NOT EXISTS sso_login_states (
+  state_hash CHAR(64) PRIMARY KEY,
+  company_id INT NOT NULL,
+  nonce VARCHAR(64) NOT NULL,
+  code_verifier VARCHAR(128) NOT NULL,
+  redirect_uri VARCHAR(500) NOT NULL,
+  created_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  INDEX idx_sso_login_states_expires (expires_at)
+);
+
+-- The provider account (issuer and subject) each user signs in with
+CREATE TABLE IF NOT EXISTS user_identities (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  company_id INT NOT NULL,
+  user_id INT NOT NULL,
+  issuer VARCHAR(255) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  email VARCHAR(255) NULL,
+  created_at DATETIME NOT NULL,
+  last_login_at DATETIME NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  UNIQUE KEY unique_identity_per_company (company_id, issuer, subject)
+);
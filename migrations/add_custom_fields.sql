@@ -0,0 +1,49 @@
+-- Custom asset fields: admin-defined fields per company, optionally limited to one asset
+-- category, with their values stored on each asset as a JSON object keyed by field key.
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+
+CREATE TABLE IF NOT EXISTS custom_fields (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  company_id INT NOT NULL,
+  category_id INT NULL,
+  field_key VARCHAR(50) NOT NULL,
+  label VARCHAR(100) NOT NULL,
+  field_type ENUM('text', 'number', 'date', 'select', 'multi_select', 'boolean') NOT NULL,
+  required BOOLEAN NOT NULL DEFAULT FALSE,
+  options JSON NULL,
+  min_value DOUBLE NULL,
+  max_value DOUBLE NULL,
+  max_length INT NULL,
+  pattern VARCHAR(255) NULL,
+  sort_order INT NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (category_id) REFERENCES asset_categories(id) ON DELETE CASCADE,
+  UNIQUE KEY uniq_custom_fields_key (company_id, field_key)
+);
+
+DELIMITER $$
+DROP PROCEDURE IF EXISTS add_column_if_missing $$
+CREATE PROCEDURE add_column_if_missing(
+  IN p_table  VARCHAR(64),
+  IN p_column VARCHAR(64),
+  IN p_definition TEXT
+)
+BEGIN
+  DECLARE col_count INT;
+  SELECT COUNT(*) INTO col_count
+  FROM INFORMATION_SCHEMA.COLUMNS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_column;
+  IF col_count = 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` ADD COLUMN `', p_column, '` ', p_definition);
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+-- The application validates values against the field definitions and removes a field's
+-- values when the field is deleted
+CALL add_column_if_missing('assets', 'custom_fields', 'JSON NULL AFTER primary_photo_id');
+
+DROP PROCEDURE add_column_if_missing;
@@ -0,0 +1,31 @@
+-- Depreciation: category defaults and per-asset overrides of method, useful life and salvage value
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+
+DELIMITER $$
+DROP PROCEDURE IF EXISTS add_column_if_missing $$
+CREATE PROCEDURE add_column_if_missing(
+  IN p_table  VARCHAR(64),
+  IN p_column VARCHAR(64),
+  IN p_definition TEXT
+)
+BEGIN
+  DECLARE col_count INT;
+  SELECT COUNT(*) INTO col_count
+  FROM INFORMATION_SCHEMA.COLUMNS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_column;
+  IF col_count = 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` ADD COLUMN `', p_column, '` ', p_definition);
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+CALL add_column_if_missing('asset_categories', 'depreciation_method', "ENUM('straight_line', 'declining_balance', 'sum_of_years') NULL");
+CALL add_column_if_missing('asset_categories', 'useful_life_years', 'INT NULL');
+CALL add_column_if_missing('asset_categories', 'salvage_percent', 'DECIMAL(5,2) NULL');
+
+CALL add_column_if_missing('assets', 'depreciation_method', "ENUM('straight_line', 'declining_balance', 'sum_of_years') NULL");
+CALL add_column_if_missing('assets', 'useful_life_years', 'INT NULL');
+CALL add_column_if_missing('assets', 'salvage_value', 'DECIMAL(10,2) NULL');
+
+DROP PROCEDURE add_column_if_missing;
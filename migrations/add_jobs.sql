@@ -0,0 +1,32 @@
+-- Background jobs: queued label sheets and reports, run by the server's job workers
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+
+CREATE TABLE IF NOT EXISTS jobs (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  company_id INT NOT NULL,
+  user_id INT NULL,
+  job_type VARCHAR(50) NOT NULL,
+  payload JSON NOT NULL,
+  status ENUM('queued', 'running', 'succeeded', 'failed', 'cancelled') NOT NULL DEFAULT 'queued',
+  progress TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  attempts INT NOT NULL DEFAULT 0,
+  max_attempts INT NOT NULL DEFAULT 3,
+  cancel_requested TINYINT(1) NOT NULL DEFAULT 0,
+  claim_token CHAR(32) NULL,
+  error TEXT NULL,
+  result JSON NULL,
+  artifact_path VARCHAR(255) NULL,
+  artifact_name VARCHAR(255) NULL,
+  run_after DATETIME NOT NULL,
+  created_at DATETIME NOT NULL,
+  started_at DATETIME NULL,
+  heartbeat_at DATETIME NULL,
+  finished_at DATETIME NULL,
+  expires_at DATETIME NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_jobs_claim_token (claim_token),
+  INDEX idx_jobs_queue (status, run_after),
+  INDEX idx_jobs_user (company_id, user_id, id),
+  INDEX idx_jobs_expires (expires_at)
+);
@@ -0,0 +1,28 @@
+-- Label templates: per-company page, grid, field and logo layouts for barcode label sheets
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+
+CREATE TABLE IF NOT EXISTS label_templates (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  company_id INT NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  page_width DECIMAL(7,2) NOT NULL,
+  page_height DECIMAL(7,2) NOT NULL,
+  grid_rows INT NOT NULL,
+  grid_columns INT NOT NULL,
+  margin_top DECIMAL(7,2) NOT NULL DEFAULT 0,
+  margin_left DECIMAL(7,2) NOT NULL DEFAULT 0,
+  column_gap DECIMAL(7,2) NOT NULL DEFAULT 0,
+  row_gap DECIMAL(7,2) NOT NULL DEFAULT 0,
+  label_width DECIMAL(7,2) NOT NULL,
+  label_height DECIMAL(7,2) NOT NULL,
+  padding DECIMAL(7,2) NOT NULL DEFAULT 0,
+  fields JSON NOT NULL,
+  header_font_size DECIMAL(4,1) NOT NULL DEFAULT 0,
+  field_font_size DECIMAL(4,1) NOT NULL,
+  logo_position ENUM('none', 'top_left', 'top_right') NOT NULL DEFAULT 'none',
+  logo_height DECIMAL(7,2) NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  INDEX idx_label_templates_company (company_id, name)
+);
@@ -0,0 +1,34 @@
+-- Maintenance work orders: open/completed/cancelled lifecycle, scheduling and recurrence
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+-- Existing maintenance records were logged after the fact and become completed work orders.
+
+DELIMITER $$
+DROP PROCEDURE IF EXISTS add_column_if_missing $$
+CREATE PROCEDURE add_column_if_missing(
+  IN p_table  VARCHAR(64),
+  IN p_column VARCHAR(64),
+  IN p_definition TEXT
+)
+BEGIN
+  DECLARE col_count INT;
+  SELECT COUNT(*) INTO col_count
+  FROM INFORMATION_SCHEMA.COLUMNS
+  WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_column;
+  IF col_count = 0 THEN
+    SET @ddl = CONCAT('ALTER TABLE `', p_table, '` ADD COLUMN `', p_column, '` ', p_definition);
+    PREPARE s FROM @ddl; EXECUTE s; DEALLOCATE PREPARE s;
+  END IF;
+END $$
+DELIMITER ;
+
+CALL add_column_if_missing('asset_maintenance', 'status', "ENUM('open', 'completed', 'cancelled') NOT NULL DEFAULT 'completed'");
+CALL add_column_if_missing('asset_maintenance', 'scheduled_date', 'DATE NULL');
+CALL add_column_if_missing('asset_maintenance', 'completed_at', 'DATETIME NULL');
+CALL add_column_if_missing('asset_maintenance', 'recurrence_days', 'INT NULL');
+CALL add_column_if_missing('asset_maintenance', 'previous_asset_status', 'VARCHAR(20) NULL');
+
+DROP PROCEDURE add_column_if_missing;
+
+UPDATE asset_maintenance SET completed_at = performed_at WHERE status = 'completed' AND completed_at IS NULL;
+
+CREATE INDEX idx_maintenance_company_asset_status ON asset_maintenance(company_id, asset_id, status);
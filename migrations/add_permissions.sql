@@ -0,0 +1,28 @@
+-- Fine-grained permissions: custom roles per company bundling permissions, and role
+-- columns that accept their names next to the built-in admin, manager and user roles.
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+-- Run after migrations/add_company_sso.sql. user_roles is no longer written; logins
+-- derive its legacy names from the role's permissions.
+
+CREATE TABLE IF NOT EXISTS company_roles (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  company_id INT NOT NULL,
+  name VARCHAR(50) NOT NULL,
+  description VARCHAR(255) NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  UNIQUE KEY unique_role_per_company (company_id, name)
+);
+
+-- Permissions granted by each custom role, e.g. assets:write or reports:export
+CREATE TABLE IF NOT EXISTS company_role_permissions (
+  role_id INT NOT NULL,
+  permission VARCHAR(50) NOT NULL,
+  PRIMARY KEY (role_id, permission),
+  FOREIGN KEY (role_id) REFERENCES company_roles(id) ON DELETE CASCADE
+);
+
+-- Roles are names of built-in or custom roles rather than a fixed list (safe to re-run)
+ALTER TABLE users MODIFY role VARCHAR(50) DEFAULT 'user';
+ALTER TABLE company_sso MODIFY default_role VARCHAR(50) NOT NULL DEFAULT 'user';
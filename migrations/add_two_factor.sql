@@ -0,0 +1,44 @@
+-- TOTP two-factor authentication: authenticator secrets, one-time recovery codes and the
+-- short-lived challenges a login is completed with after the password.
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+-- Company policy lives in company_settings under 'two_factor_required' ('true'/'false').
+
+-- A row without enabled_at is a setup waiting for its first code. last_used_step stops
+-- a code from being used twice.
+CREATE TABLE IF NOT EXISTS user_two_factor (
+  user_id INT PRIMARY KEY,
+  company_id INT NOT NULL,
+  secret VARCHAR(64) NOT NULL,
+  enabled_at DATETIME NULL,
+  last_used_step BIGINT NULL,
+  created_at DATETIME NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Only SHA-256 hashes of recovery codes are stored; each can be used once
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  user_id INT NOT NULL,
+  code_hash CHAR(64) NOT NULL,
+  created_at DATETIME NOT NULL,
+  used_at DATETIME NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  INDEX idx_user_recovery_codes_user (user_id, code_hash)
+);
+
+-- Logins waiting for their second factor, by SHA-256 hash of the challenge token.
+-- via_sso marks logins whose first factor was single sign-on rather than a password.
+CREATE TABLE IF NOT EXISTS login_challenges (
+  token_hash CHAR(64) PRIMARY KEY,
+  company_id INT NOT NULL,
+  user_id INT NOT NULL,
+  purpose VARCHAR(20) NOT NULL,
+  via_sso BOOLEAN NOT NULL DEFAULT FALSE,
+  attempts INT NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  INDEX idx_login_challenges_expires (expires_at)
+);
@@ -0,0 +1,31 @@
+-- Server-side sessions: every login opens a session holding rotating refresh tokens.
+-- Access tokens name their session and stop working as soon as it is revoked.
+-- MySQL-compatible (Amazon RDS MySQL). Run with the target DB selected (-D <db_name>).
+-- Access tokens issued before this migration are refused; users sign in again once.
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+  id CHAR(32) PRIMARY KEY,
+  company_id INT NOT NULL,
+  user_id INT NOT NULL,
+  user_agent VARCHAR(255) NULL,
+  ip_address VARCHAR(45) NULL,
+  created_at DATETIME NOT NULL,
+  last_used_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  revoked_at DATETIME NULL,
+  revoked_reason VARCHAR(50) NULL,
+  FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  INDEX idx_user_sessions_user (company_id, user_id, revoked_at),
+  INDEX idx_user_sessions_expires (expires_at)
+);
+
+-- Only SHA-256 hashes of refresh tokens are stored. A token is marked used when it is
+-- exchanged; presenting it again revokes its session.
+CREATE TABLE IF NOT EXISTS session_refresh_tokens (
+  token_hash CHAR(64) PRIMARY KEY,
+  session_id CHAR(32) NOT NULL,
+  created_at DATETIME NOT NULL,
+  used_at DATETIME NULL,
+  FOREIGN KEY (session_id) REFERENCES user_sessions(id) ON DELETE CASCADE
+);
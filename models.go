@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -53,6 +54,9 @@ type AssetCategory struct {
 	Description *string   `json:"description" db:"description"`
 	Color       *string   `json:"color" db:"color"`
 	IsActive    bool      `json:"is_active" db:"is_active"`
+	DepreciationMethod *string  `json:"depreciation_method" db:"depreciation_method"`
+	UsefulLifeYears    *int     `json:"useful_life_years" db:"useful_life_years"`
+	SalvagePercent     *float64 `json:"salvage_percent" db:"salvage_percent"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -79,9 +83,38 @@ type Asset struct {
 	Barcode          *string   `json:"barcode" db:"barcode"`
 	QRCode           *string   `json:"qr_code" db:"qr_code"`
 	Logo             *string   `json:"logo" db:"logo"`
+	PrimaryPhotoID   *int      `json:"primary_photo_id" db:"primary_photo_id"`
 	CreatedBy        int       `json:"created_by" db:"created_by"`
 	CreatedAt        time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+	DeletedAt        *time.Time `json:"deleted_at" db:"deleted_at"`
+	DeletedBy        *int      `json:"deleted_by" db:"deleted_by"`
+	DepreciationMethod *string  `json:"depreciation_method" db:"depreciation_method"`
+	UsefulLifeYears  *int      `json:"useful_life_years" db:"useful_life_years"`
+	SalvageValue     *float64  `json:"salvage_value" db:"salvage_value"`
+	CustomFields     customFieldValues `json:"custom_fields" db:"custom_fields"`
+	BookValue        *float64  `json:"book_value" db:"-"`
+	Depreciation     *depreciationPolicy `json:"-" db:"-"`
+}
+
+// CustomField is an extra asset field defined by a company, for all its assets or for
+// the assets of one category
+type CustomField struct {
+	ID         int        `json:"id" db:"id"`
+	CompanyID  int        `json:"-" db:"company_id"`
+	CategoryID *int       `json:"category_id" db:"category_id"`
+	Key        string     `json:"key" db:"field_key"`
+	Label      string     `json:"label" db:"label"`
+	Type       string     `json:"type" db:"field_type"`
+	Required   bool       `json:"required" db:"required"`
+	Options    []string   `json:"options,omitempty" db:"options"`
+	MinValue   *float64   `json:"min_value,omitempty" db:"min_value"`
+	MaxValue   *float64   `json:"max_value,omitempty" db:"max_value"`
+	MaxLength  *int       `json:"max_length,omitempty" db:"max_length"`
+	Pattern    *string    `json:"pattern,omitempty" db:"pattern"`
+	SortOrder  int        `json:"sort_order" db:"sort_order"`
+	CreatedAt  *time.Time `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty" db:"updated_at"`
 }
 
 // AssetMaintenance represents asset maintenance history
@@ -97,6 +130,53 @@ type AssetMaintenance struct {
 	NextMaintenanceDate  *time.Time `json:"next_maintenance_date" db:"next_maintenance_date"`
 	CreatedBy            int       `json:"created_by" db:"created_by"`
 	CreatedAt            time.Time `json:"created_at" db:"created_at"`
+	Status               string     `json:"status" db:"status"`
+	ScheduledDate        *time.Time `json:"scheduled_date" db:"scheduled_date"`
+	CompletedAt          *time.Time `json:"completed_at" db:"completed_at"`
+	RecurrenceDays       *int       `json:"recurrence_days" db:"recurrence_days"`
+	PreviousAssetStatus  *string    `json:"-" db:"previous_asset_status"`
+}
+
+// MaintenanceRequest represents creating or editing a maintenance work order
+type MaintenanceRequest struct {
+	AssetID             int      `json:"asset_id"`
+	MaintenanceType     string   `json:"maintenance_type" binding:"required"`
+	Description         string   `json:"description" binding:"required"`
+	Cost                *float64 `json:"cost"`
+	PerformedBy         string   `json:"performed_by"`
+	ScheduledDate       string   `json:"scheduled_date"`
+	NextMaintenanceDate string   `json:"next_maintenance_date"`
+	RecurrenceDays      *int     `json:"recurrence_days"`
+}
+
+// CompleteMaintenanceRequest represents closing a work order as done
+type CompleteMaintenanceRequest struct {
+	Cost                *float64 `json:"cost"`
+	PerformedBy         string   `json:"performed_by"`
+	NextMaintenanceDate string   `json:"next_maintenance_date"`
+}
+
+// MaintenanceDue represents an asset whose next preventive maintenance is due
+type MaintenanceDue struct {
+	AssetID             int       `json:"asset_id"`
+	AssetName           string    `json:"asset_name"`
+	InstitutionName     *string   `json:"institution_name"`
+	Department          *string   `json:"department"`
+	Location            *string   `json:"location"`
+	LastMaintenanceID   int       `json:"last_maintenance_id"`
+	MaintenanceType     string    `json:"maintenance_type"`
+	NextMaintenanceDate time.Time `json:"next_maintenance_date"`
+	RecurrenceDays      *int      `json:"recurrence_days"`
+	DaysUntilDue        int       `json:"days_until_due"`
+}
+
+// MaintenanceCostSummary represents the maintenance cost roll-up of one asset
+type MaintenanceCostSummary struct {
+	AssetID           int        `json:"asset_id"`
+	AssetName         string     `json:"asset_name"`
+	WorkOrders        int        `json:"work_orders"`
+	TotalCost         float64    `json:"total_cost"`
+	LastMaintenanceAt *time.Time `json:"last_maintenance_at"`
 }
 
 // AssetAssignment represents asset assignment history
@@ -109,6 +189,125 @@ type AssetAssignment struct {
 	AssignedAt  time.Time `json:"assigned_at" db:"assigned_at"`
 	ReturnedAt  *time.Time `json:"returned_at" db:"returned_at"`
 	Notes       *string   `json:"notes" db:"notes"`
+	DueDate     *time.Time `json:"due_date" db:"due_date"`
+	ReturnedBy  *int       `json:"returned_by" db:"returned_by"`
+	ReturnNotes *string    `json:"return_notes" db:"return_notes"`
+	AssetName   string     `json:"asset_name" db:"-"`
+	AssigneeUsername *string `json:"assignee_username" db:"-"`
+	DaysOverdue int        `json:"days_overdue,omitempty" db:"-"`
+}
+
+// LabelTemplate describes the page, label grid and content of printed asset labels.
+// Sizes are in mm and font sizes in points.
+type LabelTemplate struct {
+	ID             int        `json:"id,omitempty" db:"id"`
+	Preset         string     `json:"preset,omitempty" db:"-"`
+	Name           string     `json:"name" db:"name"`
+	PageWidth      float64    `json:"page_width" db:"page_width"`
+	PageHeight     float64    `json:"page_height" db:"page_height"`
+	Rows           int        `json:"rows" db:"grid_rows"`
+	Columns        int        `json:"columns" db:"grid_columns"`
+	MarginTop      float64    `json:"margin_top" db:"margin_top"`
+	MarginLeft     float64    `json:"margin_left" db:"margin_left"`
+	ColumnGap      float64    `json:"column_gap" db:"column_gap"`
+	RowGap         float64    `json:"row_gap" db:"row_gap"`
+	LabelWidth     float64    `json:"label_width" db:"label_width"`
+	LabelHeight    float64    `json:"label_height" db:"label_height"`
+	Padding        float64    `json:"padding" db:"padding"`
+	Fields         []string   `json:"fields" db:"fields"`
+	HeaderFontSize float64    `json:"header_font_size" db:"header_font_size"`
+	FieldFontSize  float64    `json:"field_font_size" db:"field_font_size"`
+	LogoPosition   string     `json:"logo_position" db:"logo_position"`
+	LogoHeight     float64    `json:"logo_height" db:"logo_height"`
+	PhotoPosition  string     `json:"photo_position" db:"photo_position"`
+	PhotoWidth     float64    `json:"photo_width" db:"photo_width"`
+	CreatedAt      *time.Time `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// AssetAttachment is a file uploaded for an asset, such as a photo, invoice, warranty or manual
+type AssetAttachment struct {
+	ID           int       `json:"id" db:"id"`
+	CompanyID    int       `json:"-" db:"company_id"`
+	AssetID      int       `json:"asset_id" db:"asset_id"`
+	Kind         string    `json:"kind" db:"kind"`
+	Filename     string    `json:"filename" db:"filename"`
+	ContentType  string    `json:"content_type" db:"content_type"`
+	Size         int64     `json:"size" db:"size"`
+	Width        *int      `json:"width" db:"width"`
+	Height       *int      `json:"height" db:"height"`
+	Description  *string   `json:"description" db:"description"`
+	StorageKey   string    `json:"-" db:"storage_key"`
+	ThumbnailKey *string   `json:"-" db:"thumbnail_key"`
+	UploadedBy   *int      `json:"uploaded_by" db:"uploaded_by"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	IsPrimary    bool      `json:"is_primary" db:"-"`
+	DownloadURL  string    `json:"download_url" db:"-"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty" db:"-"`
+}
+
+// AssetScan represents one scan of an asset's barcode or QR code
+type AssetScan struct {
+	ID             int       `json:"id" db:"id"`
+	AssetID        int       `json:"asset_id" db:"asset_id"`
+	UserID         *int      `json:"user_id" db:"user_id"`
+	Username       *string   `json:"username" db:"-"`
+	Code           string    `json:"code" db:"code"`
+	MatchedBy      string    `json:"matched_by" db:"matched_by"`
+	Latitude       *float64  `json:"latitude" db:"latitude"`
+	Longitude      *float64  `json:"longitude" db:"longitude"`
+	AccuracyMeters *float64  `json:"accuracy_meters" db:"accuracy_meters"`
+	ScannedAt      time.Time `json:"scanned_at" db:"scanned_at"`
+}
+
+// Artifact is a generated file, such as a report, invoice or label sheet, kept in the
+// artifact storage until it expires
+type Artifact struct {
+	ID          string    `json:"id" db:"id"`
+	CompanyID   int       `json:"-" db:"company_id"`
+	UserID      *int      `json:"-" db:"user_id"`
+	Kind        string    `json:"kind" db:"kind"`
+	Filename    string    `json:"filename" db:"filename"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Size        int64     `json:"size" db:"size"`
+	StorageKey  string    `json:"-" db:"storage_key"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// Job is a unit of background work, such as a label sheet or report, queued by a user
+type Job struct {
+	ID              int64           `json:"id" db:"id"`
+	CompanyID       int             `json:"-" db:"company_id"`
+	UserID          *int            `json:"-" db:"user_id"`
+	Type            string          `json:"type" db:"job_type"`
+	Payload         json.RawMessage `json:"-" db:"payload"`
+	Status          string          `json:"status" db:"status"`
+	Progress        int             `json:"progress" db:"progress"`
+	Attempts        int             `json:"attempts" db:"attempts"`
+	MaxAttempts     int             `json:"max_attempts" db:"max_attempts"`
+	CancelRequested bool            `json:"cancel_requested" db:"cancel_requested"`
+	Error           *string         `json:"error" db:"error"`
+	Result          json.RawMessage `json:"result" db:"result"`
+	ArtifactID      *string         `json:"artifact_id" db:"artifact_id"`
+	ArtifactName    *string         `json:"artifact_name" db:"artifact_name"`
+	DownloadURL     string          `json:"download_url,omitempty" db:"-"`
+	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
+	StartedAt       *time.Time      `json:"started_at" db:"started_at"`
+	FinishedAt      *time.Time      `json:"finished_at" db:"finished_at"`
+	ExpiresAt       *time.Time      `json:"expires_at" db:"expires_at"`
+}
+
+// CheckOutRequest represents checking an asset out to a user
+type CheckOutRequest struct {
+	AssignedTo int    `json:"assigned_to" binding:"required"`
+	DueDate    string `json:"due_date"`
+	Notes      string `json:"notes"`
+}
+
+// CheckInRequest represents returning a checked-out asset
+type CheckInRequest struct {
+	Notes string `json:"notes"`
 }
 
 // CompanySetting represents company settings
@@ -128,6 +327,8 @@ type LoginRequest struct {
 	Username    string `json:"username" binding:"required"`
 	Password    string `json:"password" binding:"required"`
 	CompanyCode string `json:"company_code"` // optional; if empty, login resolves by username only
+	// EnrollmentCode lets a user company policy requires 2FA of set it up at this login
+	EnrollmentCode string `json:"enrollment_code"`
 }
 
 // RegisterCompanyRequest represents company registration request
@@ -158,7 +359,7 @@ type AddUserRequest struct {
 	Password  string `json:"password" binding:"required,min=6"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
-	Role      string `json:"role"` // admin, manager, user
+	Role      string `json:"role"` // admin, manager, user or a custom role
 }
 
 // UpdateUserRequest represents updating an existing user
@@ -167,7 +368,7 @@ type UpdateUserRequest struct {
 	Email     string `json:"email"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
-	Role      string `json:"role"` // admin, manager, user
+	Role      string `json:"role"` // admin, manager, user or a custom role
 	IsActive  *bool  `json:"is_active"`
 }
 
@@ -217,6 +418,9 @@ type SearchAssetsRequest struct {
 	Department      string `json:"department"`
 	Status          string `json:"status"`
 	CategoryID      *int   `json:"category_id"`
+	CustomFields    map[string]string `json:"custom_fields"`
+	Page            int    `json:"page"`
+	PageSize        int    `json:"page_size"`
 }
 
 // AssetRequest represents asset creation/update request (legacy compatibility)
@@ -233,6 +437,7 @@ type AssetRequest struct {
 	Status          string  `json:"status"`
 	PurchaseDate    string  `json:"purchaseDate"`
 	PurchasePrice   float64 `json:"purchasePrice"`
+	CustomFields    map[string]interface{} `json:"customFields"`
 }
 
 // MultipleAssetRequest represents multiple asset creation request
@@ -240,34 +445,60 @@ type MultipleAssetRequest struct {
 	Assets []AssetRequest `json:"assets" binding:"required"`
 }
 
+// LabelOptions are the output options shared by the barcode requests
+type LabelOptions struct {
+	Symbology  string           `json:"symbology" form:"symbology"`
+	TemplateID labelTemplateRef `json:"templateId" form:"templateId"`
+	Format     string           `json:"format" form:"format"` // pdf (default), zpl or epl
+	DPI        int              `json:"dpi" form:"dpi"`       // printer resolution for zpl and epl
+}
+
 // BarcodeRequest represents barcode generation request
 type BarcodeRequest struct {
 	AssetIDs []int `json:"assetIds" binding:"required"`
+	LabelOptions
 }
 
 // InstitutionBarcodeRequest represents institution-based barcode generation
 type InstitutionBarcodeRequest struct {
 	Institution string `json:"institution" binding:"required"`
+	LabelOptions
 }
 
 // InstitutionDepartmentBarcodeRequest represents institution and department barcode generation
 type InstitutionDepartmentBarcodeRequest struct {
 	Institution string `json:"institution" binding:"required"`
 	Department  string `json:"department" binding:"required"`
+	LabelOptions
+}
+
+// AllInstitutionsBarcodeRequest holds the query parameters of the all-institutions batch
+type AllInstitutionsBarcodeRequest struct {
+	LabelOptions
+	Output string `form:"output"` // single (default) or zip
 }
 
 // ReportRequest represents report generation request
 type ReportRequest struct {
-	AssetType       string   `json:"assetType"`
-	Location        string   `json:"location"`
-	Status          string   `json:"status"`
-	StartDate       string   `json:"startDate"`
-	EndDate         string   `json:"endDate"`
-	Manufacturer    []string `json:"manufacturer"`
-	ModelNumber     string   `json:"modelNumber"`
-	InstitutionName string   `json:"institutionName"`
-	Department      string   `json:"department"`
-	FunctionalArea  string   `json:"functionalArea"`
+	AssetType       string   `json:"assetType" form:"assetType"`
+	Location        string   `json:"location" form:"location"`
+	Status          string   `json:"status" form:"status"`
+	StartDate       string   `json:"startDate" form:"startDate"`
+	EndDate         string   `json:"endDate" form:"endDate"`
+	Manufacturer    []string `json:"manufacturer" form:"manufacturer"`
+	ModelNumber     string   `json:"modelNumber" form:"modelNumber"`
+	InstitutionName string   `json:"institutionName" form:"institutionName"`
+	Department      string   `json:"department" form:"department"`
+	FunctionalArea  string   `json:"functionalArea" form:"functionalArea"`
+	CustomFields    map[string]string `json:"customFields" form:"-"` // read from customFields[key] in query strings
+}
+
+// ExportRequest represents a streaming asset export request: report filters plus
+// an output format (csv, xlsx, jsonl or pdf) and the columns to include
+type ExportRequest struct {
+	ReportRequest
+	Format  string   `json:"format" form:"format"`
+	Columns []string `json:"columns" form:"columns"`
 }
 
 // InvoiceRequest represents invoice generation request
@@ -289,6 +520,9 @@ type AddCategoryRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
 	Color       string `json:"color"`
+	DepreciationMethod string   `json:"depreciation_method"`
+	UsefulLifeYears    *int     `json:"useful_life_years"`
+	SalvagePercent     *float64 `json:"salvage_percent"`
 }
 
 // UpdateCategoryRequest represents updating an asset category
@@ -297,15 +531,204 @@ type UpdateCategoryRequest struct {
 	Description string `json:"description"`
 	Color       string `json:"color"`
 	IsActive    *bool  `json:"is_active"`
+	DepreciationMethod string   `json:"depreciation_method"`
+	UsefulLifeYears    *int     `json:"useful_life_years"`
+	SalvagePercent     *float64 `json:"salvage_percent"`
+}
+
+// DepreciationSettingsRequest represents overriding an asset's category depreciation.
+// Unset fields fall back to the category's defaults.
+type DepreciationSettingsRequest struct {
+	DepreciationMethod string   `json:"depreciation_method"`
+	UsefulLifeYears    *int     `json:"useful_life_years"`
+	SalvageValue       *float64 `json:"salvage_value"`
+}
+
+// DepreciationPeriod represents one year of an asset's depreciation schedule
+type DepreciationPeriod struct {
+	Year                    int     `json:"year"`
+	PeriodStart             string  `json:"period_start"`
+	PeriodEnd               string  `json:"period_end"`
+	OpeningBookValue        float64 `json:"opening_book_value"`
+	Depreciation            float64 `json:"depreciation"`
+	AccumulatedDepreciation float64 `json:"accumulated_depreciation"`
+	ClosingBookValue        float64 `json:"closing_book_value"`
+}
+
+// DepreciationRegisterRow represents one asset's line in the period-end depreciation register
+type DepreciationRegisterRow struct {
+	AssetID            int      `json:"asset_id,omitempty"`
+	AssetName          string   `json:"asset_name"`
+	CategoryID         *int     `json:"category_id,omitempty"`
+	InstitutionName    *string  `json:"institution_name,omitempty"`
+	Department         *string  `json:"department,omitempty"`
+	Method             string   `json:"method,omitempty"`
+	UsefulLifeYears    int      `json:"useful_life_years,omitempty"`
+	InServiceDate      string   `json:"in_service_date,omitempty"`
+	DisposedDate       string   `json:"disposed_date,omitempty"`
+	Cost               float64  `json:"cost"`
+	SalvageValue       float64  `json:"salvage_value"`
+	OpeningAccumulated float64  `json:"opening_accumulated"`
+	PeriodDepreciation float64  `json:"period_depreciation"`
+	ClosingAccumulated float64  `json:"closing_accumulated"`
+	BookValue          float64  `json:"book_value"`
+}
+
+// DepreciationSchedule represents the full depreciation schedule of an asset
+type DepreciationSchedule struct {
+	AssetID                 int                  `json:"asset_id"`
+	AssetName               string               `json:"asset_name"`
+	Method                  string               `json:"method"`
+	UsefulLifeYears         int                  `json:"useful_life_years"`
+	Cost                    float64              `json:"cost"`
+	SalvageValue            float64              `json:"salvage_value"`
+	InServiceDate           string               `json:"in_service_date"`
+	AccumulatedDepreciation float64              `json:"accumulated_depreciation"`
+	BookValue               float64              `json:"book_value"`
+	Periods                 []DepreciationPeriod `json:"periods"`
 }
 
 // LoginResponse represents login response
 type LoginResponse struct {
-	Token     string  `json:"token"`
-	User      User    `json:"user"`
-	Company   Company `json:"company"`
-	ExpiresAt int64   `json:"expires_at"`
-	Roles     []string `json:"roles"`
+	Token            string   `json:"token"`
+	RefreshToken     string   `json:"refresh_token"`
+	User             User     `json:"user"`
+	Company          Company  `json:"company"`
+	ExpiresAt        int64    `json:"expires_at"`
+	RefreshExpiresAt int64    `json:"refresh_expires_at"`
+	Roles            []string `json:"roles"`
+	Permissions      []string `json:"permissions"`
+	RecoveryCodes    []string `json:"recovery_codes,omitempty"`
+}
+
+// TokenResponse is a new access token and refresh token of a session. Expiry times are
+// Unix seconds.
+type TokenResponse struct {
+	Token            string `json:"token"`
+	ExpiresAt        int64  `json:"expires_at"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresAt int64  `json:"refresh_expires_at"`
+}
+
+// RefreshRequest exchanges a refresh token for new tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// TOTPSetup is what an authenticator app needs to enroll: the secret, as text and as
+// an otpauth:// URL, and a QR code of the URL as a PNG data URI
+type TOTPSetup struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+	QRCode     string `json:"qr_code"`
+}
+
+// TwoFactorChallenge is returned by login instead of tokens when a second factor is
+// needed. TwoFactor is "verify" for enrolled users and "enroll" for users company policy
+// requires to set up 2FA who signed in with an enrollment code; they also get the setup
+// to scan.
+type TwoFactorChallenge struct {
+	TwoFactor      string     `json:"two_factor"`
+	ChallengeToken string     `json:"challenge_token"`
+	ExpiresAt      int64      `json:"expires_at"`
+	Setup          *TOTPSetup `json:"setup,omitempty"`
+}
+
+// LoginTwoFactorRequest completes a login with an authenticator code or a recovery code
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// TwoFactorCodeRequest carries a code from the user's authenticator app
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTwoFactorRequest confirms turning 2FA off with the password and a second factor
+type DisableTwoFactorRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// CompanySSO is a company's OpenID Connect single sign-on configuration. The client
+// secret is never returned. RedirectURI is the callback to register at the provider and
+// LoginURL where users start signing in.
+type CompanySSO struct {
+	Configured           bool              `json:"configured"`
+	Issuer               string            `json:"issuer"`
+	ClientID             string            `json:"client_id"`
+	ClientSecret         string            `json:"-"`
+	ClientSecretSet      bool              `json:"client_secret_set"`
+	Scopes               string            `json:"scopes"`
+	RoleClaim            string            `json:"role_claim"`
+	RoleMapping          map[string]string `json:"role_mapping"`
+	DefaultRole          string            `json:"default_role"`
+	AutoProvision        bool              `json:"auto_provision"`
+	DisablePasswordLogin bool              `json:"disable_password_login"`
+	Enabled              bool              `json:"enabled"`
+	ConfiguredBy         *int              `json:"configured_by"`
+	RedirectURI          string            `json:"redirect_uri"`
+	LoginURL             string            `json:"login_url"`
+	UpdatedAt            time.Time         `json:"updated_at"`
+}
+
+// CompanySSORequest configures single sign-on. An empty client_secret keeps the stored one.
+type CompanySSORequest struct {
+	Issuer               string            `json:"issuer" binding:"required"`
+	ClientID             string            `json:"client_id" binding:"required"`
+	ClientSecret         string            `json:"client_secret"`
+	Scopes               string            `json:"scopes"`
+	RoleClaim            string            `json:"role_claim"`
+	RoleMapping          map[string]string `json:"role_mapping"`
+	DefaultRole          string            `json:"default_role"`
+	AutoProvision        *bool             `json:"auto_provision"`
+	DisablePasswordLogin *bool             `json:"disable_password_login"`
+	Enabled              *bool             `json:"enabled"`
+}
+
+// SSOTicketRequest exchanges the ticket of a finished single sign-on for tokens
+type SSOTicketRequest struct {
+	Ticket         string `json:"ticket" binding:"required"`
+	EnrollmentCode string `json:"enrollment_code"`
+}
+
+// Permission is an action a role can allow
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Role is a named set of permissions users are given. Built-in roles exist in every
+// company and cannot be changed; companies define custom roles next to them.
+type Role struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Permissions []string   `json:"permissions"`
+	BuiltIn     bool       `json:"built_in"`
+	UserCount   int        `json:"user_count"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+// RoleRequest creates or changes a custom role. The name cannot be changed later.
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// UserSession is a signed-in device of a user
+type UserSession struct {
+	ID         string    `json:"id" db:"id"`
+	UserAgent  *string   `json:"user_agent" db:"user_agent"`
+	IPAddress  *string   `json:"ip_address" db:"ip_address"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+	Current    bool      `json:"current" db:"-"`
 }
 
 // APIResponse represents generic API response
@@ -323,6 +746,58 @@ type PaginatedResponse struct {
 	Page       int         `json:"page"`
 	PageSize   int         `json:"page_size"`
 	TotalPages int         `json:"total_pages"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// FieldChange is the before and after value of one field in an asset history entry
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AssetHistoryEntry represents one recorded change to an asset
+type AssetHistoryEntry struct {
+	ID        int64                  `json:"id" db:"id"`
+	AssetID   int                    `json:"asset_id" db:"asset_id"`
+	Action    string                 `json:"action" db:"action"`
+	Changes   map[string]FieldChange `json:"changes" db:"changes"`
+	UserID    *int                   `json:"user_id" db:"user_id"`
+	Username  *string                `json:"username"`
+	RequestID *string                `json:"request_id" db:"request_id"`
+	CreatedAt time.Time              `json:"created_at" db:"created_at"`
+}
+
+// ImportRowError describes one problem found in a spreadsheet row during import
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ImportResult represents the outcome of a spreadsheet import or dry run
+type ImportResult struct {
+	DryRun    bool              `json:"dry_run"`
+	TotalRows int               `json:"total_rows"`
+	ValidRows int               `json:"valid_rows"`
+	Errors    []ImportRowError  `json:"errors"`
+	Mapping   map[string]string `json:"mapping"`
+	AssetIDs  []int64           `json:"asset_ids,omitempty"`
+}
+
+// FacetCount is the number of search results sharing one value of a facet
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// SearchResponse represents a page of search results with facet counts
+type SearchResponse struct {
+	Data       interface{}             `json:"data"`
+	Total      int                     `json:"total"`
+	Page       int                     `json:"page"`
+	PageSize   int                     `json:"page_size"`
+	TotalPages int                     `json:"total_pages"`
+	Facets     map[string][]FacetCount `json:"facets"`
 }
 
 // DashboardStats represents dashboard statistics
@@ -331,6 +806,7 @@ type DashboardStats struct {
 	ActiveAssets    int     `json:"active_assets"`
 	TotalUsers      int     `json:"total_users"`
 	TotalValue      float64 `json:"total_value"`
+	TotalBookValue  float64 `json:"total_book_value"`
 	TotalBarcodes   int     `json:"total_barcodes"`
 	ScannedBarcodes int     `json:"scanned_barcodes"`
 	AssetsByStatus  map[string]int `json:"assets_by_status"`
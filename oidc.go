@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcRequestTimeout bounds every request to an identity provider
+	oidcRequestTimeout = 10 * time.Second
+
+	// oidcCacheTTL is how long discovery documents and signing keys are reused
+	oidcCacheTTL = time.Hour
+
+	// oidcKeyRefreshInterval limits refetching the signing keys for unknown key IDs
+	oidcKeyRefreshInterval = time.Minute
+
+	// oidcMaxResponseBytes caps the size of identity provider responses
+	oidcMaxResponseBytes = 1 << 20
+)
+
+// oidcSigningMethods are the ID token algorithms accepted. Symmetric (HS*) ID tokens
+// are not supported.
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// oidcHTTPClient talks to identity providers. It connects directly rather than through
+// a proxy, so oidcDialControl checks the address of the provider itself, including after
+// redirects and DNS changes.
+var oidcHTTPClient = &http.Client{
+	Timeout: oidcRequestTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: oidcRequestTimeout, Control: oidcDialControl}).DialContext,
+		TLSHandshakeTimeout: oidcRequestTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+// oidcAllowInsecure reports whether OIDC_ALLOW_INSECURE_ISSUERS lets providers use plain
+// http and internal addresses, to test against a mock provider
+func oidcAllowInsecure() bool {
+	allow, _ := strconv.ParseBool(os.Getenv("OIDC_ALLOW_INSECURE_ISSUERS"))
+	return allow
+}
+
+// oidcInternalIP reports whether an address is loopback, link-local, private or otherwise
+// not a public host. Providers are configured by company admins, so requests to such
+// addresses would let them probe this server's network and cloud metadata services.
+func oidcInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// oidcDialControl refuses connections to internal addresses, checked after DNS resolution
+func oidcDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && oidcInternalIP(ip) && !oidcAllowInsecure() {
+		return fmt.Errorf("identity provider address %s is not public", host)
+	}
+	return nil
+}
+
+// oidcProvider is the part of an identity provider's discovery document that is used
+type oidcProvider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// oidcKeySet is the signing keys of a provider, by key ID
+type oidcKeySet struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// oidcCache holds discovery documents by issuer and key sets by JWKS URL
+var oidcCache = struct {
+	sync.Mutex
+	providers  map[string]*oidcProvider
+	discovered map[string]time.Time
+	keySets    map[string]*oidcKeySet
+}{
+	providers:  make(map[string]*oidcProvider),
+	discovered: make(map[string]time.Time),
+	keySets:    make(map[string]*oidcKeySet),
+}
+
+// oidcIdentity is who an ID token says signed in
+type oidcIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	FirstName     string
+	LastName      string
+	Claims        jwt.MapClaims
+}
+
+// validateOIDCIssuer requires an absolute https issuer URL on a public host
+func validateOIDCIssuer(issuer string) error {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("issuer must be an absolute URL without query or fragment")
+	}
+	return validateOIDCURL("issuer", issuer)
+}
+
+// validateOIDCURL requires an https URL whose host is not localhost or an internal IP
+// address. With OIDC_ALLOW_INSECURE_ISSUERS=true plain http and any host are accepted, to
+// test against a mock provider. Host names are checked again when connecting.
+func validateOIDCURL(name, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute URL", name)
+	}
+	if oidcAllowInsecure() {
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return fmt.Errorf("%s must use https", name)
+		}
+		return nil
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%s must use https", name)
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && oidcInternalIP(ip)) {
+		return fmt.Errorf("%s must be on a public host", name)
+	}
+	return nil
+}
+
+// oidcGetJSON fetches a JSON document from an identity provider
+func oidcGetJSON(rawURL string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseBytes)).Decode(v)
+}
+
+// discoverOIDC returns the discovery document of an issuer. The document must name the
+// same issuer, so a provider cannot speak for another.
+func discoverOIDC(issuer string) (*oidcProvider, error) {
+	oidcCache.Lock()
+	provider, ok := oidcCache.providers[issuer]
+	if ok && time.Since(oidcCache.discovered[issuer]) < oidcCacheTTL {
+		oidcCache.Unlock()
+		return provider, nil
+	}
+	oidcCache.Unlock()
+
+	var doc oidcProvider
+	if err := oidcGetJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, err
+	}
+	if doc.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q, not %q", doc.Issuer, issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks the authorization, token or JWKS endpoint")
+	}
+	// The browser is sent to the authorization endpoint; this server fetches the others
+	endpoints := []struct{ name, url string }{
+		{"authorization_endpoint", doc.AuthorizationEndpoint},
+		{"token_endpoint", doc.TokenEndpoint},
+		{"jwks_uri", doc.JWKSURI},
+	}
+	for _, endpoint := range endpoints {
+		if err := validateOIDCURL(endpoint.name, endpoint.url); err != nil {
+			return nil, fmt.Errorf("discovery document: %v", err)
+		}
+	}
+
+	oidcCache.Lock()
+	oidcCache.providers[issuer] = &doc
+	oidcCache.discovered[issuer] = time.Now()
+	oidcCache.Unlock()
+	return &doc, nil
+}
+
+// signingKey returns the provider key with an ID. Keys are refetched when one is
+// unknown, as providers rotate them, but at most once per oidcKeyRefreshInterval.
+func (p *oidcProvider) signingKey(kid string) (crypto.PublicKey, error) {
+	oidcCache.Lock()
+	set := oidcCache.keySets[p.JWKSURI]
+	oidcCache.Unlock()
+
+	if set != nil {
+		if key, ok := set.keys[kid]; ok && time.Since(set.fetchedAt) < oidcCacheTTL {
+			return key, nil
+		}
+	}
+	if set == nil || time.Since(set.fetchedAt) >= oidcKeyRefreshInterval {
+		var doc struct {
+			Keys []JWK `json:"keys"`
+		}
+		if err := oidcGetJSON(p.JWKSURI, &doc); err != nil {
+			return nil, err
+		}
+		set = &oidcKeySet{keys: make(map[string]crypto.PublicKey), fetchedAt: time.Now()}
+		for _, jwk := range doc.Keys {
+			if jwk.Use != "" && jwk.Use != "sig" {
+				continue
+			}
+			// Keys of unsupported types are skipped; tokens signed with them are refused
+			if key, err := parseJWK(jwk); err == nil {
+				set.keys[jwk.Kid] = key
+			}
+		}
+		oidcCache.Lock()
+		oidcCache.keySets[p.JWKSURI] = set
+		oidcCache.Unlock()
+	}
+
+	key, ok := set.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// parseJWK reads an RSA, EC or Ed25519 public key in JSON Web Key form
+func parseJWK(jwk JWK) (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("unsupported OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+// pkceChallenge returns the S256 code challenge of a PKCE code verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorizationURL is where a browser is sent to sign in at the provider
+func (p *oidcProvider) authorizationURL(clientID, redirectURI, scopes, state, nonce, verifier string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", clientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", scopes)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", pkceChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// exchangeCode trades an authorization code for the ID token. The client authenticates
+// with HTTP Basic unless the provider only supports client_secret_post.
+func (p *oidcProvider) exchangeCode(clientID, clientSecret, code, redirectURI, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", verifier)
+
+	basic := len(p.TokenAuthMethods) == 0
+	for _, method := range p.TokenAuthMethods {
+		if method == "client_secret_basic" {
+			basic = true
+		}
+	}
+	if !basic {
+		form.Set("client_id", clientID)
+		form.Set("client_secret", clientSecret)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		// RFC 6749 section 2.3.1: the credentials are form-encoded before Basic encoding
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseBytes)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint returned no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry and nonce and
+// returns who it identifies
+func (p *oidcProvider) verifyIDToken(rawIDToken, clientID, nonce string) (*oidcIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(kid)
+	},
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if exp, err := claims.GetExpirationTime(); err != nil || exp == nil {
+		return nil, errors.New("ID token has no expiry")
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != clientID {
+			return nil, errors.New("ID token was issued to another client")
+		}
+	}
+
+	identity := &oidcIdentity{Claims: claims}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	identity.Email, _ = claims["email"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+	identity.Username, _ = claims["preferred_username"].(string)
+	identity.FirstName, _ = claims["given_name"].(string)
+	identity.LastName, _ = claims["family_name"].(string)
+	return identity, nil
+}
+
+// claimValues returns the string values of a claim, which may be a string or a list.
+// A dotted path reaches into nested claims, such as Keycloak's realm_access.roles.
+func (i *oidcIdentity) claimValues(path string) []string {
+	var value interface{} = map[string]interface{}(i.Claims)
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
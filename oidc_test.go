@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateOIDCURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://login.example.edu/realms/staff", true},
+		{"http://login.example.edu", false},
+		{"https://localhost:8443", false},
+		{"https://127.0.0.1/jwks", false},
+		{"https://169.254.169.254/latest/meta-data/", false},
+		{"https://10.0.0.5/token", false},
+		{"https://192.168.1.1/token", false},
+		{"https://[::1]/token", false},
+		{"https://[fe80::1]/token", false},
+		{"javascript:alert(1)", false},
+		{"/token", false},
+	}
+	for _, tt := range tests {
+		if err := validateOIDCURL("jwks_uri", tt.url); (err == nil) != tt.ok {
+			t.Errorf("validateOIDCURL(%q) = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+
+	t.Setenv("OIDC_ALLOW_INSECURE_ISSUERS", "true")
+	if err := validateOIDCURL("issuer", "http://localhost:8080/default"); err != nil {
+		t.Errorf("mock provider with OIDC_ALLOW_INSECURE_ISSUERS: %v", err)
+	}
+	if err := validateOIDCURL("issuer", "ftp://localhost/default"); err == nil {
+		t.Error("ftp issuer accepted")
+	}
+}
+
+// TestOIDCRefusesInternalAddresses checks the connection itself, for host names that
+// pass validation but resolve to an internal address
+func TestOIDCRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached the internal server")
+	}))
+	defer server.Close()
+
+	t.Setenv("OIDC_ALLOW_INSECURE_ISSUERS", "false")
+	var doc oidcProvider
+	err := oidcGetJSON(server.URL+"/.well-known/openid-configuration", &doc)
+	if err == nil || !strings.Contains(err.Error(), "is not public") {
+		t.Fatalf("got %v, want the connection refused", err)
+	}
+}
+
+func TestDiscoveryValidatesEndpoints(t *testing.T) {
+	t.Setenv("OIDC_ALLOW_INSECURE_ISSUERS", "true")
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"issuer":"` + issuer + `","authorization_endpoint":"` + issuer + `/auth",` +
+			`"token_endpoint":"` + issuer + `/token","jwks_uri":"file:///etc/passwd"}`))
+	}))
+	defer server.Close()
+	issuer = server.URL
+
+	_, err := discoverOIDC(issuer)
+	if err == nil || !strings.Contains(err.Error(), "jwks_uri") {
+		t.Fatalf("got %v, want the jwks_uri refused", err)
+	}
+}
+
+// testIdP is an identity provider with one RSA signing key, which issues the ID token
+// its test sets for the code "good-code"
+type testIdP struct {
+	t         *testing.T
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	idToken   string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{t: t, key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/auth",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]JWK{"keys": {{
+			Kty: "RSA", Kid: "test-key", Use: "sig", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, _ := r.BasicAuth()
+		switch {
+		case clientID != "asset-tagging" || secret != "client-secret":
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		case r.PostFormValue("code") != "good-code" || pkceChallenge(r.PostFormValue("code_verifier")) != idp.challenge:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken, "token_type": "Bearer"})
+		}
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// sign returns an ID token with claims, signed with key under the provider's key ID
+func (idp *testIdP) sign(key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOIDCLogin(t *testing.T) {
+	t.Setenv("OIDC_ALLOW_INSECURE_ISSUERS", "true")
+	idp := newTestIdP(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := discoverOIDC(idp.server.URL)
+	if err != nil {
+		t.Fatalf("discoverOIDC: %v", err)
+	}
+	if provider.TokenEndpoint != idp.server.URL+"/token" {
+		t.Fatalf("token endpoint %q", provider.TokenEndpoint)
+	}
+
+	const redirectURI, nonce, verifier = "https://api.example.com/api/sso/callback", "the-nonce", "the-verifier"
+	authURL, err := url.Parse(provider.authorizationURL("asset-tagging", redirectURI, defaultSSOScopes, "state", nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.challenge = authURL.Query().Get("code_challenge")
+
+	claims := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss": idp.server.URL, "aud": "asset-tagging", "sub": "user-1", "nonce": nonce,
+			"email": "ada@example.edu", "email_verified": true, "preferred_username": "ada",
+			"iat": time.Now().Unix(), "exp": time.Now().Add(5 * time.Minute).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	tests := []struct {
+		name    string
+		idToken string
+		ok      bool
+	}{
+		{"valid", idp.sign(idp.key, claims(nil)), true},
+		{"wrong nonce", idp.sign(idp.key, claims(func(c jwt.MapClaims) { c["nonce"] = "replayed" })), false},
+		{"no nonce", idp.sign(idp.key, claims(func(c jwt.MapClaims) { delete(c, "nonce") })), false},
+		{"wrong audience", idp.sign(idp.key, claims(func(c jwt.MapClaims) { c["aud"] = "another-app" })), false},
+		{"wrong issuer", idp.sign(idp.key, claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })), false},
+		{"expired", idp.sign(idp.key, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), false},
+		{"bad signature", idp.sign(otherKey, claims(nil)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.idToken = tt.idToken
+			rawIDToken, err := provider.exchangeCode("asset-tagging", "client-secret", "good-code", redirectURI, verifier)
+			if err != nil {
+				t.Fatalf("exchangeCode: %v", err)
+			}
+			identity, err := provider.verifyIDToken(rawIDToken, "asset-tagging", nonce)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("ID token accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyIDToken: %v", err)
+			}
+			if identity.Subject != "user-1" || identity.Email != "ada@example.edu" || !identity.EmailVerified || identity.Username != "ada" {
+				t.Fatalf("identity = %+v", identity)
+			}
+		})
+	}
+
+	if _, err := provider.exchangeCode("asset-tagging", "client-secret", "good-code", redirectURI, "another-verifier"); err == nil {
+		t.Error("code redeemed with the wrong PKCE verifier")
+	}
+	if _, err := provider.exchangeCode("asset-tagging", "wrong-secret", "good-code", redirectURI, verifier); err == nil {
+		t.Error("code redeemed with the wrong client secret")
+	}
+}
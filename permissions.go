@@ -0,0 +1,768 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permissions that requirePermission guards routes with
+const (
+	permAssetsRead         = "assets:read"
+	permAssetsWrite        = "assets:write"
+	permAssetsDelete       = "assets:delete"
+	permAssetsPurge        = "assets:purge"
+	permAssetsTag          = "assets:tag"
+	permCategoriesManage   = "categories:manage"
+	permCustomFieldsManage = "custom_fields:manage"
+	permMaintenanceWrite   = "maintenance:write"
+	permLabelsManage       = "labels:manage"
+	permBarcodesPrint      = "barcodes:print"
+	permReportsExport      = "reports:export"
+	permUsersManage        = "users:manage"
+	permRolesManage        = "roles:manage"
+	permCompanyManage      = "company:manage"
+)
+
+// permissionCatalog lists every permission with what it allows, in display order
+var permissionCatalog = []Permission{
+	{permAssetsRead, "View assets, their history, attachments and the dashboard"},
+	{permAssetsWrite, "Add, edit, import, check out and check in assets and upload attachments"},
+	{permAssetsDelete, "Move assets to the trash"},
+	{permAssetsPurge, "Permanently delete assets from the trash"},
+	{permAssetsTag, "Assign asset tags in bulk"},
+	{permCategoriesManage, "Add, edit and delete asset categories"},
+	{permCustomFieldsManage, "Define custom asset fields"},
+	{permMaintenanceWrite, "Schedule, update and complete maintenance work orders"},
+	{permLabelsManage, "Create and edit label templates"},
+	{permBarcodesPrint, "Generate and print barcode labels"},
+	{permReportsExport, "Generate reports and export assets"},
+	{permUsersManage, "Add, edit and deactivate users and end their sessions"},
+	{permRolesManage, "Create and edit custom roles"},
+	{permCompanyManage, "Change company details, settings and single sign-on"},
+}
+
+// adminPermissions are the permissions that administer the company rather than its
+// assets. Company 2FA policy covers every role holding one.
+var adminPermissions = []string{permUsersManage, permRolesManage, permCompanyManage}
+
+// builtInRoleOrder lists the built-in roles from most to least privileged
+var builtInRoleOrder = []string{"admin", "manager", "user"}
+
+// builtInRoles are the roles every company has. Admins may do everything, managers
+// everything but administer the company or purge assets, and users work with assets.
+var builtInRoles = func() map[string]Role {
+	all := make([]string, len(permissionCatalog))
+	for i, p := range permissionCatalog {
+		all[i] = p.Name
+	}
+	var manager []string
+	for _, p := range all {
+		if p != permUsersManage && p != permRolesManage && p != permCompanyManage && p != permAssetsPurge {
+			manager = append(manager, p)
+		}
+	}
+	return map[string]Role{
+		"admin":   {Name: "admin", Description: "Full access", Permissions: all, BuiltIn: true},
+		"manager": {Name: "manager", Description: "Manages assets and their configuration", Permissions: manager, BuiltIn: true},
+		"user": {Name: "user", Description: "Works with assets", BuiltIn: true, Permissions: []string{
+			permAssetsRead, permAssetsWrite, permMaintenanceWrite, permBarcodesPrint, permReportsExport,
+		}},
+	}
+}()
+
+// roleNamePattern is what a custom role name must look like
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// isPermission reports whether a permission exists
+func isPermission(name string) bool {
+	for _, p := range permissionCatalog {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedPermissions lists a permission set in catalog order
+func sortedPermissions(perms map[string]bool) []string {
+	list := []string{}
+	for _, p := range permissionCatalog {
+		if perms[p.Name] {
+			list = append(list, p.Name)
+		}
+	}
+	return list
+}
+
+// rolePermissions returns the permissions of a built-in or custom role of a company;
+// ok is false when the role does not exist
+func rolePermissions(companyID int, role string) (perms map[string]bool, ok bool, err error) {
+	perms = make(map[string]bool)
+	if r, found := builtInRoles[role]; found {
+		for _, p := range r.Permissions {
+			perms[p] = true
+		}
+		return perms, true, nil
+	}
+
+	rows, err := db.Query(`
+		SELECT p.permission
+		FROM company_roles r
+		LEFT JOIN company_role_permissions p ON p.role_id = r.id
+		WHERE r.company_id = ? AND r.name = ?`, companyID, role)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p sql.NullString
+		if err := rows.Scan(&p); err != nil {
+			return nil, false, err
+		}
+		ok = true
+		if p.Valid {
+			perms[p.String] = true
+		}
+	}
+	return perms, ok, rows.Err()
+}
+
+// currentPermissions returns the permissions of the current user's role, loaded once
+// per request
+func currentPermissions(c *gin.Context) (map[string]bool, error) {
+	if perms, ok := c.Get("permissions"); ok {
+		return perms.(map[string]bool), nil
+	}
+	user := getCurrentUser(c)
+	if user == nil {
+		return map[string]bool{}, nil
+	}
+	perms, _, err := rolePermissions(user.CompanyID, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	c.Set("permissions", perms)
+	return perms, nil
+}
+
+// requirePermission allows a request only when the current user's role grants a permission
+func requirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if getCurrentUserID(c) == 0 {
+			c.JSON(http.StatusUnauthorized, APIResponse{
+				Success: false,
+				Error:   "Unauthorized",
+			})
+			c.Abort()
+			return
+		}
+
+		perms, err := currentPermissions(c)
+		if err != nil {
+			log.Printf("Error loading permissions: %v", err)
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   "Failed to get user permissions",
+			})
+			c.Abort()
+			return
+		}
+		if !perms[permission] {
+			c.JSON(http.StatusForbidden, APIResponse{
+				Success: false,
+				Error:   "Permission required: " + permission,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// legacyRoles names a permission set the way the user_roles table did, for clients
+// that still read the login response's roles
+func legacyRoles(perms map[string]bool) []string {
+	roles := []string{}
+	if perms[permUsersManage] {
+		roles = append(roles, "userManagement")
+	}
+	if perms[permAssetsWrite] {
+		roles = append(roles, "assetManagement")
+	}
+	if perms[permBarcodesPrint] {
+		roles = append(roles, "encodeAssets")
+	}
+	return roles
+}
+
+// permissionsLacking lists the permissions of a set that another set does not include
+func permissionsLacking(own, perms map[string]bool) []string {
+	var missing []string
+	for _, p := range sortedPermissions(perms) {
+		if !own[p] {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// missingPermissions lists the permissions of a set the current user does not hold
+func missingPermissions(c *gin.Context, perms map[string]bool) ([]string, error) {
+	own, err := currentPermissions(c)
+	if err != nil {
+		return nil, err
+	}
+	return permissionsLacking(own, perms), nil
+}
+
+// checkGrantable makes sure the current user holds every permission they grant, so
+// nobody can give away more access than they have. It returns the HTTP status and error
+// to respond with, or 0.
+func checkGrantable(c *gin.Context, perms map[string]bool) (int, string) {
+	missing, err := missingPermissions(c, perms)
+	if err != nil {
+		log.Printf("Error loading permissions: %v", err)
+		return http.StatusInternalServerError, "Failed to get user permissions"
+	}
+	if len(missing) > 0 {
+		return http.StatusForbidden, "You cannot grant permissions you do not have: " + strings.Join(missing, ", ")
+	}
+	return 0, ""
+}
+
+// checkAssignableRole checks that a role exists in the company and that the current user
+// may give it to someone. It returns the HTTP status and error to respond with, or 0.
+func checkAssignableRole(c *gin.Context, companyID int, role string) (int, string) {
+	perms, ok, err := rolePermissions(companyID, role)
+	if err != nil {
+		log.Printf("Error loading role %s: %v", role, err)
+		return http.StatusInternalServerError, "Internal Server Error"
+	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Sprintf("Unknown role %q", role)
+	}
+	return checkGrantable(c, perms)
+}
+
+// checkManageableUser checks that a user exists in the company and that the current user
+// holds every permission of their role, so nobody can edit, deactivate or reset the 2FA
+// of a more privileged user. It returns the HTTP status and error to respond with, or 0.
+func checkManageableUser(c *gin.Context, companyID, userID int) (int, string) {
+	var role string
+	err := db.QueryRow("SELECT role FROM users WHERE id = ? AND company_id = ?", userID, companyID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return http.StatusNotFound, "User not found"
+	}
+	if err != nil {
+		log.Printf("Error fetching user %d: %v", userID, err)
+		return http.StatusInternalServerError, "Internal Server Error"
+	}
+	perms, _, err := rolePermissions(companyID, role)
+	if err != nil {
+		log.Printf("Error loading role %s: %v", role, err)
+		return http.StatusInternalServerError, "Internal Server Error"
+	}
+	missing, err := missingPermissions(c, perms)
+	if err != nil {
+		log.Printf("Error loading permissions: %v", err)
+		return http.StatusInternalServerError, "Failed to get user permissions"
+	}
+	if len(missing) > 0 {
+		return http.StatusForbidden, "You cannot manage a user with permissions you do not have: " + strings.Join(missing, ", ")
+	}
+	return 0, ""
+}
+
+// lockoutPermissions are the permissions some active user of a company must keep, or
+// nobody could manage its users or its settings again
+var lockoutPermissions = []string{permUsersManage, permCompanyManage}
+
+// countPermissionHolders counts the company's active users whose built-in or custom role
+// grants a permission, leaving out the holders of exceptRole and the user exceptUserID
+func countPermissionHolders(q rowQuerier, companyID int, permission, exceptRole string, exceptUserID int) (int, error) {
+	granted := "p.permission IS NOT NULL"
+	args := []interface{}{permission, companyID, exceptRole, exceptUserID}
+	for _, name := range builtInRoleOrder {
+		for _, p := range builtInRoles[name].Permissions {
+			if p == permission {
+				granted += " OR u.role = ?"
+				args = append(args, name)
+			}
+		}
+	}
+	var holders int
+	err := q.QueryRow(`
+		SELECT COUNT(*)
+		FROM users u
+		LEFT JOIN company_roles r ON r.company_id = u.company_id AND r.name = u.role
+		LEFT JOIN company_role_permissions p ON p.role_id = r.id AND p.permission = ?
+		WHERE u.company_id = ? AND u.is_active = true AND u.role <> ? AND u.id <> ? AND (`+granted+`)`,
+		args...).Scan(&holders)
+	return holders, err
+}
+
+// lastHeldPermission returns the first lockout permission among removed that nobody would
+// have once the holders of exceptRole or the user exceptUserID lose it, or ""
+func lastHeldPermission(q rowQuerier, companyID int, removed []string, exceptRole string, exceptUserID int) (string, error) {
+	for _, permission := range removed {
+		lockout := false
+		for _, p := range lockoutPermissions {
+			lockout = lockout || p == permission
+		}
+		if !lockout {
+			continue
+		}
+		holders, err := countPermissionHolders(q, companyID, permission, "", 0)
+		if err != nil {
+			return "", err
+		}
+		others, err := countPermissionHolders(q, companyID, permission, exceptRole, exceptUserID)
+		if err != nil {
+			return "", err
+		}
+		if holders > 0 && others == 0 {
+			return permission, nil
+		}
+	}
+	return "", nil
+}
+
+// checkPermissionsKept refuses to take permissions away from the holders of exceptRole or
+// the user exceptUserID when nobody else would be left with a lockout permission among
+// them. It returns the HTTP status and error to respond with, or 0.
+func checkPermissionsKept(q rowQuerier, companyID int, removed []string, exceptRole string, exceptUserID int) (int, string) {
+	permission, err := lastHeldPermission(q, companyID, removed, exceptRole, exceptUserID)
+	if err != nil {
+		log.Printf("Error counting permission holders: %v", err)
+		return http.StatusInternalServerError, "Internal Server Error"
+	}
+	if permission != "" {
+		return http.StatusConflict, fmt.Sprintf("No other active user has the %s permission; give it to someone else first", permission)
+	}
+	return 0, ""
+}
+
+// loadCustomRoles returns a company's custom roles by name
+func loadCustomRoles(companyID int) ([]Role, error) {
+	rows, err := db.Query(`
+		SELECT r.name, r.description, r.created_at, r.updated_at, p.permission
+		FROM company_roles r
+		LEFT JOIN company_role_permissions p ON p.role_id = r.id
+		WHERE r.company_id = ?
+		ORDER BY r.name`, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []Role
+	perms := map[string]map[string]bool{}
+	for rows.Next() {
+		var name, description string
+		var createdAt, updatedAt time.Time
+		var permission sql.NullString
+		if err := rows.Scan(&name, &description, &createdAt, &updatedAt, &permission); err != nil {
+			return nil, err
+		}
+		if perms[name] == nil {
+			perms[name] = map[string]bool{}
+			roles = append(roles, Role{Name: name, Description: description, CreatedAt: &createdAt, UpdatedAt: &updatedAt})
+		}
+		if permission.Valid {
+			perms[name][permission.String] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		roles[i].Permissions = sortedPermissions(perms[roles[i].Name])
+	}
+	return roles, nil
+}
+
+// getPermissionsHandler lists every permission and those the current user holds
+func getPermissionsHandler(c *gin.Context) {
+	perms, err := currentPermissions(c)
+	if err != nil {
+		log.Printf("Error loading permissions: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data: gin.H{
+			"permissions": permissionCatalog,
+			"granted":     sortedPermissions(perms),
+		},
+	})
+}
+
+// getRolesHandler lists the built-in and custom roles of the company with how many
+// users have each
+func getRolesHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	internalError := func(err error) {
+		log.Printf("Error fetching roles: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	custom, err := loadCustomRoles(companyID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	counts := map[string]int{}
+	rows, err := db.Query("SELECT role, COUNT(*) FROM users WHERE company_id = ? GROUP BY role", companyID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role string
+		var count int
+		if err := rows.Scan(&role, &count); err != nil {
+			internalError(err)
+			return
+		}
+		counts[role] = count
+	}
+
+	roles := []Role{}
+	for _, name := range builtInRoleOrder {
+		roles = append(roles, builtInRoles[name])
+	}
+	roles = append(roles, custom...)
+	for i := range roles {
+		roles[i].UserCount = counts[roles[i].Name]
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    roles,
+	})
+}
+
+// rolePermissionsFromRequest checks the permissions of a role request, dropping duplicates
+func rolePermissionsFromRequest(c *gin.Context, req RoleRequest) (map[string]bool, bool) {
+	perms := make(map[string]bool)
+	for _, p := range req.Permissions {
+		if !isPermission(p) {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Unknown permission %q", p),
+			})
+			return nil, false
+		}
+		perms[p] = true
+	}
+	if status, message := checkGrantable(c, perms); status != 0 {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return nil, false
+	}
+	return perms, true
+}
+
+// saveRolePermissions replaces the permissions of a custom role
+func saveRolePermissions(tx *sql.Tx, roleID int64, perms map[string]bool) error {
+	if _, err := tx.Exec("DELETE FROM company_role_permissions WHERE role_id = ?", roleID); err != nil {
+		return err
+	}
+	for _, p := range sortedPermissions(perms) {
+		if _, err := tx.Exec("INSERT INTO company_role_permissions (role_id, permission) VALUES (?, ?)", roleID, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createRoleHandler defines a custom role. Users can only bundle permissions they hold.
+func createRoleHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+	if !roleNamePattern.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "name must be 2-50 lowercase letters, digits, dashes or underscores, starting with a letter",
+		})
+		return
+	}
+	if _, ok := builtInRoles[req.Name]; ok {
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "A built-in role has this name",
+		})
+		return
+	}
+	perms, ok := rolePermissionsFromRequest(c, req)
+	if !ok {
+		return
+	}
+
+	internalError := func(err error) {
+		log.Printf("Error creating role: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	// Check if the role already exists in this company
+	var existingID int
+	err := db.QueryRow("SELECT id FROM company_roles WHERE company_id = ? AND name = ?", companyID, req.Name).Scan(&existingID)
+	if err == nil {
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "Role already exists",
+		})
+		return
+	}
+	if err != sql.ErrNoRows {
+		internalError(err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`
+		INSERT INTO company_roles (company_id, name, description, created_at, updated_at)
+		VALUES (?, ?, ?, NOW(), NOW())`, companyID, req.Name, strings.TrimSpace(req.Description))
+	if err != nil {
+		internalError(err)
+		return
+	}
+	roleID, _ := result.LastInsertId()
+	if err := saveRolePermissions(tx, roleID, perms); err != nil {
+		internalError(err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		internalError(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Role created successfully",
+		Data: Role{
+			Name:        req.Name,
+			Description: strings.TrimSpace(req.Description),
+			Permissions: sortedPermissions(perms),
+		},
+	})
+}
+
+// updateRoleHandler changes the description and permissions of a custom role. Users
+// with the role get the new permissions on their next request. Only users who may manage
+// everyone with the role can change it, and no change may take users:manage or
+// company:manage away from the last users holding them.
+func updateRoleHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	name := c.Param("name")
+	if _, ok := builtInRoles[name]; ok {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Built-in roles cannot be changed",
+		})
+		return
+	}
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+	if req.Name != "" && req.Name != name {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Roles cannot be renamed",
+		})
+		return
+	}
+	perms, ok := rolePermissionsFromRequest(c, req)
+	if !ok {
+		return
+	}
+
+	internalError := func(err error) {
+		log.Printf("Error updating role: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer tx.Rollback()
+	var roleID int64
+	err = tx.QueryRow("SELECT id FROM company_roles WHERE company_id = ? AND name = ? FOR UPDATE", companyID, name).Scan(&roleID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Role not found",
+		})
+		return
+	}
+	if err != nil {
+		internalError(err)
+		return
+	}
+
+	// The change applies to every user with the role, so it needs the rights to manage each
+	// of them, and must not leave the company without someone to administer it
+	var holders int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE company_id = ? AND role = ?", companyID, name).Scan(&holders); err != nil {
+		internalError(err)
+		return
+	}
+	current, _, err := rolePermissions(companyID, name)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if holders > 0 {
+		missing, err := missingPermissions(c, current)
+		if err != nil {
+			internalError(err)
+			return
+		}
+		if len(missing) > 0 {
+			c.JSON(http.StatusForbidden, APIResponse{
+				Success: false,
+				Error:   "You cannot change a role whose users have permissions you do not have: " + strings.Join(missing, ", "),
+			})
+			return
+		}
+	}
+	if status, message := checkPermissionsKept(tx, companyID, permissionsLacking(perms, current), name, 0); status != 0 {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE company_roles SET description = ?, updated_at = NOW() WHERE id = ?",
+		strings.TrimSpace(req.Description), roleID); err != nil {
+		internalError(err)
+		return
+	}
+	if err := saveRolePermissions(tx, roleID, perms); err != nil {
+		internalError(err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		internalError(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Role updated successfully",
+		Data: Role{
+			Name:        name,
+			Description: strings.TrimSpace(req.Description),
+			Permissions: sortedPermissions(perms),
+		},
+	})
+}
+
+// deleteRoleHandler deletes a custom role that no user has and single sign-on does not
+// assign
+func deleteRoleHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	name := c.Param("name")
+	if _, ok := builtInRoles[name]; ok {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Built-in roles cannot be deleted",
+		})
+		return
+	}
+
+	internalError := func(err error) {
+		log.Printf("Error deleting role: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	var users int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE company_id = ? AND role = ?", companyID, name).Scan(&users); err != nil {
+		internalError(err)
+		return
+	}
+	if users > 0 {
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Role is assigned to %d users; give them another role first", users),
+		})
+		return
+	}
+	cfg, err := loadCompanySSO(companyID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if cfg != nil && cfg.assignsRole(name) {
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "Single sign-on assigns this role; change its role mapping first",
+		})
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM company_roles WHERE company_id = ? AND name = ?", companyID, name)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Role not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Role deleted successfully",
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// useMockDB points the global db at a mock database for the duration of a test
+func useMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("opening mock database: %v", err)
+	}
+	saved := db
+	db = conn
+	t.Cleanup(func() {
+		db = saved
+		conn.Close()
+	})
+	return mock
+}
+
+// userRequest builds the context of a request by a user of ownCompany with a role,
+// about the user targetID
+func userRequest(role string, targetID int, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(targetID)}}
+	c.Set("user", User{ID: 5, CompanyID: ownCompany, Role: role})
+	c.Set("user_id", 5)
+	c.Set("company_id", ownCompany)
+	return c, w
+}
+
+func expectTargetRole(mock sqlmock.Sqlmock, targetID int, role string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT role FROM users WHERE id = ? AND company_id = ?")).
+		WithArgs(targetID, ownCompany).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(role))
+}
+
+// TestManagingMorePrivilegedUser checks that a user who may manage users, but holds
+// fewer permissions than an admin, cannot edit, deactivate, sign out or reset the 2FA
+// of an admin. sqlmock fails on any statement that was not expected, so passing
+// expectations also prove that the admin was left unchanged.
+func TestManagingMorePrivilegedUser(t *testing.T) {
+	handlers := []struct {
+		name    string
+		body    string
+		handler gin.HandlerFunc
+	}{
+		{"update", `{"email":"taken@example.com"}`, updateUserHandler},
+		{"delete", "", deleteUserHandler},
+		{"revoke sessions", "", revokeUserSessionsHandler},
+		{"reset 2FA", "", resetUserTwoFactorHandler},
+	}
+	for _, h := range handlers {
+		t.Run(h.name, func(t *testing.T) {
+			mock := useMockDB(t)
+			expectTargetRole(mock, 9, "admin")
+			// "support" is a custom role that may manage users but not the company
+			mock.ExpectQuery(regexp.QuoteMeta("FROM company_roles r")).
+				WithArgs(ownCompany, "support").
+				WillReturnRows(sqlmock.NewRows([]string{"permission"}).
+					AddRow(permAssetsRead).AddRow(permUsersManage))
+
+			c, w := userRequest("support", 9, h.body)
+			h.handler(c)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("got %d %s, want 403", w.Code, w.Body)
+			}
+			checkMock(t, mock)
+		})
+	}
+}
+
+func TestManagingUnknownUser(t *testing.T) {
+	mock := useMockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT role FROM users WHERE id = ? AND company_id = ?")).
+		WithArgs(9, ownCompany).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+	c, w := userRequest("admin", 9, "")
+	deleteUserHandler(c)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got %d %s, want 404", w.Code, w.Body)
+	}
+	checkMock(t, mock)
+}
+
+// TestResetTwoFactorOfEqualUser is the counterpart of the tests above: an admin may
+// reset the 2FA of another admin
+func TestResetTwoFactorOfEqualUser(t *testing.T) {
+	mock := useMockDB(t)
+	expectTargetRole(mock, 9, "admin")
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM user_recovery_codes WHERE user_id = ?")).
+		WithArgs(9).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM user_two_factor WHERE user_id = ?")).
+		WithArgs(9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	c, w := userRequest("admin", 9, "")
+	resetUserTwoFactorHandler(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", w.Code, w.Body)
+	}
+	checkMock(t, mock)
+}
+
+// expectRoleUpdate expects the lock and current permissions of a custom role with holders
+func expectRoleUpdate(mock sqlmock.Sqlmock, name string, holders int, perms ...string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM company_roles WHERE company_id = ? AND name = ? FOR UPDATE")).
+		WithArgs(ownCompany, name).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE company_id = ? AND role = ?")).
+		WithArgs(ownCompany, name).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(holders))
+	rows := sqlmock.NewRows([]string{"permission"})
+	for _, p := range perms {
+		rows.AddRow(p)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("FROM company_roles r")).
+		WithArgs(ownCompany, name).
+		WillReturnRows(rows)
+}
+
+func expectPermissionHolders(mock sqlmock.Sqlmock, permission, exceptRole string, exceptUserID, holders int) {
+	mock.ExpectQuery(regexp.QuoteMeta("LEFT JOIN company_role_permissions p ON p.role_id = r.id AND p.permission = ?")).
+		WithArgs(permission, ownCompany, exceptRole, exceptUserID, "admin").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(holders))
+}
+
+// TestUpdateRoleOfMorePrivilegedUsers checks that changing a role needs every permission
+// its users have, like managing each of them would
+func TestUpdateRoleOfMorePrivilegedUsers(t *testing.T) {
+	mock := useMockDB(t)
+	expectRoleUpdate(mock, "auditor", 2, permAssetsRead, permCompanyManage)
+	mock.ExpectRollback()
+
+	c, w := userRequest("manager", 0, `{"permissions":["assets:read"]}`)
+	c.Params = gin.Params{{Key: "name", Value: "auditor"}}
+	updateRoleHandler(c)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got %d %s, want 403", w.Code, w.Body)
+	}
+	checkMock(t, mock)
+}
+
+// TestUpdateRoleKeepsAdministrators checks that a role change cannot take users:manage
+// from the last users who have it
+func TestUpdateRoleKeepsAdministrators(t *testing.T) {
+	tests := []struct {
+		name   string
+		others int
+		status int
+	}{
+		{"last holders", 0, http.StatusConflict},
+		{"other holders", 1, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := useMockDB(t)
+			expectRoleUpdate(mock, "owner", 1, permAssetsRead, permUsersManage)
+			expectPermissionHolders(mock, permUsersManage, "", 0, 1)
+			expectPermissionHolders(mock, permUsersManage, "owner", 0, tt.others)
+			if tt.status == http.StatusOK {
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE company_roles SET description = ?")).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM company_role_permissions WHERE role_id = ?")).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO company_role_permissions")).
+					WithArgs(3, permAssetsRead).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			c, w := userRequest("admin", 0, `{"permissions":["assets:read"]}`)
+			c.Params = gin.Params{{Key: "name", Value: "owner"}}
+			updateRoleHandler(c)
+			if w.Code != tt.status {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			checkMock(t, mock)
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Label output formats
+const (
+	labelFormatPDF = "pdf"
+	labelFormatZPL = "zpl"
+	labelFormatEPL = "epl"
+)
+
+// defaultPrinterDPI is the resolution of most desktop Zebra printers
+const defaultPrinterDPI = 203
+
+// printerDPIs are the print head resolutions commands can be generated for
+var printerDPIs = map[int]bool{203: true, 300: true, 600: true}
+
+// printerLanguages are the printer command languages, keyed by output format
+var printerLanguages = map[string]printerLanguage{
+	labelFormatZPL: zplLanguage{},
+	labelFormatEPL: eplLanguage{},
+}
+
+// resolveLabelFormat checks the output format and printer resolution of a barcode
+// request; PDF is the default and the resolution only applies to printer formats
+func resolveLabelFormat(format string, dpi int) (string, int, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" || format == labelFormatPDF {
+		return labelFormatPDF, 0, nil
+	}
+	if _, ok := printerLanguages[format]; !ok {
+		return "", 0, errors.New("format must be pdf, zpl or epl")
+	}
+	if dpi == 0 {
+		dpi = defaultPrinterDPI
+	}
+	if !printerDPIs[dpi] {
+		return "", 0, errors.New("dpi must be 203, 300 or 600")
+	}
+	return format, dpi, nil
+}
+
+// labelFormatContentTypes are the response content types of the printer formats
+var labelFormatContentTypes = map[string]string{
+	labelFormatZPL: "application/vnd.zebra-zpl",
+	labelFormatEPL: "application/vnd.zebra-epl",
+}
+
+// ServePrinterJob returns a printer format sheet as the response body, named after the
+// PDF it replaces, so the job can be sent straight to a printer port. The IDs of assets
+// left out are listed in the X-Skipped-Assets header.
+func (s *labelSheet) ServePrinterJob(c *gin.Context, pdfFilename string, skipped []gin.H) {
+	var buf bytes.Buffer
+	if err := s.r.write(&buf); err != nil {
+		log.Printf("Error writing %s labels: %v", s.format, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	ids := make([]string, len(skipped))
+	for i, skip := range skipped {
+		ids[i] = fmt.Sprint(skip["assetId"])
+	}
+	if len(ids) > 0 {
+		c.Header("X-Skipped-Assets", strings.Join(ids, ","))
+	}
+	filename := strings.TrimSuffix(pdfFilename, ".pdf") + "." + s.format
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, labelFormatContentTypes[s.format], buf.Bytes())
+}
+
+// printerCode is a barcode sized in printer dots. Module is the narrow bar or cell width;
+// Height is the bar height of linear codes and the full height of 2D codes.
+type printerCode struct {
+	Symbology string
+	Payload   string
+	Module    int
+	Height    int
+	Width     int
+}
+
+// printerLanguage writes one printer command language. Each page of a sheet is sent as
+// one label format, so multi-column rolls print a row of labels at a time.
+type printerLanguage interface {
+	startLabel(buf *bytes.Buffer, width, height int)
+	endLabel(buf *bytes.Buffer)
+	text(buf *bytes.Buffer, x, y, width, height int, text string)
+	barcode(buf *bytes.Buffer, x, y int, code printerCode)
+}
+
+// printerLabelRenderer draws label sheets as printer commands. Barcodes use the printer's
+// own symbologies, sized to whole dots, and the output only depends on its input so it
+// can be compared with golden files.
+type printerLabelRenderer struct {
+	lang    printerLanguage
+	tpl     LabelTemplate
+	symName string
+	sym     barcodeSymbology
+	dpmm    float64
+	buf     bytes.Buffer
+	pages   int
+}
+
+// newPrinterLabelRenderer starts a command stream for a template at a print resolution
+func newPrinterLabelRenderer(lang printerLanguage, dpi int, tpl LabelTemplate, symName string, sym barcodeSymbology) *printerLabelRenderer {
+	return &printerLabelRenderer{lang: lang, tpl: tpl, symName: symName, sym: sym, dpmm: float64(dpi) / 25.4}
+}
+
+// dots converts mm to printer dots
+func (r *printerLabelRenderer) dots(mm float64) int {
+	return int(math.Round(mm * r.dpmm))
+}
+
+// logoWidth leaves logos off: printers would need them downloaded as graphics first
+func (r *printerLabelRenderer) logoWidth(path string, height float64) (float64, bool) {
+	return 0, false
+}
+
+// barcode sizes a payload in whole dots per module. Modules never get narrower than the
+// symbology's MinModule, and codes grow towards its preferred Width where the label allows.
+func (r *printerLabelRenderer) barcode(payload string, maxWidth, maxHeight float64) (labelBarcode, error) {
+	if err := r.sym.Validate(payload); err != nil {
+		return labelBarcode{}, err
+	}
+	encoded, err := r.sym.Encode(payload)
+	if err != nil {
+		return labelBarcode{}, err
+	}
+
+	bounds := encoded.Bounds()
+	modules, rows := bounds.Dx(), bounds.Dy()
+	minModule := int(math.Ceil(r.sym.MinModule * r.dpmm))
+	maxModule := r.dots(maxWidth) / modules
+	if maxModule < minModule {
+		return labelBarcode{}, fmt.Errorf("payload needs %d modules, too many for a %.1f mm wide label", modules, maxWidth)
+	}
+	module := r.dots(r.sym.Width) / modules
+	if module > maxModule {
+		module = maxModule
+	}
+	if module < minModule {
+		module = minModule
+	}
+
+	code := printerCode{Symbology: r.symName, Payload: payload}
+	if encoded.Metadata().Dimensions == 2 {
+		if module*rows > r.dots(maxHeight) {
+			module = r.dots(maxHeight) / rows
+		}
+		if module < minModule {
+			return labelBarcode{}, fmt.Errorf("label is too short for a %d x %d module code", modules, rows)
+		}
+		code.Height = module * rows
+	} else {
+		height := math.Min(r.sym.Height, maxHeight)
+		if height < minLinearBarcodeHeight {
+			return labelBarcode{}, errors.New("label leaves no room for the barcode")
+		}
+		code.Height = r.dots(height)
+	}
+	code.Module, code.Width = module, module*modules
+
+	return labelBarcode{
+		height: float64(code.Height) / r.dpmm,
+		draw: func(x, y float64) {
+			r.lang.barcode(&r.buf, r.dots(x), r.dots(y), code)
+		},
+	}, nil
+}
+
+func (r *printerLabelRenderer) startPage() {
+	if r.pages > 0 {
+		r.lang.endLabel(&r.buf)
+	}
+	r.lang.startLabel(&r.buf, r.dots(r.tpl.PageWidth), r.dots(r.tpl.PageHeight))
+	r.pages++
+}
+
+// text prints in the printer's built-in font, shortened by its average character width
+func (r *printerLabelRenderer) text(x, y, width, size float64, bold bool, text string) {
+	height := r.dots(size * 25.4 / 72)
+	if height < 1 {
+		height = 1
+	}
+	r.lang.text(&r.buf, r.dots(x), r.dots(y), r.dots(width), height, text)
+}
+
+func (r *printerLabelRenderer) image(path string, x, y, width, height float64) {}
+
+// photo leaves photos off for the same reason as logos
+func (r *printerLabelRenderer) photo(jpeg []byte, maxWidth, maxHeight float64) (labelPhoto, bool) {
+	return labelPhoto{}, false
+}
+
+func (r *printerLabelRenderer) pageCount() int {
+	return r.pages
+}
+
+func (r *printerLabelRenderer) write(w io.Writer) error {
+	if r.pages > 0 {
+		r.lang.endLabel(&r.buf)
+	}
+	out := bytes.ReplaceAll(r.buf.Bytes(), []byte(pageCountAlias), []byte(strconv.Itoa(r.pages)))
+	_, err := w.Write(out)
+	return err
+}
+
+// fitChars shortens text to a number of characters
+func fitChars(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	if n <= 3 {
+		return ""
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// clampMagnification limits a module size to the largest magnification a printer accepts
+func clampMagnification(module, limit int) int {
+	if module > limit {
+		return limit
+	}
+	return module
+}
+
+// zplLanguage writes ZPL II for Zebra printers
+type zplLanguage struct{}
+
+// zplEscape hex-escapes the characters ZPL reserves in ^FH field data
+func zplEscape(s string) string {
+	return strings.NewReplacer(`\`, `\5C`, "^", `\5E`, "~", `\7E`).Replace(s)
+}
+
+func (zplLanguage) startLabel(buf *bytes.Buffer, width, height int) {
+	// ^CI28 reads field data as UTF-8
+	fmt.Fprintf(buf, "^XA\n^CI28\n^PW%d\n^LL%d\n^LH0,0\n", width, height)
+}
+
+func (zplLanguage) endLabel(buf *bytes.Buffer) {
+	buf.WriteString("^XZ\n")
+}
+
+// text uses the scalable font 0, whose characters average about 0.6 of their height
+func (zplLanguage) text(buf *bytes.Buffer, x, y, width, height int, text string) {
+	text = fitChars(text, width*10/(height*6))
+	if text == "" {
+		return
+	}
+	fmt.Fprintf(buf, "^FO%d,%d^A0N,%d,%d^FH\\^FD%s^FS\n", x, y, height, height, zplEscape(text))
+}
+
+func (zplLanguage) barcode(buf *bytes.Buffer, x, y int, code printerCode) {
+	data := zplEscape(code.Payload)
+	switch code.Symbology {
+	case "code128":
+		// Mode A picks the Code 128 subsets automatically
+		fmt.Fprintf(buf, "^FO%d,%d^BY%d^BCN,%d,N,N,N,A^FH\\^FD%s^FS\n", x, y, code.Module, code.Height, data)
+	case "code39":
+		fmt.Fprintf(buf, "^FO%d,%d^BY%d,3.0^B3N,Y,%d,N,N^FH\\^FD%s^FS\n", x, y, code.Module, code.Height, data)
+	case "ean13":
+		fmt.Fprintf(buf, "^FO%d,%d^BY%d^BEN,%d,N,N^FD%s^FS\n", x, y, code.Module, code.Height, code.Payload)
+	case "datamatrix":
+		fmt.Fprintf(buf, "^FO%d,%d^BXN,%d,200^FH\\^FD%s^FS\n", x, y, code.Module, data)
+	case "qr":
+		// Model 2, error correction M, automatic input mode
+		fmt.Fprintf(buf, "^FO%d,%d^BQN,2,%d^FH\\^FDMA,%s^FS\n", x, y, clampMagnification(code.Module, 10), data)
+	case "pdf417":
+		// Rows are two modules high, as in the PDF labels; security level 2
+		fmt.Fprintf(buf, "^FO%d,%d^BY%d^B7N,%d,2^FH\\^FD%s^FS\n", x, y, code.Module, 2*code.Module, data)
+	}
+}
+
+// eplLanguage writes EPL2 for older Zebra and Eltron printers
+type eplLanguage struct{}
+
+// eplFonts are the heights in dots of EPL fonts 1 to 5 on a 203 dpi printer; widths
+// are about 0.6 of the height
+var eplFonts = []int{12, 16, 20, 24, 48}
+
+// eplEscape quotes EPL string data, which is limited to ASCII
+func eplEscape(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r > 127 {
+			return '?'
+		}
+		return r
+	}, s)
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+func (eplLanguage) startLabel(buf *bytes.Buffer, width, height int) {
+	// N clears the image buffer; Q sets the label length with a 3 mm gap at 203 dpi
+	fmt.Fprintf(buf, "\nN\nq%d\nQ%d,24\n", width, height)
+}
+
+func (eplLanguage) endLabel(buf *bytes.Buffer) {
+	buf.WriteString("P1\n")
+}
+
+// text picks the largest built-in font no taller than the requested height. Font sizes
+// are given for 203 dpi, so on finer heads the text is printed smaller.
+func (eplLanguage) text(buf *bytes.Buffer, x, y, width, height int, text string) {
+	font := 1
+	for i, h := range eplFonts {
+		if h <= height {
+			font = i + 1
+		}
+	}
+	text = fitChars(text, width*10/(eplFonts[font-1]*6))
+	if text == "" {
+		return
+	}
+	fmt.Fprintf(buf, "A%d,%d,0,%d,1,1,N,\"%s\"\n", x, y, font, eplEscape(text))
+}
+
+func (eplLanguage) barcode(buf *bytes.Buffer, x, y int, code printerCode) {
+	data := eplEscape(code.Payload)
+	switch code.Symbology {
+	case "code128":
+		fmt.Fprintf(buf, "B%d,%d,0,1,%d,%d,%d,N,\"%s\"\n", x, y, code.Module, 2*code.Module, code.Height, data)
+	case "code39":
+		// 3C adds the mod 43 check digit, as on the PDF labels
+		fmt.Fprintf(buf, "B%d,%d,0,3C,%d,%d,%d,N,\"%s\"\n", x, y, code.Module, 3*code.Module, code.Height, data)
+	case "ean13":
+		fmt.Fprintf(buf, "B%d,%d,0,E30,%d,%d,%d,N,\"%s\"\n", x, y, code.Module, 2*code.Module, code.Height, data)
+	case "datamatrix":
+		fmt.Fprintf(buf, "b%d,%d,D,h%d,\"%s\"\n", x, y, code.Module, data)
+	case "qr":
+		fmt.Fprintf(buf, "b%d,%d,Q,m2,s%d,eM,\"%s\"\n", x, y, clampMagnification(code.Module, 99), data)
+	case "pdf417":
+		fmt.Fprintf(buf, "b%d,%d,P,%d,%d,x%d,y%d,s2,\"%s\"\n", x, y, code.Width, code.Height, code.Module, 2*code.Module, data)
+	}
+}
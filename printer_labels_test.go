@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Run go test -run TestPrinterLabelGolden -update to rewrite the golden files after an
+// intended change to the printer output
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// goldenAssets are fixed assets covering tagged and untagged assets, stored QR codes and
+// text that printer languages have to escape
+func goldenAssets() []Asset {
+	return []Asset{
+		{
+			ID: 1, AssetName: "Dell Latitude 5420", Status: "Active",
+			Barcode: strPtr("AST-000001"), QRCode: strPtr("ASSET:1"),
+			AssetType: strPtr("Laptop"), Location: strPtr("Room 101"),
+		},
+		{
+			ID: 27, AssetName: `Router ^FS ~JR \ "main"`, Status: "Active",
+			QRCode: strPtr("ASSET:27"), Location: strPtr("Server room"),
+		},
+		{
+			ID: 305, AssetName: "Café espresso machine with a name too long for the label", Status: "Maintenance",
+			Barcode: strPtr("AST-000305"), QRCode: strPtr("ASSET:305"),
+			Location: strPtr("Kitchen, 2nd floor"),
+		},
+	}
+}
+
+func TestPrinterLabelGolden(t *testing.T) {
+	tests := []struct {
+		golden    string
+		format    string
+		dpi       int
+		template  string
+		symbology string
+		header    string
+	}{
+		{"thermal-50x25-code128-203.zpl", labelFormatZPL, 203, "thermal-50x25", "code128", ""},
+		{"thermal-50x25-code39-300.zpl", labelFormatZPL, 300, "thermal-50x25", "code39", ""},
+		{"avery-l7163-qr-203.zpl", labelFormatZPL, 203, "avery-l7163", "qr", ""},
+		{"a4-4up-pdf417-600.zpl", labelFormatZPL, 600, "a4-4up", "pdf417", "Main campus"},
+		{"thermal-50x25-ean13-203.epl", labelFormatEPL, 203, "thermal-50x25", "ean13", ""},
+		{"avery-5163-datamatrix-203.epl", labelFormatEPL, 203, "avery-5163", "datamatrix", ""},
+		{"a4-2up-code128-300.epl", labelFormatEPL, 300, "a4-2up", "code128", "Main campus"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			// The logo is left off printer labels, so a missing file must not matter
+			sheet := newLabelSheet(tt.format, tt.dpi, labelTemplatePresets[tt.template],
+				tt.symbology, barcodeSymbologies[tt.symbology], "testdata/no-such-logo.png")
+			sheet.SetHeader(tt.header)
+			if skipped := sheet.AddAll(goldenAssets()); len(skipped) > 0 {
+				t.Fatalf("assets skipped: %v", skipped)
+			}
+			var got bytes.Buffer
+			if err := sheet.Write(&got); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+
+			path := filepath.Join("testdata", tt.golden)
+			if *updateGolden {
+				if err := os.WriteFile(path, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading golden file: %v", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("output differs from %s:\n%s", path, got.Bytes())
+			}
+		})
+	}
+}
+
+func TestResolveLabelFormat(t *testing.T) {
+	tests := []struct {
+		format, wantFormat string
+		dpi, wantDPI       int
+		ok                 bool
+	}{
+		{"", "pdf", 300, 0, true},
+		{"ZPL", "zpl", 0, defaultPrinterDPI, true},
+		{"epl", "epl", 600, 600, true},
+		{"zpl", "", 150, 0, false},
+		{"png", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		format, dpi, err := resolveLabelFormat(tt.format, tt.dpi)
+		if (err == nil) != tt.ok || format != tt.wantFormat || dpi != tt.wantDPI {
+			t.Errorf("resolveLabelFormat(%q, %d) = %q, %d, %v", tt.format, tt.dpi, format, dpi, err)
+		}
+	}
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/xuri/excelize/v2"
@@ -13,85 +13,78 @@ import (
 	"github.com/jung-kurt/gofpdf"
 )
 
-// generateReportHandler generates a filtered report
-func generateReportHandler(c *gin.Context) {
-	// Get query parameters
-	assetType := c.Query("assetType")
-	location := c.Query("location")
-	status := c.Query("status")
-	startDate := c.Query("startDate")
-	endDate := c.Query("endDate")
-	manufacturer := c.Query("manufacturer")
-	modelNumber := c.Query("modelNumber")
-	institutionName := c.Query("institutionName")
-	department := c.Query("department")
-	functionalArea := c.Query("functionalArea")
-
-	// Validate required parameters
-	if institutionName == "" {
-		c.JSON(http.StatusBadRequest, APIResponse{
-			Success: false,
-			Error:   "Institution name is required",
-		})
-		return
-	}
-
-	// Build query dynamically
-	query := "SELECT id, asset_name, asset_type, institution_name, department, functional_area, manufacturer, model_number, serial_number, location, status, purchase_date, purchase_price, created_at, updated_at FROM assets WHERE institution_name = ?"
-	var args []interface{}
-	args = append(args, institutionName)
-
-	if assetType != "" && assetType != "All" {
-		query += " AND asset_type = ?"
-		args = append(args, assetType)
-	}
-
-	if location != "" && location != "All" {
-		query += " AND location = ?"
-		args = append(args, location)
-	}
+// Job types of reports
+const (
+	jobTypeAssetReport = "asset_report"
+	jobTypeInvoice     = "invoice"
+)
 
-	if status != "" && status != "All" {
-		query += " AND status = ?"
-		args = append(args, status)
+// reportFilter converts a ReportRequest into an AssetFilter, treating "All" as no filter
+func reportFilter(req ReportRequest) AssetFilter {
+	value := func(s string) string {
+		if s == "All" {
+			return ""
+		}
+		return s
 	}
 
-	if startDate != "" {
-		query += " AND purchase_date >= ?"
-		args = append(args, startDate)
+	filter := AssetFilter{
+		AssetType:       value(req.AssetType),
+		Location:        value(req.Location),
+		Status:          value(req.Status),
+		PurchasedFrom:   req.StartDate,
+		PurchasedTo:     req.EndDate,
+		ModelNumber:     value(req.ModelNumber),
+		InstitutionName: value(req.InstitutionName),
+		Department:      value(req.Department),
+		FunctionalArea:  value(req.FunctionalArea),
 	}
-
-	if endDate != "" {
-		query += " AND purchase_date <= ?"
-		args = append(args, endDate)
+	if len(req.Manufacturer) > 0 && req.Manufacturer[0] != "All" {
+		filter.Manufacturers = req.Manufacturer
 	}
+	return filter
+}
 
-	if manufacturer != "" && manufacturer != "All" {
-		query += " AND manufacturer = ?"
-		args = append(args, manufacturer)
+// generateReportHandler generates a filtered report
+func generateReportHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
 	}
 
-	if modelNumber != "" && modelNumber != "All" {
-		query += " AND model_number = ?"
-		args = append(args, modelNumber)
+	// Get query parameters
+	req := ReportRequest{
+		AssetType:       c.Query("assetType"),
+		Location:        c.Query("location"),
+		Status:          c.Query("status"),
+		StartDate:       c.Query("startDate"),
+		EndDate:         c.Query("endDate"),
+		ModelNumber:     c.Query("modelNumber"),
+		InstitutionName: c.Query("institutionName"),
+		Department:      c.Query("department"),
+		FunctionalArea:  c.Query("functionalArea"),
+		CustomFields:    c.QueryMap("customFields"),
+	}
+	if manufacturer := c.Query("manufacturer"); manufacturer != "" {
+		req.Manufacturer = []string{manufacturer}
 	}
 
-	if department != "" && department != "All" {
-		query += " AND department = ?"
-		args = append(args, department)
+	// Validate required parameters
+	if req.InstitutionName == "" {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Institution name is required",
+		})
+		return
 	}
 
-	if functionalArea != "" && functionalArea != "All" {
-		query += " AND functional_area = ?"
-		args = append(args, functionalArea)
+	filter := reportFilter(req)
+	filter.OrderBy = "asset_name"
+	if filter.CustomFields, ok = requireCustomFieldFilters(c, store, req.CustomFields); !ok {
+		return
 	}
 
-	// Add ordering
-	query += " ORDER BY asset_name"
-
-	log.Printf("Executing report query: %s with args: %v", query, args)
-
-	rows, err := db.Query(query, args...)
+	results, err := store.List(filter)
 	if err != nil {
 		log.Printf("Error fetching assets for report: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
@@ -100,49 +93,12 @@ func generateReportHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	var assets []gin.H
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
-		if err != nil {
-			log.Printf("Error scanning asset: %v", err)
-			continue
-		}
 
-		assets = append(assets, gin.H{
-			"id":              asset.ID,
-			"assetName":       asset.AssetName,
-			"assetType":       asset.AssetType,
-			"institutionName": asset.InstitutionName,
-			"department":      asset.Department,
-			"functionalArea":  asset.FunctionalArea,
-			"marketValue":     asset.PurchasePrice, // Use purchase price as market value
-			"manufacturer":    asset.Manufacturer,
-			"modelNumber":     asset.ModelNumber,
-			"serialNumber":    asset.SerialNumber,
-			"location":        asset.Location,
-			"status":          asset.Status,
-			"purchaseDate":    asset.PurchaseDate.Format("2006-01-02"),
-			"purchasePrice":   asset.PurchasePrice,
-			"createdAt":       asset.CreatedAt.Format("2006-01-02 15:04:05"),
-			"updatedAt":       asset.UpdatedAt.Format("2006-01-02 15:04:05"),
-		})
-	}
-
-	// Check for any errors during iteration
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating assets: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Error processing report data",
-		})
-		return
+	assets := []gin.H{}
+	for _, asset := range results {
+		row := assetResponse(asset)
+		row["marketValue"] = asset.PurchasePrice // Use purchase price as market value
+		assets = append(assets, row)
 	}
 
 	log.Printf("Report generated successfully: %d assets found", len(assets))
@@ -151,6 +107,11 @@ func generateReportHandler(c *gin.Context) {
 
 // generateAssetReportHandler generates a detailed asset report
 func generateAssetReportHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
 	var req ReportRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, APIResponse{
@@ -159,66 +120,17 @@ func generateAssetReportHandler(c *gin.Context) {
 		})
 		return
 	}
-
-	// Build query dynamically
-	query := "SELECT id, asset_name, asset_type, institution_name, department, functional_area, manufacturer, model_number, serial_number, location, status, purchase_date, purchase_price, created_at, updated_at FROM assets WHERE 1=1"
-	var args []interface{}
-
-	if req.AssetType != "" && req.AssetType != "All" {
-		query += " AND asset_type = ?"
-		args = append(args, req.AssetType)
-	}
-
-	if req.Location != "" && req.Location != "All" {
-		query += " AND location = ?"
-		args = append(args, req.Location)
-	}
-
-	if req.Status != "" && req.Status != "All" {
-		query += " AND status = ?"
-		args = append(args, req.Status)
-	}
-
-	if req.StartDate != "" {
-		query += " AND purchase_date >= ?"
-		args = append(args, req.StartDate)
-	}
-
-	if req.EndDate != "" {
-		query += " AND purchase_date <= ?"
-		args = append(args, req.EndDate)
-	}
-
-	if len(req.Manufacturer) > 0 && req.Manufacturer[0] != "All" {
-		placeholders := strings.Repeat("?,", len(req.Manufacturer))
-		placeholders = placeholders[:len(placeholders)-1]
-		query += fmt.Sprintf(" AND manufacturer IN (%s)", placeholders)
-		for _, m := range req.Manufacturer {
-			args = append(args, m)
-		}
-	}
-
-	if req.ModelNumber != "" && req.ModelNumber != "All" {
-		query += " AND model_number = ?"
-		args = append(args, req.ModelNumber)
-	}
-
-	if req.InstitutionName != "" && req.InstitutionName != "All" {
-		query += " AND institution_name = ?"
-		args = append(args, req.InstitutionName)
-	}
-
-	if req.Department != "" && req.Department != "All" {
-		query += " AND department = ?"
-		args = append(args, req.Department)
+	// Custom field filters are checked before queueing so mistakes are reported right away
+	filter := reportFilter(req)
+	if filter.CustomFields, ok = requireCustomFieldFilters(c, store, req.CustomFields); !ok {
+		return
 	}
-
-	if req.FunctionalArea != "" && req.FunctionalArea != "All" {
-		query += " AND functional_area = ?"
-		args = append(args, req.FunctionalArea)
+	if c.Query("async") == "true" {
+		enqueueJobHandler(c, jobTypeAssetReport, req)
+		return
 	}
 
-	rows, err := db.Query(query, args...)
+	assets, err := store.List(filter)
 	if err != nil {
 		log.Printf("Error fetching assets for report: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
@@ -227,31 +139,41 @@ func generateAssetReportHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	var assets []Asset
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
-		if err != nil {
-			log.Printf("Error scanning asset: %v", err)
-			continue
-		}
-		assets = append(assets, asset)
-	}
 
 	// Generate Excel report
-	f := excelize.NewFile()
+	f := newAssetReport(assets)
 	defer func() {
 		if err := f.Close(); err != nil {
 			log.Printf("Error closing Excel file: %v", err)
 		}
 	}()
 
+	// Save file
+	filename := fmt.Sprintf("asset_report_%s.xlsx", time.Now().Format("20060102_150405"))
+	art, ok := saveArtifact(c, artifactAssetReport, filename, func(w io.Writer) error {
+		return f.Write(w)
+	})
+	if !ok {
+		return
+	}
+	downloadURL, _ := artifacts.SignedURL(art.ID)
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Asset report generated successfully",
+		Data: gin.H{
+			"filename":    filename,
+			"artifactId":  art.ID,
+			"downloadUrl": downloadURL,
+			"assetCount":  len(assets),
+		},
+	})
+}
+
+// newAssetReport builds the asset report workbook, one row per asset
+func newAssetReport(assets []Asset) *excelize.File {
+	f := excelize.NewFile()
+
 	// Set headers
 	headers := []string{"ID", "Asset Name", "Asset Type", "Institution", "Department", "Functional Area", "Manufacturer", "Model Number", "Serial Number", "Location", "Status", "Purchase Date", "Purchase Price", "Created At"}
 	for i, header := range headers {
@@ -280,25 +202,7 @@ func generateAssetReportHandler(c *gin.Context) {
 		if err := f.SetCellValue("Sheet1", fmt.Sprintf("N%d", row), asset.CreatedAt.Format("2006-01-02 15:04:05")); err != nil { log.Printf("SetCellValue N: %v", err) }
 	}
 
-	// Save file
-	filename := fmt.Sprintf("asset_report_%s.xlsx", time.Now().Format("20060102_150405"))
-	if err := f.SaveAs(filename); err != nil {
-		log.Printf("Error saving Excel file: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Internal Server Error",
-		})
-		return
-	}
-
-	c.JSON(http.StatusOK, APIResponse{
-		Success: true,
-		Message: "Asset report generated successfully",
-		Data: gin.H{
-			"filename":   filename,
-			"assetCount": len(assets),
-		},
-	})
+	return f
 }
 
 // generateInvoiceHandler generates an invoice PDF
@@ -311,7 +215,37 @@ func generateInvoiceHandler(c *gin.Context) {
 		})
 		return
 	}
+	if c.Query("async") == "true" {
+		enqueueJobHandler(c, jobTypeInvoice, req)
+		return
+	}
+
+	pdf, totalAmount := newInvoicePDF(req)
+
+	// Save PDF
+	filename := fmt.Sprintf("invoice_%s.pdf", time.Now().Format("20060102_150405"))
+	art, ok := saveArtifact(c, artifactInvoice, filename, pdf.Output)
+	if !ok {
+		return
+	}
+	downloadURL, _ := artifacts.SignedURL(art.ID)
 
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Invoice generated successfully",
+		Data: gin.H{
+			"filename":     filename,
+			"artifactId":   art.ID,
+			"downloadUrl":  downloadURL,
+			"totalAmount":  totalAmount,
+			"itemCount":    len(req.Items),
+			"customerName": req.CustomerName,
+		},
+	})
+}
+
+// newInvoicePDF lays out an invoice and returns it with its total
+func newInvoicePDF(req InvoiceRequest) (*gofpdf.Fpdf, float64) {
 	// Create PDF
 	pdf := gofpdf.New("P", "mm", "A4", "")
 	pdf.AddPage()
@@ -383,56 +317,81 @@ func generateInvoiceHandler(c *gin.Context) {
 	pdf.Ln(5)
 	pdf.Cell(190, 5, "3. All disputes will be resolved through proper channels.")
 
-	// Save PDF
-	filename := fmt.Sprintf("invoice_%s.pdf", time.Now().Format("20060102_150405"))
-	err := pdf.OutputFileAndClose(filename)
+	return pdf, totalAmount
+}
+
+// runAssetReportJob writes a queued asset report to the job's file
+func runAssetReportJob(job *runningJob) (interface{}, error) {
+	var req ReportRequest
+	if err := job.DecodePayload(&req); err != nil {
+		return nil, err
+	}
+	store, err := job.AssetStore()
 	if err != nil {
-		log.Printf("Error saving PDF: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Internal Server Error",
-		})
-		return
+		return nil, err
+	}
+	filter := reportFilter(req)
+	if filter.CustomFields, err = store.CustomFieldFilters(req.CustomFields); err != nil {
+		return nil, err
+	}
+	assets, err := store.List(filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := job.Progress(1, 2); err != nil {
+		return nil, err
 	}
 
-	c.JSON(http.StatusOK, APIResponse{
-		Success: true,
-		Message: "Invoice generated successfully",
-		Data: gin.H{
-			"filename":     filename,
-			"totalAmount":  totalAmount,
-			"itemCount":    len(req.Items),
-			"customerName": req.CustomerName,
-		},
-	})
+	report := newAssetReport(assets)
+	defer report.Close()
+	f, err := job.CreateArtifact(fmt.Sprintf("asset_report_%s.xlsx", time.Now().Format("20060102_150405")))
+	if err != nil {
+		return nil, err
+	}
+	if err := report.Write(f); err != nil {
+		return nil, err
+	}
+	return gin.H{"assetCount": len(assets)}, nil
+}
+
+// runInvoiceJob writes a queued invoice to the job's file
+func runInvoiceJob(job *runningJob) (interface{}, error) {
+	var req InvoiceRequest
+	if err := job.DecodePayload(&req); err != nil {
+		return nil, err
+	}
+	pdf, totalAmount := newInvoicePDF(req)
+	f, err := job.CreateArtifact(fmt.Sprintf("invoice_%s.pdf", time.Now().Format("20060102_150405")))
+	if err != nil {
+		return nil, err
+	}
+	if err := pdf.Output(f); err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"totalAmount":  totalAmount,
+		"itemCount":    len(req.Items),
+		"customerName": req.CustomerName,
+	}, nil
 }
 
-// downloadHandler serves file downloads
+// downloadHandler serves a generated file of the current company by its artifact ID
 func downloadHandler(c *gin.Context) {
-	filename := c.Query("filename")
-	if filename == "" {
-		c.JSON(http.StatusBadRequest, APIResponse{
-			Success: false,
-			Error:   "Filename is required",
-		})
+	art, err := artifacts.Get(getCurrentCompanyID(c), c.Param("id"))
+	if err != nil {
+		artifactError(c, err, "fetching")
 		return
 	}
-
-	// Set headers for file download
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Expires", "0")
-	c.Header("Cache-Control", "must-revalidate")
-	c.Header("Pragma", "public")
-
-	// Serve the file
-	c.File(filename)
+	artifacts.Serve(c, art)
 }
 
 // fetchAssetsByInstitutionHandler fetches assets by institution for Excel reports
 func fetchAssetsByInstitutionHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
 	var req InstitutionBarcodeRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, APIResponse{
@@ -442,17 +401,7 @@ func fetchAssetsByInstitutionHandler(c *gin.Context) {
 		return
 	}
 
-	// Build query with institution filter
-	query := `
-		SELECT id, asset_name, asset_type, institution_name, department, functional_area, 
-		manufacturer, model_number, serial_number, location, status, purchase_date, 
-		purchase_price, created_at, updated_at 
-		FROM assets 
-		WHERE institution_name = ? AND institution_name IS NOT NULL
-		ORDER BY asset_name
-	`
-
-	rows, err := db.Query(query, req.Institution)
+	results, err := store.List(AssetFilter{InstitutionName: req.Institution, OrderBy: "asset_name"})
 	if err != nil {
 		log.Printf("Error fetching assets by institution: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{
@@ -461,59 +410,22 @@ func fetchAssetsByInstitutionHandler(c *gin.Context) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	var assets []gin.H
-	for rows.Next() {
-		var asset Asset
-		err := rows.Scan(
-			&asset.ID, &asset.AssetName, &asset.AssetType, &asset.InstitutionName, &asset.Department,
-			&asset.FunctionalArea, &asset.Manufacturer, &asset.ModelNumber, &asset.SerialNumber,
-			&asset.Location, &asset.Status, &asset.PurchaseDate, &asset.PurchasePrice,
-			&asset.CreatedAt, &asset.UpdatedAt)
-		if err != nil {
-			log.Printf("Error scanning asset: %v", err)
-			continue
-		}
 
+	assets := []gin.H{}
+	for _, asset := range results {
 		// Calculate market value (use purchase price as fallback)
 		marketValue := 0.0
 		if asset.PurchasePrice != nil {
 			marketValue = *asset.PurchasePrice
 		}
 
-		assets = append(assets, gin.H{
-			"id":              asset.ID,
-			"assetName":       asset.AssetName,
-			"assetType":       asset.AssetType,
-			"institutionName": asset.InstitutionName,
-			"department":      asset.Department,
-			"functionalArea":  asset.FunctionalArea,
-			"marketValue":     marketValue,
-			"location":        asset.Location,
-			"status":          asset.Status,
-			"manufacturer":    asset.Manufacturer,
-			"modelNumber":     asset.ModelNumber,
-			"serialNumber":    asset.SerialNumber,
-			"purchaseDate":    asset.PurchaseDate.Format("2006-01-02"),
-			"purchasePrice":   asset.PurchasePrice,
-			"createdAt":       asset.CreatedAt.Format("2006-01-02 15:04:05"),
-			"updatedAt":       asset.UpdatedAt.Format("2006-01-02 15:04:05"),
-		})
-	}
-
-	// Check for any errors during iteration
-	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating assets: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Error processing assets",
-		})
-		return
+		row := assetResponse(asset)
+		row["marketValue"] = marketValue
+		assets = append(assets, row)
 	}
 
 	c.JSON(http.StatusOK, APIResponse{
 		Success: true,
 		Data:    assets,
 	})
-} 
\ No newline at end of file
+}
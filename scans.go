@@ -0,0 +1,343 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How a scanned code was matched to an asset
+const (
+	scanMatchBarcode = "barcode"
+	scanMatchQRCode  = "qr_code"
+	scanMatchLegacy  = "legacy"
+	scanMatchEAN13   = "ean13"
+	scanMatchSerial  = "serial_number"
+)
+
+// scannedAssetsWindowDays is the period the dashboard counts scanned assets over
+const scannedAssetsWindowDays = 30
+
+// errAmbiguousScan is returned when a serial number matches several assets
+var errAmbiguousScan = errors.New("scanned code matches several assets")
+
+// scanStore resolves scanned codes to the company's assets and keeps the scan log
+type scanStore struct {
+	assets *sqlAssetStore
+}
+
+// requireScanStore resolves the request's scan store or aborts with 401
+func requireScanStore(c *gin.Context) (*scanStore, bool) {
+	assets, err := sqlAssetStoreFor(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Company context required",
+		})
+		c.Abort()
+		return nil, false
+	}
+	return &scanStore{assets: assets}, true
+}
+
+// liveAssetsWhere returns the live assets of the company matching a condition
+func (s *scanStore) liveAssetsWhere(condition string, arg interface{}) ([]Asset, error) {
+	return s.assets.queryAssets(
+		fmt.Sprintf("SELECT %s FROM assets WHERE company_id = ? AND deleted_at IS NULL AND %s ORDER BY id LIMIT 2",
+			assetColumns, condition),
+		s.assets.companyID, arg)
+}
+
+// legacyAssetID extracts the asset ID from codes printed before tags were stored: the
+// "ID:12|Name:..." string of generateBarcodeData and the "AST-000012" fallback payload
+func legacyAssetID(code string) (int, bool) {
+	var digits string
+	switch {
+	case strings.HasPrefix(code, "ID:"):
+		digits = strings.SplitN(code[len("ID:"):], "|", 2)[0]
+	case strings.HasPrefix(code, "AST-"):
+		digits = code[len("AST-"):]
+	default:
+		return 0, false
+	}
+	id, err := strconv.Atoi(digits)
+	return id, err == nil && id > 0
+}
+
+// ean13AssetID extracts the asset ID from the restricted-circulation EAN-13 codes of
+// ean13AssetPayload: prefix 2, the asset ID in digits 2-12 and a valid check digit
+func ean13AssetID(code string) (int, bool) {
+	if len(code) != 13 || code[0] != '2' || validateEAN13(code) != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(code[1:12])
+	return id, err == nil && id > 0
+}
+
+// scanLookup is one way of matching a scanned code to assets
+type scanLookup struct {
+	matchedBy string
+	condition string
+	arg       interface{}
+}
+
+// isTrashedTag reports whether a code is the stored barcode or QR code of a trashed
+// asset of the company
+func (s *scanStore) isTrashedTag(code string) (bool, error) {
+	var trashed bool
+	err := s.assets.queryRow(`
+		SELECT EXISTS(SELECT 1 FROM assets WHERE company_id = ? AND deleted_at IS NOT NULL
+		AND (barcode = ? OR qr_code = ?))`,
+		s.assets.companyID, code, code).Scan(&trashed)
+	return trashed, err
+}
+
+// firstMatch returns the asset matched by the first lookup that matches any
+func (s *scanStore) firstMatch(lookups []scanLookup) (*Asset, string, error) {
+	for _, l := range lookups {
+		assets, err := s.liveAssetsWhere(l.condition, l.arg)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(assets) > 1 {
+			return nil, "", errAmbiguousScan
+		}
+		if len(assets) == 1 {
+			return &assets[0], l.matchedBy, nil
+		}
+	}
+	return nil, "", sql.ErrNoRows
+}
+
+// Resolve finds the live asset a scanned code belongs to. Stored barcodes and QR codes
+// are tried first, then asset IDs carried by legacy and EAN-13 payloads, then serial
+// numbers. A code that is the stored tag of a trashed asset is never read as an asset ID,
+// so it cannot resolve to another asset. Returns sql.ErrNoRows when nothing matches and
+// errAmbiguousScan when a serial number is shared by several assets.
+func (s *scanStore) Resolve(code string) (*Asset, string, error) {
+	asset, matchedBy, err := s.firstMatch([]scanLookup{
+		{scanMatchBarcode, "barcode = ?", code},
+		{scanMatchQRCode, "qr_code = ?", code},
+	})
+	if err != sql.ErrNoRows {
+		return asset, matchedBy, err
+	}
+
+	var lookups []scanLookup
+	if id, ok := legacyAssetID(code); ok {
+		lookups = append(lookups, scanLookup{scanMatchLegacy, "id = ?", id})
+	}
+	if id, ok := ean13AssetID(code); ok {
+		lookups = append(lookups, scanLookup{scanMatchEAN13, "id = ?", id})
+	}
+	if len(lookups) > 0 {
+		trashed, err := s.isTrashedTag(code)
+		if err != nil {
+			return nil, "", err
+		}
+		if trashed {
+			lookups = nil
+		}
+	}
+	return s.firstMatch(append(lookups, scanLookup{scanMatchSerial, "serial_number = ?", code}))
+}
+
+// Record logs a scan of an asset by the store's user
+func (s *scanStore) Record(scan *AssetScan) error {
+	var userID interface{}
+	if s.assets.actor.UserID > 0 {
+		userID = s.assets.actor.UserID
+	}
+	scan.ScannedAt = time.Now()
+	result, err := s.assets.exec(`
+		INSERT INTO asset_scans (company_id, asset_id, user_id, code, matched_by, latitude, longitude,
+		accuracy_meters, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.assets.companyID, scan.AssetID, userID, scan.Code, scan.MatchedBy, scan.Latitude, scan.Longitude,
+		scan.AccuracyMeters, scan.ScannedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	scan.ID = int(id)
+	return err
+}
+
+// History returns the scans of an asset, newest first
+func (s *scanStore) History(assetID int) ([]AssetScan, error) {
+	rows, err := s.assets.query(`
+		SELECT sc.id, sc.asset_id, sc.user_id, u.username, sc.code, sc.matched_by, sc.latitude, sc.longitude,
+		sc.accuracy_meters, sc.scanned_at
+		FROM asset_scans sc
+		LEFT JOIN users u ON u.id = sc.user_id
+		WHERE sc.company_id = ? AND sc.asset_id = ?
+		ORDER BY sc.scanned_at DESC, sc.id DESC`, s.assets.companyID, assetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scans := []AssetScan{}
+	for rows.Next() {
+		var scan AssetScan
+		if err := rows.Scan(&scan.ID, &scan.AssetID, &scan.UserID, &scan.Username, &scan.Code, &scan.MatchedBy,
+			&scan.Latitude, &scan.Longitude, &scan.AccuracyMeters, &scan.ScannedAt); err != nil {
+			return nil, err
+		}
+		scans = append(scans, scan)
+	}
+	return scans, rows.Err()
+}
+
+// ScannedAssetCount returns how many distinct assets were scanned since a time
+func (s *scanStore) ScannedAssetCount(since time.Time) (int, error) {
+	var count int
+	err := s.assets.queryRow(
+		"SELECT COUNT(DISTINCT asset_id) FROM asset_scans WHERE company_id = ? AND scanned_at >= ?",
+		s.assets.companyID, since).Scan(&count)
+	return count, err
+}
+
+// scanLocation reads the optional lat, lng and accuracy query parameters of a scan
+func scanLocation(c *gin.Context, scan *AssetScan) error {
+	lat, lng := c.Query("lat"), c.Query("lng")
+	if lat == "" && lng == "" {
+		return nil
+	}
+	latitude, err := strconv.ParseFloat(lat, 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return errors.New("lat must be between -90 and 90")
+	}
+	longitude, err := strconv.ParseFloat(lng, 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return errors.New("lng must be between -180 and 180")
+	}
+	scan.Latitude, scan.Longitude = &latitude, &longitude
+
+	if acc := c.Query("accuracy"); acc != "" {
+		accuracy, err := strconv.ParseFloat(acc, 64)
+		if err != nil || accuracy < 0 {
+			return errors.New("accuracy must be a positive number of meters")
+		}
+		scan.AccuracyMeters = &accuracy
+	}
+	return nil
+}
+
+// scanAssetHandler resolves a scanned code to the asset record and logs the scan.
+// The route is a wildcard because tags may contain '/'.
+func scanAssetHandler(c *gin.Context) {
+	store, ok := requireScanStore(c)
+	if !ok {
+		return
+	}
+
+	code := strings.TrimSpace(strings.TrimPrefix(c.Param("code"), "/"))
+	if code == "" || len(code) > 255 {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Scanned code is required and may be at most 255 characters",
+		})
+		return
+	}
+	scan := AssetScan{Code: code}
+	if err := scanLocation(c, &scan); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	asset, matchedBy, err := store.Resolve(code)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "No asset matches the scanned code",
+		})
+		return
+	case errors.Is(err, errAmbiguousScan):
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	case err != nil:
+		log.Printf("Error resolving scanned code %q: %v", code, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	scan.AssetID, scan.MatchedBy = asset.ID, matchedBy
+	if err := store.Record(&scan); err != nil {
+		log.Printf("Error recording scan of asset %d: %v", asset.ID, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data: gin.H{
+			"asset":     assetResponse(*asset),
+			"matchedBy": matchedBy,
+			"scan":      scan,
+		},
+	})
+}
+
+// getAssetScansHandler returns the scan log of an asset
+func getAssetScansHandler(c *gin.Context) {
+	store, ok := requireScanStore(c)
+	if !ok {
+		return
+	}
+	assetID, ok := pathAssetID(c)
+	if !ok {
+		return
+	}
+
+	if _, err := store.assets.Get(assetID); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Asset not found",
+			})
+			return
+		}
+		log.Printf("Error fetching asset %d: %v", assetID, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	scans, err := store.History(assetID)
+	if err != nil {
+		log.Printf("Error fetching scans of asset %d: %v", assetID, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    scans,
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEAN13AssetID(t *testing.T) {
+	tests := []struct {
+		code string
+		id   int
+		ok   bool
+	}{
+		{"2000000000428", 42, true},
+		{"2000000000427", 0, false},
+		{"200000000042", 0, false},
+		{"4006381333931", 0, false},
+		{"2000000000008", 0, false},
+		{"20000000004A8", 0, false},
+	}
+	for _, tt := range tests {
+		if id, ok := ean13AssetID(tt.code); id != tt.id || ok != tt.ok {
+			t.Errorf("ean13AssetID(%q) = %d, %v, want %d, %v", tt.code, id, ok, tt.id, tt.ok)
+		}
+	}
+}
+
+// expectScanLookup expects one lookup of live assets
+func expectScanLookup(mock sqlmock.Sqlmock, condition string, arg interface{}, rows *sqlmock.Rows) {
+	mock.ExpectQuery(regexp.QuoteMeta("deleted_at IS NULL AND "+condition)).
+		WithArgs(ownCompany, arg).
+		WillReturnRows(rows)
+}
+
+// expectStoredTagLookups expects the barcode and QR code lookups of a code to find nothing.
+// The first lookup reads the category defaults, which the store keeps for the others.
+func expectStoredTagLookups(mock sqlmock.Sqlmock, code string) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM asset_categories WHERE company_id = ?")).
+		WithArgs(ownCompany).
+		WillReturnRows(categoryDefaultRows())
+	expectScanLookup(mock, "barcode = ?", code, assetRows())
+	expectScanLookup(mock, "qr_code = ?", code, assetRows())
+}
+
+func expectTrashedTag(mock sqlmock.Sqlmock, code string, trashed bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("deleted_at IS NOT NULL AND (barcode = ? OR qr_code = ?)")).
+		WithArgs(ownCompany, code, code).
+		WillReturnRows(sqlmock.NewRows([]string{"trashed"}).AddRow(trashed))
+}
+
+func TestResolveEAN13(t *testing.T) {
+	store, mock := newMockAssetStore(t, ownCompany)
+	expectStoredTagLookups(mock, "2000000000428")
+	expectTrashedTag(mock, "2000000000428", false)
+	expectScanLookup(mock, "id = ?", 42, assetRows().AddRow(assetRow(42, ownCompany)...))
+
+	asset, matchedBy, err := (&scanStore{assets: store}).Resolve("2000000000428")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if asset.ID != 42 || matchedBy != scanMatchEAN13 {
+		t.Fatalf("got asset %d matched by %s, want 42 matched by %s", asset.ID, matchedBy, scanMatchEAN13)
+	}
+	checkMock(t, mock)
+}
+
+// TestResolveTrashedTag checks that the tag of a trashed asset is not read as the ID of
+// another asset: "AST-000012" stored on a trashed asset must not resolve to asset 12
+func TestResolveTrashedTag(t *testing.T) {
+	store, mock := newMockAssetStore(t, ownCompany)
+	expectStoredTagLookups(mock, "AST-000012")
+	expectTrashedTag(mock, "AST-000012", true)
+	expectScanLookup(mock, "serial_number = ?", "AST-000012", assetRows())
+
+	if _, _, err := (&scanStore{assets: store}).Resolve("AST-000012"); err != sql.ErrNoRows {
+		t.Fatalf("got %v, want sql.ErrNoRows", err)
+	}
+	checkMock(t, mock)
+}
@@ -29,7 +29,7 @@ CREATE TABLE IF NOT EXISTS users (
     password_hash VARCHAR(255) NOT NULL,
     first_name VARCHAR(100),
     last_name VARCHAR(100),
-    role ENUM('admin', 'manager', 'user') DEFAULT 'user',
+    role VARCHAR(50) DEFAULT 'user',
     is_active BOOLEAN DEFAULT TRUE,
     last_login TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -39,7 +39,137 @@ CREATE TABLE IF NOT EXISTS users (
     UNIQUE KEY unique_email_per_company (company_id, email)
 );
 
--- User roles with company association
+-- Signed-in sessions; access tokens name their session and stop working once it is revoked
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id CHAR(32) PRIMARY KEY,
+    company_id INT NOT NULL,
+    user_id INT NOT NULL,
+    user_agent VARCHAR(255) NULL,
+    ip_address VARCHAR(45) NULL,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME NULL,
+    revoked_reason VARCHAR(50) NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_user_sessions_user (company_id, user_id, revoked_at),
+    INDEX idx_user_sessions_expires (expires_at)
+);
+
+-- SHA-256 hashes of the refresh tokens of sessions; each token may be used once
+CREATE TABLE IF NOT EXISTS session_refresh_tokens (
+    token_hash CHAR(64) PRIMARY KEY,
+    session_id CHAR(32) NOT NULL,
+    created_at DATETIME NOT NULL,
+    used_at DATETIME NULL,
+    FOREIGN KEY (session_id) REFERENCES user_sessions(id) ON DELETE CASCADE
+);
+
+-- TOTP secrets of users with two-factor authentication; rows without enabled_at are pending setups
+CREATE TABLE IF NOT EXISTS user_two_factor (
+    user_id INT PRIMARY KEY,
+    company_id INT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    enabled_at DATETIME NULL,
+    last_used_step BIGINT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- SHA-256 hashes of one-time two-factor recovery codes
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    used_at DATETIME NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_user_recovery_codes_user (user_id, code_hash)
+);
+
+-- Logins waiting for their second factor
+CREATE TABLE IF NOT EXISTS login_challenges (
+    token_hash CHAR(64) PRIMARY KEY,
+    company_id INT NOT NULL,
+    user_id INT NOT NULL,
+    purpose VARCHAR(20) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_login_challenges_expires (expires_at)
+);
+
+-- OpenID Connect single sign-on configuration per company
+CREATE TABLE IF NOT EXISTS company_sso (
+    company_id INT PRIMARY KEY,
+    issuer VARCHAR(255) NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    client_secret VARCHAR(500) NOT NULL,
+    scopes VARCHAR(255) NOT NULL DEFAULT 'openid email profile',
+    role_claim VARCHAR(255) NOT NULL DEFAULT '',
+    role_mapping JSON NULL,
+    default_role VARCHAR(50) NOT NULL DEFAULT 'user',
+    auto_provision BOOLEAN NOT NULL DEFAULT TRUE,
+    disable_password_login BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE
+);
+
+-- Single sign-ons waiting for the provider's callback
+CREATE TABLE IF NOT EXISTS sso_login_states (
+    state_hash CHAR(64) PRIMARY KEY,
+    company_id INT NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    redirect_uri VARCHAR(500) NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    INDEX idx_sso_login_states_expires (expires_at)
+);
+
+-- Identity provider accounts linked to users
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    company_id INT NOT NULL,
+    user_id INT NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NULL,
+    created_at DATETIME NOT NULL,
+    last_login_at DATETIME NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE KEY unique_identity_per_company (company_id, issuer, subject)
+);
+
+-- Custom roles of a company; users.role names one of these or a built-in role
+CREATE TABLE IF NOT EXISTS company_roles (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    company_id INT NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    UNIQUE KEY unique_role_per_company (company_id, name)
+);
+
+-- Permissions granted by each custom role
+CREATE TABLE IF NOT EXISTS company_role_permissions (
+    role_id INT NOT NULL,
+    permission VARCHAR(50) NOT NULL,
+    PRIMARY KEY (role_id, permission),
+    FOREIGN KEY (role_id) REFERENCES company_roles(id) ON DELETE CASCADE
+);
+
+-- User roles with company association (legacy; no longer written)
 CREATE TABLE IF NOT EXISTS user_roles (
     id INT AUTO_INCREMENT PRIMARY KEY,
     user_id INT NOT NULL,
@@ -58,12 +188,38 @@ CREATE TABLE IF NOT EXISTS asset_categories (
     description TEXT,
     color VARCHAR(7) DEFAULT '#007bff', -- Hex color for UI
     is_active BOOLEAN DEFAULT TRUE,
+    -- Depreciation defaults for the category's assets
+    depreciation_method ENUM('straight_line', 'declining_balance', 'sum_of_years') NULL,
+    useful_life_years INT NULL,
+    salvage_percent DECIMAL(5,2) NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
     UNIQUE KEY unique_category_per_company (company_id, name)
 );
 
+-- Custom asset fields defined by company admins, optionally for one category only
+CREATE TABLE IF NOT EXISTS custom_fields (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    company_id INT NOT NULL,
+    category_id INT NULL,
+    field_key VARCHAR(50) NOT NULL,
+    label VARCHAR(100) NOT NULL,
+    field_type ENUM('text', 'number', 'date', 'select', 'multi_select', 'boolean') NOT NULL,
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    options JSON NULL,
+    min_value DOUBLE NULL,
+    max_value DOUBLE NULL,
+    max_length INT NULL,
+    pattern VARCHAR(255) NULL,
+    sort_order INT NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES asset_categories(id) ON DELETE CASCADE,
+    UNIQUE KEY uniq_custom_fields_key (company_id, field_key)
+);
+
 -- Assets table with company association
 CREATE TABLE IF NOT EXISTS assets (
     id INT AUTO_INCREMENT PRIMARY KEY,
@@ -83,15 +239,78 @@ CREATE TABLE IF NOT EXISTS assets (
     purchase_price DECIMAL(10,2),
     assigned_to INT NULL,
     notes TEXT,
-    barcode VARCHAR(255) UNIQUE,
-    qr_code VARCHAR(255) UNIQUE,
+    -- Asset tag, assigned once at creation, and the payload printed in 2D codes
+    barcode VARCHAR(255),
+    qr_code VARCHAR(255),
     created_by INT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at DATETIME NULL,
+    deleted_by INT NULL,
+    -- Depreciation overrides; NULL falls back to the category's defaults
+    depreciation_method ENUM('straight_line', 'declining_balance', 'sum_of_years') NULL,
+    useful_life_years INT NULL,
+    salvage_value DECIMAL(10,2) NULL,
+    -- An image attachment of the asset, checked by the application
+    primary_photo_id INT NULL,
+    -- Values of the company's custom fields, keyed by field key
+    custom_fields JSON NULL,
     FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
     FOREIGN KEY (category_id) REFERENCES asset_categories(id) ON DELETE SET NULL,
     FOREIGN KEY (assigned_to) REFERENCES users(id) ON DELETE SET NULL,
-    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE KEY uq_assets_company_barcode (company_id, barcode),
+    UNIQUE KEY uq_assets_company_qr_code (company_id, qr_code)
+);
+
+-- Last asset tag number handed out per company and tag prefix
+CREATE TABLE IF NOT EXISTS asset_tag_sequences (
+    company_id INT NOT NULL,
+    prefix VARCHAR(100) NOT NULL,
+    last_value BIGINT NOT NULL,
+    PRIMARY KEY (company_id, prefix),
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE
+);
+
+-- Files uploaded for an asset; contents are kept in the artifact storage
+CREATE TABLE IF NOT EXISTS asset_attachments (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    company_id INT NOT NULL,
+    asset_id INT NOT NULL,
+    kind ENUM('photo', 'invoice', 'warranty', 'manual', 'other') NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    width INT NULL,
+    height INT NULL,
+    description VARCHAR(500) NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    thumbnail_key VARCHAR(255) NULL,
+    uploaded_by INT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (asset_id) REFERENCES assets(id) ON DELETE CASCADE,
+    FOREIGN KEY (uploaded_by) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_asset_attachments_asset (company_id, asset_id)
+);
+
+-- Barcode / QR code scans with the scanning user and an optional GPS fix
+CREATE TABLE IF NOT EXISTS asset_scans (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    company_id INT NOT NULL,
+    asset_id INT NOT NULL,
+    user_id INT NULL,
+    code VARCHAR(255) NOT NULL,
+    matched_by ENUM('barcode', 'qr_code', 'legacy', 'serial_number') NOT NULL,
+    latitude DECIMAL(9,6) NULL,
+    longitude DECIMAL(9,6) NULL,
+    accuracy_meters DECIMAL(8,2) NULL,
+    scanned_at DATETIME NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (asset_id) REFERENCES assets(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_asset_scans_asset (company_id, asset_id, scanned_at),
+    INDEX idx_asset_scans_company (company_id, scanned_at)
 );
 
 -- Asset maintenance history
@@ -107,6 +326,12 @@ CREATE TABLE IF NOT EXISTS asset_maintenance (
     next_maintenance_date DATE,
     created_by INT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    status ENUM('open', 'completed', 'cancelled') NOT NULL DEFAULT 'completed',
+    scheduled_date DATE NULL,
+    completed_at DATETIME NULL,
+    recurrence_days INT NULL,
+    previous_asset_status VARCHAR(20) NULL,
+    INDEX idx_maintenance_company_asset_status (company_id, asset_id, status),
     FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
     FOREIGN KEY (asset_id) REFERENCES assets(id) ON DELETE CASCADE,
     FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
@@ -122,12 +347,114 @@ CREATE TABLE IF NOT EXISTS asset_assignments (
     assigned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     returned_at TIMESTAMP NULL,
     notes TEXT,
+    due_date DATE NULL,
+    returned_by INT NULL,
+    return_notes TEXT NULL,
+    -- 1 while the assignment is open, so the unique key allows one open assignment per asset
+    open_flag TINYINT GENERATED ALWAYS AS (IF(returned_at IS NULL, 1, NULL)) STORED,
+    UNIQUE KEY uq_assignments_open_asset (asset_id, open_flag),
+    INDEX idx_assignments_company_user (company_id, assigned_to, returned_at),
+    INDEX idx_assignments_company_due (company_id, returned_at, due_date),
     FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
     FOREIGN KEY (asset_id) REFERENCES assets(id) ON DELETE CASCADE,
     FOREIGN KEY (assigned_to) REFERENCES users(id) ON DELETE CASCADE,
     FOREIGN KEY (assigned_by) REFERENCES users(id) ON DELETE CASCADE
 );
 
+-- Asset audit trail (asset_id has no foreign key so history outlives deleted assets)
+CREATE TABLE IF NOT EXISTS asset_history (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    company_id INT NOT NULL,
+    asset_id INT NOT NULL,
+    action ENUM('create', 'update', 'delete', 'restore', 'purge', 'status_change', 'assignment') NOT NULL,
+    changes JSON NOT NULL,
+    user_id INT NULL,
+    request_id VARCHAR(64) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_asset_history_asset (company_id, asset_id, id),
+    INDEX idx_asset_history_request (request_id)
+);
+
+-- Label templates for barcode label sheets (sizes in mm, font sizes in points)
+CREATE TABLE IF NOT EXISTS label_templates (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    company_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    page_width DECIMAL(7,2) NOT NULL,
+    page_height DECIMAL(7,2) NOT NULL,
+    grid_rows INT NOT NULL,
+    grid_columns INT NOT NULL,
+    margin_top DECIMAL(7,2) NOT NULL DEFAULT 0,
+    margin_left DECIMAL(7,2) NOT NULL DEFAULT 0,
+    column_gap DECIMAL(7,2) NOT NULL DEFAULT 0,
+    row_gap DECIMAL(7,2) NOT NULL DEFAULT 0,
+    label_width DECIMAL(7,2) NOT NULL,
+    label_height DECIMAL(7,2) NOT NULL,
+    padding DECIMAL(7,2) NOT NULL DEFAULT 0,
+    fields JSON NOT NULL,
+    header_font_size DECIMAL(4,1) NOT NULL DEFAULT 0,
+    field_font_size DECIMAL(4,1) NOT NULL,
+    logo_position ENUM('none', 'top_left', 'top_right') NOT NULL DEFAULT 'none',
+    logo_height DECIMAL(7,2) NOT NULL DEFAULT 0,
+    photo_position ENUM('none', 'left', 'right') NOT NULL DEFAULT 'none',
+    photo_width DECIMAL(7,2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    INDEX idx_label_templates_company (company_id, name)
+);
+
+-- Generated files (reports, invoices, label sheets) kept in the artifact storage
+CREATE TABLE IF NOT EXISTS artifacts (
+    id CHAR(32) PRIMARY KEY,
+    company_id INT NOT NULL,
+    user_id INT NULL,
+    kind VARCHAR(50) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_artifacts_company (company_id, created_at),
+    INDEX idx_artifacts_expires (expires_at)
+);
+
+-- Background jobs (label sheets and reports) and their progress
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    company_id INT NOT NULL,
+    user_id INT NULL,
+    job_type VARCHAR(50) NOT NULL,
+    payload JSON NOT NULL,
+    status ENUM('queued', 'running', 'succeeded', 'failed', 'cancelled') NOT NULL DEFAULT 'queued',
+    progress TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 3,
+    cancel_requested TINYINT(1) NOT NULL DEFAULT 0,
+    claim_token CHAR(32) NULL,
+    error TEXT NULL,
+    result JSON NULL,
+    artifact_id CHAR(32) NULL,
+    artifact_name VARCHAR(255) NULL,
+    run_after DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    started_at DATETIME NULL,
+    heartbeat_at DATETIME NULL,
+    finished_at DATETIME NULL,
+    expires_at DATETIME NULL,
+    FOREIGN KEY (company_id) REFERENCES companies(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    UNIQUE KEY uq_jobs_claim_token (claim_token),
+    INDEX idx_jobs_queue (status, run_after),
+    INDEX idx_jobs_user (company_id, user_id, id),
+    INDEX idx_jobs_expires (expires_at)
+);
+
 -- Company settings
 CREATE TABLE IF NOT EXISTS company_settings (
     id INT AUTO_INCREMENT PRIMARY KEY,
@@ -169,7 +496,6 @@ CREATE INDEX idx_assets_institution ON assets(company_id, institution_name);
 CREATE INDEX idx_assets_department ON assets(company_id, department);
 CREATE INDEX idx_assets_status ON assets(company_id, status);
 CREATE INDEX idx_assets_type ON assets(company_id, asset_type);
-CREATE INDEX idx_assets_barcode ON assets(barcode);
-CREATE INDEX idx_assets_qr_code ON assets(qr_code);
+CREATE INDEX idx_assets_deleted ON assets(company_id, deleted_at);
 CREATE INDEX idx_users_company ON users(company_id);
 CREATE INDEX idx_categories_company ON asset_categories(company_id); 
\ No newline at end of file
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// assetFullTextColumns must match the column list of the ft_assets_search FULLTEXT index
+const assetFullTextColumns = `asset_name, asset_type, institution_name, department, manufacturer,
+	model_number, serial_number, location, notes`
+
+// maxFacetValues caps the number of values returned per facet
+const maxFacetValues = 20
+
+// snippetRadius is the number of characters kept either side of the first match in long fields
+const snippetRadius = 60
+
+// assetSearchFacets maps facet names in the response to the columns they count
+var assetSearchFacets = map[string]string{
+	"status":      "status",
+	"asset_type":  "asset_type",
+	"institution": "institution_name",
+	"department":  "department",
+}
+
+// AssetSearchQuery is a ranked full-text search over the company's assets
+type AssetSearchQuery struct {
+	Text     string
+	Filter   AssetFilter
+	Page     int
+	PageSize int
+}
+
+// AssetSearchHit is one matching asset and its relevance score
+type AssetSearchHit struct {
+	Asset Asset
+	Score float64
+}
+
+// AssetSearchResult is one page of hits plus the total and facet counts of the whole result set
+type AssetSearchResult struct {
+	Hits   []AssetSearchHit
+	Total  int
+	Facets map[string][]FacetCount
+}
+
+// searchTerms splits free text into the words the FULLTEXT parser will see
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// booleanSearchExpression turns free text into a BOOLEAN MODE expression in which
+// every word is required and may be a prefix ("dell lap" matches "Dell Laptop")
+func booleanSearchExpression(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = "+" + term + "*"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Search runs a full-text query combined with the structured filter.
+// Rows whose serial or model number equals the whole query rank first, so scanned
+// identifiers that the FULLTEXT parser splits into short tokens are still found.
+func (s *sqlAssetStore) Search(q AssetSearchQuery) (AssetSearchResult, error) {
+	result := AssetSearchResult{Facets: make(map[string][]FacetCount)}
+
+	clause, args := s.where(q.Filter)
+	scoreExpr := "0"
+	var scoreArgs []interface{}
+	orderBy := "asset_name, id"
+	var orderArgs []interface{}
+
+	text := strings.TrimSpace(q.Text)
+	if text != "" {
+		expr := booleanSearchExpression(searchTerms(text))
+		match := fmt.Sprintf("MATCH(%s) AGAINST(? IN BOOLEAN MODE)", assetFullTextColumns)
+		clause += fmt.Sprintf(" AND (%s OR serial_number = ? OR model_number = ?)", match)
+		args = append(args, expr, text, text)
+
+		scoreExpr = match
+		scoreArgs = []interface{}{expr}
+		orderBy = "(COALESCE(serial_number, '') = ? OR COALESCE(model_number, '') = ?) DESC, score DESC, id"
+		orderArgs = []interface{}{text, text}
+	}
+
+	if err := s.queryRow("SELECT COUNT(*) FROM assets WHERE "+clause, args...).Scan(&result.Total); err != nil {
+		return result, err
+	}
+
+	query := fmt.Sprintf("SELECT %s, %s AS score FROM assets WHERE %s ORDER BY %s LIMIT ? OFFSET ?",
+		assetColumns, scoreExpr, clause, orderBy)
+	queryArgs := append(append(append(append([]interface{}{}, scoreArgs...), args...), orderArgs...),
+		q.PageSize, (q.Page-1)*q.PageSize)
+
+	defaults, err := s.depreciationDefaults()
+	if err != nil {
+		return result, err
+	}
+	rows, err := s.query(query, queryArgs...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hit AssetSearchHit
+		asset, err := scanAsset(rows, &hit.Score)
+		if err != nil {
+			return result, err
+		}
+		applyDepreciation(&asset, defaults)
+		hit.Asset = asset
+		result.Hits = append(result.Hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	for name, column := range assetSearchFacets {
+		counts, err := s.facetCounts(column, clause, args)
+		if err != nil {
+			return result, err
+		}
+		result.Facets[name] = counts
+	}
+
+	return result, nil
+}
+
+// facetCounts counts the matching assets per value of a column, most frequent first
+func (s *sqlAssetStore) facetCounts(column, clause string, args []interface{}) ([]FacetCount, error) {
+	rows, err := s.query(fmt.Sprintf(
+		"SELECT %s, COUNT(*) AS n FROM assets WHERE %s AND %s IS NOT NULL AND %s != '' GROUP BY %s ORDER BY n DESC, %s LIMIT %d",
+		column, clause, column, column, column, column, maxFacetValues), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FacetCount{}
+	for rows.Next() {
+		var fc FacetCount
+		if err := rows.Scan(&fc.Value, &fc.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, fc)
+	}
+	return counts, rows.Err()
+}
+
+// highlighter wraps occurrences of the search terms in <mark> tags
+type highlighter struct {
+	re *regexp.Regexp
+}
+
+// newHighlighter builds a highlighter for free text, or nil when there is nothing to highlight
+func newHighlighter(text string) *highlighter {
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	return &highlighter{re: regexp.MustCompile(`(?i)(` + strings.Join(quoted, "|") + `)`)}
+}
+
+// highlight returns the HTML-escaped value with matches marked, trimmed to a snippet
+// around the first match when long, and false when the value does not match
+func (h *highlighter) highlight(value string) (string, bool) {
+	matches := h.re.FindAllStringIndex(value, -1)
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	start, end := 0, len(value)
+	if matches[0][0] > snippetRadius {
+		start = matches[0][0] - snippetRadius
+	}
+	if matches[0][1]+snippetRadius < end {
+		end = matches[0][1] + snippetRadius
+	}
+	// Keep the snippet on rune boundaries
+	for start > 0 && !utf8.RuneStart(value[start]) {
+		start--
+	}
+	for end < len(value) && !utf8.RuneStart(value[end]) {
+		end++
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, m := range matches {
+		if m[0] < start || m[1] > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(value[pos:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(value[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		pos = m[1]
+	}
+	b.WriteString(html.EscapeString(value[pos:end]))
+	if end < len(value) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
+
+// assetHighlights returns the highlighted text fields of an asset keyed by their response name
+func (h *highlighter) assetHighlights(asset Asset) map[string]string {
+	fields := map[string]string{
+		"assetName":       asset.AssetName,
+		"assetType":       safeString(asset.AssetType),
+		"institutionName": safeString(asset.InstitutionName),
+		"department":      safeString(asset.Department),
+		"manufacturer":    safeString(asset.Manufacturer),
+		"modelNumber":     safeString(asset.ModelNumber),
+		"serialNumber":    safeString(asset.SerialNumber),
+		"location":        safeString(asset.Location),
+		"notes":           safeString(asset.Notes),
+	}
+
+	highlights := make(map[string]string)
+	for name, value := range fields {
+		if marked, ok := h.highlight(value); ok {
+			highlights[name] = marked
+		}
+	}
+	return highlights
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Reasons a session was revoked
+const (
+	sessionRevokedLogout    = "logout"
+	sessionRevokedLogoutAll = "logout_all"
+	sessionRevokedAdmin     = "admin"
+	sessionRevokedReuse     = "refresh_token_reuse"
+	sessionRevokedUser      = "user_deactivated"
+	sessionRevokedTwoFactor = "two_factor_required"
+)
+
+// sessionCleanupInterval is how often expired and revoked sessions are deleted
+const sessionCleanupInterval = time.Hour
+
+// sessionRetentionDays is how long expired and revoked sessions are kept before deletion
+const sessionRetentionDays = 7
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errSessionEnded        = errors.New("session expired or revoked")
+	errRefreshTokenReused  = errors.New("refresh token was already used; the session has been revoked")
+	errTrialExpired        = errors.New("company trial has expired; please contact support")
+	errTwoFactorRequired   = errors.New("company policy now requires two-factor authentication for your role; sign in again")
+)
+
+// accessTokenTTL is how long an access token is valid, from ACCESS_TOKEN_TTL_MINUTES (default 15)
+func accessTokenTTL() time.Duration {
+	return time.Duration(envInt("ACCESS_TOKEN_TTL_MINUTES", 15)) * time.Minute
+}
+
+// refreshTokenTTL is how long a session lasts without being refreshed, from
+// REFRESH_TOKEN_TTL_DAYS (default 30)
+func refreshTokenTTL() time.Duration {
+	return time.Duration(envInt("REFRESH_TOKEN_TTL_DAYS", 30)) * 24 * time.Hour
+}
+
+// sessionMaxLifetime is how long a session lasts from sign-in however often it is
+// refreshed, from SESSION_MAX_DAYS (default 90)
+func sessionMaxLifetime() time.Duration {
+	return time.Duration(envInt("SESSION_MAX_DAYS", 90)) * 24 * time.Hour
+}
+
+// sessionExpiry returns when a session started at createdAt expires if it is used at
+// now: a refresh token lifetime later, but never past the session's maximum lifetime
+func sessionExpiry(createdAt, now time.Time) time.Time {
+	expiresAt := now.Add(refreshTokenTTL())
+	if limit := createdAt.Add(sessionMaxLifetime()); expiresAt.After(limit) {
+		return limit
+	}
+	return expiresAt
+}
+
+// randomToken returns n random bytes encoded for use in URLs and headers
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashRefreshToken returns the stored form of a refresh token. Only hashes are stored,
+// so a leaked sessions table cannot be used to refresh.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateJWT creates an access token for the given user, bound to a session
+func generateJWT(userID, companyID int, username, role, sessionID string) (string, time.Time, error) {
+	now := time.Now()
+	expirationTime := now.Add(accessTokenTTL())
+	claims := Claims{
+		UserID:    userID,
+		CompanyID: companyID,
+		Username:  username,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+
+	tokenString, err := jwtKeys.sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return tokenString, expirationTime, nil
+}
+
+// issueRefreshToken stores a new refresh token of a session and returns it
+func issueRefreshToken(tx *sql.Tx, sessionID string, now time.Time) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("INSERT INTO session_refresh_tokens (token_hash, session_id, created_at) VALUES (?, ?, ?)",
+		hashRefreshToken(token), sessionID, now)
+	return token, err
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// startSession opens a session for a user who has just authenticated and returns its
+// first access and refresh tokens
+func startSession(c *gin.Context, user User) (TokenResponse, error) {
+	var tokens TokenResponse
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return tokens, err
+	}
+	sessionID := hex.EncodeToString(buf)
+	now := time.Now()
+	expiresAt := sessionExpiry(now, now)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return tokens, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO user_sessions (id, company_id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID, user.CompanyID, user.ID, truncate(c.Request.UserAgent(), 255), c.ClientIP(), now, now, expiresAt)
+	if err != nil {
+		return tokens, err
+	}
+	refreshToken, err := issueRefreshToken(tx, sessionID, now)
+	if err != nil {
+		return tokens, err
+	}
+	accessToken, accessExpiresAt, err := generateJWT(user.ID, user.CompanyID, user.Username, user.Role, sessionID)
+	if err != nil {
+		return tokens, err
+	}
+	if err := tx.Commit(); err != nil {
+		return tokens, err
+	}
+	return TokenResponse{
+		Token:            accessToken,
+		ExpiresAt:        accessExpiresAt.Unix(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: expiresAt.Unix(),
+	}, nil
+}
+
+// refreshSession exchanges a refresh token for new tokens. Each refresh token works
+// once; presenting a used one means it was copied, so the whole session is revoked.
+// The company is checked again as at login, so sessions do not outlive a deactivated
+// company or an expired trial, and a session without 2FA ends once the company's policy
+// requires it of the user.
+func refreshSession(refreshToken string) (TokenResponse, error) {
+	var tokens TokenResponse
+	tx, err := db.Begin()
+	if err != nil {
+		return tokens, err
+	}
+	defer tx.Rollback()
+
+	var sessionID string
+	var usedAt, revokedAt, trialEndsAt *time.Time
+	var createdAt, expiresAt time.Time
+	var user User
+	var companyActive bool
+	err = tx.QueryRow(`
+		SELECT s.id, t.used_at, s.revoked_at, s.created_at, s.expires_at,
+			u.id, u.company_id, u.username, u.role, u.is_active, c.is_active, c.trial_ends_at
+		FROM session_refresh_tokens t
+		JOIN user_sessions s ON s.id = t.session_id
+		JOIN users u ON u.id = s.user_id
+		JOIN companies c ON c.id = s.company_id
+		WHERE t.token_hash = ?
+		FOR UPDATE`, hashRefreshToken(refreshToken)).Scan(
+		&sessionID, &usedAt, &revokedAt, &createdAt, &expiresAt,
+		&user.ID, &user.CompanyID, &user.Username, &user.Role, &user.IsActive, &companyActive, &trialEndsAt)
+	if err == sql.ErrNoRows {
+		return tokens, errInvalidRefreshToken
+	}
+	if err != nil {
+		return tokens, err
+	}
+
+	now := time.Now()
+	if revokedAt != nil || now.After(expiresAt) || !now.Before(createdAt.Add(sessionMaxLifetime())) ||
+		!user.IsActive || !companyActive {
+		return tokens, errSessionEnded
+	}
+	if usedAt != nil {
+		log.Printf("Refresh token reuse detected for session %s of user %d; revoking the session", sessionID, user.ID)
+		if _, err := revokeSessions(tx, "id = ?", []interface{}{sessionID}, sessionRevokedReuse); err != nil {
+			return tokens, err
+		}
+		if err := tx.Commit(); err != nil {
+			return tokens, err
+		}
+		return tokens, errRefreshTokenReused
+	}
+	if trialEndsAt != nil && now.After(*trialEndsAt) {
+		return tokens, errTrialExpired
+	}
+	required, err := twoFactorRequired(user.CompanyID, user.Role)
+	if err != nil {
+		return tokens, err
+	}
+	if required {
+		tf, err := loadUserTwoFactor(tx, user.ID)
+		if err != nil {
+			return tokens, err
+		}
+		if tf == nil || tf.EnabledAt == nil {
+			if _, err := revokeSessions(tx, "id = ?", []interface{}{sessionID}, sessionRevokedTwoFactor); err != nil {
+				return tokens, err
+			}
+			if err := tx.Commit(); err != nil {
+				return tokens, err
+			}
+			return tokens, errTwoFactorRequired
+		}
+	}
+
+	newExpiresAt := sessionExpiry(createdAt, now)
+	_, err = tx.Exec("UPDATE session_refresh_tokens SET used_at = ? WHERE token_hash = ?", now, hashRefreshToken(refreshToken))
+	if err != nil {
+		return tokens, err
+	}
+	_, err = tx.Exec("UPDATE user_sessions SET last_used_at = ?, expires_at = ? WHERE id = ?", now, newExpiresAt, sessionID)
+	if err != nil {
+		return tokens, err
+	}
+	newRefreshToken, err := issueRefreshToken(tx, sessionID, now)
+	if err != nil {
+		return tokens, err
+	}
+	if err := tx.Commit(); err != nil {
+		return tokens, err
+	}
+
+	accessToken, accessExpiresAt, err := generateJWT(user.ID, user.CompanyID, user.Username, user.Role, sessionID)
+	if err != nil {
+		return tokens, err
+	}
+	return TokenResponse{
+		Token:            accessToken,
+		ExpiresAt:        accessExpiresAt.Unix(),
+		RefreshToken:     newRefreshToken,
+		RefreshExpiresAt: newExpiresAt.Unix(),
+	}, nil
+}
+
+// revokeSessions revokes the active sessions matching a condition and returns how many
+// were revoked. Access tokens of revoked sessions are refused by authMiddleware at once.
+func revokeSessions(conn execer, condition string, args []interface{}, reason string) (int64, error) {
+	result, err := conn.Exec("UPDATE user_sessions SET revoked_at = ?, revoked_reason = ? WHERE revoked_at IS NULL AND "+condition,
+		append([]interface{}{time.Now(), reason}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// revokeUserSessions revokes every active session of a user of a company
+func revokeUserSessions(companyID, userID int, reason string) (int64, error) {
+	return revokeSessions(db, "company_id = ? AND user_id = ?", []interface{}{companyID, userID}, reason)
+}
+
+// getCurrentSessionID returns the session of the current access token
+func getCurrentSessionID(c *gin.Context) string {
+	return c.GetString("session_id")
+}
+
+// startSessionCleanup deletes sessions that expired or were revoked over a week ago,
+// with their refresh tokens, and unfinished two-factor and single sign-on logins
+func startSessionCleanup() {
+	go func() {
+		for {
+			result, err := db.Exec(`
+				DELETE FROM user_sessions
+				WHERE expires_at < NOW() - INTERVAL ? DAY OR revoked_at < NOW() - INTERVAL ? DAY`,
+				sessionRetentionDays, sessionRetentionDays)
+			if err != nil {
+				log.Printf("Error deleting old sessions: %v", err)
+			} else if n, _ := result.RowsAffected(); n > 0 {
+				log.Printf("Deleted %d old sessions", n)
+			}
+			deleteExpiredLoginChallenges()
+			deleteExpiredSSOStates()
+			time.Sleep(sessionCleanupInterval)
+		}
+	}()
+}
+
+// refreshHandler exchanges a refresh token for a new access token and refresh token
+func refreshHandler(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+
+	tokens, err := refreshSession(req.RefreshToken)
+	if err != nil {
+		if err == errInvalidRefreshToken || err == errSessionEnded || err == errRefreshTokenReused ||
+			err == errTwoFactorRequired {
+			c.JSON(http.StatusUnauthorized, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if err == errTrialExpired {
+			c.JSON(http.StatusForbidden, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		log.Printf("Error refreshing session: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Token refreshed",
+		Data:    tokens,
+	})
+}
+
+// logoutHandler ends the current session; its access and refresh tokens stop working
+func logoutHandler(c *gin.Context) {
+	_, err := revokeSessions(db, "id = ? AND user_id = ?",
+		[]interface{}{getCurrentSessionID(c), getCurrentUserID(c)}, sessionRevokedLogout)
+	if err != nil {
+		log.Printf("Error revoking session: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Logged out",
+	})
+}
+
+// logoutAllHandler ends every session of the current user, on all devices
+func logoutAllHandler(c *gin.Context) {
+	revoked, err := revokeUserSessions(getCurrentCompanyID(c), getCurrentUserID(c), sessionRevokedLogoutAll)
+	if err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Logged out of all sessions",
+		Data:    gin.H{"revoked": revoked},
+	})
+}
+
+// getSessionsHandler lists the current user's active sessions, marking the one making
+// the request
+func getSessionsHandler(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT id, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM user_sessions
+		WHERE company_id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`, getCurrentCompanyID(c), getCurrentUserID(c))
+	if err != nil {
+		log.Printf("Error fetching sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	sessions := []UserSession{}
+	for rows.Next() {
+		var s UserSession
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			log.Printf("Error scanning session: %v", err)
+			continue
+		}
+		s.Current = s.ID == getCurrentSessionID(c)
+		sessions = append(sessions, s)
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    sessions,
+	})
+}
+
+// revokeUserSessionsHandler ends every session of a user of the company (admin only)
+func revokeUserSessionsHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+		return
+	}
+
+	// Check the user belongs to this company and has no permissions the current user lacks
+	if status, message := checkManageableUser(c, companyID, userID); status != 0 {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	revoked, err := revokeUserSessions(companyID, userID, sessionRevokedAdmin)
+	if err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "User sessions revoked",
+		Data:    gin.H{"revoked": revoked},
+	})
+}
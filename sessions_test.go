@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// useTestJWTKeys signs tokens with a test secret for the duration of a test
+func useTestJWTKeys(t *testing.T) {
+	t.Helper()
+	t.Setenv("JWT_ALGORITHM", "")
+	t.Setenv("JWT_SECRET", "a-test-secret-that-is-at-least-32-bytes")
+	t.Setenv("JWT_KEY_ID", "")
+	t.Setenv("JWT_PREVIOUS_SECRETS", "")
+	t.Setenv("JWT_PREVIOUS_PUBLIC_KEY_FILES", "")
+	saved := jwtKeys
+	if err := loadJWTKeys(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { jwtKeys = saved })
+}
+
+// sessionRow is the row refreshSession reads for a refresh token
+type sessionRow struct {
+	createdAt     time.Time
+	role          string
+	companyActive bool
+	trialEndsAt   *time.Time
+}
+
+func expectSession(mock sqlmock.Sqlmock, s sessionRow) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM session_refresh_tokens t")).
+		WithArgs(hashRefreshToken("the-token")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "used_at", "revoked_at", "created_at", "expires_at",
+			"id", "company_id", "username", "role", "is_active", "is_active", "trial_ends_at"}).
+			AddRow("session-1", nil, nil, s.createdAt, time.Now().Add(time.Hour),
+				9, ownCompany, "ada", s.role, true, s.companyActive, s.trialEndsAt))
+}
+
+func TestRefreshSessionEndsWithCompany(t *testing.T) {
+	t.Setenv("SESSION_MAX_DAYS", "90")
+	trialEnded := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		session sessionRow
+		want    error
+	}{
+		{"past the maximum lifetime", sessionRow{time.Now().Add(-91 * 24 * time.Hour), "user", true, nil}, errSessionEnded},
+		{"company deactivated", sessionRow{time.Now(), "user", false, nil}, errSessionEnded},
+		{"trial expired", sessionRow{time.Now(), "user", true, &trialEnded}, errTrialExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := useMockDB(t)
+			expectSession(mock, tt.session)
+			mock.ExpectRollback()
+
+			if _, err := refreshSession("the-token"); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			checkMock(t, mock)
+		})
+	}
+}
+
+// TestRefreshSessionMaxLifetime checks that refreshing does not move a session's expiry
+// past its maximum lifetime
+func TestRefreshSessionMaxLifetime(t *testing.T) {
+	t.Setenv("REFRESH_TOKEN_TTL_DAYS", "30")
+	t.Setenv("SESSION_MAX_DAYS", "90")
+	createdAt := time.Now().Add(-80 * 24 * time.Hour).Truncate(time.Second)
+	limit := createdAt.Add(90 * 24 * time.Hour)
+	useTestJWTKeys(t)
+
+	mock := useMockDB(t)
+	expectSession(mock, sessionRow{createdAt, "user", true, nil})
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE session_refresh_tokens SET used_at = ?")).
+		WithArgs(sqlmock.AnyArg(), hashRefreshToken("the-token")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_sessions SET last_used_at = ?, expires_at = ?")).
+		WithArgs(sqlmock.AnyArg(), limit, "session-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO session_refresh_tokens")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	tokens, err := refreshSession("the-token")
+	if err != nil {
+		t.Fatalf("refreshSession: %v", err)
+	}
+	if tokens.RefreshExpiresAt != limit.Unix() {
+		t.Fatalf("refresh expires at %d, want %d", tokens.RefreshExpiresAt, limit.Unix())
+	}
+	checkMock(t, mock)
+}
+
+// TestLoginWithoutPermissionsStartsNoSession checks that a login whose role permissions
+// cannot be read fails before a session is opened
+func TestLoginWithoutPermissionsStartsNoSession(t *testing.T) {
+	mock := useMockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM company_roles r")).
+		WithArgs(ownCompany, "support").
+		WillReturnError(sqlmock.ErrCancelled)
+
+	c, w := userRequest("", 0, "")
+	completeLogin(c, User{ID: 9, CompanyID: ownCompany, Username: "ada", Role: "support"}, Company{ID: ownCompany}, nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d %s, want 500", w.Code, w.Body)
+	}
+	checkMock(t, mock)
+}
+
+// TestRefreshSessionRequiresTwoFactor checks that a session without 2FA ends at its next
+// refresh once company policy requires 2FA of the user
+func TestRefreshSessionRequiresTwoFactor(t *testing.T) {
+	mock := useMockDB(t)
+	expectSession(mock, sessionRow{time.Now(), "admin", true, nil})
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT setting_value FROM company_settings")).
+		WithArgs(ownCompany, twoFactorRequiredSetting).
+		WillReturnRows(sqlmock.NewRows([]string{"setting_value"}).AddRow("true"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT secret, enabled_at FROM user_two_factor WHERE user_id = ?")).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled_at"}))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_sessions SET revoked_at = ?")).
+		WithArgs(sqlmock.AnyArg(), sessionRevokedTwoFactor, "session-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := refreshSession("the-token"); err != errTwoFactorRequired {
+		t.Fatalf("got %v, want errTwoFactorRequired", err)
+	}
+	checkMock(t, mock)
+}
@@ -0,0 +1,871 @@
+package main
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// defaultSSOScopes are requested when a company does not configure scopes
+	defaultSSOScopes = "openid email profile"
+
+	// ssoStateTTL is how long a user has to sign in at the provider
+	ssoStateTTL = 10 * time.Minute
+
+	// ssoTicketTTL is how long the frontend has to exchange a finished sign-in for tokens
+	ssoTicketTTL = time.Minute
+
+	// ssoStateCookie binds a sign-in to the browser that started it
+	ssoStateCookie = "sso_state"
+
+	// loginChallengeSSO marks login_challenges rows that are single sign-on tickets
+	loginChallengeSSO = "sso"
+)
+
+// Reasons a single sign-on is refused after the provider vouched for the user
+var (
+	errSSONoAccount  = errors.New("no account matches this sign-in and automatic provisioning is off")
+	errSSOInactive   = errors.New("your account is deactivated")
+	errSSONoEmail    = errors.New("the identity provider did not return an email address")
+	errSSOEmailTaken = errors.New("an account with this email exists, but the identity provider did not verify the email")
+	errSSONoLink     = errors.New("your account has more permissions than the user who configured single sign-on")
+)
+
+// publicBaseURL is the externally visible URL of this server, from PUBLIC_URL or else
+// the request
+func publicBaseURL(c *gin.Context) string {
+	if base := strings.TrimSpace(os.Getenv("PUBLIC_URL")); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// ssoReturnURL is the frontend page single sign-on returns to, from SSO_RETURN_URL.
+// Without it the callback responds with the login response itself.
+func ssoReturnURL() string {
+	return strings.TrimSpace(os.Getenv("SSO_RETURN_URL"))
+}
+
+// loadCompanySSO returns a company's single sign-on configuration, or nil when it has none
+func loadCompanySSO(companyID int) (*CompanySSO, error) {
+	var cfg CompanySSO
+	var roleMapping sql.NullString
+	var configuredBy sql.NullInt64
+	err := db.QueryRow(`
+		SELECT issuer, client_id, client_secret, scopes, role_claim, role_mapping, default_role,
+		       auto_provision, disable_password_login, enabled, configured_by, updated_at
+		FROM company_sso WHERE company_id = ?`, companyID).Scan(
+		&cfg.Issuer, &cfg.ClientID, &cfg.ClientSecret, &cfg.Scopes, &cfg.RoleClaim, &roleMapping, &cfg.DefaultRole,
+		&cfg.AutoProvision, &cfg.DisablePasswordLogin, &cfg.Enabled, &configuredBy, &cfg.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg.Configured = true
+	cfg.ClientSecretSet = cfg.ClientSecret != ""
+	if configuredBy.Valid {
+		id := int(configuredBy.Int64)
+		cfg.ConfiguredBy = &id
+	}
+	cfg.RoleMapping = map[string]string{}
+	if roleMapping.Valid && roleMapping.String != "" {
+		if err := json.Unmarshal([]byte(roleMapping.String), &cfg.RoleMapping); err != nil {
+			return nil, fmt.Errorf("role_mapping: %v", err)
+		}
+	}
+	return &cfg, nil
+}
+
+// passwordLoginDisabled reports whether a company only allows single sign-on
+func passwordLoginDisabled(companyID int) (bool, error) {
+	var disabled bool
+	err := db.QueryRow("SELECT disable_password_login AND enabled FROM company_sso WHERE company_id = ?",
+		companyID).Scan(&disabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return disabled, err
+}
+
+// mappedRole returns the role the identity's role claim values map to, or the default
+// role when none match. When several match, the role with the most permissions wins.
+func (cfg *CompanySSO) mappedRole(companyID int, identity *oidcIdentity) (string, error) {
+	best, bestCount := cfg.DefaultRole, -1
+	for _, value := range identity.claimValues(cfg.RoleClaim) {
+		role, ok := cfg.RoleMapping[value]
+		if !ok {
+			continue
+		}
+		perms, _, err := rolePermissions(companyID, role)
+		if err != nil {
+			return "", err
+		}
+		if len(perms) > bestCount {
+			best, bestCount = role, len(perms)
+		}
+	}
+	return best, nil
+}
+
+// assignsRole reports whether single sign-on can give users a role
+func (cfg *CompanySSO) assignsRole(role string) bool {
+	if cfg.DefaultRole == role {
+		return true
+	}
+	for _, r := range cfg.RoleMapping {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSSOLinkable makes sure the current user holds every permission of the users a
+// provider at issuer could sign in as by email: everyone not linked to it yet. Otherwise
+// whoever configures single sign-on could take over a more privileged account through a
+// provider they control. It returns the HTTP status and error to respond with, or 0.
+func checkSSOLinkable(c *gin.Context, companyID int, issuer string) (int, string) {
+	internalError := func(err error) (int, string) {
+		log.Printf("Error checking single sign-on accounts: %v", err)
+		return http.StatusInternalServerError, "Internal Server Error"
+	}
+	rows, err := db.Query(`
+		SELECT DISTINCT u.role FROM users u
+		WHERE u.company_id = ? AND NOT EXISTS (
+			SELECT 1 FROM user_identities ui
+			WHERE ui.company_id = u.company_id AND ui.user_id = u.id AND ui.issuer = ?)`, companyID, issuer)
+	if err != nil {
+		return internalError(err)
+	}
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			rows.Close()
+			return internalError(err)
+		}
+		roles = append(roles, role)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return internalError(err)
+	}
+
+	linkable := make(map[string]bool)
+	for _, role := range roles {
+		perms, _, err := rolePermissions(companyID, role)
+		if err != nil {
+			return internalError(err)
+		}
+		for p := range perms {
+			linkable[p] = true
+		}
+	}
+	missing, err := missingPermissions(c, linkable)
+	if err != nil {
+		return internalError(err)
+	}
+	if len(missing) > 0 {
+		return http.StatusForbidden, "Single sign-on could sign in as users with permissions you do not have: " + strings.Join(missing, ", ")
+	}
+	return 0, ""
+}
+
+// mayLinkByEmail reports whether a sign-in matched by email may be linked to a user: only
+// when whoever saved the configuration holds every permission of the user's role, which
+// also covers users created or promoted since
+func (cfg *CompanySSO) mayLinkByEmail(companyID int, user User) (bool, error) {
+	if cfg.ConfiguredBy == nil {
+		return false, nil
+	}
+	var role string
+	err := db.QueryRow("SELECT role FROM users WHERE id = ? AND company_id = ? AND is_active = true",
+		*cfg.ConfiguredBy, companyID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	own, _, err := rolePermissions(companyID, role)
+	if err != nil {
+		return false, err
+	}
+	perms, _, err := rolePermissions(companyID, user.Role)
+	if err != nil {
+		return false, err
+	}
+	return len(permissionsLacking(own, perms)) == 0, nil
+}
+
+// resolveSSOUser finds the company user an identity signs in as: the user linked to it
+// earlier, else the user with its verified email, else a new user when provisioning is
+// on. With a role claim configured, the user's role follows the provider at every login,
+// except that it never demotes the last user who can manage users or the company.
+func resolveSSOUser(cfg *CompanySSO, companyID int, identity *oidcIdentity) (User, error) {
+	const columns = "u.id, u.company_id, u.username, u.email, u.first_name, u.last_name, u.role, u.is_active, u.last_login"
+	var user User
+	scan := func(row *sql.Row) error {
+		return row.Scan(&user.ID, &user.CompanyID, &user.Username, &user.Email,
+			&user.FirstName, &user.LastName, &user.Role, &user.IsActive, &user.LastLogin)
+	}
+
+	err := scan(db.QueryRow(`
+		SELECT `+columns+` FROM user_identities ui JOIN users u ON u.id = ui.user_id
+		WHERE ui.company_id = ? AND ui.issuer = ? AND ui.subject = ?`, companyID, cfg.Issuer, identity.Subject))
+	linked := err == nil
+	if err == sql.ErrNoRows {
+		if identity.Email == "" {
+			return user, errSSONoEmail
+		}
+		err = scan(db.QueryRow(`SELECT `+columns+` FROM users u WHERE u.company_id = ? AND u.email = ?`,
+			companyID, identity.Email))
+		if err == nil && !identity.EmailVerified {
+			return user, errSSOEmailTaken
+		}
+		if err == nil {
+			ok, err := cfg.mayLinkByEmail(companyID, user)
+			if err != nil {
+				return user, err
+			}
+			if !ok {
+				log.Printf("Single sign-on refused to link user %d, who has permissions beyond the configuration's", user.ID)
+				return user, errSSONoLink
+			}
+		}
+		if err == sql.ErrNoRows {
+			if !cfg.AutoProvision {
+				return user, errSSONoAccount
+			}
+			user, err = provisionSSOUser(cfg, companyID, identity)
+		}
+	}
+	if err != nil {
+		return user, err
+	}
+	if !user.IsActive {
+		return user, errSSOInactive
+	}
+
+	if cfg.RoleClaim != "" {
+		role, err := cfg.mappedRole(companyID, identity)
+		if err != nil {
+			return user, err
+		}
+		if role != user.Role {
+			role, err = keepLastAdministrator(companyID, user, role)
+			if err != nil {
+				return user, err
+			}
+		}
+		if role != user.Role {
+			if _, err := db.Exec("UPDATE users SET role = ?, updated_at = NOW() WHERE id = ? AND company_id = ?",
+				role, user.ID, companyID); err != nil {
+				return user, err
+			}
+			log.Printf("Single sign-on changed the role of user %d from %s to %s", user.ID, user.Role, role)
+			user.Role = role
+		}
+	}
+
+	if linked {
+		_, err = db.Exec(`
+			UPDATE user_identities SET email = ?, last_login_at = NOW()
+			WHERE company_id = ? AND issuer = ? AND subject = ?`, identity.Email, companyID, cfg.Issuer, identity.Subject)
+	} else {
+		_, err = db.Exec(`
+			INSERT INTO user_identities (company_id, user_id, issuer, subject, email, created_at, last_login_at)
+			VALUES (?, ?, ?, ?, ?, NOW(), NOW())`, companyID, user.ID, cfg.Issuer, identity.Subject, identity.Email)
+	}
+	return user, err
+}
+
+// keepLastAdministrator returns the role a provider's role claim may give a user: the
+// claimed role, unless it would take users:manage or company:manage from the last active
+// user holding it, in which case the user keeps their role
+func keepLastAdministrator(companyID int, user User, claimed string) (string, error) {
+	current, _, err := rolePermissions(companyID, user.Role)
+	if err != nil {
+		return "", err
+	}
+	perms, _, err := rolePermissions(companyID, claimed)
+	if err != nil {
+		return "", err
+	}
+	permission, err := lastHeldPermission(db, companyID, permissionsLacking(perms, current), "", user.ID)
+	if err != nil {
+		return "", err
+	}
+	if permission != "" {
+		log.Printf("Single sign-on kept the role %s of user %d, the last user with %s, instead of %s",
+			user.Role, user.ID, permission, claimed)
+		return user.Role, nil
+	}
+	return claimed, nil
+}
+
+// provisionSSOUser creates the user for a first single sign-on. The username comes from
+// preferred_username or the email, numbered when taken. The user gets an unusable
+// random password, so they can only sign in through the provider.
+func provisionSSOUser(cfg *CompanySSO, companyID int, identity *oidcIdentity) (User, error) {
+	var user User
+	base := strings.TrimSpace(identity.Username)
+	if base == "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	username := base
+	for n := 2; ; n++ {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE company_id = ? AND username = ?",
+			companyID, username).Scan(&count); err != nil {
+			return user, err
+		}
+		if count == 0 {
+			break
+		}
+		username = fmt.Sprintf("%s%d", base, n)
+	}
+
+	password, err := randomToken(32)
+	if err != nil {
+		return user, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return user, err
+	}
+	role := cfg.DefaultRole
+	if cfg.RoleClaim != "" {
+		if role, err = cfg.mappedRole(companyID, identity); err != nil {
+			return user, err
+		}
+	}
+	optional := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return &s
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO users (company_id, username, email, password_hash, first_name, last_name, role)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		companyID, username, identity.Email, string(hashedPassword), optional(identity.FirstName), optional(identity.LastName), role)
+	if err != nil {
+		return user, err
+	}
+	userID, _ := result.LastInsertId()
+	log.Printf("Single sign-on provisioned user %d (%s) in company %d", userID, username, companyID)
+
+	return User{
+		ID:        int(userID),
+		CompanyID: companyID,
+		Username:  username,
+		Email:     identity.Email,
+		FirstName: optional(identity.FirstName),
+		LastName:  optional(identity.LastName),
+		Role:      role,
+		IsActive:  true,
+	}, nil
+}
+
+// getCompanySSOHandler returns the company's single sign-on configuration, with the
+// redirect URI to register at the provider even before it is configured (admin only)
+func getCompanySSOHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	cfg, err := loadCompanySSO(companyID)
+	if err != nil {
+		log.Printf("Error fetching single sign-on configuration: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	if cfg == nil {
+		cfg = &CompanySSO{Scopes: defaultSSOScopes, RoleMapping: map[string]string{}, DefaultRole: "user", AutoProvision: true}
+	}
+
+	var companyCode string
+	if err := db.QueryRow("SELECT company_code FROM companies WHERE id = ?", companyID).Scan(&companyCode); err != nil {
+		log.Printf("Error fetching company: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	cfg.RedirectURI = publicBaseURL(c) + "/api/sso/callback"
+	cfg.LoginURL = publicBaseURL(c) + "/api/sso/" + url.PathEscape(companyCode) + "/login"
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    cfg,
+	})
+}
+
+// updateCompanySSOHandler configures the company's OpenID Connect provider (admin only).
+// The issuer's discovery document is fetched to check it before saving.
+func updateCompanySSOHandler(c *gin.Context) {
+	var req CompanySSORequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+	companyID := getCurrentCompanyID(c)
+
+	badRequest := func(message string) {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+	}
+	internalError := func(err error) {
+		log.Printf("Error saving single sign-on configuration: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	req.Issuer = strings.TrimSpace(req.Issuer)
+	if len(req.Issuer) > 255 {
+		badRequest("issuer must be at most 255 characters")
+		return
+	}
+	if err := validateOIDCIssuer(req.Issuer); err != nil {
+		badRequest(err.Error())
+		return
+	}
+	if req.Scopes = strings.Join(strings.Fields(req.Scopes), " "); req.Scopes == "" {
+		req.Scopes = defaultSSOScopes
+	}
+	hasOpenID := false
+	for _, scope := range strings.Fields(req.Scopes) {
+		hasOpenID = hasOpenID || scope == "openid"
+	}
+	if !hasOpenID {
+		badRequest("scopes must include openid")
+		return
+	}
+	if req.DefaultRole == "" {
+		req.DefaultRole = "user"
+	}
+	if req.RoleMapping == nil {
+		req.RoleMapping = map[string]string{}
+	}
+	// Single sign-on can only hand out roles the admin configuring it could assign
+	roles := []string{req.DefaultRole}
+	for value, role := range req.RoleMapping {
+		if value == "" {
+			badRequest("role_mapping claim values cannot be empty")
+			return
+		}
+		roles = append(roles, role)
+	}
+	for _, role := range roles {
+		if status, message := checkAssignableRole(c, companyID, role); status != 0 {
+			c.JSON(status, APIResponse{
+				Success: false,
+				Error:   message,
+			})
+			return
+		}
+	}
+	// Nor can it sign in as users with permissions the admin lacks
+	if status, message := checkSSOLinkable(c, companyID, req.Issuer); status != 0 {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+	autoProvision := req.AutoProvision == nil || *req.AutoProvision
+	disablePasswordLogin := req.DisablePasswordLogin != nil && *req.DisablePasswordLogin
+	enabled := req.Enabled == nil || *req.Enabled
+
+	existing, err := loadCompanySSO(companyID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if req.ClientSecret == "" {
+		if existing == nil || !existing.ClientSecretSet {
+			badRequest("client_secret is required")
+			return
+		}
+		req.ClientSecret = existing.ClientSecret
+	}
+
+	// Only an admin who has signed in through this provider may turn password login off,
+	// so a misconfigured provider cannot lock everyone out
+	if disablePasswordLogin && enabled {
+		var linked int
+		err := db.QueryRow("SELECT COUNT(*) FROM user_identities WHERE company_id = ? AND user_id = ? AND issuer = ?",
+			companyID, getCurrentUserID(c), req.Issuer).Scan(&linked)
+		if err != nil {
+			internalError(err)
+			return
+		}
+		if linked == 0 {
+			badRequest("Sign in with single sign-on at least once before disabling password login")
+			return
+		}
+	}
+
+	if _, err := discoverOIDC(req.Issuer); err != nil {
+		badRequest("Could not load the provider's discovery document: " + err.Error())
+		return
+	}
+
+	roleMapping, err := json.Marshal(req.RoleMapping)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	_, err = db.Exec(`
+		INSERT INTO company_sso (company_id, issuer, client_id, client_secret, scopes, role_claim, role_mapping,
+			default_role, auto_provision, disable_password_login, enabled, configured_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), NOW())
+		ON DUPLICATE KEY UPDATE issuer = VALUES(issuer), client_id = VALUES(client_id),
+			client_secret = VALUES(client_secret), scopes = VALUES(scopes), role_claim = VALUES(role_claim),
+			role_mapping = VALUES(role_mapping), default_role = VALUES(default_role),
+			auto_provision = VALUES(auto_provision), disable_password_login = VALUES(disable_password_login),
+			enabled = VALUES(enabled), configured_by = VALUES(configured_by), updated_at = NOW()`,
+		companyID, req.Issuer, strings.TrimSpace(req.ClientID), req.ClientSecret, req.Scopes,
+		strings.TrimSpace(req.RoleClaim), string(roleMapping), req.DefaultRole, autoProvision, disablePasswordLogin, enabled,
+		getCurrentUserID(c))
+	if err != nil {
+		internalError(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Single sign-on configuration saved",
+	})
+}
+
+// deleteCompanySSOHandler removes the company's single sign-on configuration, which
+// turns password login back on (admin only). Links between users and provider identities
+// are kept for when it is configured again.
+func deleteCompanySSOHandler(c *gin.Context) {
+	if _, err := db.Exec("DELETE FROM company_sso WHERE company_id = ?", getCurrentCompanyID(c)); err != nil {
+		log.Printf("Error deleting single sign-on configuration: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Single sign-on configuration removed",
+	})
+}
+
+// ssoLoginHandler starts single sign-on for a company: it remembers a state, nonce and
+// PKCE verifier for the callback and redirects the browser to the provider
+func ssoLoginHandler(c *gin.Context) {
+	company, err := loadLoginCompany("company_code = ?", c.Param("company_code"))
+	var cfg *CompanySSO
+	if err == nil {
+		cfg, err = loadCompanySSO(company.ID)
+	}
+	if err == sql.ErrNoRows || (err == nil && (cfg == nil || !cfg.Enabled)) {
+		c.JSON(http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Single sign-on is not configured for this company",
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error starting single sign-on: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	provider, err := discoverOIDC(cfg.Issuer)
+	if err != nil {
+		log.Printf("Error discovering identity provider %s: %v", cfg.Issuer, err)
+		c.JSON(http.StatusBadGateway, APIResponse{
+			Success: false,
+			Error:   "The identity provider is unavailable",
+		})
+		return
+	}
+
+	var state, nonce, verifier string
+	for _, token := range []*string{&state, &nonce, &verifier} {
+		if *token, err = randomToken(32); err != nil {
+			break
+		}
+	}
+	redirectURI := publicBaseURL(c) + "/api/sso/callback"
+	if err == nil {
+		_, err = db.Exec(`
+			INSERT INTO sso_login_states (state_hash, company_id, nonce, code_verifier, redirect_uri, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, NOW(), ?)`,
+			sha256Hex(state), company.ID, nonce, verifier, redirectURI, time.Now().Add(ssoStateTTL))
+	}
+	if err != nil {
+		log.Printf("Error starting single sign-on: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	secure := strings.HasPrefix(redirectURI, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, state, int(ssoStateTTL.Seconds()), "/api/sso", "", secure, true)
+	c.Redirect(http.StatusFound, provider.authorizationURL(cfg.ClientID, redirectURI, cfg.Scopes, state, nonce, verifier))
+}
+
+// ssoFail ends a single sign-on with an error, on the frontend page when there is one
+func ssoFail(c *gin.Context, status int, message string) {
+	if returnURL := ssoReturnURL(); returnURL != "" {
+		c.Redirect(http.StatusFound, returnURL+"#error="+url.QueryEscape(message))
+		return
+	}
+	c.JSON(status, APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// ssoCallbackHandler finishes single sign-on when the provider sends the browser back:
+// it redeems the code, verifies the ID token and signs the matching user in. With
+// SSO_RETURN_URL set it redirects there with a one-time #ticket for POST /api/login/sso;
+// otherwise it responds with the login response. Like a password, the provider is only
+// the first factor: company 2FA policy applies as to any login.
+func ssoCallbackHandler(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		if description := c.Query("error_description"); description != "" {
+			providerError += ": " + description
+		}
+		ssoFail(c, http.StatusUnauthorized, "The identity provider refused the sign-in: "+providerError)
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	cookie, _ := c.Cookie(ssoStateCookie)
+	c.SetCookie(ssoStateCookie, "", -1, "/api/sso", "", false, true)
+	if state == "" || code == "" || !hmac.Equal([]byte(cookie), []byte(state)) {
+		ssoFail(c, http.StatusBadRequest, "Invalid single sign-on state; start signing in again")
+		return
+	}
+
+	internalError := func(err error) {
+		log.Printf("Error completing single sign-on: %v", err)
+		ssoFail(c, http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	// Each state is used once
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer tx.Rollback()
+	var companyID int
+	var nonce, verifier, redirectURI string
+	var expiresAt time.Time
+	err = tx.QueryRow(`
+		SELECT company_id, nonce, code_verifier, redirect_uri, expires_at
+		FROM sso_login_states WHERE state_hash = ? FOR UPDATE`, sha256Hex(state)).Scan(
+		&companyID, &nonce, &verifier, &redirectURI, &expiresAt)
+	if err == nil {
+		if _, err = tx.Exec("DELETE FROM sso_login_states WHERE state_hash = ?", sha256Hex(state)); err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err == sql.ErrNoRows || (err == nil && time.Now().After(expiresAt)) {
+		ssoFail(c, http.StatusBadRequest, "Invalid single sign-on state; start signing in again")
+		return
+	}
+	if err != nil {
+		internalError(err)
+		return
+	}
+
+	company, err := loadLoginCompany("id = ?", companyID)
+	if err == sql.ErrNoRows {
+		ssoFail(c, http.StatusUnauthorized, "Invalid credentials or company")
+		return
+	}
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if status, message := checkLoginCompany(company, true); status != 0 {
+		ssoFail(c, status, message)
+		return
+	}
+	cfg, err := loadCompanySSO(company.ID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if cfg == nil || !cfg.Enabled {
+		ssoFail(c, http.StatusNotFound, "Single sign-on is not configured for this company")
+		return
+	}
+
+	provider, err := discoverOIDC(cfg.Issuer)
+	var rawIDToken string
+	if err == nil {
+		rawIDToken, err = provider.exchangeCode(cfg.ClientID, cfg.ClientSecret, code, redirectURI, verifier)
+	}
+	if err != nil {
+		log.Printf("Error redeeming single sign-on code with %s: %v", cfg.Issuer, err)
+		ssoFail(c, http.StatusBadGateway, "Could not complete the sign-in with the identity provider")
+		return
+	}
+	identity, err := provider.verifyIDToken(rawIDToken, cfg.ClientID, nonce)
+	if err != nil {
+		log.Printf("Rejected ID token from %s: %v", cfg.Issuer, err)
+		ssoFail(c, http.StatusUnauthorized, "The identity provider's ID token is not valid")
+		return
+	}
+
+	user, err := resolveSSOUser(cfg, company.ID, identity)
+	if err == errSSONoAccount || err == errSSOInactive || err == errSSONoEmail || err == errSSOEmailTaken ||
+		err == errSSONoLink {
+		ssoFail(c, http.StatusForbidden, "Single sign-on refused: "+err.Error())
+		return
+	}
+	if err != nil {
+		internalError(err)
+		return
+	}
+
+	if returnURL := ssoReturnURL(); returnURL != "" {
+		ticket, err := randomToken(32)
+		if err == nil {
+			_, err = db.Exec(`
+				INSERT INTO login_challenges (token_hash, company_id, user_id, purpose, attempts, created_at, expires_at)
+				VALUES (?, ?, ?, ?, 0, NOW(), ?)`,
+				sha256Hex(ticket), user.CompanyID, user.ID, loginChallengeSSO, time.Now().Add(ssoTicketTTL))
+		}
+		if err != nil {
+			internalError(err)
+			return
+		}
+		c.Redirect(http.StatusFound, returnURL+"#ticket="+url.QueryEscape(ticket))
+		return
+	}
+	completeFirstFactor(c, user, company, true, "")
+}
+
+// ssoTicketHandler exchanges the one-time ticket of a finished single sign-on for a
+// session, returning the same response as a password login, including its 2FA challenge
+func ssoTicketHandler(c *gin.Context) {
+	var req SSOTicketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+
+	internalError := func(err error) {
+		log.Printf("Error redeeming single sign-on ticket: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer tx.Rollback()
+
+	var expiresAt time.Time
+	var user User
+	tokenHash := sha256Hex(req.Ticket)
+	err = tx.QueryRow(`
+		SELECT ch.expires_at,
+		       u.id, u.company_id, u.username, u.email, u.first_name, u.last_name, u.role, u.is_active, u.last_login
+		FROM login_challenges ch
+		JOIN users u ON u.id = ch.user_id AND u.company_id = ch.company_id
+		WHERE ch.token_hash = ? AND ch.purpose = ?
+		FOR UPDATE`, tokenHash, loginChallengeSSO).Scan(
+		&expiresAt,
+		&user.ID, &user.CompanyID, &user.Username, &user.Email, &user.FirstName, &user.LastName,
+		&user.Role, &user.IsActive, &user.LastLogin)
+	if err == nil {
+		if _, err = tx.Exec("DELETE FROM login_challenges WHERE token_hash = ?", tokenHash); err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err == sql.ErrNoRows || (err == nil && (time.Now().After(expiresAt) || !user.IsActive)) {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Invalid or expired single sign-on ticket",
+		})
+		return
+	}
+	if err != nil {
+		internalError(err)
+		return
+	}
+
+	company, err := loadLoginCompany("id = ?", user.CompanyID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Invalid credentials or company",
+		})
+		return
+	}
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if status, message := checkLoginCompany(company, true); status != 0 {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+	completeFirstFactor(c, user, company, true, req.EnrollmentCode)
+}
+
+// deleteExpiredSSOStates removes single sign-ons nobody finished in time
+func deleteExpiredSSOStates() {
+	if _, err := db.Exec("DELETE FROM sso_login_states WHERE expires_at < NOW()"); err != nil {
+		log.Printf("Error deleting expired single sign-on states: %v", err)
+	}
+}
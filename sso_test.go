@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestSSOTicketAsksForSecondFactor checks that a user with 2FA who signs in through the
+// identity provider gets a 2FA challenge rather than a session
+func TestSSOTicketAsksForSecondFactor(t *testing.T) {
+	mock := useMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM login_challenges ch")).
+		WithArgs(sha256Hex("the-ticket"), loginChallengeSSO).
+		WillReturnRows(sqlmock.NewRows([]string{"expires_at", "id", "company_id", "username", "email",
+			"first_name", "last_name", "role", "is_active", "last_login"}).
+			AddRow(time.Now().Add(time.Minute), 9, ownCompany, "ada", "ada@example.edu", nil, nil, "admin", true, nil))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM login_challenges WHERE token_hash = ?")).
+		WithArgs(sha256Hex("the-ticket")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM companies")).
+		WithArgs(ownCompany).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "company_name", "company_code", "email",
+			"subscription_plan", "is_active", "trial_ends_at"}).
+			AddRow(ownCompany, "Example University", "EXU", "it@example.edu", "basic", true, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT secret, enabled_at FROM user_two_factor WHERE user_id = ?")).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled_at"}).AddRow("JBSWY3DPEHPK3PXP", time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO login_challenges")).
+		WithArgs(sqlmock.AnyArg(), ownCompany, 9, loginChallengeVerify, true, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	c, w := userRequest("", 0, `{"ticket":"the-ticket"}`)
+	ssoTicketHandler(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", w.Code, w.Body)
+	}
+	var resp struct {
+		Data struct {
+			Token     string `json:"token"`
+			TwoFactor string `json:"two_factor"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Token != "" || resp.Data.TwoFactor != loginChallengeVerify {
+		t.Fatalf("got %s, want a 2FA challenge", w.Body)
+	}
+	checkMock(t, mock)
+}
+
+// TestSSOLinkableUsers checks that a user who may configure single sign-on, but is not an
+// admin, cannot point it at a provider that could sign in as an admin
+func TestSSOLinkableUsers(t *testing.T) {
+	const issuer = "https://login.example.edu"
+	tests := []struct {
+		actor  string
+		status int
+	}{
+		{"admin", 0},
+		{"manager", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.actor, func(t *testing.T) {
+			mock := useMockDB(t)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT u.role FROM users u")).
+				WithArgs(ownCompany, issuer).
+				WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("user").AddRow("admin"))
+
+			c, _ := userRequest(tt.actor, 0, "")
+			if status, message := checkSSOLinkable(c, ownCompany, issuer); status != tt.status {
+				t.Fatalf("got %d %s, want %d", status, message, tt.status)
+			}
+			checkMock(t, mock)
+		})
+	}
+}
+
+// TestSSOLinkByEmail checks that sign-ins matched by email only link users whose
+// permissions the user who configured single sign-on holds
+func TestSSOLinkByEmail(t *testing.T) {
+	configuredBy := 5
+	cfg := &CompanySSO{ConfiguredBy: &configuredBy}
+	tests := []struct {
+		configurer string
+		user       string
+		ok         bool
+	}{
+		{"admin", "admin", true},
+		{"manager", "user", true},
+		{"manager", "admin", false},
+		{"", "user", false},
+	}
+	for _, tt := range tests {
+		mock := useMockDB(t)
+		rows := sqlmock.NewRows([]string{"role"})
+		if tt.configurer != "" {
+			rows.AddRow(tt.configurer)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT role FROM users WHERE id = ? AND company_id = ? AND is_active = true")).
+			WithArgs(configuredBy, ownCompany).
+			WillReturnRows(rows)
+
+		ok, err := cfg.mayLinkByEmail(ownCompany, User{ID: 9, Role: tt.user})
+		if err != nil || ok != tt.ok {
+			t.Errorf("configured by %q, linking %s: got %v, %v, want %v", tt.configurer, tt.user, ok, err, tt.ok)
+		}
+		checkMock(t, mock)
+	}
+
+	if ok, err := (&CompanySSO{}).mayLinkByEmail(ownCompany, User{ID: 9, Role: "user"}); ok || err != nil {
+		t.Errorf("configuration without configured_by: got %v, %v, want false", ok, err)
+	}
+}
+
+// TestSSORoleClaimKeepsLastAdministrator checks that a role claim does not demote the
+// last user who can manage users, but does demote an admin when others remain
+func TestSSORoleClaimKeepsLastAdministrator(t *testing.T) {
+	tests := []struct {
+		name   string
+		others int
+		want   string
+	}{
+		{"last admin", 0, "admin"},
+		{"other admins", 1, "user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := useMockDB(t)
+			expectPermissionHolders(mock, permUsersManage, "", 0, 2)
+			expectPermissionHolders(mock, permUsersManage, "", 9, tt.others)
+			if tt.others > 0 {
+				expectPermissionHolders(mock, permCompanyManage, "", 0, 2)
+				expectPermissionHolders(mock, permCompanyManage, "", 9, tt.others)
+			}
+
+			role, err := keepLastAdministrator(ownCompany, User{ID: 9, Role: "admin"}, "user")
+			if err != nil || role != tt.want {
+				t.Fatalf("got %q, %v, want %q", role, err, tt.want)
+			}
+			checkMock(t, mock)
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/code39"
+	"github.com/boombuler/barcode/datamatrix"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/pdf417"
+	"github.com/boombuler/barcode/qr"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultSymbology keeps the Code 128 labels printed before symbologies were configurable
+	defaultSymbology = "code128"
+
+	// barcodeSymbologySetting is the company_settings key holding the company's default symbology
+	barcodeSymbologySetting = "barcode_symbology"
+
+	// barcodePixelsPerModule is the resolution barcode images are rendered at
+	barcodePixelsPerModule = 4
+)
+
+// barcodeSymbology describes how one symbology builds, checks, encodes and sizes asset barcodes
+type barcodeSymbology struct {
+	Payload  func(asset Asset) string
+	Validate func(payload string) error
+	Encode   func(payload string) (barcode.Barcode, error)
+
+	// Width is the preferred printed width in mm. Codes whose modules would come out
+	// narrower than MinModule mm are printed wider, up to the width of the label.
+	Width     float64
+	MinModule float64
+
+	// Height is the printed bar height in mm of linear codes; 2D codes keep their aspect ratio
+	Height float64
+}
+
+// barcodeSymbologies are the supported symbologies, keyed by the name used in requests and settings
+var barcodeSymbologies = map[string]barcodeSymbology{
+	"code128": {
+		Payload:  compactAssetPayload,
+		Validate: validateASCII,
+		Encode: func(p string) (barcode.Barcode, error) {
+			return code128.Encode(p)
+		},
+		Width: 80, MinModule: 0.19, Height: 20,
+	},
+	"code39": {
+		Payload:  compactAssetPayload,
+		Validate: validateCode39,
+		Encode: func(p string) (barcode.Barcode, error) {
+			return code39.Encode(p, true, false)
+		},
+		Width: 80, MinModule: 0.19, Height: 20,
+	},
+	"ean13": {
+		Payload:  ean13AssetPayload,
+		Validate: validateEAN13,
+		Encode: func(p string) (barcode.Barcode, error) {
+			return ean.Encode(p)
+		},
+		// 37.29 x 22.85 mm is the nominal EAN-13 size
+		Width: 37.29, MinModule: 0.264, Height: 22.85,
+	},
+	"datamatrix": {
+		Payload:  qrAssetPayload,
+		Validate: validateASCII,
+		Encode:   datamatrix.Encode,
+		Width:    25, MinModule: 0.4,
+	},
+	"qr": {
+		Payload:  qrAssetPayload,
+		Validate: validateUTF8Length(2953),
+		Encode: func(p string) (barcode.Barcode, error) {
+			return qr.Encode(p, qr.M, qr.Auto)
+		},
+		Width: 30, MinModule: 0.5,
+	},
+	"pdf417": {
+		Payload:  qrAssetPayload,
+		Validate: validateASCII,
+		Encode: func(p string) (barcode.Barcode, error) {
+			return pdf417.Encode(p, 2)
+		},
+		Width: 60, MinModule: 0.25,
+	},
+}
+
+// symbologyNames lists the supported symbologies for error messages
+func symbologyNames() string {
+	return "code128, code39, ean13, datamatrix, qr or pdf417"
+}
+
+// compactAssetPayload is the asset's tag, a short Code 39 safe identifier that fits linear
+// codes on small labels. Assets not tagged yet fall back to their ID.
+func compactAssetPayload(asset Asset) string {
+	if asset.Barcode != nil && *asset.Barcode != "" {
+		return *asset.Barcode
+	}
+	return fmt.Sprintf("AST-%06d", asset.ID)
+}
+
+// qrAssetPayload is the stored QR code of an asset, which unlike the descriptive
+// generateBarcodeData payload does not change when the asset is edited
+func qrAssetPayload(asset Asset) string {
+	if asset.QRCode != nil && *asset.QRCode != "" {
+		return *asset.QRCode
+	}
+	return generateBarcodeData(asset)
+}
+
+// ean13AssetPayload builds a 12 digit restricted-circulation number (prefix 2, reserved
+// for internal use) from the asset ID; the encoder appends the check digit
+func ean13AssetPayload(asset Asset) string {
+	return fmt.Sprintf("2%011d", asset.ID)
+}
+
+// validateASCII rejects payloads that need characters outside 7-bit ASCII
+func validateASCII(payload string) error {
+	if payload == "" {
+		return errors.New("payload is empty")
+	}
+	for _, r := range payload {
+		if r > 127 {
+			return fmt.Errorf("payload contains non-ASCII character %q", r)
+		}
+	}
+	return nil
+}
+
+// validateCode39 accepts the 43 characters of standard Code 39
+func validateCode39(payload string) error {
+	if payload == "" {
+		return errors.New("payload is empty")
+	}
+	for _, r := range payload {
+		if !strings.ContainsRune("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ -.$/+%", r) {
+			return fmt.Errorf("Code 39 cannot encode %q", r)
+		}
+	}
+	return nil
+}
+
+// validateEAN13 accepts 12 digits, or 13 digits with a valid check digit
+func validateEAN13(payload string) error {
+	if len(payload) != 12 && len(payload) != 13 {
+		return errors.New("EAN-13 needs 12 or 13 digits")
+	}
+	for _, r := range payload {
+		if r < '0' || r > '9' {
+			return errors.New("EAN-13 can only encode digits")
+		}
+	}
+	if len(payload) == 13 {
+		sum := 0
+		for i, r := range payload[:12] {
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += int(r-'0') * weight
+		}
+		if check := (10 - sum%10) % 10; int(payload[12]-'0') != check {
+			return errors.New("EAN-13 check digit does not match")
+		}
+	}
+	return nil
+}
+
+// validateUTF8Length returns a validator limiting payloads to max bytes
+func validateUTF8Length(max int) func(string) error {
+	return func(payload string) error {
+		if payload == "" {
+			return errors.New("payload is empty")
+		}
+		if len(payload) > max {
+			return fmt.Errorf("payload is longer than %d bytes", max)
+		}
+		return nil
+	}
+}
+
+// errUnknownSymbology is returned for symbology names that are not supported
+var errUnknownSymbology = fmt.Errorf("symbology must be one of %s", symbologyNames())
+
+// resolveSymbology picks the requested symbology, else the company's default, else Code 128
+func resolveSymbology(companyID int, requested string) (string, barcodeSymbology, error) {
+	name := strings.ToLower(strings.TrimSpace(requested))
+	if name == "" {
+		setting, ok, err := getCompanySetting(companyID, barcodeSymbologySetting)
+		if err != nil {
+			return "", barcodeSymbology{}, err
+		}
+		name = defaultSymbology
+		if ok {
+			name = setting
+		}
+	}
+	sym, ok := barcodeSymbologies[name]
+	if !ok {
+		return "", barcodeSymbology{}, errUnknownSymbology
+	}
+	return name, sym, nil
+}
+
+// requireSymbology resolves the symbology of a barcode request, writing 400 when it is unknown
+func requireSymbology(c *gin.Context, companyID int, requested string) (string, barcodeSymbology, bool) {
+	name, sym, err := resolveSymbology(companyID, requested)
+	if errors.Is(err, errUnknownSymbology) {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return "", sym, false
+	}
+	if err != nil {
+		log.Printf("Error resolving barcode symbology: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return "", sym, false
+	}
+	return name, sym, true
+}
+
+// skippedBarcode reports an asset left out of a barcode sheet
+func skippedBarcode(asset Asset, err error) gin.H {
+	return gin.H{
+		"assetId": asset.ID,
+		"error":   err.Error(),
+	}
+}
+
+// barcodeImage is an encoded barcode rendered as PNG, with its printed size in mm
+type barcodeImage struct {
+	PNG           []byte
+	Width, Height float64
+}
+
+// render validates and encodes a payload and sizes it to fit maxWidth x maxHeight mm
+// without modules narrower than MinModule. Images are rendered at a whole number of
+// pixels per module so bars stay crisp.
+func (s barcodeSymbology) render(payload string, maxWidth, maxHeight float64) (barcodeImage, error) {
+	if err := s.Validate(payload); err != nil {
+		return barcodeImage{}, err
+	}
+	code, err := s.Encode(payload)
+	if err != nil {
+		return barcodeImage{}, err
+	}
+
+	bounds := code.Bounds()
+	modules, rows := bounds.Dx(), bounds.Dy()
+	minWidth := float64(modules) * s.MinModule
+	if minWidth > maxWidth {
+		return barcodeImage{}, fmt.Errorf("payload needs %d modules, too many for a %.1f mm wide label", modules, maxWidth)
+	}
+	width := math.Max(math.Min(s.Width, maxWidth), minWidth)
+
+	heightPx := 50 * barcodePixelsPerModule
+	height := math.Min(s.Height, maxHeight)
+	if code.Metadata().Dimensions == 2 {
+		heightPx = rows * barcodePixelsPerModule
+		height = width * float64(rows) / float64(modules)
+		if height > maxHeight {
+			width, height = maxHeight*float64(modules)/float64(rows), maxHeight
+		}
+		if width < minWidth {
+			return barcodeImage{}, fmt.Errorf("label is too short for a %d x %d module code", modules, rows)
+		}
+	} else if height < minLinearBarcodeHeight {
+		return barcodeImage{}, errors.New("label leaves no room for the barcode")
+	}
+
+	scaled, err := barcode.Scale(code, modules*barcodePixelsPerModule, heightPx)
+	if err != nil {
+		return barcodeImage{}, err
+	}
+
+	// Barcode images are 16-bit gray, which gofpdf cannot embed
+	gray := image.NewGray(scaled.Bounds())
+	draw.Draw(gray, gray.Bounds(), scaled, scaled.Bounds().Min, draw.Src)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gray); err != nil {
+		return barcodeImage{}, err
+	}
+	return barcodeImage{PNG: buf.Bytes(), Width: width, Height: height}, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+
+	// Register the decoders of the accepted photo formats
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// thumbnailSize is the longest side of a thumbnail, in pixels
+	thumbnailSize = 320
+
+	// thumbnailQuality is the JPEG quality of thumbnails
+	thumbnailQuality = 80
+
+	// maxImagePixels bounds the size of decoded photos, so a small file that claims
+	// huge dimensions cannot exhaust memory
+	maxImagePixels = 50000000
+)
+
+// thumbnail is the downscaled JPEG of an image, with the size of the image once shown
+// the right way up
+type thumbnail struct {
+	Width  int
+	Height int
+	JPEG   []byte
+}
+
+// makeThumbnail decodes an image and returns its thumbnail, turned the way its EXIF
+// orientation asks for
+func makeThumbnail(r io.ReadSeeker) (*thumbnail, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, errors.New("image could not be read")
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image may be at most %d megapixels", maxImagePixels/1000000)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		orientation = jpegOrientation(r)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, errors.New("image could not be read")
+	}
+
+	small := orient(downscale(img, thumbnailSize), orientation)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, small, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+
+	t := &thumbnail{Width: cfg.Width, Height: cfg.Height, JPEG: buf.Bytes()}
+	if orientation >= 5 {
+		t.Width, t.Height = t.Height, t.Width
+	}
+	return t, nil
+}
+
+// downscale shrinks an image to fit a square of size pixels by averaging the pixels
+// each output pixel covers. Transparent areas are flattened onto white, as JPEG has no
+// alpha channel. Images that already fit are only flattened.
+func downscale(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, h*size/w
+		} else {
+			dw, dh = w*size/h, size
+		}
+		if dw < 1 {
+			dw = 1
+		}
+		if dh < 1 {
+			dh = 1
+		}
+	}
+
+	pixel := pixelReader(img)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	sums := make([]uint64, dw*3)
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := b.Min.Y+dy*h/dh, b.Min.Y+(dy+1)*h/dh
+		for i := range sums {
+			sums[i] = 0
+		}
+		for y := y0; y < y1; y++ {
+			for dx := 0; dx < dw; dx++ {
+				x0, x1 := b.Min.X+dx*w/dw, b.Min.X+(dx+1)*w/dw
+				for x := x0; x < x1; x++ {
+					r, g, bl := pixel(x, y)
+					sums[dx*3] += uint64(r)
+					sums[dx*3+1] += uint64(g)
+					sums[dx*3+2] += uint64(bl)
+				}
+			}
+		}
+		for dx := 0; dx < dw; dx++ {
+			n := uint64((y1 - y0) * ((dx+1)*w/dw - dx*w/dw))
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i] = uint8(sums[dx*3] / n)
+			dst.Pix[i+1] = uint8(sums[dx*3+1] / n)
+			dst.Pix[i+2] = uint8(sums[dx*3+2] / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
+
+// pixelReader returns a function reading the colour of a pixel flattened onto white,
+// reading the pixel buffers of common image types directly
+func pixelReader(img image.Image) func(x, y int) (uint8, uint8, uint8) {
+	switch m := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (uint8, uint8, uint8) {
+			ci := m.COffset(x, y)
+			return color.YCbCrToRGB(m.Y[m.YOffset(x, y)], m.Cb[ci], m.Cr[ci])
+		}
+	case *image.Gray:
+		return func(x, y int) (uint8, uint8, uint8) {
+			v := m.Pix[m.PixOffset(x, y)]
+			return v, v, v
+		}
+	case *image.RGBA:
+		return func(x, y int) (uint8, uint8, uint8) {
+			p := m.Pix[m.PixOffset(x, y):]
+			white := 0xff - p[3]
+			return p[0] + white, p[1] + white, p[2] + white
+		}
+	case *image.NRGBA:
+		return func(x, y int) (uint8, uint8, uint8) {
+			p := m.Pix[m.PixOffset(x, y):]
+			a := uint32(p[3])
+			blend := func(v uint8) uint8 { return uint8((uint32(v)*a + 0xff*(0xff-a)) / 0xff) }
+			return blend(p[0]), blend(p[1]), blend(p[2])
+		}
+	}
+	return func(x, y int) (uint8, uint8, uint8) {
+		r, g, b, a := img.At(x, y).RGBA()
+		white := 0xffff - a
+		return uint8((r + white) >> 8), uint8((g + white) >> 8), uint8((b + white) >> 8)
+	}
+}
+
+// orient turns an image the way an EXIF orientation (1-8) asks for
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var nx, ny int
+			switch orientation {
+			case 2: // mirrored
+				nx, ny = w-1-x, y
+			case 3: // upside down
+				nx, ny = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				nx, ny = x, h-1-y
+			case 5: // mirrored, turned left
+				nx, ny = y, x
+			case 6: // turned left, needs turning right
+				nx, ny = h-1-y, x
+			case 7: // mirrored, turned right
+				nx, ny = h-1-y, w-1-x
+			case 8: // turned right, needs turning left
+				nx, ny = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(nx, ny):dst.PixOffset(nx, ny)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, or 1 when it has none
+func jpegOrientation(r io.Reader) int {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return 1
+	}
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(br, marker[:]); err != nil || marker[0] != 0xff {
+			return 1
+		}
+		// Stop at the start of the image data or the end of the image
+		if marker[1] == 0xda || marker[1] == 0xd9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(marker[2:]))
+		if length < 2 {
+			return 1
+		}
+		if marker[1] != 0xe1 {
+			if _, err := br.Discard(length - 2); err != nil {
+				return 1
+			}
+			continue
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			return 1
+		}
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+// exifOrientation reads the orientation tag of the first IFD of EXIF TIFF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		// Orientation is tag 0x0112, a SHORT
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
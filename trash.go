@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultTrashRetentionDays applies when ASSET_TRASH_RETENTION_DAYS is unset
+	defaultTrashRetentionDays = 30
+
+	// trashRetentionSetting is the company_settings key overriding the retention per company
+	trashRetentionSetting = "asset_trash_retention_days"
+
+	trashPurgeInterval  = time.Hour
+	trashPurgeBatchSize = 500
+)
+
+// trashRetentionDays returns the server-wide retention period for trashed assets.
+// Zero keeps trashed assets until they are purged by hand.
+func trashRetentionDays() int {
+	if v := os.Getenv("ASSET_TRASH_RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err == nil && days >= 0 {
+			return days
+		}
+		log.Printf("Invalid ASSET_TRASH_RETENTION_DAYS %q, using %d", v, defaultTrashRetentionDays)
+	}
+	return defaultTrashRetentionDays
+}
+
+// getTrashHandler returns one page of the current company's trashed assets.
+// Takes the same pagination, sort and filter parameters as GET /api/assets.
+func getTrashHandler(c *gin.Context) {
+	store, ok := requireAssetStore(c)
+	if !ok {
+		return
+	}
+
+	filter, pageReq, err := parseAssetListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+	if filter.CustomFields, ok = requireCustomFieldFilters(c, store, c.QueryMap("custom_fields")); !ok {
+		return
+	}
+	filter.Trashed = true
+
+	page, err := store.ListPage(filter, pageReq)
+	if err != nil {
+		if errors.Is(err, errInvalidCursor) {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		log.Printf("Error fetching trashed assets: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	response := []gin.H{}
+	for _, asset := range page.Assets {
+		item := assetResponse(asset)
+		item["deletedAt"] = formatTimestamp(asset.DeletedAt)
+		item["deletedBy"] = asset.DeletedBy
+		response = append(response, item)
+	}
+
+	pageNumber := pageReq.Page
+	if pageReq.Cursor != "" {
+		pageNumber = 0
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data: PaginatedResponse{
+			Data:       response,
+			Total:      page.Total,
+			Page:       pageNumber,
+			PageSize:   pageReq.PageSize,
+			TotalPages: (page.Total + pageReq.PageSize - 1) / pageReq.PageSize,
+			NextCursor: page.NextCursor,
+		},
+	})
+}
+
+// trashActionHandler returns a handler applying a store operation to the trashed asset in the path
+func trashActionHandler(action func(store AssetStore, id int) error, verb string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store, ok := requireAssetStore(c)
+		if !ok {
+			return
+		}
+
+		assetID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "Invalid asset ID",
+			})
+			return
+		}
+
+		if err := action(store, assetID); err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, APIResponse{
+					Success: false,
+					Error:   "Asset not found in trash",
+				})
+				return
+			}
+			log.Printf("Error %s asset: %v", verb, err)
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   "Internal Server Error",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Message: "Asset " + verb + " successfully",
+		})
+	}
+}
+
+// restoreAssetHandler takes an asset out of the trash
+var restoreAssetHandler = trashActionHandler(AssetStore.Restore, "restored")
+
+// purgeAssetHandler permanently deletes a trashed asset (admin only)
+var purgeAssetHandler = trashActionHandler(AssetStore.Purge, "purged")
+
+// purgeExpiredAssets permanently deletes trashed assets older than their company's
+// retention period, in batches, recording each purge in the asset history
+func purgeExpiredAssets(defaultDays int) (int, error) {
+	purged := 0
+	for {
+		rows, err := db.Query(`
+			SELECT a.id, a.company_id
+			FROM assets a
+			LEFT JOIN company_settings cs ON cs.company_id = a.company_id AND cs.setting_key = ?
+			WHERE a.deleted_at IS NOT NULL
+			AND COALESCE(CAST(cs.setting_value AS UNSIGNED), ?) > 0
+			AND a.deleted_at < NOW() - INTERVAL COALESCE(CAST(cs.setting_value AS UNSIGNED), ?) DAY
+			ORDER BY a.id
+			LIMIT ?`, trashRetentionSetting, defaultDays, defaultDays, trashPurgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		type expired struct{ id, companyID int }
+		var batch []expired
+		for rows.Next() {
+			var e expired
+			if err := rows.Scan(&e.id, &e.companyID); err != nil {
+				rows.Close()
+				return purged, err
+			}
+			batch = append(batch, e)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return purged, err
+		}
+
+		for _, e := range batch {
+			store, err := newSQLAssetStore(db, e.companyID, auditActor{})
+			if err != nil {
+				return purged, err
+			}
+			// ErrNoRows means the asset was restored or purged since it was selected
+			if err := store.Purge(e.id); err != nil && err != sql.ErrNoRows {
+				return purged, err
+			}
+			purged++
+		}
+
+		if len(batch) < trashPurgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// startTrashPurger purges expired trashed assets now and then every trashPurgeInterval
+func startTrashPurger() {
+	defaultDays := trashRetentionDays()
+	go func() {
+		for {
+			purged, err := purgeExpiredAssets(defaultDays)
+			if err != nil {
+				log.Printf("Error purging expired trashed assets: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d expired trashed assets", purged)
+			}
+			time.Sleep(trashPurgeInterval)
+		}
+	}()
+}
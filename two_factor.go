@@ -0,0 +1,1074 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// twoFactorRequiredSetting is the company_settings key that, when "true", makes 2FA
+	// mandatory for the roles in twoFactorPolicyRoles and roles with adminPermissions
+	twoFactorRequiredSetting = "two_factor_required"
+
+	// TOTP parameters (RFC 6238), the defaults every authenticator app supports
+	totpPeriod      = 30
+	totpDigits      = 6
+	totpModulus     = 1000000
+	totpSecretBytes = 20
+
+	// totpSkew is how many periods a code may be early or late, for clock drift
+	totpSkew = 1
+
+	// recoveryCodeCount is how many one-time recovery codes a user gets at a time
+	recoveryCodeCount = 10
+
+	// loginChallengeTTL is how long a user has to enter the second factor after the password
+	loginChallengeTTL = 5 * time.Minute
+
+	// loginChallengeMaxAttempts is how many wrong codes end a challenge
+	loginChallengeMaxAttempts = 5
+
+	// enrollmentCodeTTL is how long a user has to redeem an enrollment code at login
+	enrollmentCodeTTL = 72 * time.Hour
+)
+
+// Kinds of login challenge
+const (
+	loginChallengeVerify = "verify"
+	loginChallengeEnroll = "enroll"
+
+	// loginChallengeEnrollmentCode rows are enrollment codes an administrator issued,
+	// which let a user the policy covers set up 2FA at login
+	loginChallengeEnrollmentCode = "enrollment_code"
+)
+
+// twoFactorPolicyRoles are the roles the company 2FA policy applies to
+var twoFactorPolicyRoles = map[string]bool{"admin": true, "manager": true}
+
+var (
+	errInvalidLoginChallenge = errors.New("invalid or expired two-factor challenge; sign in again")
+	errEnrollmentRequired    = errors.New("company policy requires two-factor authentication for your role; " +
+		"ask an administrator for an enrollment code and sign in with it")
+)
+
+// rowQuerier is a *sql.DB or *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// userTwoFactor is a user's TOTP enrollment. It is pending until EnabledAt is set by
+// confirming a first code.
+type userTwoFactor struct {
+	Secret    string
+	EnabledAt *time.Time
+}
+
+// validateTwoFactorSetting checks a value of the two_factor_required setting
+func validateTwoFactorSetting(v string) error {
+	if v != "true" && v != "false" {
+		return fmt.Errorf("%s must be true or false", twoFactorRequiredSetting)
+	}
+	return nil
+}
+
+// totpIssuer names the service in authenticator apps, from TOTP_ISSUER
+func totpIssuer() string {
+	if issuer := strings.TrimSpace(os.Getenv("TOTP_ISSUER")); issuer != "" {
+		return issuer
+	}
+	return "Asset Tagging"
+}
+
+// sha256Hex returns the stored form of a recovery code or challenge token
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTOTPSecret returns a random secret in the unpadded base32 authenticator apps expect
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf), nil
+}
+
+// totpCode returns the code of a secret for one time step (RFC 4226 dynamic truncation)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// matchTOTP returns the time step a code is valid for, allowing totpSkew steps of drift
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// verifyTOTP checks a code against a user's secret. Each code is accepted once: the
+// step it belongs to is recorded and codes of that step or earlier are refused.
+func verifyTOTP(conn execer, userID int, secret, code string) (bool, error) {
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	result, err := conn.Exec(`
+		UPDATE user_two_factor SET last_used_step = ?
+		WHERE user_id = ? AND (last_used_step IS NULL OR last_used_step < ?)`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// totpURL returns the otpauth:// URL authenticator apps enroll from
+func totpURL(account, secret string) string {
+	issuer := totpIssuer()
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriod))
+	// Authenticator apps expect spaces as %20 rather than the form encoding's +
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// totpQRCode renders an otpauth:// URL as a QR code PNG data URI
+func totpQRCode(otpauthURL string) (string, error) {
+	code, err := qr.Encode(otpauthURL, qr.M, qr.Auto)
+	if err != nil {
+		return "", err
+	}
+	scaled, err := barcode.Scale(code, 256, 256)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaled); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// loadUserTwoFactor returns a user's enrollment, or nil when they have none
+func loadUserTwoFactor(q rowQuerier, userID int) (*userTwoFactor, error) {
+	var tf userTwoFactor
+	err := q.QueryRow("SELECT secret, enabled_at FROM user_two_factor WHERE user_id = ?", userID).
+		Scan(&tf.Secret, &tf.EnabledAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// twoFactorPolicyCovers reports whether the company 2FA policy, when on, applies to a role
+func twoFactorPolicyCovers(companyID int, role string) (bool, error) {
+	if twoFactorPolicyRoles[role] {
+		return true, nil
+	}
+	perms, _, err := rolePermissions(companyID, role)
+	if err != nil {
+		return false, err
+	}
+	covered := false
+	for _, p := range adminPermissions {
+		covered = covered || perms[p]
+	}
+	return covered, nil
+}
+
+// twoFactorRequired reports whether company policy requires 2FA for a role
+func twoFactorRequired(companyID int, role string) (bool, error) {
+	covered, err := twoFactorPolicyCovers(companyID, role)
+	if err != nil || !covered {
+		return false, err
+	}
+	value, ok, err := getCompanySetting(companyID, twoFactorRequiredSetting)
+	return ok && value == "true", err
+}
+
+// revokeSessionsWithoutTwoFactor ends the sessions of a company's users the 2FA policy
+// covers who have not enabled 2FA, when the policy is turned on. They sign in again
+// with an enrollment code.
+func revokeSessionsWithoutTwoFactor(tx *sql.Tx, companyID int) (int64, error) {
+	rows, err := tx.Query(`
+		SELECT u.id, u.role
+		FROM users u
+		LEFT JOIN user_two_factor tf ON tf.user_id = u.id AND tf.enabled_at IS NOT NULL
+		WHERE u.company_id = ? AND tf.user_id IS NULL`, companyID)
+	if err != nil {
+		return 0, err
+	}
+	roles := map[int]string{}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		var role string
+		if err := rows.Scan(&id, &role); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		roles[id] = role
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	covered := map[string]bool{}
+	var revoked int64
+	for _, id := range userIDs {
+		role := roles[id]
+		c, seen := covered[role]
+		if !seen {
+			if c, err = twoFactorPolicyCovers(companyID, role); err != nil {
+				return revoked, err
+			}
+			covered[role] = c
+		}
+		if !c {
+			continue
+		}
+		n, err := revokeSessions(tx, "company_id = ? AND user_id = ?", []interface{}{companyID, id}, sessionRevokedTwoFactor)
+		if err != nil {
+			return revoked, err
+		}
+		revoked += n
+	}
+	return revoked, nil
+}
+
+// beginTOTPSetup gives a user a new pending secret, replacing any earlier pending one.
+// The account is shown in authenticator apps as username@COMPANYCODE.
+func beginTOTPSetup(user User, companyCode string) (TOTPSetup, error) {
+	var setup TOTPSetup
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return setup, err
+	}
+	_, err = db.Exec(`
+		INSERT INTO user_two_factor (user_id, company_id, secret, created_at) VALUES (?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE secret = VALUES(secret), enabled_at = NULL, last_used_step = NULL, created_at = NOW()`,
+		user.ID, user.CompanyID, secret)
+	if err != nil {
+		return setup, err
+	}
+
+	otpauthURL := totpURL(user.Username+"@"+companyCode, secret)
+	qrCode, err := totpQRCode(otpauthURL)
+	if err != nil {
+		return setup, err
+	}
+	return TOTPSetup{Secret: secret, OTPAuthURL: otpauthURL, QRCode: qrCode}, nil
+}
+
+// newOneTimeCode returns a random code formatted as xxxxx-xxxxx, for people to type
+func newOneTimeCode() (string, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for j, b := range buf {
+		buf[j] = alphabet[int(b)%len(alphabet)]
+	}
+	return string(buf[:5]) + "-" + string(buf[5:]), nil
+}
+
+// newRecoveryCodes returns fresh recovery codes
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newOneTimeCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in a typed recovery code
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// replaceRecoveryCodes gives a user new recovery codes, invalidating the old ones. Only
+// hashes are stored; the codes are returned to be shown once.
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err := tx.Exec("INSERT INTO user_recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, NOW())",
+			userID, sha256Hex(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// useRecoveryCode spends one of a user's recovery codes, reporting whether it was valid
+func useRecoveryCode(conn execer, userID int, code string) (bool, error) {
+	result, err := conn.Exec(`
+		UPDATE user_recovery_codes SET used_at = NOW()
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`, userID, sha256Hex(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// checkSecondFactor accepts an authenticator code or, failing that, a recovery code
+func checkSecondFactor(conn execer, userID int, tf *userTwoFactor, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		return verifyTOTP(conn, userID, tf.Secret, code)
+	}
+	if recoveryCode != "" {
+		return useRecoveryCode(conn, userID, recoveryCode)
+	}
+	return false, nil
+}
+
+// twoFactorChallenge decides whether a user who gave the right password still needs a
+// second factor. It returns nil when they do not; otherwise it opens a challenge the
+// login is completed with. A user policy requires 2FA of who has not enrolled gets a
+// secret to set up only with an enrollment code an administrator issued them, as the
+// first factor alone must not be enough to choose the second; without one the login
+// fails with errEnrollmentRequired.
+func twoFactorChallenge(user User, company Company, viaSSO bool, enrollmentCode string) (*TwoFactorChallenge, error) {
+	tf, err := loadUserTwoFactor(db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	challenge := &TwoFactorChallenge{TwoFactor: loginChallengeVerify}
+	if tf == nil || tf.EnabledAt == nil {
+		required, err := twoFactorRequired(user.CompanyID, user.Role)
+		if err != nil || !required {
+			return nil, err
+		}
+		if ok, err := redeemEnrollmentCode(user, enrollmentCode); err != nil || !ok {
+			if err == nil {
+				err = errEnrollmentRequired
+			}
+			return nil, err
+		}
+		setup, err := beginTOTPSetup(user, company.CompanyCode)
+		if err != nil {
+			return nil, err
+		}
+		challenge.TwoFactor = loginChallengeEnroll
+		challenge.Setup = &setup
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(loginChallengeTTL)
+	_, err = db.Exec(`
+		INSERT INTO login_challenges (token_hash, company_id, user_id, purpose, via_sso, attempts, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, 0, NOW(), ?)`,
+		sha256Hex(token), user.CompanyID, user.ID, challenge.TwoFactor, viaSSO, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	challenge.ChallengeToken = token
+	challenge.ExpiresAt = expiresAt.Unix()
+	return challenge, nil
+}
+
+// redeemEnrollmentCode spends an unexpired enrollment code issued to a user, reporting
+// whether there was one
+func redeemEnrollmentCode(user User, code string) (bool, error) {
+	if code == "" {
+		return false, nil
+	}
+	result, err := db.Exec(`
+		DELETE FROM login_challenges
+		WHERE token_hash = ? AND company_id = ? AND user_id = ? AND purpose = ? AND expires_at > NOW()`,
+		sha256Hex(normalizeRecoveryCode(code)), user.CompanyID, user.ID, loginChallengeEnrollmentCode)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// loginTwoFactorHandler completes a login with the second factor. Enroll challenges
+// take the first code from the newly scanned secret, which turns 2FA on, and the
+// response then includes the user's recovery codes.
+func loginTwoFactorHandler(c *gin.Context) {
+	var req LoginTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "code or recovery_code is required",
+		})
+		return
+	}
+
+	internalError := func(err error) {
+		log.Printf("Error completing two-factor login: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the challenge so concurrent guesses are counted one after another
+	tokenHash := sha256Hex(req.ChallengeToken)
+	var purpose string
+	var viaSSO bool
+	var attempts int
+	var expiresAt time.Time
+	var user User
+	err = tx.QueryRow(`
+		SELECT ch.purpose, ch.via_sso, ch.attempts, ch.expires_at,
+		       u.id, u.company_id, u.username, u.email, u.first_name, u.last_name, u.role, u.is_active, u.last_login
+		FROM login_challenges ch
+		JOIN users u ON u.id = ch.user_id AND u.company_id = ch.company_id
+		WHERE ch.token_hash = ? AND ch.purpose IN (?, ?)
+		FOR UPDATE`, tokenHash, loginChallengeVerify, loginChallengeEnroll).Scan(
+		&purpose, &viaSSO, &attempts, &expiresAt,
+		&user.ID, &user.CompanyID, &user.Username, &user.Email, &user.FirstName, &user.LastName,
+		&user.Role, &user.IsActive, &user.LastLogin)
+	if err == sql.ErrNoRows || (err == nil && (time.Now().After(expiresAt) || !user.IsActive)) {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   errInvalidLoginChallenge.Error(),
+		})
+		return
+	}
+	if err != nil {
+		internalError(err)
+		return
+	}
+
+	tf, err := loadUserTwoFactor(tx, user.ID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	ok := false
+	if tf != nil {
+		if purpose == loginChallengeEnroll {
+			// Recovery codes do not exist before enrollment
+			ok, err = verifyTOTP(tx, user.ID, tf.Secret, req.Code)
+		} else if tf.EnabledAt != nil {
+			ok, err = checkSecondFactor(tx, user.ID, tf, req.Code, req.RecoveryCode)
+		}
+		if err != nil {
+			internalError(err)
+			return
+		}
+	}
+
+	if !ok {
+		attempts++
+		if attempts >= loginChallengeMaxAttempts {
+			_, err = tx.Exec("DELETE FROM login_challenges WHERE token_hash = ?", tokenHash)
+		} else {
+			_, err = tx.Exec("UPDATE login_challenges SET attempts = ? WHERE token_hash = ?", attempts, tokenHash)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			internalError(err)
+			return
+		}
+		message := "Invalid two-factor code"
+		if attempts >= loginChallengeMaxAttempts {
+			message = "Too many invalid codes; sign in again"
+		}
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	// The company may have changed since the first factor; refusing here leaves
+	// the challenge and any enrollment as they were
+	company, err := loadLoginCompany("id = ?", user.CompanyID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Error:   "Invalid credentials or company",
+		})
+		return
+	}
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if status, message := checkLoginCompany(company, viaSSO); status != 0 {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	var recoveryCodes []string
+	if purpose == loginChallengeEnroll {
+		if _, err := tx.Exec("UPDATE user_two_factor SET enabled_at = NOW() WHERE user_id = ?", user.ID); err != nil {
+			internalError(err)
+			return
+		}
+		if recoveryCodes, err = replaceRecoveryCodes(tx, user.ID); err != nil {
+			internalError(err)
+			return
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM login_challenges WHERE token_hash = ?", tokenHash); err != nil {
+		internalError(err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		internalError(err)
+		return
+	}
+
+	completeLogin(c, user, company, recoveryCodes)
+}
+
+// deleteExpiredLoginChallenges removes challenges nobody completed in time
+func deleteExpiredLoginChallenges() {
+	if _, err := db.Exec("DELETE FROM login_challenges WHERE expires_at < NOW()"); err != nil {
+		log.Printf("Error deleting expired login challenges: %v", err)
+	}
+}
+
+// getTwoFactorStatusHandler reports whether the current user has 2FA, whether policy
+// requires it and how many recovery codes they have left
+func getTwoFactorStatusHandler(c *gin.Context) {
+	userID := getCurrentUserID(c)
+	tf, err := loadUserTwoFactor(db, userID)
+	if err != nil {
+		log.Printf("Error fetching two-factor status: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	user := getCurrentUser(c)
+	required, err := twoFactorRequired(user.CompanyID, user.Role)
+	if err != nil {
+		log.Printf("Error fetching two-factor policy: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+
+	var remaining int
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL",
+		userID).Scan(&remaining); err != nil {
+		log.Printf("Error counting recovery codes: %v", err)
+	}
+
+	status := gin.H{
+		"enabled":                  tf != nil && tf.EnabledAt != nil,
+		"enabled_at":               nil,
+		"required":                 required,
+		"recovery_codes_remaining": remaining,
+	}
+	if tf != nil && tf.EnabledAt != nil {
+		status["enabled_at"] = tf.EnabledAt
+	}
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    status,
+	})
+}
+
+// setupTwoFactorHandler starts enrollment with a new secret to scan. 2FA is not on
+// until a code from it is confirmed with enableTwoFactorHandler.
+func setupTwoFactorHandler(c *gin.Context) {
+	userID := getCurrentUserID(c)
+	tf, err := loadUserTwoFactor(db, userID)
+	if err != nil {
+		log.Printf("Error fetching two-factor status: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	if tf != nil && tf.EnabledAt != nil {
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "Two-factor authentication is already enabled",
+		})
+		return
+	}
+
+	user := getCurrentUser(c)
+	var companyCode string
+	err = db.QueryRow("SELECT company_code FROM companies WHERE id = ?", user.CompanyID).Scan(&companyCode)
+	if err == nil {
+		var setup TOTPSetup
+		if setup, err = beginTOTPSetup(*user, companyCode); err == nil {
+			c.JSON(http.StatusOK, APIResponse{
+				Success: true,
+				Message: "Scan the QR code and confirm a code to enable two-factor authentication",
+				Data:    setup,
+			})
+			return
+		}
+	}
+	log.Printf("Error starting two-factor setup: %v", err)
+	c.JSON(http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Error:   "Internal Server Error",
+	})
+}
+
+// enableTwoFactorHandler confirms a code from the pending secret, turning 2FA on, and
+// returns the user's recovery codes. They are shown only this once.
+func enableTwoFactorHandler(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+	userID := getCurrentUserID(c)
+
+	internalError := func(err error) {
+		log.Printf("Error enabling two-factor authentication: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer tx.Rollback()
+
+	tf, err := loadUserTwoFactor(tx, userID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if tf == nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Start two-factor setup first",
+		})
+		return
+	}
+	if tf.EnabledAt != nil {
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "Two-factor authentication is already enabled",
+		})
+		return
+	}
+	ok, err := verifyTOTP(tx, userID, tf.Secret, req.Code)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid two-factor code",
+		})
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE user_two_factor SET enabled_at = NOW() WHERE user_id = ?", userID); err != nil {
+		internalError(err)
+		return
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		internalError(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Two-factor authentication enabled; store the recovery codes somewhere safe",
+		Data:    gin.H{"recovery_codes": codes},
+	})
+}
+
+// regenerateRecoveryCodesHandler replaces the current user's recovery codes after
+// checking an authenticator code
+func regenerateRecoveryCodesHandler(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+	userID := getCurrentUserID(c)
+
+	internalError := func(err error) {
+		log.Printf("Error replacing recovery codes: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer tx.Rollback()
+
+	tf, err := loadUserTwoFactor(tx, userID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if tf == nil || tf.EnabledAt == nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Two-factor authentication is not enabled",
+		})
+		return
+	}
+	ok, err := verifyTOTP(tx, userID, tf.Secret, req.Code)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid two-factor code",
+		})
+		return
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		internalError(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Recovery codes replaced; the old codes no longer work",
+		Data:    gin.H{"recovery_codes": codes},
+	})
+}
+
+// disableTwoFactorHandler turns 2FA off for the current user, confirmed with their
+// password and a second factor. Users company policy requires 2FA of cannot turn it off.
+func disableTwoFactorHandler(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid request data: " + err.Error(),
+		})
+		return
+	}
+	companyID := getCurrentCompanyID(c)
+	userID := getCurrentUserID(c)
+
+	internalError := func(err error) {
+		log.Printf("Error disabling two-factor authentication: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	var passwordHash, role string
+	err := db.QueryRow("SELECT password_hash, role FROM users WHERE id = ? AND company_id = ?",
+		userID, companyID).Scan(&passwordHash, &role)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)) != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid password",
+		})
+		return
+	}
+	required, err := twoFactorRequired(companyID, role)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if required {
+		c.JSON(http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   "Company policy requires two-factor authentication for your role",
+		})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer tx.Rollback()
+
+	tf, err := loadUserTwoFactor(tx, userID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if tf == nil || tf.EnabledAt == nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Two-factor authentication is not enabled",
+		})
+		return
+	}
+	ok, err := checkSecondFactor(tx, userID, tf, req.Code, req.RecoveryCode)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid two-factor code",
+		})
+		return
+	}
+	if err := removeTwoFactor(tx, userID); err != nil {
+		internalError(err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		internalError(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+// removeTwoFactor deletes a user's secret and recovery codes
+func removeTwoFactor(conn execer, userID int) error {
+	if _, err := conn.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	_, err := conn.Exec("DELETE FROM user_two_factor WHERE user_id = ?", userID)
+	return err
+}
+
+// resetUserTwoFactorHandler removes a user's 2FA, for a user who lost their device and
+// recovery codes (admin only). If policy requires 2FA of them, they need an enrollment
+// code from issueEnrollmentCodeHandler to sign in again.
+func resetUserTwoFactorHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+		return
+	}
+
+	// Check the user belongs to this company and has no permissions the current user lacks
+	if status, message := checkManageableUser(c, companyID, userID); status != 0 {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	if err := removeTwoFactor(db, userID); err != nil {
+		log.Printf("Error resetting two-factor authentication: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+		return
+	}
+	log.Printf("User %d reset two-factor authentication of user %d", getCurrentUserID(c), userID)
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Two-factor authentication reset",
+	})
+}
+
+// issueEnrollmentCodeHandler gives a user a one-time code to set up 2FA with at their
+// next login, which company policy otherwise refuses until they have 2FA. Issuing a
+// new code replaces the user's earlier ones.
+func issueEnrollmentCodeHandler(c *gin.Context) {
+	companyID := getCurrentCompanyID(c)
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid user ID",
+		})
+		return
+	}
+
+	// Check the user belongs to this company and has no permissions the current user lacks
+	if status, message := checkManageableUser(c, companyID, userID); status != 0 {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	internalError := func(err error) {
+		log.Printf("Error issuing two-factor enrollment code: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Internal Server Error",
+		})
+	}
+
+	tf, err := loadUserTwoFactor(db, userID)
+	if err != nil {
+		internalError(err)
+		return
+	}
+	if tf != nil && tf.EnabledAt != nil {
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "The user already has two-factor authentication; reset it first",
+		})
+		return
+	}
+
+	code, err := newOneTimeCode()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	expiresAt := time.Now().Add(enrollmentCodeTTL)
+	tx, err := db.Begin()
+	if err != nil {
+		internalError(err)
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM login_challenges WHERE company_id = ? AND user_id = ? AND purpose = ?",
+		companyID, userID, loginChallengeEnrollmentCode)
+	if err == nil {
+		_, err = tx.Exec(`
+			INSERT INTO login_challenges (token_hash, company_id, user_id, purpose, attempts, created_at, expires_at)
+			VALUES (?, ?, ?, ?, 0, NOW(), ?)`,
+			sha256Hex(normalizeRecoveryCode(code)), companyID, userID, loginChallengeEnrollmentCode, expiresAt)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		internalError(err)
+		return
+	}
+	log.Printf("User %d issued a two-factor enrollment code to user %d", getCurrentUserID(c), userID)
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Give the user the enrollment code to sign in with",
+		Data: gin.H{
+			"enrollment_code": code,
+			"expires_at":      expiresAt.Unix(),
+		},
+	})
+}
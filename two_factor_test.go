@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base32"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// currentTOTPCode returns the code an authenticator app shows for testTOTPSecret now
+func currentTOTPCode(t *testing.T) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(testTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, time.Now().Unix()/totpPeriod)
+}
+
+// expectValidSecondFactor expects a verify challenge of user 9 and a correct code for it
+func expectValidSecondFactor(mock sqlmock.Sqlmock, viaSSO bool) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM login_challenges ch")).
+		WithArgs(sha256Hex("the-challenge"), loginChallengeVerify, loginChallengeEnroll).
+		WillReturnRows(sqlmock.NewRows([]string{"purpose", "via_sso", "attempts", "expires_at", "id", "company_id",
+			"username", "email", "first_name", "last_name", "role", "is_active", "last_login"}).
+			AddRow(loginChallengeVerify, viaSSO, 0, time.Now().Add(time.Minute), 9, ownCompany,
+				"ada", "ada@example.edu", nil, nil, "admin", true, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT secret, enabled_at FROM user_two_factor WHERE user_id = ?")).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled_at"}).AddRow(testTOTPSecret, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_two_factor SET last_used_step = ?")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func expectLoginCompany(mock sqlmock.Sqlmock, trialEndsAt *time.Time) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM companies")).
+		WithArgs(ownCompany).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "company_name", "company_code", "email",
+			"subscription_plan", "is_active", "trial_ends_at"}).
+			AddRow(ownCompany, "Example University", "EXU", "it@example.edu", "basic", true, trialEndsAt))
+}
+
+// TestLoginTwoFactorChecksCompany checks that a correct second factor does not complete
+// a login the company stopped allowing after the first factor
+func TestLoginTwoFactorChecksCompany(t *testing.T) {
+	trialEnded := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name        string
+		viaSSO      bool
+		trialEndsAt *time.Time
+		ssoOnly     bool
+	}{
+		{"trial expired", false, &trialEnded, false},
+		{"trial expired after single sign-on", true, &trialEnded, false},
+		{"password login disabled", false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := useMockDB(t)
+			expectValidSecondFactor(mock, tt.viaSSO)
+			expectLoginCompany(mock, tt.trialEndsAt)
+			if tt.trialEndsAt == nil {
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT disable_password_login AND enabled FROM company_sso")).
+					WithArgs(ownCompany).
+					WillReturnRows(sqlmock.NewRows([]string{"disabled"}).AddRow(tt.ssoOnly))
+			}
+			mock.ExpectRollback()
+
+			c, w := userRequest("", 0, `{"challenge_token":"the-challenge","code":"`+currentTOTPCode(t)+`"}`)
+			loginTwoFactorHandler(c)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("got %d %s, want 403", w.Code, w.Body)
+			}
+			checkMock(t, mock)
+		})
+	}
+}
+
+// TestEnrollmentNeedsCode checks that a password alone does not get a user the policy
+// covers a secret to set up 2FA with; an enrollment code issued to them does
+func TestEnrollmentNeedsCode(t *testing.T) {
+	admin := User{ID: 9, CompanyID: ownCompany, Username: "ada", Role: "admin"}
+	tests := []struct {
+		name string
+		code string
+		want error
+	}{
+		{"no code", "", errEnrollmentRequired},
+		{"unknown code", "abcde-fghij", errEnrollmentRequired},
+		{"issued code", "ABCDE-FGHIJ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := useMockDB(t)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT secret, enabled_at FROM user_two_factor WHERE user_id = ?")).
+				WithArgs(9).
+				WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled_at"}))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT setting_value FROM company_settings")).
+				WithArgs(ownCompany, twoFactorRequiredSetting).
+				WillReturnRows(sqlmock.NewRows([]string{"setting_value"}).AddRow("true"))
+			if tt.code != "" {
+				redeemed := int64(0)
+				if tt.want == nil {
+					redeemed = 1
+				}
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM login_challenges")).
+					WithArgs(sha256Hex("abcdefghij"), ownCompany, 9, loginChallengeEnrollmentCode).
+					WillReturnResult(sqlmock.NewResult(0, redeemed))
+			}
+			if tt.want == nil {
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_two_factor")).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO login_challenges")).
+					WithArgs(sqlmock.AnyArg(), ownCompany, 9, loginChallengeEnroll, false, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			challenge, err := twoFactorChallenge(admin, Company{ID: ownCompany, CompanyCode: "EXU"}, false, tt.code)
+			if err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (challenge.TwoFactor != loginChallengeEnroll || challenge.Setup == nil) {
+				t.Fatalf("got %+v, want an enroll challenge with a setup", challenge)
+			}
+			checkMock(t, mock)
+		})
+	}
+}
+
+// TestRequiringTwoFactor checks that turning the policy on needs 2FA of whoever does it,
+// and signs out the users it covers who have not enabled 2FA
+func TestRequiringTwoFactor(t *testing.T) {
+	body := `{"` + twoFactorRequiredSetting + `":"true"}`
+	t.Run("actor without 2FA", func(t *testing.T) {
+		mock := useMockDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT secret, enabled_at FROM user_two_factor WHERE user_id = ?")).
+			WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled_at"}))
+
+		c, w := userRequest("admin", 0, body)
+		updateCompanySettingsHandler(c)
+		if w.Code != http.StatusConflict {
+			t.Fatalf("got %d %s, want 409", w.Code, w.Body)
+		}
+		checkMock(t, mock)
+	})
+
+	t.Run("actor with 2FA", func(t *testing.T) {
+		mock := useMockDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT secret, enabled_at FROM user_two_factor WHERE user_id = ?")).
+			WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled_at"}).AddRow(testTOTPSecret, time.Now()))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO company_settings")).
+			WithArgs(ownCompany, twoFactorRequiredSetting, "true").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("LEFT JOIN user_two_factor tf")).
+			WithArgs(ownCompany).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "role"}).AddRow(7, "manager").AddRow(8, "user"))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE user_sessions SET revoked_at = ?")).
+			WithArgs(sqlmock.AnyArg(), sessionRevokedTwoFactor, ownCompany, 7).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		c, w := userRequest("admin", 0, body)
+		updateCompanySettingsHandler(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d %s, want 200", w.Code, w.Body)
+		}
+		checkMock(t, mock)
+	})
+}
@@ -10,46 +10,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// adminMiddleware checks if the current user has admin role
-func adminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userID := getCurrentUserID(c)
-		if userID == 0 {
-			c.JSON(http.StatusUnauthorized, APIResponse{
-				Success: false,
-				Error:   "Unauthorized",
-			})
-			c.Abort()
-			return
-		}
-
-		// Get user's role
-		var role string
-		err := db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, APIResponse{
-				Success: false,
-				Error:   "Failed to get user role",
-			})
-			c.Abort()
-			return
-		}
-
-		// Check if user is admin
-		if role != "admin" {
-			c.JSON(http.StatusForbidden, APIResponse{
-				Success: false,
-				Error:   "Admin access required",
-			})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
-}
-
-// getUsersHandler returns all users for the current company (admin only)
+// getUsersHandler returns all users for the current company (requires users:manage)
 func getUsersHandler(c *gin.Context) {
 	companyID := getCurrentCompanyID(c)
 
@@ -184,6 +145,13 @@ func addUserHandler(c *gin.Context) {
 	if req.Role == "" {
 		req.Role = "user"
 	}
+	if status, message := checkAssignableRole(c, companyID, req.Role); status != 0 {
+		c.JSON(status, APIResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
 
 	// Insert the user
 	result, err := db.Exec(`
@@ -200,25 +168,6 @@ func addUserHandler(c *gin.Context) {
 
 	userID, _ := result.LastInsertId()
 
-	// Create default user roles based on the role
-	var roles []string
-	switch req.Role {
-	case "admin":
-		roles = []string{"userManagement", "assetManagement", "encodeAssets"}
-	case "manager":
-		roles = []string{"assetManagement", "encodeAssets"}
-	default:
-		roles = []string{"encodeAssets"}
-	}
-
-	// Insert user roles
-	for _, role := range roles {
-		_, err = db.Exec("INSERT INTO user_roles (user_id, company_id, role) VALUES (?, ?, ?)", userID, companyID, role)
-		if err != nil {
-			log.Printf("Error adding user role %s: %v", role, err)
-		}
-	}
-
 	c.JSON(http.StatusCreated, APIResponse{
 		Success: true,
 		Message: "User added successfully",
@@ -249,20 +198,11 @@ func updateUserHandler(c *gin.Context) {
 		return
 	}
 
-	// Check if user exists and belongs to this company
-	var existingID int
-	err = db.QueryRow("SELECT id FROM users WHERE id = ? AND company_id = ?", userID, companyID).Scan(&existingID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, APIResponse{
-				Success: false,
-				Error:   "User not found",
-			})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, APIResponse{
+	// Check the user belongs to this company and has no permissions the current user lacks
+	if status, message := checkManageableUser(c, companyID, userID); status != 0 {
+		c.JSON(status, APIResponse{
 			Success: false,
-			Error:   "Database error: " + err.Error(),
+			Error:   message,
 		})
 		return
 	}
@@ -289,6 +229,13 @@ func updateUserHandler(c *gin.Context) {
 		params = append(params, req.LastName)
 	}
 	if req.Role != "" {
+		if status, message := checkAssignableRole(c, companyID, req.Role); status != 0 {
+			c.JSON(status, APIResponse{
+				Success: false,
+				Error:   message,
+			})
+			return
+		}
 		updates = append(updates, "role = ?")
 		params = append(params, req.Role)
 	}
@@ -321,6 +268,13 @@ func updateUserHandler(c *gin.Context) {
 		return
 	}
 
+	// Deactivated users are signed out everywhere, so reactivating them does not revive old sessions
+	if req.IsActive != nil && !*req.IsActive {
+		if _, err := revokeUserSessions(companyID, userID, sessionRevokedUser); err != nil {
+			log.Printf("Failed to revoke sessions of user %d: %v", userID, err)
+		}
+	}
+
 	c.JSON(http.StatusOK, APIResponse{
 		Success: true,
 		Message: "User updated successfully",
@@ -339,20 +293,11 @@ func deleteUserHandler(c *gin.Context) {
 		return
 	}
 
-	// Check if user exists and belongs to this company
-	var existingID int
-	err = db.QueryRow("SELECT id FROM users WHERE id = ? AND company_id = ?", userID, companyID).Scan(&existingID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, APIResponse{
-				Success: false,
-				Error:   "User not found",
-			})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, APIResponse{
+	// Check the user belongs to this company and has no permissions the current user lacks
+	if status, message := checkManageableUser(c, companyID, userID); status != 0 {
+		c.JSON(status, APIResponse{
 			Success: false,
-			Error:   "Database error: " + err.Error(),
+			Error:   message,
 		})
 		return
 	}
@@ -366,9 +311,12 @@ func deleteUserHandler(c *gin.Context) {
 		})
 		return
 	}
+	if _, err := revokeUserSessions(companyID, userID, sessionRevokedUser); err != nil {
+		log.Printf("Failed to revoke sessions of user %d: %v", userID, err)
+	}
 
 	c.JSON(http.StatusOK, APIResponse{
 		Success: true,
 		Message: "User deleted successfully",
 	})
-} 
\ No newline at end of file
+}